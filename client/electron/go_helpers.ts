@@ -18,6 +18,8 @@
  * allowing users to call Go functions from TypeScript.
  */
 
+import {app} from 'electron';
+
 import {pathToEmbeddedTun2socksBinary} from './app_paths';
 import {ChildProcessHelper} from './process';
 
@@ -73,6 +75,7 @@ async function checkUDPConnectivityWithArgs(
   const tun2socks = new ChildProcessHelper(pathToEmbeddedTun2socksBinary());
   tun2socks.isDebugModeEnabled = debugMode;
 
+  args.push('-clientVersion', app.getVersion());
   console.debug('[tun2socks] - checking connectivity ...', args);
   const output = await tun2socks.launch(args);
 
@@ -14,7 +14,7 @@
 
 import {platform} from 'os';
 
-import {powerMonitor} from 'electron';
+import {app, powerMonitor} from 'electron';
 
 import {pathToEmbeddedTun2socksBinary} from './app_paths';
 import {checkUDPConnectivity, checkUDPConnectivityWindows} from './go_helpers';
@@ -305,6 +305,7 @@ class GoTun2socks {
     //   -tunName outline-tap0 -tunDNS 1.1.1.1,9.9.9.9 \
     //   -tunAddr 10.0.85.2 -tunGw 10.0.85.1 -tunMask 255.255.255.0 \
     //   -client '{ "transport:" {"host": "127.0.0.1", "port": 1080, "password": "mypassword", "cipher": "chacha20-ietf-poly1035"} }' \
+    //   -clientVersion 1.15.0 \
     //   [-dnsFallback] [-checkConnectivity] [-proxyPrefix]
 
     args.push('-keyID', this.keyId);
@@ -314,6 +315,7 @@ class GoTun2socks {
     args.push('-tunMask', TUN2SOCKS_VIRTUAL_ROUTER_NETMASK);
     args.push('-tunDNS', DNS_RESOLVERS.join(','));
     args.push('-client', clientConfig);
+    args.push('-clientVersion', app.getVersion());
     args.push('-logLevel', this.process.isDebugModeEnabled ? 'debug' : 'info');
     if (!isUdpEnabled) {
       args.push('-dnsFallback');
@@ -46,6 +46,7 @@ import {
   TunnelStatus,
 } from '../web/app/outline_server_repository/vpn';
 import * as errors from '../web/model/errors';
+import {GoErrorCode, PlatformError} from '../web/model/platform_error';
 
 // TODO: can we define these macros in other .d.ts files with default values?
 // Build-time macros injected by webpack's DefinePlugin:
@@ -461,6 +462,13 @@ function main() {
     // To clearly identify app restarts in Sentry.
     console.info('Outline is starting');
 
+    // Configs can depend on the app version, so the backend needs it before parsing any.
+    try {
+      await invokeGoMethod('SetClientVersion', app.getVersion());
+    } catch (e) {
+      console.error('failed to set the client version in the backend', e);
+    }
+
     setupMenu();
     setupTray();
     // TODO(fortuna): Start the app with the window hidden on auto-start?
@@ -569,6 +577,20 @@ function main() {
           break;
         }
 
+        case 'ParseTunnelConfig': {
+          const output = await invokeGoMethod(method, params);
+          // The routing daemon can only exclude a single proxy IP from the tunnel, so reject the configs
+          // that connect through several first hops when they are parsed, rather than when connecting.
+          if (!USE_MODERN_ROUTING && JSON.parse(output).firstHops?.length) {
+            const perr = new PlatformError(
+              GoErrorCode.INVALID_CONFIG,
+              'configs that connect through multiple servers are not supported on this platform'
+            );
+            throw new Error(perr.toJSON());
+          }
+          return output;
+        }
+
         case 'StopProxying':
           // Disconnects from the current server, if any.
           // TODO: refactor channel name and namespace to a constant
@@ -0,0 +1,88 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"context"
+	"fmt"
+)
+
+// ConfigRefKey is the config key used to reference a named definition instead of specifying the config inline.
+const ConfigRefKey = "$ref"
+
+// Definitions holds named config nodes that can be referenced with [ConfigRefKey].
+//
+// A definition is parsed lazily the first time it's referenced, and the parsed object is shared by all
+// the references to it that are parsed by the same [TypeParser]. It's not safe for concurrent use.
+type Definitions struct {
+	nodes   map[string]ConfigNode
+	parsed  map[definitionKey]definitionResult
+	parsing map[string]bool
+}
+
+type definitionKey struct {
+	name   string
+	parser any
+}
+
+type definitionResult struct {
+	value any
+	err   error
+}
+
+// NewDefinitions creates a [Definitions] with the given named config nodes.
+func NewDefinitions(nodes map[string]ConfigNode) *Definitions {
+	return &Definitions{
+		nodes:   nodes,
+		parsed:  make(map[definitionKey]definitionResult),
+		parsing: make(map[string]bool),
+	}
+}
+
+type definitionsContextKey struct{}
+
+// WithDefinitions returns a context that makes the given definitions available to [TypeParser.Parse].
+func WithDefinitions(ctx context.Context, defs *Definitions) context.Context {
+	return context.WithValue(ctx, definitionsContextKey{}, defs)
+}
+
+func definitionsFromContext(ctx context.Context) *Definitions {
+	defs, _ := ctx.Value(definitionsContextKey{}).(*Definitions)
+	return defs
+}
+
+// parse returns the definition with the given name parsed by the given parser, reusing the previous result if
+// the definition was already parsed by that parser.
+func (d *Definitions) parse(ctx context.Context, name string, parser any, parse func(context.Context, ConfigNode) (any, error)) (any, error) {
+	node, ok := d.nodes[name]
+	if !ok {
+		return nil, fmt.Errorf("definition \"%v\" not found", name)
+	}
+	key := definitionKey{name, parser}
+	if result, ok := d.parsed[key]; ok {
+		return result.value, result.err
+	}
+	if d.parsing[name] {
+		return nil, fmt.Errorf("definition \"%v\" has a cyclic reference", name)
+	}
+	d.parsing[name] = true
+	value, err := parse(withAbsolutePath(ctx, joinPath("definitions", name)), node)
+	delete(d.parsing, name)
+	if err != nil {
+		err = fmt.Errorf("definition \"%v\" failed: %w", name, err)
+	}
+	d.parsed[key] = definitionResult{value, err}
+	return value, err
+}
@@ -0,0 +1,177 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testNode struct {
+	Name     string
+	Children []*testNode
+}
+
+// newTestNodeParser returns a parser for testNode and a pointer to the number of nodes it created.
+func newTestNodeParser() (*TypeParser[*testNode], *int) {
+	created := 0
+	parser := NewTypeParser(func(ctx context.Context, input ConfigNode) (*testNode, error) {
+		name, ok := input.(string)
+		if !ok {
+			return nil, errors.New("parser not specified")
+		}
+		created++
+		return &testNode{Name: name}, nil
+	})
+	parser.RegisterSubParser("list", func(ctx context.Context, input map[string]any) (*testNode, error) {
+		created++
+		node := &testNode{Name: "list"}
+		children, _ := input["children"].([]any)
+		for _, childConfig := range children {
+			child, err := parser.Parse(ctx, childConfig)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+	})
+	return parser, &created
+}
+
+func parseWithDefinitions(t *testing.T, parser *TypeParser[*testNode], configText string) (*testNode, error) {
+	var config struct {
+		Definitions map[string]ConfigNode
+		Root        ConfigNode
+	}
+	node, err := ParseConfigYAML(configText)
+	require.NoError(t, err)
+	require.NoError(t, MapToAny(node.(map[string]any), &config))
+	ctx := WithDefinitions(context.Background(), NewDefinitions(config.Definitions))
+	return parser.Parse(ctx, config.Root)
+}
+
+func TestTypeParser_RefSharesDefinition(t *testing.T) {
+	parser, created := newTestNodeParser()
+	root, err := parseWithDefinitions(t, parser, `
+definitions:
+  shared:
+    $type: list
+    children: [a, b]
+root:
+  $type: list
+  children:
+    - $ref: shared
+    - $type: list
+      children:
+        - $ref: shared`)
+	require.NoError(t, err)
+
+	require.Len(t, root.Children, 2)
+	shared := root.Children[0]
+	require.Equal(t, "list", shared.Name)
+	require.Len(t, shared.Children, 2)
+	require.Same(t, shared, root.Children[1].Children[0])
+	// root, shared and its 2 children, and the inner list.
+	require.Equal(t, 5, *created)
+}
+
+func TestTypeParser_RefToRef(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	root, err := parseWithDefinitions(t, parser, `
+definitions:
+  alias:
+    $ref: leaf
+  leaf: a
+root:
+  $type: list
+  children:
+    - $ref: alias
+    - $ref: leaf`)
+	require.NoError(t, err)
+	require.Equal(t, "a", root.Children[0].Name)
+	require.Same(t, root.Children[0], root.Children[1])
+}
+
+func TestTypeParser_RefCycle(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	_, err := parseWithDefinitions(t, parser, `
+definitions:
+  a:
+    $type: list
+    children:
+      - $ref: b
+  b:
+    $ref: a
+root:
+  $ref: a`)
+	require.ErrorContains(t, err, `definition "a" has a cyclic reference`)
+
+	_, err = parseWithDefinitions(t, parser, `
+definitions:
+  self:
+    $ref: self
+root:
+  $ref: self`)
+	require.ErrorContains(t, err, `definition "self" has a cyclic reference`)
+}
+
+func TestTypeParser_RefNotFound(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	_, err := parseWithDefinitions(t, parser, `
+definitions:
+  a: a
+root:
+  $ref: b`)
+	require.ErrorContains(t, err, `definition "b" not found`)
+
+	// No definitions in the context.
+	_, err = parser.Parse(context.Background(), map[string]any{ConfigRefKey: "a"})
+	require.ErrorContains(t, err, `definition "a" not found`)
+}
+
+func TestTypeParser_RefInvalid(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	_, err := parseWithDefinitions(t, parser, `
+definitions:
+  a: a
+root:
+  $ref: a
+  $type: list`)
+	require.ErrorContains(t, err, "$ref cannot be combined with other keys")
+
+	_, err = parseWithDefinitions(t, parser, `
+root:
+  $ref: 1`)
+	require.ErrorContains(t, err, "$ref must be a string")
+}
+
+func TestTypeParser_RefFailureIsReported(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	_, err := parseWithDefinitions(t, parser, `
+definitions:
+  bad:
+    $type: unknown
+root:
+  $type: list
+  children:
+    - $ref: bad
+    - $ref: bad`)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+	require.ErrorContains(t, err, `definition "bad" failed`)
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatConfigYAML returns the canonical YAML text of a config node, which must be made of the types that
+// [ParseConfigYAML] returns. Maps use the block style with sorted keys, and strings are only quoted if needed.
+//
+// We don't use yaml.Marshal because it doesn't preserve some strings.
+// See https://github.com/Jigsaw-Code/outline-apps/issues/2576.
+func FormatConfigYAML(node ConfigNode) (string, error) {
+	var out strings.Builder
+	if err := writeYAMLNode(&out, node, 0); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// writeYAMLNode writes the node, followed by a new line. Maps and lists are written as blocks at the given indentation.
+func writeYAMLNode(out *strings.Builder, node ConfigNode, indent int) error {
+	switch typed := node.(type) {
+	case map[string]any:
+		if len(typed) == 0 {
+			out.WriteString("{}\n")
+			return nil
+		}
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			keyText, err := formatYAMLScalar(key)
+			if err != nil {
+				return fmt.Errorf("invalid key %q: %w", key, err)
+			}
+			out.WriteString(strings.Repeat(" ", indent))
+			out.WriteString(keyText)
+			out.WriteString(":")
+			if err := writeYAMLValue(out, typed[key], indent+2); err != nil {
+				return fmt.Errorf("invalid value for key %q: %w", key, err)
+			}
+		}
+		return nil
+	case []any:
+		if len(typed) == 0 {
+			out.WriteString("[]\n")
+			return nil
+		}
+		for i, item := range typed {
+			// Write the item at the indentation of its content, then replace the first indentation with the dash.
+			var itemOut strings.Builder
+			if err := writeYAMLNode(&itemOut, item, indent+2); err != nil {
+				return fmt.Errorf("invalid item %d: %w", i, err)
+			}
+			itemText := itemOut.String()
+			out.WriteString(strings.Repeat(" ", indent))
+			out.WriteString("- ")
+			out.WriteString(strings.TrimPrefix(itemText, strings.Repeat(" ", indent+2)))
+		}
+		return nil
+	default:
+		text, err := formatYAMLScalar(node)
+		if err != nil {
+			return err
+		}
+		out.WriteString(text)
+		out.WriteString("\n")
+		return nil
+	}
+}
+
+// writeYAMLValue writes the value of a map entry, after the key and colon.
+func writeYAMLValue(out *strings.Builder, value ConfigNode, indent int) error {
+	switch typed := value.(type) {
+	case map[string]any:
+		if len(typed) > 0 {
+			out.WriteString("\n")
+			return writeYAMLNode(out, typed, indent)
+		}
+	case []any:
+		if len(typed) > 0 {
+			out.WriteString("\n")
+			return writeYAMLNode(out, typed, indent)
+		}
+	}
+	out.WriteString(" ")
+	return writeYAMLNode(out, value, indent)
+}
+
+func formatYAMLScalar(value ConfigNode) (string, error) {
+	switch typed := value.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case int:
+		return strconv.FormatInt(int64(typed), 10), nil
+	case int64:
+		return strconv.FormatInt(typed, 10), nil
+	case uint64:
+		return strconv.FormatUint(typed, 10), nil
+	case float64:
+		// JSON numbers are valid YAML, and JSON fails on NaN and infinities.
+		text, err := json.Marshal(typed)
+		return string(text), err
+	case string:
+		if isPlainYAMLString(typed) {
+			return typed, nil
+		}
+		if !utf8.ValidString(typed) {
+			return "", fmt.Errorf("string is not valid UTF-8")
+		}
+		// JSON strings are valid double-quoted YAML strings.
+		var text bytes.Buffer
+		encoder := json.NewEncoder(&text)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(typed); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(text.String(), "\n"), nil
+	default:
+		return "", fmt.Errorf("unsupported config node type %T", value)
+	}
+}
+
+// isPlainYAMLString returns whether the string can be written without quotes. It's conservative, and
+// only allows for strings that can't be mistaken for other values or YAML syntax.
+func isPlainYAMLString(s string) bool {
+	if s == "" {
+		return false
+	}
+	first := s[0]
+	if !isASCIILetter(first) && first != '_' && first != '$' && first != '/' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isASCIILetter(c) || (c >= '0' && c <= '9') || strings.IndexByte("_$/.-@+=~", c) != -1 {
+			continue
+		}
+		// Colons are only safe if not followed by a space or the end of the string.
+		if c == ':' && i+1 < len(s) && s[i+1] != ' ' {
+			continue
+		}
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "null", "yes", "no", "on", "off", "y", "n":
+		return false
+	}
+	return true
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatConfigYAML(t *testing.T) {
+	node, err := ParseConfigYAML(`
+transport:
+  $type: tcpudp
+  tcp:
+    $type: shadowsocks
+    endpoint: example.com:443
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET
+    prefix: "POST "
+  udp:
+    $type: first-supported
+    options:
+      - $type: shadowsocks
+        endpoint: {$type: dial, address: 1.2.3.4:53}
+      - [a, b]
+      - null
+      - {}
+      - []
+count: 3
+enabled: true`)
+	require.NoError(t, err)
+
+	text, err := FormatConfigYAML(node)
+	require.NoError(t, err)
+	require.Equal(t, `count: 3
+enabled: true
+transport:
+  $type: tcpudp
+  tcp:
+    $type: shadowsocks
+    cipher: chacha20-ietf-poly1305
+    endpoint: example.com:443
+    prefix: "POST "
+    secret: SECRET
+  udp:
+    $type: first-supported
+    options:
+      - $type: shadowsocks
+        endpoint:
+          $type: dial
+          address: "1.2.3.4:53"
+      - - a
+        - b
+      - null
+      - {}
+      - []
+`, text)
+
+	reparsed, err := ParseConfigYAML(text)
+	require.NoError(t, err)
+	require.Equal(t, node, reparsed)
+}
+
+func TestFormatConfigYAML_Strings(t *testing.T) {
+	for _, value := range []string{
+		"", "plain", "SSH-2.0\r\n", "yes", "No", "null", "~", "1.5", "0x10", ".inf", "a: b", "a:", "#comment", "a #b",
+		" leading", "trailing ", "-dash", "- item", "[list]", "{map}", "*alias", "&anchor", "!tag", "|", ">", "'quote'",
+		`"double"`, "back\\slash", "ünïcödé", "\x00\x01\x7f", " ", "%percent", "@at", "`tick`", "ss://a@b:1/?x=1&y=2#tag",
+	} {
+		text, err := FormatConfigYAML(map[string]any{"key": value, value: "value"})
+		require.NoError(t, err, value)
+		node, err := ParseConfigYAML(text)
+		require.NoError(t, err, "%q formatted as:\n%v", value, text)
+		require.Equal(t, map[string]any{"key": value, value: "value"}, node, "%q formatted as:\n%v", value, text)
+	}
+}
+
+func TestFormatConfigYAML_RandomStrings(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	alphabet := []rune("aZ09 :#-?[]{}!&*|>'\"%@`,\\\r\n\t\x00ÿ ")
+	for i := 0; i < 1000; i++ {
+		runes := make([]rune, rng.IntN(8))
+		for j := range runes {
+			runes[j] = alphabet[rng.IntN(len(alphabet))]
+		}
+		value := string(runes)
+		text, err := FormatConfigYAML([]any{value})
+		require.NoError(t, err, value)
+		node, err := ParseConfigYAML(text)
+		require.NoError(t, err, "%q formatted as:\n%v", value, text)
+		require.Equal(t, []any{value}, node, "%q formatted as:\n%v", value, text)
+	}
+}
+
+func TestFormatConfigYAML_Errors(t *testing.T) {
+	_, err := FormatConfigYAML(map[string]any{"key": struct{}{}})
+	require.ErrorContains(t, err, `invalid value for key "key": unsupported config node type struct {}`)
+
+	_, err = FormatConfigYAML([]any{"\xff"})
+	require.ErrorContains(t, err, "invalid item 0: string is not valid UTF-8")
+}
@@ -0,0 +1,174 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// ParseError is an error from [TypeParser.Parse] annotated with the location of the config node that caused it.
+type ParseError struct {
+	// Path is the path of the config node, like "transport.tcp.table[3].dialer.endpoint".
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// FieldError is returned by [MapToAny] when a field of the input map is unknown or invalid.
+type FieldError struct {
+	// Field is the path of the field relative to the input map, like "table[3].dialer".
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return "field " + e.Field + ": " + e.Message
+}
+
+type pathContextKey struct{}
+
+// WithPath returns a context for parsing the config node at the given path relative to the current node.
+// Use it when calling a [ParseFunc] on a nested node, so errors can report where the node is.
+// Map keys are separated by dots and list indices go in brackets, like in "table[3].dialer".
+func WithPath(ctx context.Context, relativePath string) context.Context {
+	return withAbsolutePath(ctx, joinPath(PathFromContext(ctx), relativePath))
+}
+
+func withAbsolutePath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, pathContextKey{}, path)
+}
+
+// PathFromContext returns the path of the config node being parsed, as set by [WithPath].
+func PathFromContext(ctx context.Context) string {
+	path, _ := ctx.Value(pathContextKey{}).(string)
+	return path
+}
+
+func joinPath(base, relative string) string {
+	if base == "" || relative == "" || strings.HasPrefix(relative, "[") {
+		return base + relative
+	}
+	return base + "." + relative
+}
+
+// annotateError adds the location of the node being parsed to the error, unless a nested parser already did.
+func annotateError(ctx context.Context, err error) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return err
+	}
+	path := PathFromContext(ctx)
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		path = joinPath(path, fieldErr.Field)
+	}
+	return &ParseError{Path: path, Err: err}
+}
+
+// newFieldError converts a decoding error for the given YAML text into a [FieldError], if the error
+// has a location in the text.
+func newFieldError(yamlText []byte, err error) error {
+	var yamlErr yaml.Error
+	if !errors.As(err, &yamlErr) || yamlErr.GetToken() == nil {
+		return err
+	}
+	file, parseErr := parser.ParseBytes(yamlText, 0)
+	if parseErr != nil {
+		return err
+	}
+	node := findNodeByToken(file, yamlErr.GetToken())
+	if node == nil {
+		return err
+	}
+	return &FieldError{Field: strings.TrimPrefix(strings.TrimPrefix(node.GetPath(), "$"), "."), Message: yamlErr.GetMessage()}
+}
+
+type nodeFinder struct {
+	offset int
+	found  ast.Node
+}
+
+func (f *nodeFinder) Visit(node ast.Node) ast.Visitor {
+	if f.found != nil {
+		return nil
+	}
+	if tk := node.GetToken(); tk != nil && tk.Position.Offset == f.offset {
+		if _, isMappingValue := node.(*ast.MappingValueNode); !isMappingValue {
+			f.found = node
+			return nil
+		}
+	}
+	return f
+}
+
+func findNodeByToken(file *ast.File, tk *token.Token) ast.Node {
+	finder := &nodeFinder{offset: tk.Position.Offset}
+	for _, doc := range file.Docs {
+		ast.Walk(finder, doc)
+	}
+	return finder.found
+}
+
+// FindPosition returns the line and column in the given YAML text of the node with the given path, as in
+// [ParseError.Path]. If the node is not in the text, which can happen when the node was generated by a
+// parser, it returns the position of the closest ancestor that is in the text.
+func FindPosition(yamlText string, path string) (line int, column int, ok bool) {
+	file, err := parser.ParseBytes([]byte(yamlText), 0)
+	if err != nil {
+		return 0, 0, false
+	}
+	for {
+		if yamlPath, err := yaml.PathString("$" + pathSeparator(path) + path); err == nil {
+			if node, err := yamlPath.FilterFile(file); err == nil && node != nil && node.GetToken() != nil {
+				position := node.GetToken().Position
+				return position.Line, position.Column, true
+			}
+		}
+		if path == "" {
+			return 0, 0, false
+		}
+		path = parentPath(path)
+	}
+}
+
+func pathSeparator(path string) string {
+	if path == "" || strings.HasPrefix(path, "[") {
+		return ""
+	}
+	return "."
+}
+
+// parentPath returns the path without its last key or index.
+func parentPath(path string) string {
+	cut := strings.LastIndexAny(path, ".[")
+	if cut < 0 {
+		return ""
+	}
+	return path[:cut]
+}
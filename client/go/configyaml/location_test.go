@@ -0,0 +1,140 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPath(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, "", PathFromContext(ctx))
+	ctx = WithPath(ctx, "transport")
+	require.Equal(t, "transport", PathFromContext(ctx))
+	ctx = WithPath(ctx, "tcp.table")
+	require.Equal(t, "transport.tcp.table", PathFromContext(ctx))
+	ctx = WithPath(ctx, "[3]")
+	require.Equal(t, "transport.tcp.table[3]", PathFromContext(ctx))
+	require.Equal(t, "transport.tcp.table[3]", PathFromContext(WithPath(ctx, "")))
+}
+
+func TestMapToAny_UnknownFieldPath(t *testing.T) {
+	type entry struct {
+		Dialer any
+		IPs    []string
+	}
+	var config struct {
+		Table []entry
+	}
+	err := MapToAny(map[string]any{
+		"table": []any{
+			map[string]any{"ips": []any{"10.0.0.0/8"}},
+			map[string]any{"dialr": "direct"},
+		},
+	}, &config)
+	var fieldErr *FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	require.Equal(t, "table[1].dialr", fieldErr.Field)
+	require.Equal(t, `unknown field "dialr"`, fieldErr.Message)
+}
+
+func TestTypeParser_ParseErrorPath(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	parser.RegisterSubParser("strict", func(ctx context.Context, input map[string]any) (*testNode, error) {
+		var config struct{ Name string }
+		if err := MapToAny(input, &config); err != nil {
+			return nil, err
+		}
+		return &testNode{Name: config.Name}, nil
+	})
+	parser.RegisterSubParser("indexed", func(ctx context.Context, input map[string]any) (*testNode, error) {
+		children, _ := input["children"].([]any)
+		for i, childConfig := range children {
+			if _, err := parser.Parse(WithPath(ctx, fmt.Sprintf("children[%d]", i)), childConfig); err != nil {
+				return nil, err
+			}
+		}
+		return &testNode{Name: "indexed"}, nil
+	})
+
+	node, err := ParseConfigYAML(`
+$type: indexed
+children:
+  - a
+  - $type: strict
+    nme: b`)
+	require.NoError(t, err)
+	_, err = parser.Parse(WithPath(context.Background(), "root"), node)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	require.Equal(t, "root.children[1].nme", parseErr.Path)
+
+	_, err = parser.Parse(WithPath(context.Background(), "root"), map[string]any{ConfigTypeKey: "unknown"})
+	require.ErrorAs(t, err, &parseErr)
+	require.Equal(t, "root", parseErr.Path)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestTypeParser_ParseErrorPathInDefinition(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	_, err := parseWithDefinitions(t, parser, `
+definitions:
+  bad:
+    $type: unknown
+root:
+  $ref: bad`)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	require.Equal(t, "definitions.bad", parseErr.Path)
+}
+
+func TestFindPosition(t *testing.T) {
+	configText := `
+transport:
+  $type: tcpudp
+  tcp:
+    $type: iptable
+    table:
+      - ips: [10.0.0.0/8]
+        dialer: direct
+      - ips: [192.168.0.0/16]
+        dialer:
+          $type: shadowsocks
+          endpoint: example.com:443`
+
+	line, column, ok := FindPosition(configText, "transport.tcp.table[1].dialer.endpoint")
+	require.True(t, ok)
+	require.Equal(t, 12, line)
+	require.Equal(t, 21, column)
+
+	line, column, ok = FindPosition(configText, "transport.tcp.table[0].dialer")
+	require.True(t, ok)
+	require.Equal(t, 8, line)
+	require.Equal(t, 17, column)
+
+	// Nodes that are not in the text resolve to the closest ancestor.
+	line, column, ok = FindPosition(configText, "transport.tcp.table[1].dialer.endpoint.dialer")
+	require.True(t, ok)
+	require.Equal(t, 12, line)
+	require.Equal(t, 21, column)
+
+	_, _, ok = FindPosition("{invalid", "transport")
+	require.False(t, ok)
+}
@@ -45,6 +45,7 @@ func ParseConfigYAML(configText string) (ConfigNode, error) {
 
 // MapToAny marshalls a map into a struct. It's a helper for parsers that want to
 // map config maps into their config structures.
+// Errors about specific fields, like unknown fields, wrap a [FieldError] with the path of the field.
 func MapToAny(in map[string]any, out any) error {
 	newMap := make(map[string]any)
 	for k, v := range in {
@@ -63,7 +64,7 @@ func MapToAny(in map[string]any, out any) error {
 	}
 	decoder := yaml.NewDecoder(bytes.NewReader(yamlText), yaml.DisallowUnknownField())
 	if err := decoder.Decode(out); err != nil {
-		return fmt.Errorf("error decoding YAML: %w", err)
+		return fmt.Errorf("error decoding YAML: %w", newFieldError(yamlText, err))
 	}
 	return nil
 }
@@ -74,6 +75,11 @@ func MapToAny(in map[string]any, out any) error {
 type TypeParser[T any] struct {
 	fallbackHandler ParseFunc[T]
 	subparsers      map[string]func(context.Context, map[string]any) (T, error)
+
+	// Schema information. See [TypeParser.JSONSchema].
+	name             string
+	fallbackSchemas  []*Schema
+	subparserSchemas map[string]*Schema
 }
 
 var _ ParseFunc[any] = (*TypeParser[any])(nil).Parse
@@ -81,13 +87,23 @@ var _ ParseFunc[any] = (*TypeParser[any])(nil).Parse
 // NewTypeParser creates a [TypeParser] that calls the fallbackHandler if there's no parser specified in the config.
 func NewTypeParser[T any](fallbackHandler func(context.Context, ConfigNode) (T, error)) *TypeParser[T] {
 	return &TypeParser[T]{
-		fallbackHandler: fallbackHandler,
-		subparsers:      make(map[string]func(context.Context, map[string]any) (T, error)),
+		fallbackHandler:  fallbackHandler,
+		subparsers:       make(map[string]func(context.Context, map[string]any) (T, error)),
+		subparserSchemas: make(map[string]*Schema),
 	}
 }
 
 // Parse implements [ParseFunc] for the type T.
+// Errors wrap a [ParseError] with the path of the innermost config node that failed to parse.
 func (p *TypeParser[T]) Parse(ctx context.Context, config ConfigNode) (T, error) {
+	result, err := p.parse(ctx, config)
+	if err != nil {
+		return result, annotateError(ctx, err)
+	}
+	return result, nil
+}
+
+func (p *TypeParser[T]) parse(ctx context.Context, config ConfigNode) (T, error) {
 	var zero T
 
 	// Iterate while the input is a function call.
@@ -96,6 +112,9 @@ func (p *TypeParser[T]) Parse(ctx context.Context, config ConfigNode) (T, error)
 		if !ok {
 			break
 		}
+		if _, ok := inMap[ConfigRefKey]; ok {
+			return p.parseRef(ctx, inMap)
+		}
 		parserNameAny, ok := inMap[ConfigTypeKey]
 		if !ok {
 			break
@@ -135,9 +154,42 @@ func (p *TypeParser[T]) Parse(ctx context.Context, config ConfigNode) (T, error)
 	return p.fallbackHandler(ctx, config)
 }
 
+// parseRef returns the definition referenced by the [ConfigRefKey] in the input map.
+func (p *TypeParser[T]) parseRef(ctx context.Context, inMap map[string]any) (T, error) {
+	var zero T
+	if len(inMap) != 1 {
+		return zero, fmt.Errorf("%v cannot be combined with other keys", ConfigRefKey)
+	}
+	name, ok := inMap[ConfigRefKey].(string)
+	if !ok {
+		return zero, fmt.Errorf("%v must be a string, found \"%T\"", ConfigRefKey, inMap[ConfigRefKey])
+	}
+	defs := definitionsFromContext(ctx)
+	if defs == nil {
+		return zero, fmt.Errorf("definition \"%v\" not found", name)
+	}
+	value, err := defs.parse(ctx, name, p, func(ctx context.Context, node ConfigNode) (any, error) {
+		return p.Parse(ctx, node)
+	})
+	if err != nil {
+		return zero, err
+	}
+	// value may be a nil interface, so we can't assert it unconditionally.
+	typed, _ := value.(T)
+	return typed, nil
+}
+
 // RegisterSubParser registers the given subparser function with the given name for the type T.
 // Note that a subparser always take a map[string]any, not [ConfigNode], since we must have a map[string]any in
 // order to set the value for the ConfigParserKey.
 func (p *TypeParser[T]) RegisterSubParser(name string, function func(context.Context, map[string]any) (T, error)) {
 	p.subparsers[name] = function
+	delete(p.subparserSchemas, name)
+}
+
+// RegisterSubParserWithSchema is like [TypeParser.RegisterSubParser], but it also registers the schema of the
+// config the subparser takes, for [TypeParser.JSONSchema]. The $type field is added to the schema.
+func (p *TypeParser[T]) RegisterSubParserWithSchema(name string, schema *Schema, function func(context.Context, map[string]any) (T, error)) {
+	p.subparsers[name] = function
+	p.subparserSchemas[name] = schema
 }
@@ -0,0 +1,281 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// jsonSchemaDialect is the JSON Schema version of the schemas returned by [TypeParser.JSONSchema].
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// referenceDefName is the name of the schema definition for the [ConfigRefKey] directive.
+const referenceDefName = "Reference"
+
+// Schema describes the format of a config node, so it can be exported as a JSON Schema.
+type Schema struct {
+	// Config is a value of the struct type that the config map is decoded into with [MapToAny].
+	// The properties are derived by reflection from its exported fields, with the same names MapToAny uses.
+	// If nil, the config is described by JSON instead.
+	Config any
+	// Refs maps the config path of the [ConfigNode] fields in Config to the parser of their values.
+	// Paths use the config field names separated by dots, and go through lists and maps, like "table.dialer".
+	Refs map[string]SchemaRef
+	// JSON is a JSON Schema for configs that are not decoded into a struct, like {"type": "string"}.
+	// It's ignored if Config is set.
+	JSON map[string]any
+}
+
+// SchemaRef refers to the schema of all the configs that a parser takes. It's implemented by [TypeParser].
+type SchemaRef interface {
+	schemaName() string
+	addSchemaDefs(b *schemaBuilder) error
+}
+
+// SetSchema sets the name of the type T in the JSON Schema, and the schemas of the configs that the
+// fallback handler takes. Without fallback schemas, only configs for the registered subparsers are valid.
+func (p *TypeParser[T]) SetSchema(name string, fallback ...*Schema) {
+	p.name = name
+	p.fallbackSchemas = fallback
+}
+
+// JSONSchema returns a JSON Schema for the configs that the parser takes, including the configs of all
+// the parsers it refers to. Each parser is a definition in "$defs", named after the name given to
+// [TypeParser.SetSchema], and each of its subparsers is a definition named "<parser name>.<subparser name>".
+// Subparsers registered without a schema accept any fields.
+func (p *TypeParser[T]) JSONSchema() (map[string]any, error) {
+	b := &schemaBuilder{
+		defs:    map[string]any{},
+		parsers: map[string]SchemaRef{},
+	}
+	root, err := b.ref(p)
+	if err != nil {
+		return nil, err
+	}
+	root["$schema"] = jsonSchemaDialect
+	root["$defs"] = b.defs
+	return root, nil
+}
+
+func (p *TypeParser[T]) schemaName() string {
+	return p.name
+}
+
+func (p *TypeParser[T]) addSchemaDefs(b *schemaBuilder) error {
+	alternatives := make([]any, 0, len(p.fallbackSchemas)+len(p.subparsers)+1)
+	for _, schema := range p.fallbackSchemas {
+		jsonSchema, err := b.schema(schema)
+		if err != nil {
+			return fmt.Errorf("invalid fallback schema for %v: %w", p.name, err)
+		}
+		alternatives = append(alternatives, jsonSchema)
+	}
+
+	names := make([]string, 0, len(p.subparsers))
+	for name := range p.subparsers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		defName := p.name + "." + name
+		jsonSchema, err := b.subparserSchema(name, p.subparserSchemas[name])
+		if err != nil {
+			return fmt.Errorf("invalid schema for %v: %w", defName, err)
+		}
+		b.defs[defName] = jsonSchema
+		alternatives = append(alternatives, defRef(defName))
+	}
+
+	b.defs[referenceDefName] = map[string]any{
+		"type":                 "object",
+		"properties":           map[string]any{ConfigRefKey: map[string]any{"type": "string"}},
+		"required":             []any{ConfigRefKey},
+		"additionalProperties": false,
+	}
+	alternatives = append(alternatives, defRef(referenceDefName))
+
+	b.defs[p.name] = map[string]any{"anyOf": alternatives}
+	return nil
+}
+
+// schemaBuilder collects the definitions of a JSON Schema.
+type schemaBuilder struct {
+	defs    map[string]any
+	parsers map[string]SchemaRef
+}
+
+func defRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+// ref returns a reference to the schema of the given parser, adding its definitions if needed.
+func (b *schemaBuilder) ref(parser SchemaRef) (map[string]any, error) {
+	name := parser.schemaName()
+	if name == "" {
+		return nil, errors.New("parser has no schema name")
+	}
+	if existing, ok := b.parsers[name]; ok {
+		if existing != parser {
+			return nil, fmt.Errorf("schema name %v is used by multiple parsers", name)
+		}
+		return defRef(name), nil
+	}
+	if name == referenceDefName {
+		return nil, fmt.Errorf("schema name %v is reserved", name)
+	}
+	b.parsers[name] = parser
+	if err := parser.addSchemaDefs(b); err != nil {
+		return nil, err
+	}
+	return defRef(name), nil
+}
+
+// subparserSchema returns the schema of the config map for the subparser with the given name.
+func (b *schemaBuilder) subparserSchema(name string, schema *Schema) (map[string]any, error) {
+	typeProperty := map[string]any{"const": name}
+	if schema == nil {
+		return map[string]any{
+			"type":       "object",
+			"properties": map[string]any{ConfigTypeKey: typeProperty},
+			"required":   []any{ConfigTypeKey},
+		}, nil
+	}
+	jsonSchema := map[string]any{
+		"type":                 "object",
+		"properties":           map[string]any{},
+		"additionalProperties": false,
+	}
+	if schema.Config != nil {
+		var err error
+		jsonSchema, err = b.schema(schema)
+		if err != nil {
+			return nil, err
+		}
+		if jsonSchema["type"] != "object" {
+			return nil, fmt.Errorf("config must be a struct, found %T", schema.Config)
+		}
+	}
+	jsonSchema["properties"].(map[string]any)[ConfigTypeKey] = typeProperty
+	jsonSchema["required"] = []any{ConfigTypeKey}
+	return jsonSchema, nil
+}
+
+// schema returns the JSON Schema for the given [Schema].
+func (b *schemaBuilder) schema(schema *Schema) (map[string]any, error) {
+	if schema.Config == nil {
+		jsonSchema := make(map[string]any, len(schema.JSON))
+		for k, v := range schema.JSON {
+			jsonSchema[k] = v
+		}
+		return jsonSchema, nil
+	}
+	usedRefs := make(map[string]bool, len(schema.Refs))
+	jsonSchema, err := b.typeSchema(reflect.TypeOf(schema.Config), "", schema.Refs, usedRefs)
+	if err != nil {
+		return nil, err
+	}
+	for path := range schema.Refs {
+		if !usedRefs[path] {
+			return nil, fmt.Errorf("ref path %v is not a config node field of %T", path, schema.Config)
+		}
+	}
+	return jsonSchema, nil
+}
+
+// typeSchema returns the JSON Schema for values of the given type at the given path in the config.
+func (b *schemaBuilder) typeSchema(t reflect.Type, path string, refs map[string]SchemaRef, usedRefs map[string]bool) (map[string]any, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.typeSchema(t.Elem(), path, refs, usedRefs)
+	case reflect.Interface:
+		parser, ok := refs[path]
+		if !ok {
+			// Any value.
+			return map[string]any{}, nil
+		}
+		usedRefs[path] = true
+		return b.ref(parser)
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := b.typeSchema(t.Elem(), path, refs, usedRefs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %v", t.Key())
+		}
+		values, err := b.typeSchema(t.Elem(), path, refs, usedRefs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		properties := map[string]any{}
+		if err := b.addFieldSchemas(properties, t, path, refs, usedRefs); err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}, nil
+	default:
+		return nil, fmt.Errorf("unsupported config type %v", t)
+	}
+}
+
+// addFieldSchemas adds the schemas of the fields of the given struct type to properties. Field names follow
+// the YAML decoder rules: the yaml or json tag name if present, or the lowercase field name otherwise.
+func (b *schemaBuilder) addFieldSchemas(properties map[string]any, t reflect.Type, path string, refs map[string]SchemaRef, usedRefs map[string]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "" {
+			tag = field.Tag.Get("json")
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if slices.Contains(strings.Split(options, ","), "inline") {
+			if err := b.addFieldSchemas(properties, field.Type, path, refs, usedRefs); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fieldSchema, err := b.typeSchema(field.Type, joinPath(path, name), refs, usedRefs)
+		if err != nil {
+			return fmt.Errorf("invalid field %v: %w", field.Name, err)
+		}
+		properties[name] = fieldSchema
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configyaml
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testListConfig struct {
+	Children    []ConfigNode
+	Max_Size    int
+	Renamed     string `yaml:"name"`
+	Ignored     string `yaml:"-"`
+	testOptions `yaml:",inline"`
+}
+
+type testOptions struct {
+	Enabled bool
+	Labels  map[string]string
+}
+
+func TestTypeParser_JSONSchema(t *testing.T) {
+	parser, _ := newTestNodeParser()
+	parser.SetSchema("Node", &Schema{JSON: map[string]any{"type": "string"}})
+	parser.RegisterSubParserWithSchema("list", &Schema{
+		Config: testListConfig{},
+		Refs:   map[string]SchemaRef{"children": parser},
+	}, parser.subparsers["list"])
+	parser.RegisterSubParserWithSchema("empty", &Schema{}, parser.subparsers["list"])
+	parser.RegisterSubParser("opaque", parser.subparsers["list"])
+
+	schema, err := parser.JSONSchema()
+	require.NoError(t, err)
+
+	// Compare the JSON to ignore the Go types.
+	schemaJSON, err := json.Marshal(schema)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$ref": "#/$defs/Node",
+  "$defs": {
+    "Node": {
+      "anyOf": [
+        {"type": "string"},
+        {"$ref": "#/$defs/Node.empty"},
+        {"$ref": "#/$defs/Node.list"},
+        {"$ref": "#/$defs/Node.opaque"},
+        {"$ref": "#/$defs/Reference"}
+      ]
+    },
+    "Node.empty": {
+      "type": "object",
+      "properties": {"$type": {"const": "empty"}},
+      "required": ["$type"],
+      "additionalProperties": false
+    },
+    "Node.list": {
+      "type": "object",
+      "properties": {
+        "$type": {"const": "list"},
+        "children": {"type": "array", "items": {"$ref": "#/$defs/Node"}},
+        "max_size": {"type": "integer"},
+        "name": {"type": "string"},
+        "enabled": {"type": "boolean"},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}}
+      },
+      "required": ["$type"],
+      "additionalProperties": false
+    },
+    "Node.opaque": {
+      "type": "object",
+      "properties": {"$type": {"const": "opaque"}},
+      "required": ["$type"]
+    },
+    "Reference": {
+      "type": "object",
+      "properties": {"$ref": {"type": "string"}},
+      "required": ["$ref"],
+      "additionalProperties": false
+    }
+  }
+}`, string(schemaJSON))
+}
+
+func TestTypeParser_JSONSchema_NestedParsers(t *testing.T) {
+	leaves, _ := newTestNodeParser()
+	leaves.SetSchema("Leaf", &Schema{JSON: map[string]any{"type": "string"}})
+	trees, _ := newTestNodeParser()
+	trees.SetSchema("Tree")
+	trees.RegisterSubParserWithSchema("list", &Schema{
+		Config: struct {
+			Table []struct {
+				Leaf ConfigNode
+				Tree ConfigNode
+			}
+		}{},
+		Refs: map[string]SchemaRef{"table.leaf": leaves, "table.tree": trees},
+	}, trees.subparsers["list"])
+
+	schema, err := trees.JSONSchema()
+	require.NoError(t, err)
+	defs := schema["$defs"].(map[string]any)
+	require.Contains(t, defs, "Leaf")
+	require.Contains(t, defs, "Leaf.list")
+	require.Equal(t, map[string]any{"anyOf": []any{map[string]any{"$ref": "#/$defs/Tree.list"}, map[string]any{"$ref": "#/$defs/Reference"}}}, defs["Tree"])
+	entry := defs["Tree.list"].(map[string]any)["properties"].(map[string]any)["table"].(map[string]any)["items"].(map[string]any)
+	require.Equal(t, map[string]any{"$ref": "#/$defs/Leaf"}, entry["properties"].(map[string]any)["leaf"])
+	require.Equal(t, map[string]any{"$ref": "#/$defs/Tree"}, entry["properties"].(map[string]any)["tree"])
+}
+
+func TestTypeParser_JSONSchema_Errors(t *testing.T) {
+	t.Run("Missing Name", func(t *testing.T) {
+		parser, _ := newTestNodeParser()
+		_, err := parser.JSONSchema()
+		require.ErrorContains(t, err, "parser has no schema name")
+	})
+
+	t.Run("Invalid Ref Path", func(t *testing.T) {
+		parser, _ := newTestNodeParser()
+		parser.SetSchema("Node")
+		parser.RegisterSubParserWithSchema("list", &Schema{
+			Config: testListConfig{},
+			Refs:   map[string]SchemaRef{"child": parser},
+		}, parser.subparsers["list"])
+		_, err := parser.JSONSchema()
+		require.ErrorContains(t, err, "ref path child is not a config node field")
+	})
+
+	t.Run("Duplicate Name", func(t *testing.T) {
+		parser, _ := newTestNodeParser()
+		parser.SetSchema("Node")
+		other, _ := newTestNodeParser()
+		other.SetSchema("Node")
+		parser.RegisterSubParserWithSchema("list", &Schema{
+			Config: testListConfig{},
+			Refs:   map[string]SchemaRef{"children": other},
+		}, parser.subparsers["list"])
+		_, err := parser.JSONSchema()
+		require.ErrorContains(t, err, "schema name Node is used by multiple parsers")
+	})
+
+	t.Run("Config Not Struct", func(t *testing.T) {
+		parser, _ := newTestNodeParser()
+		parser.SetSchema("Node")
+		parser.RegisterSubParserWithSchema("list", &Schema{Config: "text"}, parser.subparsers["list"])
+		_, err := parser.JSONSchema()
+		require.ErrorContains(t, err, "config must be a struct")
+	})
+}
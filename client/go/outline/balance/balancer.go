@@ -0,0 +1,253 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package balance implements the selection of members of a pool of equivalent servers, with
+// health-based ejection of failing members.
+package balance
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Strategy is the way a [Balancer] spreads new connections across its members.
+type Strategy int
+
+const (
+	// WeightedRandom picks a random member, with probability proportional to its weight.
+	WeightedRandom Strategy = iota
+	// RoundRobin picks the members in turn.
+	RoundRobin
+	// LeastConnections picks the member with the fewest open connections.
+	LeastConnections
+)
+
+// Options configures a [Balancer].
+type Options struct {
+	Strategy Strategy
+	// Weights are the relative weights of the members for the WeightedRandom strategy.
+	// If empty, all members have the same weight.
+	Weights []int
+	// MaxFailures is the number of consecutive failures after which a member is ejected.
+	MaxFailures int
+	// EjectionTime is how long a member is ejected for the first time. It doubles on each
+	// consecutive ejection, up to MaxEjectionTime.
+	EjectionTime    time.Duration
+	MaxEjectionTime time.Duration
+	// Probe checks whether an ejected member works again, once its ejection time is over.
+	// It's only called when the balancer is in use. If nil, the member is readmitted on
+	// probation, and is ejected again on the first failure.
+	Probe func(member int) error
+}
+
+type member struct {
+	weight int
+	// activeConns is the number of connections being dialed or open.
+	activeConns int
+	// failures is the number of consecutive failures.
+	failures int
+	// ejections is the number of consecutive ejections.
+	ejections    int
+	ejected      bool
+	ejectedUntil time.Time
+	probing      bool
+	// probation is set when the member was readmitted without a probe.
+	probation bool
+}
+
+// Balancer picks members of a pool for new connections and keeps track of their health.
+// It's safe for concurrent use.
+type Balancer struct {
+	options Options
+
+	mu      sync.Mutex
+	members []member
+	next    int
+}
+
+// New creates a [Balancer] with the given number of members.
+func New(numMembers int, options Options) (*Balancer, error) {
+	if numMembers <= 0 {
+		return nil, errors.New("must have at least one member")
+	}
+	if len(options.Weights) != 0 && len(options.Weights) != numMembers {
+		return nil, fmt.Errorf("got %d weights for %d members", len(options.Weights), numMembers)
+	}
+	switch options.Strategy {
+	case WeightedRandom, RoundRobin, LeastConnections:
+	default:
+		return nil, fmt.Errorf("unsupported strategy %d", options.Strategy)
+	}
+	if options.MaxFailures <= 0 {
+		return nil, fmt.Errorf("max failures must be positive, found %d", options.MaxFailures)
+	}
+	if options.EjectionTime <= 0 {
+		return nil, fmt.Errorf("ejection time must be positive, found %v", options.EjectionTime)
+	}
+	if options.MaxEjectionTime < options.EjectionTime {
+		return nil, fmt.Errorf("max ejection time %v must not be less than ejection time %v", options.MaxEjectionTime, options.EjectionTime)
+	}
+	members := make([]member, numMembers)
+	for i := range members {
+		members[i].weight = 1
+		if len(options.Weights) != 0 {
+			if options.Weights[i] <= 0 {
+				return nil, fmt.Errorf("weight must be positive, found %d", options.Weights[i])
+			}
+			members[i].weight = options.Weights[i]
+		}
+	}
+	return &Balancer{options: options, members: members}, nil
+}
+
+// Pick selects the member to use for a new connection. The caller must call [Balancer.Report]
+// with the result of the connection attempt. If all members are ejected, it picks among all of them.
+func (b *Balancer) Pick() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	candidates := make([]int, 0, len(b.members))
+	for i := range b.members {
+		m := &b.members[i]
+		if m.ejected && !now.Before(m.ejectedUntil) && !m.probing {
+			b.startProbe(i)
+		}
+		if !m.ejected {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range b.members {
+			candidates = append(candidates, i)
+		}
+	}
+
+	var picked int
+	switch b.options.Strategy {
+	case RoundRobin:
+		picked = candidates[0]
+		for _, i := range candidates {
+			if i >= b.next {
+				picked = i
+				break
+			}
+		}
+		b.next = picked + 1
+	case LeastConnections:
+		var fewest []int
+		for _, i := range candidates {
+			if len(fewest) == 0 || b.members[i].activeConns < b.members[fewest[0]].activeConns {
+				fewest = append(fewest[:0], i)
+			} else if b.members[i].activeConns == b.members[fewest[0]].activeConns {
+				fewest = append(fewest, i)
+			}
+		}
+		// We use math/rand here because there's no need for strong randomness.
+		picked = fewest[rand.Intn(len(fewest))]
+	default:
+		totalWeight := 0
+		for _, i := range candidates {
+			totalWeight += b.members[i].weight
+		}
+		r := rand.Intn(totalWeight)
+		for _, i := range candidates {
+			r -= b.members[i].weight
+			if r < 0 {
+				picked = i
+				break
+			}
+		}
+	}
+	b.members[picked].activeConns++
+	return picked
+}
+
+// startProbe checks an ejected member whose ejection time is over. Must be called with the lock held.
+func (b *Balancer) startProbe(i int) {
+	m := &b.members[i]
+	if b.options.Probe == nil {
+		m.ejected = false
+		m.probation = true
+		m.failures = b.options.MaxFailures - 1
+		return
+	}
+	m.probing = true
+	go func() {
+		err := b.options.Probe(i)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		m.probing = false
+		if err != nil {
+			b.eject(i)
+			return
+		}
+		m.ejected = false
+		m.ejections = 0
+		m.failures = 0
+	}()
+}
+
+// eject removes the member from the pool for its ejection time, which doubles on each consecutive
+// ejection. Must be called with the lock held.
+func (b *Balancer) eject(i int) {
+	m := &b.members[i]
+	ejectionTime := b.options.EjectionTime
+	for j := 0; j < m.ejections && ejectionTime < b.options.MaxEjectionTime; j++ {
+		ejectionTime *= 2
+	}
+	m.ejected = true
+	m.ejectedUntil = time.Now().Add(min(ejectionTime, b.options.MaxEjectionTime))
+	m.ejections++
+	m.failures = 0
+	m.probation = false
+}
+
+// Report records the result of a connection attempt with a member returned by [Balancer.Pick].
+// If err is nil, the caller must call [Balancer.Release] when the connection is closed.
+func (b *Balancer) Report(i int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := &b.members[i]
+	if err == nil {
+		m.failures = 0
+		if m.probation {
+			m.probation = false
+			m.ejections = 0
+		}
+		return
+	}
+	m.activeConns--
+	m.failures++
+	if m.failures >= b.options.MaxFailures && !m.ejected {
+		b.eject(i)
+	}
+}
+
+// Release records that a connection with a member was closed.
+func (b *Balancer) Release(i int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members[i].activeConns--
+}
+
+// IsEjected returns whether the member is currently ejected from the pool.
+func (b *Balancer) IsEjected(i int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.members[i].ejected
+}
@@ -0,0 +1,185 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errDial = errors.New("dial failed")
+
+func newTestOptions(strategy Strategy) Options {
+	return Options{
+		Strategy:        strategy,
+		MaxFailures:     2,
+		EjectionTime:    50 * time.Millisecond,
+		MaxEjectionTime: time.Second,
+	}
+}
+
+func TestPick_RoundRobin(t *testing.T) {
+	b, err := New(3, newTestOptions(RoundRobin))
+	require.NoError(t, err)
+
+	var picks []int
+	for range 6 {
+		picks = append(picks, b.Pick())
+	}
+	require.Equal(t, []int{0, 1, 2, 0, 1, 2}, picks)
+}
+
+func TestPick_WeightedRandom(t *testing.T) {
+	options := newTestOptions(WeightedRandom)
+	options.Weights = []int{1, 3}
+	b, err := New(2, options)
+	require.NoError(t, err)
+
+	counts := make([]int, 2)
+	for range 4000 {
+		counts[b.Pick()]++
+	}
+	require.InDelta(t, 1000, counts[0], 200)
+	require.InDelta(t, 3000, counts[1], 200)
+}
+
+func TestPick_LeastConnections(t *testing.T) {
+	b, err := New(3, newTestOptions(LeastConnections))
+	require.NoError(t, err)
+
+	picked := map[int]bool{}
+	for range 3 {
+		i := b.Pick()
+		b.Report(i, nil)
+		picked[i] = true
+	}
+	// Each member has one connection.
+	require.Len(t, picked, 3)
+
+	b.Release(1)
+	require.Equal(t, 1, b.Pick())
+	// Failed dials don't count as connections.
+	b.Report(1, errDial)
+	require.Equal(t, 1, b.Pick())
+}
+
+func TestEjection(t *testing.T) {
+	b, err := New(2, newTestOptions(RoundRobin))
+	require.NoError(t, err)
+
+	require.Equal(t, 0, b.Pick())
+	b.Report(0, errDial)
+	require.False(t, b.IsEjected(0))
+	require.Equal(t, 1, b.Pick())
+	b.Report(1, nil)
+	require.Equal(t, 0, b.Pick())
+	b.Report(0, errDial)
+	require.True(t, b.IsEjected(0))
+
+	// Only the healthy member is picked during the ejection.
+	for range 3 {
+		require.Equal(t, 1, b.Pick())
+	}
+
+	// After the ejection time, the member is readmitted on probation: a single failure ejects it again.
+	time.Sleep(75 * time.Millisecond)
+	require.Equal(t, 0, b.Pick())
+	require.False(t, b.IsEjected(0))
+	b.Report(0, errDial)
+	require.True(t, b.IsEjected(0))
+
+	// The second ejection is twice as long.
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, 1, b.Pick())
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, 0, b.Pick())
+	b.Report(0, nil)
+	require.False(t, b.IsEjected(0))
+}
+
+func TestEjection_AllEjected(t *testing.T) {
+	b, err := New(2, newTestOptions(RoundRobin))
+	require.NoError(t, err)
+	for range 4 {
+		b.Report(b.Pick(), errDial)
+	}
+	require.True(t, b.IsEjected(0))
+	require.True(t, b.IsEjected(1))
+
+	// All members are considered if they are all ejected.
+	require.Equal(t, 0, b.Pick())
+	require.Equal(t, 1, b.Pick())
+}
+
+func TestEjection_Probe(t *testing.T) {
+	options := newTestOptions(RoundRobin)
+	probeResults := make(chan error)
+	probed := make(chan int)
+	options.Probe = func(member int) error {
+		probed <- member
+		return <-probeResults
+	}
+	b, err := New(2, options)
+	require.NoError(t, err)
+	b.Report(0, errDial)
+	b.Report(0, errDial)
+	require.True(t, b.IsEjected(0))
+
+	// The probe is started by a pick after the ejection time, and the member stays ejected while probing.
+	time.Sleep(75 * time.Millisecond)
+	require.Equal(t, 1, b.Pick())
+	require.Equal(t, 0, <-probed)
+	require.True(t, b.IsEjected(0))
+	require.Equal(t, 1, b.Pick())
+
+	// A failed probe ejects the member again.
+	probeResults <- errDial
+	require.Eventually(t, func() bool { return b.IsEjected(0) }, time.Second, time.Millisecond)
+	time.Sleep(120 * time.Millisecond)
+	b.Pick()
+	require.Equal(t, 0, <-probed)
+
+	// A successful probe readmits it.
+	probeResults <- nil
+	require.Eventually(t, func() bool { return !b.IsEjected(0) }, time.Second, time.Millisecond)
+	b.Report(0, errDial)
+	require.False(t, b.IsEjected(0))
+}
+
+func TestNew_Errors(t *testing.T) {
+	_, err := New(0, newTestOptions(RoundRobin))
+	require.ErrorContains(t, err, "at least one member")
+
+	options := newTestOptions(WeightedRandom)
+	options.Weights = []int{1}
+	_, err = New(2, options)
+	require.ErrorContains(t, err, "got 1 weights for 2 members")
+
+	options.Weights = []int{1, 0}
+	_, err = New(2, options)
+	require.ErrorContains(t, err, "weight must be positive")
+
+	options = newTestOptions(Strategy(10))
+	_, err = New(1, options)
+	require.ErrorContains(t, err, "unsupported strategy")
+
+	options = newTestOptions(RoundRobin)
+	options.MaxEjectionTime = time.Millisecond
+	_, err = New(1, options)
+	require.ErrorContains(t, err, "max ejection time")
+}
@@ -35,8 +35,8 @@ import (
 // Client provides a transparent container for [transport.StreamDialer] and [transport.PacketListener]
 // that is exportable (as an opaque object) via gobind.
 // It's used by the connectivity test and the tun2socks handlers.
+// Configs can run a connectivity test on StartSession() with the first-working selector.
 // TODO(fortuna):
-//   - Add connectivity test to StartSession()
 //   - Add NotifyNetworkChange() method. Needs to hold a network.PacketProxy instead of config.PacketListener
 //     to handle that.
 //   - Refactor so that StartSession returns a Client
@@ -44,6 +44,7 @@ type Client struct {
 	sd            *config.Dialer[transport.StreamConn]
 	pp            *config.PacketProxy
 	reporter      reporting.Reporter
+	sessionHooks  *config.SessionHooks
 	sessionCancel context.CancelFunc
 }
 
@@ -67,6 +68,10 @@ func (c *Client) StartSession() error {
 	slog.Debug("Starting session")
 	var sessionCtx context.Context
 	sessionCtx, c.sessionCancel = context.WithCancel(context.Background())
+	if err := c.sessionHooks.Start(sessionCtx); err != nil {
+		c.sessionCancel()
+		return err
+	}
 	c.NotifyNetworkChanged()
 	if c.reporter != nil {
 		go c.reporter.Run(sessionCtx)
@@ -84,6 +89,8 @@ func (c *Client) EndSession() error {
 type ProviderClientConfig struct {
 	Transport configyaml.ConfigNode
 	Reporter  configyaml.ConfigNode
+	// Definitions are named configs that can be referenced with $ref from the transport and reporter configs.
+	Definitions map[string]configyaml.ConfigNode
 }
 
 // NewClientResult represents the result of [NewClientAndReturnError].
@@ -98,6 +105,11 @@ type NewClientResult struct {
 type ClientConfig struct {
 	DataDir         string
 	TransportParser *configyaml.TypeParser[*config.TransportPair]
+	// ClientVersion is the version of the app, e.g. "1.15.0", for configs that depend on it.
+	ClientVersion string
+	// NetworkType is an optional hint of the network the device is on, e.g. "wifi" or "cellular",
+	// for configs that depend on it. Since the network can change, the host sets it on each connect.
+	NetworkType string
 }
 
 // New creates a new session client. It's used by the native code, so it returns a NewClientResult.
@@ -131,47 +143,44 @@ func (c *ClientConfig) new(keyID string, providerClientConfigText string) (*Clie
 
 	var providerClientConfig ProviderClientConfig
 	if err := yaml.Unmarshal([]byte(providerClientConfigText), &providerClientConfig); err != nil {
-		return nil, &platerrors.PlatformError{
-			Code:    platerrors.InvalidConfig,
-			Message: "config is not valid YAML",
-			Cause:   platerrors.ToPlatformError(err),
-		}
+		return nil, newInvalidConfigError("config is not valid YAML", providerClientConfigText, err)
+	}
+
+	// TODO(fortuna): encapsulate service storage.
+	serviceDir := ""
+	if clientConfig.DataDir != "" {
+		serviceDir = path.Join(clientConfig.DataDir, "services", keyID)
 	}
 
-	transportPair, err := clientConfig.TransportParser.Parse(context.Background(), providerClientConfig.Transport)
+	sessionHooks := &config.SessionHooks{}
+	parseCtx := config.WithSessionHooks(context.Background(), sessionHooks)
+	parseCtx = config.WithServiceDataDir(parseCtx, serviceDir)
+	parseCtx = config.WithMatchEnvironment(parseCtx, config.MatchEnvironment{
+		ClientVersion: clientConfig.ClientVersion,
+		NetworkType:   clientConfig.NetworkType,
+	})
+	// Definitions are parsed once per client, so all references share the same objects.
+	parseCtx = configyaml.WithDefinitions(parseCtx, configyaml.NewDefinitions(providerClientConfig.Definitions))
+	transportPair, err := clientConfig.TransportParser.Parse(configyaml.WithPath(parseCtx, "transport"), providerClientConfig.Transport)
 	if err != nil {
 		if errors.Is(err, errors.ErrUnsupported) {
-			return nil, &platerrors.PlatformError{
-				Code:    platerrors.InvalidConfig,
-				Message: "unsupported config",
-				Cause:   platerrors.ToPlatformError(err),
-			}
+			return nil, newInvalidConfigError("unsupported config", providerClientConfigText, err)
 		} else {
-			return nil, &platerrors.PlatformError{
-				Code:    platerrors.InvalidConfig,
-				Message: "failed to create transport",
-				Cause:   platerrors.ToPlatformError(err),
-			}
+			return nil, newInvalidConfigError("failed to create transport", providerClientConfigText, err)
 		}
 	}
 
-	client := &Client{sd: transportPair.StreamDialer, pp: transportPair.PacketProxy}
+	client := &Client{sd: transportPair.StreamDialer, pp: transportPair.PacketProxy, sessionHooks: sessionHooks}
 
 	// TODO: figure out a better way to handle parse calls.
 	if providerClientConfig.Reporter != nil {
-		// TODO(fortuna): encapsulate service storage.
 		cookieFilename := ""
-		if c.DataDir != "" {
-			serviceDir := path.Join(c.DataDir, "services", keyID)
+		if serviceDir != "" {
 			cookieFilename = path.Join(serviceDir, "cookies.json")
 		}
-		reporter, err := NewReporterParser(cookieFilename, client).Parse(context.Background(), providerClientConfig.Reporter)
+		reporter, err := NewReporterParser(cookieFilename, client).Parse(configyaml.WithPath(parseCtx, "reporter"), providerClientConfig.Reporter)
 		if err != nil {
-			return nil, &platerrors.PlatformError{
-				Code:    platerrors.InvalidConfig,
-				Message: "invalid reporter config",
-				Cause:   platerrors.ToPlatformError(err),
-			}
+			return nil, newInvalidConfigError("invalid reporter config", providerClientConfigText, err)
 		}
 		client.reporter = reporter
 	}
@@ -179,6 +188,30 @@ func (c *ClientConfig) new(keyID string, providerClientConfigText string) (*Clie
 	return client, nil
 }
 
+// newInvalidConfigError creates the [platerrors.InvalidConfig] error for the given config parsing error.
+// When available, the details have the "path" of the config node that failed, like "transport.tcp.endpoint",
+// and its "line" and "column" in the config text.
+func newInvalidConfigError(message string, configText string, err error) *platerrors.PlatformError {
+	platErr := &platerrors.PlatformError{
+		Code:    platerrors.InvalidConfig,
+		Message: message,
+		Cause:   platerrors.ToPlatformError(err),
+	}
+	var parseErr *configyaml.ParseError
+	var yamlErr yaml.Error
+	if errors.As(err, &parseErr) {
+		platErr.Details = platerrors.ErrorDetails{"path": parseErr.Path}
+		if line, column, ok := configyaml.FindPosition(configText, parseErr.Path); ok {
+			platErr.Details["line"] = line
+			platErr.Details["column"] = column
+		}
+	} else if errors.As(err, &yamlErr) && yamlErr.GetToken() != nil {
+		position := yamlErr.GetToken().Position
+		platErr.Details = platerrors.ErrorDetails{"line": position.Line, "column": position.Column}
+	}
+	return platErr
+}
+
 func NewReporterParser(cookiesFilename string, streamDialer transport.StreamDialer) *configyaml.TypeParser[reporting.Reporter] {
 	parser := configyaml.NewTypeParser(func(ctx context.Context, input configyaml.ConfigNode) (reporting.Reporter, error) {
 		return nil, errors.New("parser not specified")
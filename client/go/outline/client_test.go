@@ -16,7 +16,12 @@ package outline
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"os"
 	"path"
 	"testing"
@@ -25,6 +30,8 @@ import (
 	"localhost/client/go/configyaml"
 	"localhost/client/go/outline/config"
 	"localhost/client/go/outline/reporting"
+	"golang.getoutline.org/sdk/network"
+	"golang.getoutline.org/sdk/transport"
 	"github.com/stretchr/testify/require"
 )
 
@@ -205,6 +212,91 @@ transport:
 	require.Equal(t, "example.com:53", result.Client.pp.FirstHop)
 }
 
+func Test_NewTransport_Definitions(t *testing.T) {
+	config := `
+definitions:
+  proxy:
+    $type: shadowsocks
+    endpoint: example.com:4321
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET
+  proxy_endpoint:
+    $type: dial
+    address: example.com:4321
+    dialer:
+      $ref: proxy
+transport:
+  $type: tcpudp
+  tcp:
+    $type: split
+    sizes: [1]
+    dialer: {$ref: proxy}
+  udp:
+    $type: shadowsocks
+    endpoint: {$ref: proxy_endpoint}
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET`
+
+	result := (&ClientConfig{}).New("", config)
+	require.Nil(t, result.Error, "Got %v", result.Error)
+	require.Equal(t, "example.com:4321", result.Client.sd.FirstHop)
+	require.Equal(t, "example.com:4321", result.Client.pp.FirstHop)
+}
+
+func Test_NewTransport_Definitions_Cycle(t *testing.T) {
+	config := `
+definitions:
+  a:
+    $type: split
+    sizes: [1]
+    dialer: {$ref: b}
+  b:
+    $type: tlsfrag
+    length: 5
+    dialer: {$ref: a}
+transport:
+  $type: tcpudp
+  tcp: {$ref: a}`
+
+	result := (&ClientConfig{}).New("", config)
+	require.NotNil(t, result.Error)
+	require.Equal(t, "ERR_INVALID_CONFIG", result.Error.Code)
+	require.ErrorContains(t, result.Error, `definition "a" has a cyclic reference`)
+}
+
+func Test_NewTransport_ErrorLocation(t *testing.T) {
+	config := `
+transport:
+  $type: tcpudp
+  tcp:
+    $type: iptable
+    table:
+      - ips: [10.0.0.0/8]
+        dialer: {$type: direct}
+      - ips: [192.168.0.0/16]
+        dialer:
+          $type: shadowsocks
+          endpoint: example.com:4321
+          cipher: chacha20-ietf-poly1305
+          secret: SECRET
+          prefx: "POST "`
+
+	result := (&ClientConfig{}).New("", config)
+	require.NotNil(t, result.Error)
+	require.Equal(t, "ERR_INVALID_CONFIG", result.Error.Code)
+	require.Equal(t, "transport.tcp.table[1].dialer.prefx", result.Error.Details["path"])
+	require.Equal(t, 15, result.Error.Details["line"])
+	require.Equal(t, 18, result.Error.Details["column"])
+}
+
+func Test_NewTransport_InvalidYAMLLocation(t *testing.T) {
+	result := (&ClientConfig{}).New("", "transport:\n  tcp: [unclosed\n")
+	require.NotNil(t, result.Error)
+	require.Equal(t, "ERR_INVALID_CONFIG", result.Error.Code)
+	require.Contains(t, result.Error.Details, "line")
+	require.Contains(t, result.Error.Details, "column")
+}
+
 func Test_NewTransport_Unsupported(t *testing.T) {
 	config := `transport: {$type: unsupported}`
 	result := (&ClientConfig{}).New("", config)
@@ -423,3 +515,70 @@ interval: 24h`
 	_, err = NewReporterParser("", nil).Parse(context.Background(), yamlNode)
 	require.Error(t, err)
 }
+
+// echoPacketProxy is a [network.PacketProxy] that sends the packets back as if they came from their destination.
+// Sessions fail if udp is false.
+type echoPacketProxy struct {
+	udp bool
+}
+
+type echoPacketSender struct {
+	resp network.PacketResponseReceiver
+}
+
+func (p echoPacketProxy) NewSession(resp network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	if !p.udp {
+		return nil, errors.New("UDP is not supported")
+	}
+	return &echoPacketSender{resp}, nil
+}
+
+func (s *echoPacketSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	return s.resp.WriteFrom(p, net.UDPAddrFromAddrPort(destination))
+}
+
+func (s *echoPacketSender) Close() error {
+	return s.resp.Close()
+}
+
+func Test_FirstWorking_DefaultDataDir(t *testing.T) {
+	userDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", userDir)
+	t.Setenv("HOME", userDir)
+	t.Setenv("AppData", userDir)
+	configDir, err := os.UserConfigDir()
+	require.NoError(t, err)
+
+	// The fake transport sends all TCP connections to a local HTTP server, and echoes UDP packets if udp is true.
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer httpServer.Close()
+	transportParser := config.NewDefaultTransportProvider(&transport.TCPDialer{}, &transport.UDPDialer{})
+	transportParser.RegisterSubParser("fake", func(ctx context.Context, input map[string]any) (*config.TransportPair, error) {
+		var tcpDialer transport.TCPDialer
+		dial := func(ctx context.Context, address string) (transport.StreamConn, error) {
+			return tcpDialer.DialStream(ctx, httpServer.Listener.Addr().String())
+		}
+		pp := echoPacketProxy{udp: input["udp"] == true}
+		return &config.TransportPair{
+			StreamDialer: &config.Dialer[transport.StreamConn]{ConnectionProviderInfo: config.ConnectionProviderInfo{ConnType: config.ConnTypeTunneled}, Dial: dial},
+			PacketProxy:  &config.PacketProxy{ConnectionProviderInfo: config.ConnectionProviderInfo{ConnType: config.ConnTypeTunneled}, PacketProxy: pp},
+		}, nil
+	})
+
+	result := (&ClientConfig{TransportParser: transportParser}).New("key", `
+transport:
+  $type: first-working
+  options:
+    - {$type: fake, udp: false}
+    - {$type: fake, udp: true}`)
+	require.Nil(t, result.Error, "Got %v", result.Error)
+	require.NoError(t, result.Client.StartSession())
+	defer result.Client.EndSession()
+
+	// The second option works for UDP, so it's selected and persisted in the default data dir.
+	data, err := os.ReadFile(path.Join(configDir, "org.getoutline.client", "services", "key", "first_working.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"transport": 1}`, string(data))
+}
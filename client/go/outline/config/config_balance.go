@@ -0,0 +1,189 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/balance"
+	"localhost/client/go/outline/connectivity"
+	"golang.getoutline.org/sdk/transport"
+)
+
+const (
+	defaultBalanceMaxFailures     = 3
+	defaultBalanceEjectionTime    = 30 * time.Second
+	defaultBalanceMaxEjectionTime = 5 * time.Minute
+)
+
+// BalanceConfig is the format for the Balance Dialer config.
+type BalanceConfig struct {
+	// Options is the pool of equivalent dialers to spread the connections across.
+	Options []configyaml.ConfigNode
+	// Strategy is one of "random" (the default), "round-robin" or "least-connections".
+	Strategy string
+	// Weights are the relative weights of the options for the "random" strategy.
+	Weights []int
+	// Max_Failures is the number of consecutive dial failures that ejects an option. Defaults to 3.
+	Max_Failures int
+	// Ejection_Time is how long an option is first ejected for. Defaults to 30s.
+	Ejection_Time string
+	// Max_Ejection_Time is the limit for the ejection time, which doubles on each consecutive
+	// ejection. Defaults to 5m.
+	Max_Ejection_Time string
+}
+
+func NewBalanceStreamDialerSubParser(parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		probe := func(sd *Dialer[transport.StreamConn]) error {
+			return connectivity.CheckTCPConnectivity(transport.FuncStreamDialer(sd.Dial))
+		}
+		return parseBalanceDialer(ctx, input, parseSD, probe, newReleaseOnCloseStreamConn)
+	}
+}
+
+func NewBalancePacketDialerSubParser(parsePD configyaml.ParseFunc[*Dialer[net.Conn]]) func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
+		// There's no connectivity check for Packet Dialers, so ejected options are readmitted on probation.
+		return parseBalanceDialer(ctx, input, parsePD, nil, newReleaseOnClosePacketConn)
+	}
+}
+
+func parseBalanceStrategy(strategy string) (balance.Strategy, error) {
+	switch strategy {
+	case "", "random":
+		return balance.WeightedRandom, nil
+	case "round-robin":
+		return balance.RoundRobin, nil
+	case "least-connections":
+		return balance.LeastConnections, nil
+	default:
+		return 0, fmt.Errorf("unsupported strategy %q", strategy)
+	}
+}
+
+// parseBalanceDialer creates a balance Dialer. The probe function checks the connectivity of ejected
+// options and may be nil. The track function wraps the connections to call release when they are closed.
+func parseBalanceDialer[ConnType any](ctx context.Context, configMap map[string]any, parseD configyaml.ParseFunc[*Dialer[ConnType]],
+	probe func(*Dialer[ConnType]) error, track func(conn ConnType, release func()) ConnType) (*Dialer[ConnType], error) {
+	var config BalanceConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if len(config.Options) == 0 {
+		return nil, errors.New("empty list of options")
+	}
+	strategy, err := parseBalanceStrategy(config.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Weights) != 0 && strategy != balance.WeightedRandom {
+		return nil, errors.New("weights are only supported by the random strategy")
+	}
+	ejectionTime, err := parseDurationOrDefault(config.Ejection_Time, defaultBalanceEjectionTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ejection_time: %w", err)
+	}
+	maxEjectionTime, err := parseDurationOrDefault(config.Max_Ejection_Time, max(defaultBalanceMaxEjectionTime, ejectionTime))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse max_ejection_time: %w", err)
+	}
+	if config.Max_Failures == 0 {
+		config.Max_Failures = defaultBalanceMaxFailures
+	}
+
+	dialers := make([]*Dialer[ConnType], 0, len(config.Options))
+	connTypes := newConnTypeAggregator()
+	for i, optionConfig := range config.Options {
+		dialer, err := parseD(configyaml.WithPath(ctx, fmt.Sprintf("options[%d]", i)), optionConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse option %d: %w", i, err)
+		}
+		if dialer == nil {
+			return nil, fmt.Errorf("option %d is not available", i)
+		}
+		dialers = append(dialers, dialer)
+		connTypes.add(dialer.ConnType)
+	}
+	connType := connTypes.connType()
+
+	options := balance.Options{
+		Strategy:        strategy,
+		Weights:         config.Weights,
+		MaxFailures:     config.Max_Failures,
+		EjectionTime:    ejectionTime,
+		MaxEjectionTime: maxEjectionTime,
+	}
+	if probe != nil {
+		options.Probe = func(member int) error { return probe(dialers[member]) }
+	}
+	balancer, err := balance.New(len(dialers), options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid balance config: %w", err)
+	}
+
+	firstHop, firstHops := dialersFirstHops(dialers)
+	dial := func(ctx context.Context, address string) (ConnType, error) {
+		member := balancer.Pick()
+		conn, err := dialers[member].Dial(ctx, address)
+		if err != nil && ctx.Err() != nil {
+			// Don't hold cancelations against the option.
+			balancer.Release(member)
+			return conn, err
+		}
+		balancer.Report(member, err)
+		if err != nil {
+			return conn, fmt.Errorf("option %d failed: %w", member, err)
+		}
+		return track(conn, func() { balancer.Release(member) }), nil
+	}
+	return &Dialer[ConnType]{ConnectionProviderInfo{connType, firstHop, firstHops}, dial}, nil
+}
+
+type releaseOnCloseStreamConn struct {
+	transport.StreamConn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func newReleaseOnCloseStreamConn(conn transport.StreamConn, release func()) transport.StreamConn {
+	return &releaseOnCloseStreamConn{StreamConn: conn, release: release}
+}
+
+func (c *releaseOnCloseStreamConn) Close() error {
+	c.releaseOnce.Do(c.release)
+	return c.StreamConn.Close()
+}
+
+type releaseOnClosePacketConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func newReleaseOnClosePacketConn(conn net.Conn, release func()) net.Conn {
+	return &releaseOnClosePacketConn{Conn: conn, release: release}
+}
+
+func (c *releaseOnClosePacketConn) Close() error {
+	c.releaseOnce.Do(c.release)
+	return c.Conn.Close()
+}
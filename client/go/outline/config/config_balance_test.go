@@ -0,0 +1,199 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func parseTestBalanceDialer(t *testing.T, fakes *fakeDialers, configText string, probe func(*Dialer[transport.StreamConn]) error) *Dialer[transport.StreamConn] {
+	node, err := configyaml.ParseConfigYAML(configText)
+	require.NoError(t, err)
+	dialer, err := parseBalanceDialer(context.Background(), node.(map[string]any), fakes.parse, probe, newReleaseOnCloseStreamConn)
+	require.NoError(t, err)
+	return dialer
+}
+
+func TestParseBalanceDialer_RoundRobin(t *testing.T) {
+	fakes := newFakeDialers()
+	dialer := parseTestBalanceDialer(t, fakes, `
+strategy: round-robin
+options: [b, a, c]`, nil)
+	require.Equal(t, ConnTypeTunneled, dialer.ConnType)
+	require.Equal(t, "", dialer.FirstHop)
+	require.Equal(t, []string{"a:443", "b:443", "c:443"}, dialer.FirstHops)
+
+	for range 4 {
+		_, err := dialer.Dial(context.Background(), "example.com:443")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"b", "a", "c", "b"}, fakes.takeCalls())
+}
+
+func TestParseBalanceDialer_Ejection(t *testing.T) {
+	fakes := newFakeDialers()
+	fakes.setFailing("a", true)
+	probed := make(chan string, 1)
+	dialer := parseTestBalanceDialer(t, fakes, `
+strategy: round-robin
+max_failures: 2
+ejection_time: 1h
+options: [a, b]`, func(d *Dialer[transport.StreamConn]) error {
+		probed <- d.FirstHop
+		return errors.New("probe failed")
+	})
+
+	for range 4 {
+		dialer.Dial(context.Background(), "example.com:443")
+	}
+	require.Equal(t, []string{"a", "b", "a", "b"}, fakes.takeCalls())
+
+	// The failing option is ejected.
+	for range 2 {
+		_, err := dialer.Dial(context.Background(), "example.com:443")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"b", "b"}, fakes.takeCalls())
+	require.Empty(t, probed)
+}
+
+func TestParseBalanceDialer_LeastConnections(t *testing.T) {
+	fakes := newFakeDialers()
+	node, err := configyaml.ParseConfigYAML(`
+strategy: least-connections
+options: [a, b]`)
+	require.NoError(t, err)
+	parse := func(ctx context.Context, input configyaml.ConfigNode) (*Dialer[net.Conn], error) {
+		sd, err := fakes.parse(ctx, input)
+		if err != nil || sd == nil {
+			return nil, err
+		}
+		return &Dialer[net.Conn]{sd.ConnectionProviderInfo, func(ctx context.Context, address string) (net.Conn, error) {
+			_, err := sd.Dial(ctx, address)
+			client, server := net.Pipe()
+			server.Close()
+			return client, err
+		}}, nil
+	}
+	dialer, err := parseBalanceDialer(context.Background(), node.(map[string]any), parse, nil, newReleaseOnClosePacketConn)
+	require.NoError(t, err)
+
+	conn1, err := dialer.Dial(context.Background(), "example.com:53")
+	require.NoError(t, err)
+	conn2, err := dialer.Dial(context.Background(), "example.com:53")
+	require.NoError(t, err)
+	calls := fakes.takeCalls()
+	require.ElementsMatch(t, []string{"a", "b"}, calls)
+
+	// Closing a connection makes its option the least loaded.
+	require.NoError(t, conn1.Close())
+	require.NoError(t, conn1.Close())
+	_, err = dialer.Dial(context.Background(), "example.com:53")
+	require.NoError(t, err)
+	require.Equal(t, calls[:1], fakes.takeCalls())
+	conn2.Close()
+}
+
+func TestParseBalanceDialer_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		errMessage string
+	}{
+		{"no options", `options: []`, "empty list of options"},
+		{"unavailable option", `options: [a, unavailable]`, "option 1 is not available"},
+		{"invalid strategy", `{options: [a], strategy: fastest}`, `unsupported strategy "fastest"`},
+		{"weights with round-robin", `{options: [a], strategy: round-robin, weights: [1]}`, "weights are only supported by the random strategy"},
+		{"wrong number of weights", `{options: [a, b], weights: [1]}`, "got 1 weights for 2 members"},
+		{"negative max_failures", `{options: [a], max_failures: -1}`, "max failures must be positive"},
+		{"invalid ejection_time", `{options: [a], ejection_time: soon}`, "failed to parse ejection_time"},
+		{"short max_ejection_time", `{options: [a], ejection_time: 1m, max_ejection_time: 1s}`, "max ejection time 1s must not be less than ejection time 1m0s"},
+		{"unknown field", `{options: [a], timeout: 1s}`, "invalid config format"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML(tc.config)
+			require.NoError(t, err)
+			_, err = parseBalanceDialer(context.Background(), node.(map[string]any), newFakeDialers().parse, nil, newReleaseOnCloseStreamConn)
+			require.ErrorContains(t, err, tc.errMessage)
+		})
+	}
+}
+
+func TestMergeFirstHops(t *testing.T) {
+	info := func(firstHop string, firstHops ...string) ConnectionProviderInfo {
+		return ConnectionProviderInfo{ConnType: ConnTypeTunneled, FirstHop: firstHop, FirstHops: firstHops}
+	}
+	firstHop, firstHops := MergeFirstHops(info("a:1"), info("a:1"))
+	require.Equal(t, "a:1", firstHop)
+	require.Nil(t, firstHops)
+
+	firstHop, firstHops = MergeFirstHops(info("b:2"), info("a:1"), info("b:2"))
+	require.Equal(t, "", firstHop)
+	require.Equal(t, []string{"a:1", "b:2"}, firstHops)
+
+	// Providers without a first hop are ignored, and nested sets are flattened.
+	firstHop, firstHops = MergeFirstHops(info("a:1"), info(""), info("", "b:2", "c:3"))
+	require.Equal(t, "", firstHop)
+	require.Equal(t, []string{"a:1", "b:2", "c:3"}, firstHops)
+
+	firstHop, firstHops = MergeFirstHops(info(""))
+	require.Equal(t, "", firstHop)
+	require.Nil(t, firstHops)
+}
+
+func TestRegisterBalance(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: balance
+  options:
+    - $type: shadowsocks
+      endpoint: server1.example.com:1234
+      cipher: chacha20-ietf-poly1305
+      secret: SECRET
+    - $type: shadowsocks
+      endpoint: server2.example.com:1234
+      cipher: chacha20-ietf-poly1305
+      secret: SECRET
+udp:
+  $type: shadowsocks
+  endpoint:
+    $type: dial
+    address: server.example.com:1234
+    dialer:
+      $type: balance
+      strategy: round-robin
+      options: [{$type: direct}, {$type: direct}]
+  cipher: chacha20-ietf-poly1305
+  secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "", transportPair.StreamDialer.FirstHop)
+	require.Equal(t, []string{"server1.example.com:1234", "server2.example.com:1234"}, transportPair.StreamDialer.FirstHops)
+	require.Equal(t, ConnTypeTunneled, transportPair.PacketProxy.ConnType)
+}
@@ -48,7 +48,7 @@ func parseDirectDialerEndpoint[ConnType any](ctx context.Context, config any, ne
 		return nil, err
 	}
 
-	dialer, err := newDialer(ctx, dialParams.Dialer)
+	dialer, err := newDialer(configyaml.WithPath(ctx, "dialer"), dialParams.Dialer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create sub-dialer: %w", err)
 	}
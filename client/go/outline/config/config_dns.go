@@ -0,0 +1,181 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"localhost/client/go/outline/dnsintercept"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// DNSConfig is the format for the DNS config of a transport. It specifies the resolvers that answer the
+// DNS queries intercepted by the VPN.
+type DNSConfig struct {
+	// Resolvers are the resolvers to select from. Defaults to the Outline resolvers.
+	Resolvers []DNSResolverConfig
+	// Policy is how to select the resolver: "random" (the default), "failover" or "fastest".
+	Policy string
+	// Fake_IP enables the fake IP mode, where A and AAAA queries are answered with fake IPs, and the
+	// connections to fake IPs are dialed by domain name.
+	Fake_IP bool
+}
+
+// DNSResolverConfig is the format for a DNS resolver.
+type DNSResolverConfig struct {
+	// Address is the IP address or host name of the resolver. UDP resolvers require an IP address.
+	// DoH resolvers may also use an https URL.
+	Address string
+	// Port is the resolver port. Defaults to 53 for udp and tcp, 443 for doh, and 853 for dot.
+	Port uint16
+	// Protocol is one of "udp" (the default), "tcp", "doh" or "dot".
+	Protocol string
+}
+
+// dnsResolvers are the resolvers of a transport, split by the kind of DNS traffic they can handle.
+type dnsResolvers struct {
+	// stream are the resolvers for DNS over TCP.
+	stream *dnsintercept.ResolverSet
+	// upstreams are the upstreams for the stream resolvers, by index.
+	upstreams []dnsintercept.Upstream
+	// packet are the resolvers for DNS over UDP. It's nil if there are none.
+	packet *dnsintercept.ResolverSet
+	// encrypted is whether the stream resolvers are DoH or DoT resolvers, which also answer DNS over UDP.
+	encrypted bool
+}
+
+func parseDNSPolicy(policy string) (dnsintercept.ResolverPolicy, error) {
+	switch policy {
+	case "", "random":
+		return dnsintercept.RandomPolicy, nil
+	case "failover":
+		return dnsintercept.FailoverPolicy, nil
+	case "fastest":
+		return dnsintercept.FastestPolicy, nil
+	default:
+		return 0, fmt.Errorf("unsupported policy %q", policy)
+	}
+}
+
+// newDNSResolvers creates the resolvers for the DNS config. A nil config, or one without resolvers, uses the
+// Outline resolvers.
+// The upstreams connect with the given StreamDialer.
+func newDNSResolvers(config *DNSConfig, sd transport.StreamDialer) (*dnsResolvers, error) {
+	if config == nil {
+		config = &DNSConfig{}
+	}
+	if len(config.Resolvers) == 0 {
+		config = &DNSConfig{Policy: config.Policy}
+		for _, addr := range outlineDNSResolvers {
+			config.Resolvers = append(config.Resolvers, DNSResolverConfig{Address: addr.Addr().String(), Port: addr.Port()})
+		}
+	}
+	policy, err := parseDNSPolicy(config.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var streamAddrs, packetAddrs, encryptedAddrs []string
+	var streamUpstreams, encryptedUpstreams []dnsintercept.Upstream
+	for i, resolver := range config.Resolvers {
+		if resolver.Address == "" {
+			return nil, fmt.Errorf("resolver %d: address must not be empty", i)
+		}
+		switch resolver.Protocol {
+		case "", "udp":
+			ip, err := netip.ParseAddr(resolver.Address)
+			if err != nil {
+				return nil, fmt.Errorf("resolver %d: udp resolver address must be an IP address: %w", i, err)
+			}
+			addr := netip.AddrPortFrom(ip, portOrDefault(resolver.Port, 53)).String()
+			// Plain DNS resolvers also serve DNS over TCP.
+			upstream, err := dnsintercept.NewTCPUpstream(sd, addr)
+			if err != nil {
+				return nil, fmt.Errorf("resolver %d: %w", i, err)
+			}
+			streamAddrs = append(streamAddrs, addr)
+			streamUpstreams = append(streamUpstreams, upstream)
+			packetAddrs = append(packetAddrs, addr)
+		case "tcp":
+			addr := net.JoinHostPort(resolver.Address, strconv.Itoa(int(portOrDefault(resolver.Port, 53))))
+			upstream, err := dnsintercept.NewTCPUpstream(sd, addr)
+			if err != nil {
+				return nil, fmt.Errorf("resolver %d: %w", i, err)
+			}
+			streamAddrs = append(streamAddrs, addr)
+			streamUpstreams = append(streamUpstreams, upstream)
+		case "doh":
+			resolverURL := resolver.Address
+			if strings.HasPrefix(resolverURL, "https://") {
+				if resolver.Port != 0 {
+					return nil, fmt.Errorf("resolver %d: port must not be set with a URL address", i)
+				}
+			} else {
+				resolverURL = "https://" + net.JoinHostPort(resolver.Address, strconv.Itoa(int(portOrDefault(resolver.Port, 443)))) + "/dns-query"
+			}
+			upstream, err := dnsintercept.NewDoHUpstream(sd, resolverURL)
+			if err != nil {
+				return nil, fmt.Errorf("resolver %d: %w", i, err)
+			}
+			encryptedAddrs = append(encryptedAddrs, resolverURL)
+			encryptedUpstreams = append(encryptedUpstreams, upstream)
+		case "dot":
+			addr := net.JoinHostPort(resolver.Address, strconv.Itoa(int(portOrDefault(resolver.Port, 853))))
+			upstream, err := dnsintercept.NewDoTUpstream(sd, addr, resolver.Address)
+			if err != nil {
+				return nil, fmt.Errorf("resolver %d: %w", i, err)
+			}
+			encryptedAddrs = append(encryptedAddrs, addr)
+			encryptedUpstreams = append(encryptedUpstreams, upstream)
+		default:
+			return nil, fmt.Errorf("resolver %d: unsupported protocol %q", i, resolver.Protocol)
+		}
+	}
+
+	resolvers := &dnsResolvers{}
+	if len(encryptedAddrs) > 0 {
+		if len(streamAddrs) > 0 {
+			return nil, errors.New("doh and dot resolvers can't be combined with udp and tcp resolvers")
+		}
+		if resolvers.stream, err = dnsintercept.NewResolverSet(encryptedAddrs, policy); err != nil {
+			return nil, err
+		}
+		resolvers.upstreams = encryptedUpstreams
+		resolvers.encrypted = true
+		return resolvers, nil
+	}
+	if resolvers.stream, err = dnsintercept.NewResolverSet(streamAddrs, policy); err != nil {
+		return nil, err
+	}
+	resolvers.upstreams = streamUpstreams
+	if len(packetAddrs) > 0 {
+		if resolvers.packet, err = dnsintercept.NewResolverSet(packetAddrs, policy); err != nil {
+			return nil, err
+		}
+	}
+	return resolvers, nil
+}
+
+func portOrDefault(port uint16, defaultPort uint16) uint16 {
+	if port == 0 {
+		return defaultPort
+	}
+	return port
+}
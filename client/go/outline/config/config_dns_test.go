@@ -0,0 +1,283 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDNSResolvers_Default(t *testing.T) {
+	resolvers, err := newDNSResolvers(nil, &transport.TCPDialer{})
+	require.NoError(t, err)
+	require.Equal(t, len(outlineDNSResolvers), resolvers.stream.Len())
+	require.Equal(t, len(outlineDNSResolvers), resolvers.packet.Len())
+
+	resolvers, err = newDNSResolvers(&DNSConfig{Policy: "failover"}, &transport.TCPDialer{})
+	require.NoError(t, err)
+	require.Equal(t, len(outlineDNSResolvers), resolvers.packet.Len())
+	_, addr := resolvers.packet.Select()
+	require.Equal(t, outlineDNSResolvers[0].String(), addr)
+}
+
+func TestNewDNSResolvers_Protocols(t *testing.T) {
+	resolvers, err := newDNSResolvers(&DNSConfig{
+		Policy: "failover",
+		Resolvers: []DNSResolverConfig{
+			{Address: "dns.example", Protocol: "tcp"},
+			{Address: "9.9.9.9", Port: 5353},
+			{Address: "2620:fe::fe", Protocol: "udp"},
+		},
+	}, &transport.TCPDialer{})
+	require.NoError(t, err)
+
+	require.False(t, resolvers.encrypted)
+	require.Equal(t, 3, resolvers.stream.Len())
+	require.Len(t, resolvers.upstreams, 3)
+	_, addr := resolvers.stream.Select()
+	require.Equal(t, "dns.example:53", addr)
+
+	require.Equal(t, 2, resolvers.packet.Len())
+	_, addr = resolvers.packet.Select()
+	require.Equal(t, "9.9.9.9:5353", addr)
+	resolvers.packet.ReportFailure(0)
+	_, addr = resolvers.packet.Select()
+	require.Equal(t, "[2620:fe::fe]:53", addr)
+}
+
+func TestNewDNSResolvers_TCPOnly(t *testing.T) {
+	resolvers, err := newDNSResolvers(&DNSConfig{
+		Resolvers: []DNSResolverConfig{{Address: "1.1.1.1", Protocol: "tcp"}},
+	}, &transport.TCPDialer{})
+	require.NoError(t, err)
+	require.Equal(t, 1, resolvers.stream.Len())
+	require.Nil(t, resolvers.packet)
+}
+
+func TestNewDNSResolvers_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config DNSConfig
+	}{
+		{"bad policy", DNSConfig{Policy: "closest", Resolvers: []DNSResolverConfig{{Address: "1.1.1.1"}}}},
+		{"no address", DNSConfig{Resolvers: []DNSResolverConfig{{Protocol: "tcp"}}}},
+		{"udp host name", DNSConfig{Resolvers: []DNSResolverConfig{{Address: "dns.example"}}}},
+		{"bad protocol", DNSConfig{Resolvers: []DNSResolverConfig{{Address: "1.1.1.1", Protocol: "quic"}}}},
+		{"doh url with port", DNSConfig{Resolvers: []DNSResolverConfig{{Address: "https://dns.example/dns-query", Port: 443, Protocol: "doh"}}}},
+		{"doh http url", DNSConfig{Resolvers: []DNSResolverConfig{{Address: "http://dns.example/dns-query", Protocol: "doh"}}}},
+		{"mixed protocols", DNSConfig{Resolvers: []DNSResolverConfig{{Address: "1.1.1.1"}, {Address: "1.1.1.1", Protocol: "dot"}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newDNSResolvers(&tc.config, &transport.TCPDialer{})
+			require.Error(t, err)
+			require.False(t, errors.Is(err, errors.ErrUnsupported))
+		})
+	}
+}
+
+func TestNewDNSResolvers_Encrypted(t *testing.T) {
+	resolvers, err := newDNSResolvers(&DNSConfig{
+		Policy: "failover",
+		Resolvers: []DNSResolverConfig{
+			{Address: "dns.example", Protocol: "doh"},
+			{Address: "https://dns.example/custom-path", Protocol: "doh"},
+			{Address: "1.1.1.1", Port: 8853, Protocol: "dot"},
+		},
+	}, &transport.TCPDialer{})
+	require.NoError(t, err)
+	require.True(t, resolvers.encrypted)
+	require.Nil(t, resolvers.packet)
+	require.Equal(t, 3, resolvers.stream.Len())
+	require.Len(t, resolvers.upstreams, 3)
+	_, addr := resolvers.stream.Select()
+	require.Equal(t, "https://dns.example:443/dns-query", addr)
+	resolvers.stream.ReportFailure(0)
+	_, addr = resolvers.stream.Select()
+	require.Equal(t, "https://dns.example/custom-path", addr)
+	resolvers.stream.ReportFailure(1)
+	_, addr = resolvers.stream.Select()
+	require.Equal(t, "1.1.1.1:8853", addr)
+}
+
+func TestParseTCPUDP_DNS(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: direct
+udp:
+  $type: direct
+dns:
+  policy: fastest
+  resolvers:
+    - address: 9.9.9.9
+    - address: dns.example
+      protocol: tcp`)
+	require.NoError(t, err)
+
+	transportPair, err := newTestTransportProvider().Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.NotNil(t, transportPair.StreamDialer)
+	require.NotNil(t, transportPair.PacketProxy)
+	require.NotNil(t, transportPair.PacketProxy.NotifyNetworkChanged)
+}
+
+func TestParseTCPUDP_DNSTCPOnly(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: direct
+udp:
+  $type: direct
+dns:
+  resolvers:
+    - address: 9.9.9.9
+      protocol: tcp`)
+	require.NoError(t, err)
+
+	transportPair, err := newTestTransportProvider().Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.NotNil(t, transportPair.PacketProxy)
+	// The DNS cache is flushed when the network changes.
+	require.NotNil(t, transportPair.PacketProxy.NotifyNetworkChanged)
+}
+
+func TestParseTCPUDP_DNSLogsCacheStats(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: direct
+udp:
+  $type: direct`)
+	require.NoError(t, err)
+	hooks := &SessionHooks{}
+	ctx := WithSessionHooks(configyaml.WithPath(context.Background(), "transport"), hooks)
+	_, err = newTestTransportProvider().Parse(ctx, node)
+	require.NoError(t, err)
+
+	records := recordLogs(t)
+	sessionCtx, endSession := context.WithCancel(context.Background())
+	require.NoError(t, hooks.Start(sessionCtx))
+	// The stats are logged when the session ends.
+	endSession()
+	record := <-records
+	require.Equal(t, "DNS cache stats", record.Message)
+	require.Equal(t, slog.LevelInfo, record.Level)
+	attrs := make(map[string]slog.Value)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value
+		return true
+	})
+	require.Equal(t, "transport", attrs["path"].String())
+	require.Equal(t, uint64(0), attrs["misses"].Uint64())
+}
+
+func TestParseTCPUDP_DNSEncrypted(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: direct
+udp:
+  $type: direct
+dns:
+  resolvers:
+    - address: dns.example
+      protocol: doh
+    - address: dns.example
+      protocol: dot`)
+	require.NoError(t, err)
+
+	transportPair, err := newTestTransportProvider().Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.NotNil(t, transportPair.StreamDialer)
+	require.NotNil(t, transportPair.PacketProxy)
+	// The DNS cache is flushed when the network changes.
+	require.NotNil(t, transportPair.PacketProxy.NotifyNetworkChanged)
+}
+
+func TestParseShadowsocksTransport_DNS(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+endpoint: example.com:1234
+cipher: chacha20-ietf-poly1305
+secret: SECRET
+dns:
+  policy: failover
+  resolvers: [{address: 9.9.9.9}]`)
+	require.NoError(t, err)
+
+	transportPair, err := newTestTransportProvider().Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, "example.com:1234", transportPair.StreamDialer.FirstHop)
+	require.Equal(t, "example.com:1234", transportPair.PacketProxy.FirstHop)
+
+	node, err = configyaml.ParseConfigYAML(`
+endpoint: example.com:1234
+cipher: chacha20-ietf-poly1305
+secret: SECRET
+dns:
+  resolvers: [{address: dns.example}]`)
+	require.NoError(t, err)
+	_, err = newTestTransportProvider().Parse(context.Background(), node)
+	require.ErrorContains(t, err, "invalid DNS config")
+}
+
+func TestNormalizeTransportConfig_DNS(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+endpoint: example.com:1234
+cipher: chacha20-ietf-poly1305
+secret: SECRET
+dns:
+  resolvers: [{address: 9.9.9.9}]`)
+	require.NoError(t, err)
+
+	normalized, err := NormalizeTransportConfig(node)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"$type": "tcpudp",
+		"tcp":   map[string]any{"$type": "shadowsocks", "endpoint": "example.com:1234", "cipher": "chacha20-ietf-poly1305", "secret": "SECRET"},
+		"udp":   map[string]any{"$type": "shadowsocks", "endpoint": "example.com:1234", "cipher": "chacha20-ietf-poly1305", "secret": "SECRET"},
+		"dns":   node.(map[string]any)["dns"],
+	}, normalized)
+
+	// The DNS config can't be represented in a URL.
+	_, ok := SimpleShadowsocksConfig(node)
+	require.False(t, ok)
+}
+
+func TestParseTCPUDP_DNSFakeIP(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: direct
+udp:
+  $type: direct
+dns:
+  fake_ip: true`)
+	require.NoError(t, err)
+
+	transportPair, err := newTestTransportProvider().Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeDirect, transportPair.StreamDialer.ConnType)
+	require.NotNil(t, transportPair.PacketProxy.NotifyNetworkChanged)
+
+	// Fake IPs that are not assigned can't be dialed.
+	_, err = transportPair.StreamDialer.Dial(context.Background(), "198.18.0.1:443")
+	require.ErrorContains(t, err, "not assigned")
+}
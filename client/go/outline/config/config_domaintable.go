@@ -0,0 +1,170 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/domaintable"
+	"golang.getoutline.org/sdk/transport"
+)
+
+type domainTableRootConfig struct {
+	Table    []domainTableEntryConfig `yaml:"table"`
+	Fallback configyaml.ConfigNode    `yaml:"fallback,omitempty"`
+}
+
+type domainTableEntryConfig struct {
+	// Domains are exact domain names (e.g. "example.com") or wildcard suffixes (e.g. "*.example.com").
+	Domains []string `yaml:"domains,omitempty"`
+	// Keywords match any domain that contains them.
+	Keywords []string              `yaml:"keywords,omitempty"`
+	Dialer   configyaml.ConfigNode `yaml:"dialer"`
+}
+
+type parsedDomainTable[V any] struct {
+	table    domaintable.DomainTable[V]
+	fallback V
+	connType ConnType
+}
+
+// parseDomainTable parses the table and fallback of a domaintable config, and computes the aggregated [ConnType].
+// The kind describes the nested dialers in error messages (e.g. "stream dialer").
+func parseDomainTable[ConnType any, V any](
+	ctx context.Context,
+	configMap map[string]any,
+	kind string,
+	parse configyaml.ParseFunc[*Dialer[ConnType]],
+	convert func(*Dialer[ConnType]) V,
+) (*parsedDomainTable[V], error) {
+	var rootCfg domainTableRootConfig
+	if err := configyaml.MapToAny(configMap, &rootCfg); err != nil {
+		return nil, fmt.Errorf("failed to map domaintable %v config: %w", kind, err)
+	}
+
+	if len(rootCfg.Table) == 0 {
+		return nil, fmt.Errorf("domaintable config 'table' must not be empty for %v", kind)
+	}
+
+	connTypes := newConnTypeAggregator()
+
+	result := &parsedDomainTable[V]{table: domaintable.NewDomainTable[V]()}
+	for i, entryCfg := range rootCfg.Table {
+		if entryCfg.Dialer == nil {
+			return nil, fmt.Errorf("domaintable entry %d has no dialer specified", i)
+		}
+		if len(entryCfg.Domains) == 0 && len(entryCfg.Keywords) == 0 {
+			return nil, fmt.Errorf("domaintable entry %d has no domains or keywords specified", i)
+		}
+
+		parsedSubDialer, err := parse(configyaml.WithPath(ctx, fmt.Sprintf("table[%d].dialer", i)), entryCfg.Dialer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse nested %v for table entry %d: %w", kind, i, err)
+		}
+		connTypes.add(parsedSubDialer.ConnType)
+
+		sub := convert(parsedSubDialer)
+		for _, domain := range entryCfg.Domains {
+			if err := result.table.AddDomain(domain, sub); err != nil {
+				return nil, fmt.Errorf("domaintable entry %d has invalid domain '%s': %w", i, domain, err)
+			}
+		}
+		for _, keyword := range entryCfg.Keywords {
+			if err := result.table.AddKeyword(keyword, sub); err != nil {
+				return nil, fmt.Errorf("domaintable entry %d has invalid keyword '%s': %w", i, keyword, err)
+			}
+		}
+	}
+
+	if rootCfg.Fallback != nil {
+		parsedFallbackDialer, err := parse(configyaml.WithPath(ctx, "fallback"), rootCfg.Fallback)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse nested %v fallback: %w", kind, err)
+		}
+		connTypes.add(parsedFallbackDialer.ConnType)
+		result.fallback = convert(parsedFallbackDialer)
+	}
+
+	connType := connTypes.connType()
+	result.connType = connType
+	return result, nil
+}
+
+func parseDomainTableStreamDialer(
+	ctx context.Context,
+	configMap map[string]any,
+	parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]],
+) (*Dialer[transport.StreamConn], error) {
+	parsed, err := parseDomainTable(ctx, configMap, "stream dialer", parseSD,
+		func(d *Dialer[transport.StreamConn]) transport.StreamDialer {
+			return transport.FuncStreamDialer(d.Dial)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := domaintable.NewStreamDialer(parsed.table, parsed.fallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DomainTableStreamDialer: %w", err)
+	}
+
+	return &Dialer[transport.StreamConn]{
+		Dial: dialer.DialStream,
+		ConnectionProviderInfo: ConnectionProviderInfo{
+			ConnType: parsed.connType,
+		},
+	}, nil
+}
+
+func parseDomainTablePacketDialer(
+	ctx context.Context,
+	configMap map[string]any,
+	parsePD configyaml.ParseFunc[*Dialer[net.Conn]],
+) (*Dialer[net.Conn], error) {
+	parsed, err := parseDomainTable(ctx, configMap, "packet dialer", parsePD,
+		func(d *Dialer[net.Conn]) transport.PacketDialer {
+			return transport.FuncPacketDialer(d.Dial)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := domaintable.NewPacketDialer(parsed.table, parsed.fallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DomainTablePacketDialer: %w", err)
+	}
+
+	return &Dialer[net.Conn]{
+		Dial: dialer.DialPacket,
+		ConnectionProviderInfo: ConnectionProviderInfo{
+			ConnType: parsed.connType,
+		},
+	}, nil
+}
+
+func NewDomainTableStreamDialerSubParser(parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		return parseDomainTableStreamDialer(ctx, input, parseSD)
+	}
+}
+
+func NewDomainTablePacketDialerSubParser(parsePD configyaml.ParseFunc[*Dialer[net.Conn]]) func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
+		return parseDomainTablePacketDialer(ctx, input, parsePD)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDomainTableStreamDialer(t *testing.T) {
+	ctx := context.Background()
+
+	parseSD := func(ctx context.Context, config configyaml.ConfigNode) (*Dialer[transport.StreamConn], error) {
+		configMap, ok := config.(map[string]any)
+		if !ok {
+			return nil, errors.New("config is not a map[string]any")
+		}
+		name, _ := configMap["name"].(string)
+		connType := ConnTypeTunneled
+		switch name {
+		case "direct":
+			connType = ConnTypeDirect
+		case "block":
+			connType = ConnTypeBlocked
+		case "":
+			return nil, errors.New("mock dialer config must have a 'name'")
+		}
+		return &Dialer[transport.StreamConn]{Dial: (&errorStreamDialer{name: name}).DialStream, ConnectionProviderInfo: ConnectionProviderInfo{ConnType: connType}}, nil
+	}
+
+	testCases := []struct {
+		name             string
+		configYAML       string
+		expectErr        string
+		checkDialer      func(*testing.T, *Dialer[transport.StreamConn])
+		expectedConnType ConnType
+	}{
+		{
+			name: "Happy Path - domains, wildcards and keywords",
+			configYAML: `
+table:
+  - domains:
+      - example.com
+    dialer: {name: exact}
+  - domains:
+      - "*.example.com"
+    dialer: {name: wildcard}
+  - keywords:
+      - google
+    dialer: {name: keyword}
+fallback: {name: default}
+`,
+			checkDialer: func(t *testing.T, dialer *Dialer[transport.StreamConn]) {
+				_, err := dialer.Dial(ctx, "example.com:443")
+				require.ErrorContains(t, err, "dialer 'exact' called for address 'example.com:443'")
+
+				_, err = dialer.Dial(ctx, "www.example.com:443")
+				require.ErrorContains(t, err, "dialer 'wildcard' called for address 'www.example.com:443'")
+
+				_, err = dialer.Dial(ctx, "www.google.com:443")
+				require.ErrorContains(t, err, "dialer 'keyword' called for address 'www.google.com:443'")
+
+				_, err = dialer.Dial(ctx, "8.8.8.8:53")
+				require.ErrorContains(t, err, "dialer 'default' called for address '8.8.8.8:53'")
+			},
+			expectedConnType: ConnTypeTunneled,
+		},
+		{
+			name: "Happy Path - direct fallback",
+			configYAML: `
+table:
+  - domains: ["*.blocked.example"]
+    dialer: {name: proxy}
+fallback: {name: direct}
+`,
+			expectedConnType: ConnTypePartial,
+		},
+		{
+			name: "Happy Path - partial blocked",
+			configYAML: `
+table:
+  - keywords: [ads]
+    dialer: {name: block}
+fallback: {name: direct}
+`,
+			expectedConnType: ConnTypeDirect,
+		},
+		{
+			name: "Error - no fallback",
+			configYAML: `
+table:
+  - domains: [example.com]
+    dialer: {name: direct}
+`,
+			checkDialer: func(t *testing.T, dialer *Dialer[transport.StreamConn]) {
+				_, err := dialer.Dial(ctx, "other.com:443")
+				require.ErrorContains(t, err, "no dialer available for address other.com:443")
+			},
+			expectedConnType: ConnTypeDirect,
+		},
+		{
+			name:       "Error - empty table",
+			configYAML: `table: []`,
+			expectErr:  "domaintable config 'table' must not be empty for stream dialer",
+		},
+		{
+			name: "Error - missing dialer",
+			configYAML: `
+table:
+  - domains: [example.com]`,
+			expectErr: "domaintable entry 0 has no dialer specified",
+		},
+		{
+			name: "Error - missing rules",
+			configYAML: `
+table:
+  - dialer: {name: direct}`,
+			expectErr: "domaintable entry 0 has no domains or keywords specified",
+		},
+		{
+			name: "Error - invalid domain",
+			configYAML: `
+table:
+  - domains: ["www.*.example.com"]
+    dialer: {name: direct}`,
+			expectErr: "domaintable entry 0 has invalid domain 'www.*.example.com'",
+		},
+		{
+			name: "Error - nested dialer fails",
+			configYAML: `
+table:
+  - domains: [example.com]
+    dialer: {}`,
+			expectErr: "failed to parse nested stream dialer for table entry 0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML(tc.configYAML)
+			require.NoError(t, err)
+
+			dialer, err := parseDomainTableStreamDialer(ctx, node.(map[string]any), parseSD)
+			if tc.expectErr != "" {
+				require.ErrorContains(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedConnType, dialer.ConnType)
+			if tc.checkDialer != nil {
+				tc.checkDialer(t, dialer)
+			}
+		})
+	}
+}
+
+func TestParseDomainTableTCP(t *testing.T) {
+	tp := NewDefaultTransportProvider(&errorStreamDialer{name: "default-tcp"}, nil)
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: domaintable
+  table:
+    - domains: ["*.example.com"]
+      dialer:
+        $type: shadowsocks
+        endpoint: proxy.example.net:1234
+        cipher: chacha20-ietf-poly1305
+        secret: SECRET
+  fallback:
+    $type: block
+udp: null`)
+	require.NoError(t, err)
+
+	transportPair, err := tp.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+
+	_, err = transportPair.DialStream(context.Background(), "www.example.com:443")
+	require.ErrorContains(t, err, "dialer 'default-tcp' called for address 'proxy.example.net:1234'")
+
+	_, err = transportPair.DialStream(context.Background(), "other.org:443")
+	require.ErrorContains(t, err, "blocked by config")
+}
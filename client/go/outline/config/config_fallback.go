@@ -0,0 +1,175 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"localhost/client/go/configyaml"
+)
+
+const (
+	defaultFallbackDialTimeout = 5 * time.Second
+	defaultFallbackRememberFor = 5 * time.Minute
+)
+
+// FallbackConfig is the format for the Fallback Dialer config. Packet Dialers don't wait for a response to
+// connect, so packet options only fall back on local dial errors, not on unreachable servers.
+type FallbackConfig struct {
+	// Options is the list of dialers to try, in order of preference.
+	Options []configyaml.ConfigNode
+	// Dial_Timeout is the timeout for each dial attempt. Defaults to 5s.
+	Dial_Timeout string
+	// Remember_For is how long to keep using the last working option before trying the
+	// preferred options again. Defaults to 5m.
+	Remember_For string
+}
+
+func NewFallbackDialerSubParser[ConnType any](parseD configyaml.ParseFunc[*Dialer[ConnType]]) func(ctx context.Context, input map[string]any) (*Dialer[ConnType], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[ConnType], error) {
+		return parseFallbackDialer(ctx, input, parseD)
+	}
+}
+
+func parseFallbackDialer[ConnType any](ctx context.Context, configMap map[string]any, parseD configyaml.ParseFunc[*Dialer[ConnType]]) (*Dialer[ConnType], error) {
+	var config FallbackConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if len(config.Options) == 0 {
+		return nil, errors.New("empty list of options")
+	}
+	dialTimeout, err := parseDurationOrDefault(config.Dial_Timeout, defaultFallbackDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dial_timeout: %w", err)
+	}
+	rememberFor, err := parseDurationOrDefault(config.Remember_For, defaultFallbackRememberFor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remember_for: %w", err)
+	}
+
+	dialers := make([]*Dialer[ConnType], 0, len(config.Options))
+	connTypes := newConnTypeAggregator()
+	for i, optionConfig := range config.Options {
+		dialer, err := parseD(configyaml.WithPath(ctx, fmt.Sprintf("options[%d]", i)), optionConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse option %d: %w", i, err)
+		}
+		if dialer == nil {
+			return nil, fmt.Errorf("option %d is not available", i)
+		}
+		dialers = append(dialers, dialer)
+		connTypes.add(dialer.ConnType)
+	}
+	connType := connTypes.connType()
+
+	fd := &fallbackDialer[ConnType]{
+		dialers:     dialers,
+		dialTimeout: dialTimeout,
+		rememberFor: rememberFor,
+	}
+	firstHop, firstHops := dialersFirstHops(dialers)
+	return &Dialer[ConnType]{ConnectionProviderInfo{connType, firstHop, firstHops}, fd.Dial}, nil
+}
+
+// fallbackDialer tries its dialers in order until one succeeds.
+type fallbackDialer[ConnType any] struct {
+	dialers     []*Dialer[ConnType]
+	dialTimeout time.Duration
+	rememberFor time.Duration
+
+	mu sync.Mutex
+	// remembered is the index of the last working fallback option, valid until rememberedUntil.
+	remembered      int
+	rememberedUntil time.Time
+}
+
+// order returns the indices of the dialers in the order to try them.
+func (d *fallbackDialer[ConnType]) order() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	order := make([]int, 0, len(d.dialers))
+	if time.Now().Before(d.rememberedUntil) {
+		order = append(order, d.remembered)
+	}
+	for i := range d.dialers {
+		if len(order) == 0 || order[0] != i {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// update records the result of a dial with the given option.
+func (d *fallbackDialer[ConnType]) update(option int, success bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	isRemembered := time.Now().Before(d.rememberedUntil) && d.remembered == option
+	switch {
+	case success && option != 0 && !isRemembered:
+		// Don't extend the period on further successes, so we eventually retry the preferred options.
+		d.remembered = option
+		d.rememberedUntil = time.Now().Add(d.rememberFor)
+	case !success && isRemembered:
+		d.rememberedUntil = time.Time{}
+	}
+}
+
+func (d *fallbackDialer[ConnType]) Dial(ctx context.Context, address string) (ConnType, error) {
+	var zero ConnType
+	var errs []error
+	for _, option := range d.order() {
+		if ctx.Err() != nil {
+			return zero, context.Cause(ctx)
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, d.dialTimeout)
+		conn, err := d.dialers[option].Dial(dialCtx, address)
+		cancel()
+		d.update(option, err == nil)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("option %d failed: %w", option, err))
+	}
+	return zero, fmt.Errorf("all fallback options failed: %w", errors.Join(errs...))
+}
+
+// dialersFirstHops returns the FirstHop and FirstHops of a dialer that connects through any of the dialers.
+func dialersFirstHops[ConnType any](dialers []*Dialer[ConnType]) (string, []string) {
+	infos := make([]ConnectionProviderInfo, 0, len(dialers))
+	for _, dialer := range dialers {
+		infos = append(infos, dialer.ConnectionProviderInfo)
+	}
+	return MergeFirstHops(infos...)
+}
+
+// parseDurationOrDefault parses the duration text, returning defaultValue if it's empty.
+func parseDurationOrDefault(text string, defaultValue time.Duration) (time.Duration, error) {
+	if text == "" {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("duration must not be negative, found %v", duration)
+	}
+	return duration, nil
+}
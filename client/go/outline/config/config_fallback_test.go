@@ -0,0 +1,238 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDialers is a set of named test dialers that record their calls and can be made to fail.
+type fakeDialers struct {
+	mu      sync.Mutex
+	calls   []string
+	failing map[string]bool
+	hanging map[string]bool
+}
+
+func newFakeDialers() *fakeDialers {
+	return &fakeDialers{failing: make(map[string]bool), hanging: make(map[string]bool)}
+}
+
+func (f *fakeDialers) setFailing(name string, failing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing[name] = failing
+}
+
+func (f *fakeDialers) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeDialers) dial(ctx context.Context, name string) (transport.StreamConn, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, name)
+	failing, hanging := f.failing[name], f.hanging[name]
+	f.mu.Unlock()
+	if hanging {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if failing {
+		return nil, fmt.Errorf("dialer %v failed", name)
+	}
+	return nil, nil
+}
+
+// parse parses a string node into a dialer with that name and first hop.
+func (f *fakeDialers) parse(ctx context.Context, input configyaml.ConfigNode) (*Dialer[transport.StreamConn], error) {
+	name, ok := input.(string)
+	if !ok {
+		return nil, errors.New("fake dialer must be a string")
+	}
+	if name == "unavailable" {
+		return nil, nil
+	}
+	return &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnType: ConnTypeTunneled, FirstHop: name + ":443"}, func(ctx context.Context, address string) (transport.StreamConn, error) {
+		return f.dial(ctx, name)
+	}}, nil
+}
+
+func parseTestFallbackDialer(t *testing.T, fakes *fakeDialers, configText string) *Dialer[transport.StreamConn] {
+	node, err := configyaml.ParseConfigYAML(configText)
+	require.NoError(t, err)
+	dialer, err := parseFallbackDialer(context.Background(), node.(map[string]any), fakes.parse)
+	require.NoError(t, err)
+	return dialer
+}
+
+func TestParseFallbackDialer(t *testing.T) {
+	fakes := newFakeDialers()
+	dialer := parseTestFallbackDialer(t, fakes, `
+options: [a, b, c]`)
+	require.Equal(t, ConnTypeTunneled, dialer.ConnType)
+	require.Equal(t, "", dialer.FirstHop)
+	require.Equal(t, []string{"a:443", "b:443", "c:443"}, dialer.FirstHops)
+
+	_, err := dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, fakes.takeCalls())
+
+	fakes.setFailing("a", true)
+	fakes.setFailing("b", true)
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, fakes.takeCalls())
+
+	fakes.setFailing("c", true)
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.ErrorContains(t, err, "all fallback options failed")
+	require.ErrorContains(t, err, "dialer b failed")
+}
+
+func TestParseFallbackDialer_Remember(t *testing.T) {
+	fakes := newFakeDialers()
+	dialer := parseTestFallbackDialer(t, fakes, `
+options: [a, b, c]
+remember_for: 50ms`)
+
+	fakes.setFailing("a", true)
+	_, err := dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, fakes.takeCalls())
+
+	// The last working option is tried first.
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, fakes.takeCalls())
+
+	// A failure of the remembered option resets the order.
+	fakes.setFailing("b", true)
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a", "c"}, fakes.takeCalls())
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, fakes.takeCalls())
+
+	// The preferred options are retried after the remember period.
+	fakes.setFailing("a", false)
+	time.Sleep(60 * time.Millisecond)
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, fakes.takeCalls())
+}
+
+func TestParseFallbackDialer_DialTimeout(t *testing.T) {
+	fakes := newFakeDialers()
+	fakes.hanging["a"] = true
+	dialer := parseTestFallbackDialer(t, fakes, `
+options: [a, b]
+dial_timeout: 10ms`)
+
+	_, err := dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, fakes.takeCalls())
+}
+
+func TestParseFallbackDialer_Canceled(t *testing.T) {
+	fakes := newFakeDialers()
+	fakes.hanging["a"] = true
+	dialer := parseTestFallbackDialer(t, fakes, `
+options: [a, b]`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := dialer.Dial(ctx, "example.com:443")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, []string{"a"}, fakes.takeCalls())
+}
+
+func TestParseFallbackDialer_SameFirstHop(t *testing.T) {
+	dialer := parseTestFallbackDialer(t, newFakeDialers(), `
+options: [a, a]`)
+	require.Equal(t, "a:443", dialer.FirstHop)
+}
+
+func TestParseFallbackDialer_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		errMessage string
+	}{
+		{"no options", `options: []`, "empty list of options"},
+		{"unavailable option", `options: [a, unavailable]`, "option 1 is not available"},
+		{"invalid option", `options: [a, {b: c}]`, "failed to parse option 1"},
+		{"invalid timeout", `{options: [a], dial_timeout: soon}`, "failed to parse dial_timeout"},
+		{"negative remember", `{options: [a], remember_for: -1s}`, "failed to parse remember_for"},
+		{"unknown field", `{options: [a], retries: 3}`, "invalid config format"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML(tc.config)
+			require.NoError(t, err)
+			_, err = parseFallbackDialer(context.Background(), node.(map[string]any), newFakeDialers().parse)
+			require.ErrorContains(t, err, tc.errMessage)
+		})
+	}
+}
+
+func TestRegisterFallback(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: fallback
+  options:
+    - $type: shadowsocks
+      endpoint: example.com:1234
+      cipher: chacha20-ietf-poly1305
+      secret: SECRET
+    - $type: shadowsocks
+      endpoint: example.com:1234
+      cipher: chacha20-ietf-poly1305
+      secret: OTHER_SECRET
+udp:
+  $type: shadowsocks
+  endpoint:
+    $type: dial
+    address: example.com:1234
+    dialer:
+      $type: fallback
+      options: [{$type: direct}, {$type: block}]
+  cipher: chacha20-ietf-poly1305
+  secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "example.com:1234", transportPair.StreamDialer.FirstHop)
+	require.Equal(t, ConnTypeTunneled, transportPair.PacketProxy.ConnType)
+	require.Equal(t, "example.com:1234", transportPair.PacketProxy.FirstHop)
+}
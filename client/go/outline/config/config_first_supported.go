@@ -43,8 +43,8 @@ func parseFirstSupported[Output any](ctx context.Context, configMap map[string]a
 		return zero, errors.New("empty list of options")
 	}
 
-	for _, ec := range config.Options {
-		endpoint, err := parseE(ctx, ec)
+	for i, ec := range config.Options {
+		endpoint, err := parseE(configyaml.WithPath(ctx, fmt.Sprintf("options[%d]", i)), ec)
 		if errors.Is(err, errors.ErrUnsupported) {
 			continue
 		}
@@ -0,0 +1,454 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/connectivity"
+	"golang.getoutline.org/sdk/network"
+	"golang.getoutline.org/sdk/transport"
+)
+
+const defaultFirstWorkingTimeout = 10 * time.Second
+
+// firstWorkingFilename is the file in the service data directory where the selected options are persisted.
+const firstWorkingFilename = "first_working.json"
+
+// FirstWorkingConfig is the format for the First Working Transport config.
+type FirstWorkingConfig struct {
+	// Options are the candidate transports, in order of preference.
+	Options []configyaml.ConfigNode
+	// Timeout is the time budget for the connectivity tests. Defaults to 10s.
+	Timeout string
+}
+
+type serviceDataDirKey struct{}
+
+// WithServiceDataDir returns a context with the directory where configs can persist state for the service.
+func WithServiceDataDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, serviceDataDirKey{}, dir)
+}
+
+func serviceDataDirFromContext(ctx context.Context) string {
+	dir, _ := ctx.Value(serviceDataDirKey{}).(string)
+	return dir
+}
+
+// checkTransportConnectivity tests whether the transport can relay TCP and UDP traffic.
+var checkTransportConnectivity = func(tp *TransportPair) (tcpErr error, udpErr error) {
+	return connectivity.CheckTCPAndUDPConnectivity(tp, &packetProxyListener{tp.PacketProxy})
+}
+
+func NewFirstWorkingTransportPairSubParser(parseTP configyaml.ParseFunc[*TransportPair]) func(ctx context.Context, input map[string]any) (*TransportPair, error) {
+	return func(ctx context.Context, input map[string]any) (*TransportPair, error) {
+		return parseFirstWorkingTransportPair(ctx, input, parseTP)
+	}
+}
+
+func parseFirstWorkingTransportPair(ctx context.Context, configMap map[string]any, parseTP configyaml.ParseFunc[*TransportPair]) (*TransportPair, error) {
+	var config FirstWorkingConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if len(config.Options) == 0 {
+		return nil, errors.New("empty list of options")
+	}
+	timeout, err := parseDurationOrDefault(config.Timeout, defaultFirstWorkingTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse timeout: %w", err)
+	}
+
+	// Unsupported options are skipped, like in first-supported.
+	var candidates []*TransportPair
+	var candidateOptions []int
+	for i, optionConfig := range config.Options {
+		tp, err := parseTP(configyaml.WithPath(ctx, fmt.Sprintf("options[%d]", i)), optionConfig)
+		if errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse option %d: %w", i, err)
+		}
+		candidates = append(candidates, tp)
+		candidateOptions = append(candidateOptions, i)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no supported option found: %w", errors.ErrUnsupported)
+	}
+
+	selector := &firstWorkingSelector{
+		candidates: candidates,
+		options:    candidateOptions,
+		timeout:    timeout,
+		configPath: configyaml.PathFromContext(ctx),
+	}
+	if dir := serviceDataDirFromContext(ctx); dir != "" {
+		selector.storeFilename = path.Join(dir, firstWorkingFilename)
+	}
+	selector.selected = selector.loadWinner()
+	if hooks := sessionHooksFromContext(ctx); hooks != nil {
+		hooks.OnStart(selector.selectTransport)
+	}
+
+	sdInfos := make([]ConnectionProviderInfo, 0, len(candidates))
+	ppInfos := make([]ConnectionProviderInfo, 0, len(candidates))
+	sdConnTypes := newConnTypeAggregator()
+	ppConnTypes := newConnTypeAggregator()
+	for _, tp := range candidates {
+		sdInfos = append(sdInfos, tp.StreamDialer.ConnectionProviderInfo)
+		ppInfos = append(ppInfos, tp.PacketProxy.ConnectionProviderInfo)
+		sdConnTypes.add(tp.StreamDialer.ConnType)
+		ppConnTypes.add(tp.PacketProxy.ConnType)
+	}
+	sdConnType := sdConnTypes.connType()
+	ppConnType := ppConnTypes.connType()
+	sdFirstHop, sdFirstHops := MergeFirstHops(sdInfos...)
+	ppFirstHop, ppFirstHops := MergeFirstHops(ppInfos...)
+
+	dial := func(ctx context.Context, address string) (transport.StreamConn, error) {
+		return selector.current().StreamDialer.Dial(ctx, address)
+	}
+	onNetworkChanged := func() {
+		if notify := selector.current().PacketProxy.NotifyNetworkChanged; notify != nil {
+			notify()
+		}
+	}
+	return &TransportPair{
+		&Dialer[transport.StreamConn]{ConnectionProviderInfo{sdConnType, sdFirstHop, sdFirstHops}, dial},
+		&PacketProxy{ConnectionProviderInfo{ppConnType, ppFirstHop, ppFirstHops}, selector, onNetworkChanged},
+	}, nil
+}
+
+// firstWorkingSelector picks the transport to use among the candidates. Until the session starts,
+// it uses the last winner, or the first candidate if there's none.
+type firstWorkingSelector struct {
+	candidates []*TransportPair
+	// options are the indices of the candidates in the config options.
+	options       []int
+	timeout       time.Duration
+	configPath    string
+	storeFilename string
+
+	mu       sync.Mutex
+	selected int
+}
+
+var _ network.PacketProxy = (*firstWorkingSelector)(nil)
+
+func (s *firstWorkingSelector) current() *TransportPair {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.candidates[s.selected]
+}
+
+// NewSession implements [network.PacketProxy], using the transport selected when the session is created.
+func (s *firstWorkingSelector) NewSession(resp network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	return s.current().PacketProxy.NewSession(resp)
+}
+
+type firstWorkingResult struct {
+	rank   int
+	tcpErr error
+	udpErr error
+}
+
+// selectTransport tests all the candidates concurrently and selects the first one in order of preference
+// that works, starting with the current selection. Candidates that only work for TCP are only used if no
+// candidate works for both TCP and UDP. If no candidate works within the time budget, it keeps the current
+// selection.
+func (s *firstWorkingSelector) selectTransport(ctx context.Context) error {
+	s.mu.Lock()
+	selected := s.selected
+	s.mu.Unlock()
+
+	order := make([]int, 0, len(s.candidates))
+	order = append(order, selected)
+	for i := range s.candidates {
+		if i != selected {
+			order = append(order, i)
+		}
+	}
+
+	check := checkTransportConnectivity
+	results := make(chan firstWorkingResult, len(order))
+	for rank, candidate := range order {
+		go func() {
+			tcpErr, udpErr := check(s.candidates[candidate])
+			results <- firstWorkingResult{rank, tcpErr, udpErr}
+		}()
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	// The health of each candidate by rank: -1 is pending, 0 is not working, 1 is TCP only, and 2 is TCP and UDP.
+	health := slices.Repeat([]int{-1}, len(order))
+	firstErrs := make([]error, len(order))
+wait:
+	for pending := len(order); pending > 0; pending-- {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-timer.C:
+			slog.Warn("first-working connectivity tests timed out", "path", s.configPath, "timeout", s.timeout)
+			break wait
+		case result := <-results:
+			switch {
+			case result.tcpErr != nil:
+				health[result.rank] = 0
+				firstErrs[result.rank] = result.tcpErr
+			case result.udpErr != nil:
+				health[result.rank] = 1
+				firstErrs[result.rank] = result.udpErr
+			default:
+				health[result.rank] = 2
+			}
+			if bestRank(health, true) != -1 {
+				break wait
+			}
+		}
+	}
+
+	winnerRank := bestRank(health, false)
+	if winnerRank == -1 {
+		slog.Warn("no first-working option passed the connectivity test", "path", s.configPath, "err", firstErrs[0])
+		return nil
+	}
+	winner := order[winnerRank]
+	slog.Info("selected first-working option", "path", s.configPath, "option", s.options[winner], "udp", health[winnerRank] == 2)
+	s.mu.Lock()
+	s.selected = winner
+	s.mu.Unlock()
+	if winner != selected {
+		s.storeWinner(winner)
+	}
+	return nil
+}
+
+// bestRank returns the rank of the first candidate that works for TCP and UDP, or of the first candidate that
+// works for TCP if there's none. If final is true, it only returns a candidate if the pending results can't
+// change the outcome. It returns -1 if there's no candidate.
+func bestRank(health []int, final bool) int {
+	tcpOnly := -1
+	for rank, h := range health {
+		switch {
+		case h == 2:
+			return rank
+		case h == 1 && tcpOnly == -1:
+			tcpOnly = rank
+		case h == -1 && final:
+			return -1
+		}
+	}
+	return tcpOnly
+}
+
+// loadWinner returns the index of the candidate that won last time, or zero.
+func (s *firstWorkingSelector) loadWinner() int {
+	winners := s.loadWinners()
+	option, ok := winners[s.configPath]
+	if !ok {
+		return 0
+	}
+	if candidate := slices.Index(s.options, option); candidate != -1 {
+		return candidate
+	}
+	return 0
+}
+
+// loadWinners returns the winner option by config path.
+func (s *firstWorkingSelector) loadWinners() map[string]int {
+	winners := make(map[string]int)
+	if s.storeFilename == "" {
+		return winners
+	}
+	data, err := os.ReadFile(s.storeFilename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to read first-working selections", "err", err)
+		}
+		return winners
+	}
+	if err := json.Unmarshal(data, &winners); err != nil {
+		slog.Warn("failed to parse first-working selections", "err", err)
+		return make(map[string]int)
+	}
+	return winners
+}
+
+func (s *firstWorkingSelector) storeWinner(candidate int) {
+	if s.storeFilename == "" {
+		return
+	}
+	winners := s.loadWinners()
+	winners[s.configPath] = s.options[candidate]
+	data, err := json.Marshal(winners)
+	if err != nil {
+		slog.Warn("failed to encode first-working selections", "err", err)
+		return
+	}
+	if err := os.MkdirAll(path.Dir(s.storeFilename), 0700); err != nil {
+		slog.Warn("failed to create service data directory", "err", err)
+		return
+	}
+	if err := os.WriteFile(s.storeFilename, data, 0600); err != nil {
+		slog.Warn("failed to write first-working selections", "err", err)
+	}
+}
+
+// packetProxyListener is a [transport.PacketListener] that sends packets through a [network.PacketProxy].
+// It allows for testing the UDP connectivity of a [TransportPair].
+type packetProxyListener struct {
+	proxy network.PacketProxy
+}
+
+var _ transport.PacketListener = (*packetProxyListener)(nil)
+
+func (l *packetProxyListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn := &packetProxyConn{packets: make(chan proxyPacket, 16), closed: make(chan struct{})}
+	sender, err := l.proxy.NewSession(&packetProxyReceiver{conn})
+	if err != nil {
+		return nil, err
+	}
+	conn.sender = sender
+	return conn, nil
+}
+
+type proxyPacket struct {
+	payload []byte
+	source  net.Addr
+}
+
+// packetProxyConn is the [net.PacketConn] for a [network.PacketProxy] session.
+// The read deadline is only applied when a read starts.
+type packetProxyConn struct {
+	sender    network.PacketRequestSender
+	packets   chan proxyPacket
+	closeOnce sync.Once
+	doneOnce  sync.Once
+	closed    chan struct{}
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+var _ net.PacketConn = (*packetProxyConn)(nil)
+
+// packetProxyReceiver is the [network.PacketResponseReceiver] that delivers the responses to a [packetProxyConn].
+type packetProxyReceiver struct {
+	conn *packetProxyConn
+}
+
+var _ network.PacketResponseReceiver = (*packetProxyReceiver)(nil)
+
+// WriteFrom implements [network.PacketResponseReceiver]. Packets are dropped if the reader can't keep up.
+func (r *packetProxyReceiver) WriteFrom(p []byte, source net.Addr) (int, error) {
+	select {
+	case <-r.conn.closed:
+		return 0, net.ErrClosed
+	case r.conn.packets <- proxyPacket{slices.Clone(p), source}:
+	default:
+	}
+	return len(p), nil
+}
+
+// Close implements [network.PacketResponseReceiver]. It's called by the proxy when the session ends.
+func (r *packetProxyReceiver) Close() error {
+	r.conn.markDone()
+	return nil
+}
+
+func (c *packetProxyConn) markDone() {
+	c.doneOnce.Do(func() { close(c.closed) })
+}
+
+func (c *packetProxyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case packet := <-c.packets:
+		return copy(p, packet.payload), packet.source, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *packetProxyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	var destination netip.AddrPort
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		destination = udpAddr.AddrPort()
+	} else {
+		var err error
+		if destination, err = netip.ParseAddrPort(addr.String()); err != nil {
+			return 0, err
+		}
+	}
+	return c.sender.WriteTo(p, destination)
+}
+
+func (c *packetProxyConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.markDone()
+		err = c.sender.Close()
+	})
+	return err
+}
+
+func (c *packetProxyConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{}
+}
+
+func (c *packetProxyConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetProxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline is a no-op, since writes don't block.
+func (c *packetProxyConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
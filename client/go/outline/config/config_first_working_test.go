@@ -0,0 +1,186 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/network"
+	"github.com/stretchr/testify/require"
+)
+
+const firstWorkingTestConfig = `
+$type: first-working
+timeout: 1s
+options:
+  - ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@a.example.com:443
+  - $type: unsupported
+  - ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@b.example.com:443
+  - ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@c.example.com:443`
+
+// setTestConnectivity replaces the connectivity test with one that reports the health of each first hop.
+// Missing first hops fail for TCP. Delays make the test take longer for the given hop.
+func setTestConnectivity(t *testing.T, health map[string]int, delays map[string]time.Duration) {
+	original := checkTransportConnectivity
+	t.Cleanup(func() { checkTransportConnectivity = original })
+	checkTransportConnectivity = func(tp *TransportPair) (error, error) {
+		hop := tp.StreamDialer.FirstHop
+		time.Sleep(delays[hop])
+		switch health[hop] {
+		case 2:
+			return nil, nil
+		case 1:
+			return nil, errors.New("udp failed")
+		default:
+			return errors.New("tcp failed"), errors.New("udp failed")
+		}
+	}
+}
+
+func parseFirstWorkingTest(t *testing.T, serviceDir string) (*TransportPair, *SessionHooks) {
+	node, err := configyaml.ParseConfigYAML(firstWorkingTestConfig)
+	require.NoError(t, err)
+	hooks := &SessionHooks{}
+	ctx := WithServiceDataDir(WithSessionHooks(context.Background(), hooks), serviceDir)
+	tp, err := newTestTransportProvider().Parse(configyaml.WithPath(ctx, "transport"), node)
+	require.NoError(t, err)
+	return tp, hooks
+}
+
+func firstWorkingSelected(tp *TransportPair) string {
+	return tp.PacketProxy.PacketProxy.(*firstWorkingSelector).current().StreamDialer.FirstHop
+}
+
+func TestParseFirstWorking(t *testing.T) {
+	serviceDir := t.TempDir()
+	tp, hooks := parseFirstWorkingTest(t, serviceDir)
+	require.Equal(t, ConnTypeTunneled, tp.StreamDialer.ConnType)
+	hops := []string{"a.example.com:443", "b.example.com:443", "c.example.com:443"}
+	require.Equal(t, "", tp.StreamDialer.FirstHop)
+	require.Equal(t, hops, tp.StreamDialer.FirstHops)
+	require.Equal(t, "", tp.PacketProxy.FirstHop)
+	require.Equal(t, hops, tp.PacketProxy.FirstHops)
+	// Uses the first option before the session starts.
+	require.Equal(t, "a.example.com:443", firstWorkingSelected(tp))
+
+	setTestConnectivity(t, map[string]int{"b.example.com:443": 2, "c.example.com:443": 2}, nil)
+	require.NoError(t, hooks.Start(context.Background()))
+	require.Equal(t, "b.example.com:443", firstWorkingSelected(tp))
+
+	data, err := os.ReadFile(path.Join(serviceDir, firstWorkingFilename))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"transport": 2}`, string(data))
+
+	// The next session starts with the last winner, and keeps it while it works.
+	tp, hooks = parseFirstWorkingTest(t, serviceDir)
+	require.Equal(t, "b.example.com:443", firstWorkingSelected(tp))
+	setTestConnectivity(t, map[string]int{"a.example.com:443": 2, "b.example.com:443": 2}, nil)
+	require.NoError(t, hooks.Start(context.Background()))
+	require.Equal(t, "b.example.com:443", firstWorkingSelected(tp))
+}
+
+func TestParseFirstWorking_PrefersUDP(t *testing.T) {
+	tp, hooks := parseFirstWorkingTest(t, "")
+	setTestConnectivity(t, map[string]int{"a.example.com:443": 1, "b.example.com:443": 1, "c.example.com:443": 2}, nil)
+	require.NoError(t, hooks.Start(context.Background()))
+	require.Equal(t, "c.example.com:443", firstWorkingSelected(tp))
+}
+
+func TestParseFirstWorking_TCPOnly(t *testing.T) {
+	tp, hooks := parseFirstWorkingTest(t, "")
+	setTestConnectivity(t, map[string]int{"b.example.com:443": 1, "c.example.com:443": 1}, nil)
+	require.NoError(t, hooks.Start(context.Background()))
+	require.Equal(t, "b.example.com:443", firstWorkingSelected(tp))
+}
+
+func TestParseFirstWorking_NoneWorking(t *testing.T) {
+	serviceDir := t.TempDir()
+	tp, hooks := parseFirstWorkingTest(t, serviceDir)
+	setTestConnectivity(t, map[string]int{}, nil)
+	require.NoError(t, hooks.Start(context.Background()))
+	require.Equal(t, "a.example.com:443", firstWorkingSelected(tp))
+	_, err := os.Stat(path.Join(serviceDir, firstWorkingFilename))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseFirstWorking_Timeout(t *testing.T) {
+	tp, hooks := parseFirstWorkingTest(t, "")
+	setTestConnectivity(t,
+		map[string]int{"a.example.com:443": 2, "b.example.com:443": 2, "c.example.com:443": 2},
+		map[string]time.Duration{"a.example.com:443": 5 * time.Second, "b.example.com:443": 50 * time.Millisecond})
+	start := time.Now()
+	require.NoError(t, hooks.Start(context.Background()))
+	require.Less(t, time.Since(start), 5*time.Second)
+	// The first option is still pending at the deadline, so the best completed one is used.
+	require.Equal(t, "b.example.com:443", firstWorkingSelected(tp))
+}
+
+func TestParseFirstWorking_NoSupportedOption(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+$type: first-working
+options:
+  - $type: unsupported`)
+	require.NoError(t, err)
+	_, err = newTestTransportProvider().Parse(context.Background(), node)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+type echoPacketProxy struct{}
+
+type echoPacketSender struct {
+	resp network.PacketResponseReceiver
+}
+
+func (p *echoPacketProxy) NewSession(resp network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	return &echoPacketSender{resp}, nil
+}
+
+func (s *echoPacketSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	return s.resp.WriteFrom(p, net.UDPAddrFromAddrPort(destination))
+}
+
+func (s *echoPacketSender) Close() error {
+	return s.resp.Close()
+}
+
+func TestPacketProxyListener(t *testing.T) {
+	conn, err := (&packetProxyListener{&echoPacketProxy{}}).ListenPacket(context.Background())
+	require.NoError(t, err)
+
+	destination := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}
+	_, err = conn.WriteTo([]byte("hello"), destination)
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	n, source, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+	require.Equal(t, destination.String(), source.String())
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Millisecond)))
+	_, _, err = conn.ReadFrom(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	require.NoError(t, conn.Close())
+	_, _, err = conn.ReadFrom(buf)
+	require.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,95 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/httpconnect"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// HTTPConnectConfig is the format for the HTTP CONNECT Stream Dialer config.
+type HTTPConnectConfig struct {
+	// Endpoint is the stream endpoint of the HTTP proxy.
+	Endpoint configyaml.ConfigNode
+	// TLS enables TLS to the proxy. SNI overrides the server name, which defaults to the endpoint host.
+	TLS bool
+	SNI string
+	// Headers are added to every CONNECT request.
+	Headers map[string]string
+	// Username and Password set Basic proxy authorization. Token sets Bearer proxy authorization.
+	Username string
+	Password string
+	Token    string
+}
+
+func NewHTTPConnectStreamDialerSubParser(parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		return parseHTTPConnectStreamDialer(ctx, input, parseSE)
+	}
+}
+
+func parseHTTPConnectStreamDialer(ctx context.Context, configMap map[string]any, parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) (*Dialer[transport.StreamConn], error) {
+	var config HTTPConnectConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if config.Endpoint == nil {
+		return nil, errors.New("http-connect endpoint must be specified")
+	}
+
+	headers := make(http.Header)
+	for name, value := range config.Headers {
+		headers.Set(name, value)
+	}
+	switch {
+	case config.Token != "" && (config.Username != "" || config.Password != ""):
+		return nil, errors.New("http-connect token cannot be used with username or password")
+	case config.Token != "":
+		headers.Set("Proxy-Authorization", "Bearer "+config.Token)
+	case config.Username != "":
+		credentials := base64.StdEncoding.EncodeToString([]byte(config.Username + ":" + config.Password))
+		headers.Set("Proxy-Authorization", "Basic "+credentials)
+	case config.Password != "":
+		return nil, errors.New("http-connect password requires a username")
+	}
+
+	se, err := parseSE(configyaml.WithPath(ctx, "endpoint"), config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create StreamEndpoint: %w", err)
+	}
+	var proxyEndpoint transport.StreamEndpoint = transport.FuncStreamEndpoint(se.Connect)
+	if config.TLS {
+		serverName, err := tlsServerName(config.SNI, config.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http-connect tls config: %w", err)
+		}
+		proxyEndpoint = newTLSStreamEndpoint(proxyEndpoint, serverName)
+	} else if config.SNI != "" {
+		return nil, errors.New("http-connect sni requires tls")
+	}
+
+	dialer, err := httpconnect.NewStreamDialer(proxyEndpoint, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP CONNECT dialer: %w", err)
+	}
+	return &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnTypeTunneled, se.FirstHop, se.FirstHops}, dialer.DialStream}, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// newHTTPConnectTestHandler returns a handler that serves CONNECT requests by dialing the target.
+// It records the Proxy-Authorization header of each request in gotAuth.
+func newHTTPConnectTestHandler(gotAuth chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if gotAuth != nil {
+			gotAuth <- req.Header.Get("Proxy-Authorization")
+		}
+		if req.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		w.WriteHeader(http.StatusOK)
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.Flush()
+		go io.Copy(target, rw)
+		io.Copy(conn, target)
+	})
+}
+
+func requireEcho(t *testing.T, conn transport.StreamConn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+}
+
+func TestParseHTTPConnectStreamDialer(t *testing.T) {
+	echoServer := startTCPEchoServer(t)
+
+	tests := []struct {
+		name     string
+		config   map[string]any
+		wantAuth string
+	}{
+		{
+			name:     "no auth",
+			config:   map[string]any{},
+			wantAuth: "",
+		},
+		{
+			name:     "basic",
+			config:   map[string]any{"username": "user", "password": "pass"},
+			wantAuth: "Basic dXNlcjpwYXNz",
+		},
+		{
+			name:     "bearer",
+			config:   map[string]any{"token": "TOKEN"},
+			wantAuth: "Bearer TOKEN",
+		},
+		{
+			name:     "header",
+			config:   map[string]any{"headers": map[string]any{"Proxy-Authorization": "Custom X"}},
+			wantAuth: "Custom X",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAuth := make(chan string, 1)
+			proxy := httptest.NewServer(newHTTPConnectTestHandler(gotAuth))
+			defer proxy.Close()
+
+			tt.config["endpoint"] = proxy.Listener.Addr().String()
+			dialer, err := parseHTTPConnectStreamDialer(context.Background(), tt.config, directTestStreamEndpoints)
+			require.NoError(t, err)
+			require.Equal(t, ConnTypeTunneled, dialer.ConnType)
+			require.Equal(t, proxy.Listener.Addr().String(), dialer.FirstHop)
+
+			conn, err := dialer.Dial(context.Background(), echoServer.Addr().String())
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAuth, <-gotAuth)
+			requireEcho(t, conn)
+		})
+	}
+}
+
+func TestParseHTTPConnectStreamDialer_TLS(t *testing.T) {
+	proxy := httptest.NewTLSServer(newHTTPConnectTestHandler(nil))
+	defer proxy.Close()
+
+	// The test server certificate is not trusted by the system, so the handshake must fail.
+	dialer, err := parseHTTPConnectStreamDialer(context.Background(), map[string]any{
+		"endpoint": proxy.Listener.Addr().String(),
+		"tls":      true,
+	}, directTestStreamEndpoints)
+	require.NoError(t, err)
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.ErrorContains(t, err, "TLS handshake failed")
+}
+
+func TestParseHTTPConnectStreamDialer_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantErr string
+	}{
+		{"no endpoint", map[string]any{}, "http-connect endpoint must be specified"},
+		{"password only", map[string]any{"endpoint": "proxy.example.com:8080", "password": "pass"}, "http-connect password requires a username"},
+		{"token and username", map[string]any{"endpoint": "proxy.example.com:8080", "username": "user", "token": "TOKEN"}, "http-connect token cannot be used with username or password"},
+		{"sni without tls", map[string]any{"endpoint": "proxy.example.com:8080", "sni": "example.com"}, "http-connect sni requires tls"},
+		{"unknown field", map[string]any{"endpoint": "proxy.example.com:8080", "unknown": "field"}, "invalid config format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseHTTPConnectStreamDialer(context.Background(), tt.config, directTestStreamEndpoints)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRegisterHTTPConnect(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: shadowsocks
+  endpoint:
+    $type: dial
+    address: ss.example.com:4321
+    dialer:
+      $type: http-connect
+      endpoint: proxy.example.com:8080
+      tls: true
+      username: user
+      password: pass
+  cipher: chacha20-ietf-poly1305
+  secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "proxy.example.com:8080", transportPair.StreamDialer.FirstHop)
+}
@@ -16,8 +16,8 @@ package config
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"net"
 	"net/netip"
 
 	"localhost/client/go/configyaml"
@@ -35,47 +35,50 @@ type ipTableEntryConfig struct {
 	Dialer configyaml.ConfigNode `yaml:"dialer"`
 }
 
-func parseIPTableStreamDialer(
+// parsedIPTable is the result of parsing an [ipTableRootConfig] whose nested objects are of type V.
+type parsedIPTable[V any] struct {
+	table    iptable.IPTable[V]
+	fallback V
+	connType ConnType
+}
+
+// parseIPTable parses the table and fallback of an iptable config, and computes the aggregated [ConnType].
+// The nested objects are parsed with parse, and converted to the table value type with convert.
+// The kind describes the nested objects in error messages (e.g. "stream dialer").
+func parseIPTable[Parsed any, V any](
 	ctx context.Context,
 	configMap map[string]any,
-	parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]],
-) (*Dialer[transport.StreamConn], error) {
+	kind string,
+	parse configyaml.ParseFunc[Parsed],
+	info func(Parsed) ConnectionProviderInfo,
+	convert func(Parsed) V,
+) (*parsedIPTable[V], error) {
 	var rootCfg ipTableRootConfig
 	if err := configyaml.MapToAny(configMap, &rootCfg); err != nil {
-		return nil, fmt.Errorf("failed to map iptable stream config: %w", err)
+		return nil, fmt.Errorf("failed to map iptable %v config: %w", kind, err)
 	}
 
 	if len(rootCfg.Table) == 0 {
-		return nil, errors.New("iptable config 'table' must not be empty for stream dialer")
+		return nil, fmt.Errorf("iptable config 'table' must not be empty for %v", kind)
 	}
 
-	allConnTunnelled := true
-	allConnDirect := true
-	allConnBlocked := true
+	connTypes := newConnTypeAggregator()
 
-	dialerTable := iptable.NewIPTable[transport.StreamDialer]()
+	result := &parsedIPTable[V]{table: iptable.NewIPTable[V]()}
 	for i, entryCfg := range rootCfg.Table {
 		if entryCfg.Dialer == nil {
 			return nil, fmt.Errorf("iptable entry %d has no dialer specified", i)
 		}
 
-		parsedSubDialer, err := parseSD(ctx, entryCfg.Dialer)
+		parsedSub, err := parse(configyaml.WithPath(ctx, fmt.Sprintf("table[%d].dialer", i)), entryCfg.Dialer)
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse nested stream dialer for table entry %d: %w", i, err)
+			return nil, fmt.Errorf("failed to parse nested %v for table entry %d: %w", kind, i, err)
 		}
 
-		if parsedSubDialer.ConnType != ConnTypeBlocked {
-			allConnBlocked = false
-			if parsedSubDialer.ConnType != ConnTypeTunneled {
-				allConnTunnelled = false
-			}
-			if parsedSubDialer.ConnType != ConnTypeDirect {
-				allConnDirect = false
-			}
-		}
+		connTypes.add(info(parsedSub).ConnType)
 
-		ipsDialer := transport.FuncStreamDialer(parsedSubDialer.Dial)
+		sub := convert(parsedSub)
 
 		for _, ip := range entryCfg.IPs {
 			var currentPrefix netip.Prefix
@@ -90,62 +93,130 @@ func parseIPTableStreamDialer(
 				currentPrefix = netip.PrefixFrom(addr, addr.BitLen())
 			}
 
-			dialerTable.AddPrefix(currentPrefix, ipsDialer)
+			result.table.AddPrefix(currentPrefix, sub)
 		}
 	}
 
-	var fallbackDialer transport.StreamDialer
-
 	if rootCfg.Fallback != nil {
-		parsedFallbackDialer, err := parseSD(ctx, rootCfg.Fallback)
+		parsedFallback, err := parse(configyaml.WithPath(ctx, "fallback"), rootCfg.Fallback)
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse nested stream dialer fallback: %w", err)
+			return nil, fmt.Errorf("failed to parse nested %v fallback: %w", kind, err)
 		}
 
-		if parsedFallbackDialer.ConnType != ConnTypeBlocked {
-			allConnBlocked = false
-			if parsedFallbackDialer.ConnType != ConnTypeTunneled {
-				allConnTunnelled = false
-			}
-			if parsedFallbackDialer.ConnType != ConnTypeDirect {
-				allConnDirect = false
-			}
-		}
+		connTypes.add(info(parsedFallback).ConnType)
 
-		fallbackDialer = transport.FuncStreamDialer(parsedFallbackDialer.Dial)
+		result.fallback = convert(parsedFallback)
 	}
 
-	dialer, err := iptable.NewStreamDialer(dialerTable, fallbackDialer)
+	connType := connTypes.connType()
+	result.connType = connType
+	return result, nil
+}
+
+func dialerInfo[ConnType any](d *Dialer[ConnType]) ConnectionProviderInfo {
+	return d.ConnectionProviderInfo
+}
 
+func parseIPTableStreamDialer(
+	ctx context.Context,
+	configMap map[string]any,
+	parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]],
+) (*Dialer[transport.StreamConn], error) {
+	parsed, err := parseIPTable(ctx, configMap, "stream dialer", parseSD, dialerInfo,
+		func(d *Dialer[transport.StreamConn]) transport.StreamDialer {
+			return transport.FuncStreamDialer(d.Dial)
+		})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create IPTableStreamDialer: %w", err)
+		return nil, err
 	}
 
-	var connType ConnType
-	if allConnBlocked {
-		connType = ConnTypeBlocked
-	} else if allConnDirect && allConnTunnelled {
-		// These should never happen because we require len(rootCfg.Table) != 0
-		return nil, fmt.Errorf("allConnDirect, allConnTunnelled cannot all be true")
-	} else if allConnTunnelled {
-		connType = ConnTypeTunneled
-	} else if allConnDirect {
-		connType = ConnTypeDirect
-	} else {
-		connType = ConnTypePartial
+	dialer, err := iptable.NewStreamDialer(parsed.table, parsed.fallback)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPTableStreamDialer: %w", err)
 	}
 
 	return &Dialer[transport.StreamConn]{
 		Dial: dialer.DialStream,
 		ConnectionProviderInfo: ConnectionProviderInfo{
-			ConnType: connType,
+			ConnType: parsed.connType,
 		},
 	}, nil
 }
 
+func parseIPTablePacketDialer(
+	ctx context.Context,
+	configMap map[string]any,
+	parsePD configyaml.ParseFunc[*Dialer[net.Conn]],
+) (*Dialer[net.Conn], error) {
+	parsed, err := parseIPTable(ctx, configMap, "packet dialer", parsePD, dialerInfo,
+		func(d *Dialer[net.Conn]) transport.PacketDialer {
+			return transport.FuncPacketDialer(d.Dial)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := iptable.NewPacketDialer(parsed.table, parsed.fallback)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPTablePacketDialer: %w", err)
+	}
+
+	return &Dialer[net.Conn]{
+		Dial: dialer.DialPacket,
+		ConnectionProviderInfo: ConnectionProviderInfo{
+			ConnType: parsed.connType,
+		},
+	}, nil
+}
+
+func parseIPTablePacketListener(
+	ctx context.Context,
+	configMap map[string]any,
+	parsePL configyaml.ParseFunc[*PacketListener],
+) (*PacketListener, error) {
+	parsed, err := parseIPTable(ctx, configMap, "packet listener", parsePL,
+		func(pl *PacketListener) ConnectionProviderInfo {
+			return pl.ConnectionProviderInfo
+		},
+		// The *PacketListener pointer is comparable, which the iptable PacketListener requires.
+		func(pl *PacketListener) transport.PacketListener {
+			return pl
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := iptable.NewPacketListener(parsed.table, parsed.fallback)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPTablePacketListener: %w", err)
+	}
+
+	return &PacketListener{
+		ConnectionProviderInfo: ConnectionProviderInfo{
+			ConnType: parsed.connType,
+		},
+		PacketListener: listener,
+	}, nil
+}
+
 func NewIPTableStreamDialerSubParser(parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
 	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
 		return parseIPTableStreamDialer(ctx, input, parseSD)
 	}
 }
+
+func NewIPTablePacketDialerSubParser(parsePD configyaml.ParseFunc[*Dialer[net.Conn]]) func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
+		return parseIPTablePacketDialer(ctx, input, parsePD)
+	}
+}
+
+func NewIPTablePacketListenerSubParser(parsePL configyaml.ParseFunc[*PacketListener]) func(ctx context.Context, input map[string]any) (*PacketListener, error) {
+	return func(ctx context.Context, input map[string]any) (*PacketListener, error) {
+		return parseIPTablePacketListener(ctx, input, parsePL)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"testing"
 
 	"localhost/client/go/configyaml"
@@ -331,3 +332,137 @@ table:
 		require.Contains(t, err.Error(), "fallback sub-parser failed")
 	})
 }
+
+func TestParseIPTablePacketDialer(t *testing.T) {
+	ctx := context.Background()
+
+	parsePD := func(ctx context.Context, config configyaml.ConfigNode) (*Dialer[net.Conn], error) {
+		name := config.(map[string]any)["name"].(string)
+		connType := ConnTypeTunneled
+		switch name {
+		case "direct":
+			connType = ConnTypeDirect
+		case "block":
+			connType = ConnTypeBlocked
+		}
+		return &Dialer[net.Conn]{
+			ConnectionProviderInfo: ConnectionProviderInfo{ConnType: connType},
+			Dial: func(ctx context.Context, address string) (net.Conn, error) {
+				return nil, fmt.Errorf("dialer '%s' called for address '%s'", name, address)
+			},
+		}, nil
+	}
+
+	t.Run("Routes by destination", func(t *testing.T) {
+		node, err := configyaml.ParseConfigYAML(`
+table:
+  - ips:
+      - 192.168.1.0/24
+    dialer: {name: direct}
+fallback: {name: dialerA}
+`)
+		require.NoError(t, err)
+
+		dialer, err := parseIPTablePacketDialer(ctx, node.(map[string]any), parsePD)
+		require.NoError(t, err)
+		require.Equal(t, ConnTypePartial, dialer.ConnType)
+
+		_, err = dialer.Dial(ctx, "192.168.1.100:53")
+		require.ErrorContains(t, err, "dialer 'direct' called for address '192.168.1.100:53'")
+
+		_, err = dialer.Dial(ctx, "8.8.8.8:53")
+		require.ErrorContains(t, err, "dialer 'dialerA' called for address '8.8.8.8:53'")
+	})
+
+	t.Run("All blocked", func(t *testing.T) {
+		node, err := configyaml.ParseConfigYAML(`
+table:
+  - ips:
+      - 0.0.0.0/0
+    dialer: {name: block}
+`)
+		require.NoError(t, err)
+
+		dialer, err := parseIPTablePacketDialer(ctx, node.(map[string]any), parsePD)
+		require.NoError(t, err)
+		require.Equal(t, ConnTypeBlocked, dialer.ConnType)
+	})
+
+	t.Run("Error - empty table", func(t *testing.T) {
+		_, err := parseIPTablePacketDialer(ctx, map[string]any{"table": []any{}}, parsePD)
+		require.ErrorContains(t, err, "iptable config 'table' must not be empty for packet dialer")
+	})
+}
+
+func TestParseIPTablePacketListener(t *testing.T) {
+	ctx := context.Background()
+
+	listeners := map[string]*PacketListener{
+		"tunneled": {ConnectionProviderInfo{ConnType: ConnTypeTunneled}, &transport.UDPListener{}},
+		"direct":   {ConnectionProviderInfo{ConnType: ConnTypeDirect}, &transport.UDPListener{}},
+	}
+	parsePL := func(ctx context.Context, config configyaml.ConfigNode) (*PacketListener, error) {
+		name := config.(map[string]any)["name"].(string)
+		if pl, ok := listeners[name]; ok {
+			return pl, nil
+		}
+		return nil, fmt.Errorf("no mock listener found with name: %s", name)
+	}
+
+	testCases := []struct {
+		name             string
+		configYAML       string
+		expectErr        string
+		expectedConnType ConnType
+	}{
+		{
+			name: "All tunneled",
+			configYAML: `
+table:
+  - ips: [10.0.0.0/8]
+    dialer: {name: tunneled}
+fallback: {name: tunneled}`,
+			expectedConnType: ConnTypeTunneled,
+		},
+		{
+			name: "Direct fallback",
+			configYAML: `
+table:
+  - ips: [10.0.0.0/8]
+    dialer: {name: tunneled}
+fallback: {name: direct}`,
+			expectedConnType: ConnTypePartial,
+		},
+		{
+			name: "Error - missing dialer",
+			configYAML: `
+table:
+  - ips: [10.0.0.0/8]`,
+			expectErr: "iptable entry 0 has no dialer specified",
+		},
+		{
+			name: "Error - nested listener fails",
+			configYAML: `
+table:
+  - ips: [10.0.0.0/8]
+    dialer: {name: unknown}`,
+			expectErr: "failed to parse nested packet listener for table entry 0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML(tc.configYAML)
+			require.NoError(t, err)
+
+			pl, err := parseIPTablePacketListener(ctx, node.(map[string]any), parsePL)
+			if tc.expectErr != "" {
+				require.ErrorContains(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedConnType, pl.ConnType)
+			require.NotNil(t, pl.PacketListener)
+		})
+	}
+}
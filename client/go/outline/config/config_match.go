@@ -0,0 +1,238 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/useragent"
+)
+
+// MatchConfig is the format for the Match config. It selects a config based on the environment the
+// client is running on.
+type MatchConfig struct {
+	// Cases are tried in order, and the config of the first case that matches is used.
+	Cases []MatchCaseConfig
+	// Default is used if no case matches.
+	Default configyaml.ConfigNode
+}
+
+// MatchCaseConfig is a case in a [MatchConfig]. A case matches if all its conditions are met.
+// Conditions that are not specified are ignored.
+type MatchCaseConfig struct {
+	// OS is the list of operating systems to match, as in GOOS, e.g. "linux", "windows", "darwin", "android", "ios".
+	OS []string
+	// Arch is the list of CPU architectures to match, as in GOARCH, e.g. "amd64", "arm64".
+	Arch []string
+	// Client_Version is the range of client versions to match, e.g. ">=1.15.0, <2".
+	Client_Version string
+	// Network is the list of network types to match, e.g. "wifi", "cellular", "ethernet".
+	Network []string
+	// Config is the config to use if the case matches.
+	Config configyaml.ConfigNode
+}
+
+// MatchEnvironment has the information about the client that [MatchConfig] cases can match.
+type MatchEnvironment struct {
+	// GOOS and GOARCH default to the ones the app is running on.
+	GOOS   string
+	GOARCH string
+	// ClientVersion is the version of the app, e.g. "1.15.0". If empty, client version conditions never match.
+	ClientVersion string
+	// NetworkType is a hint from the app about the network the device is on, e.g. "wifi".
+	// If empty, network conditions never match.
+	NetworkType string
+}
+
+type matchEnvironmentContextKey struct{}
+
+// WithMatchEnvironment returns a context that makes the given environment available to the Match configs.
+func WithMatchEnvironment(ctx context.Context, env MatchEnvironment) context.Context {
+	return context.WithValue(ctx, matchEnvironmentContextKey{}, env)
+}
+
+func matchEnvironmentFromContext(ctx context.Context) MatchEnvironment {
+	env, _ := ctx.Value(matchEnvironmentContextKey{}).(MatchEnvironment)
+	if env.GOOS == "" {
+		env.GOOS = useragent.GOOS()
+	}
+	if env.GOARCH == "" {
+		env.GOARCH = runtime.GOARCH
+	}
+	return env
+}
+
+func NewMatchSubParser[Output any](parse configyaml.ParseFunc[Output]) func(ctx context.Context, input map[string]any) (Output, error) {
+	return func(ctx context.Context, input map[string]any) (Output, error) {
+		return parseMatch(ctx, input, parse)
+	}
+}
+
+func parseMatch[Output any](ctx context.Context, configMap map[string]any, parse configyaml.ParseFunc[Output]) (Output, error) {
+	var zero Output
+	var config MatchConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return zero, fmt.Errorf("invalid config format: %w", err)
+	}
+
+	env := matchEnvironmentFromContext(ctx)
+	for i, caseConfig := range config.Cases {
+		matches, err := caseConfig.matches(env)
+		if err != nil {
+			return zero, fmt.Errorf("invalid case %d: %w", i, err)
+		}
+		if matches {
+			return parse(configyaml.WithPath(ctx, fmt.Sprintf("cases[%d].config", i)), caseConfig.Config)
+		}
+	}
+	// An explicit null default is valid, and it usually means direct access.
+	if _, ok := configMap["default"]; ok {
+		return parse(configyaml.WithPath(ctx, "default"), config.Default)
+	}
+	return zero, fmt.Errorf("no matching case found: %w", errors.ErrUnsupported)
+}
+
+func (c *MatchCaseConfig) matches(env MatchEnvironment) (bool, error) {
+	// Validate the version range first, so invalid configs fail regardless of the environment.
+	var versionRange []versionConstraint
+	if c.Client_Version != "" {
+		var err error
+		versionRange, err = parseVersionRange(c.Client_Version)
+		if err != nil {
+			return false, fmt.Errorf("invalid client_version: %w", err)
+		}
+	}
+	if len(c.OS) > 0 && !slices.Contains(c.OS, env.GOOS) {
+		return false, nil
+	}
+	if len(c.Arch) > 0 && !slices.Contains(c.Arch, env.GOARCH) {
+		return false, nil
+	}
+	if len(c.Network) > 0 && (env.NetworkType == "" || !slices.ContainsFunc(c.Network, func(network string) bool {
+		return strings.EqualFold(network, env.NetworkType)
+	})) {
+		return false, nil
+	}
+	if versionRange != nil {
+		version, err := parseVersion(env.ClientVersion)
+		if err != nil {
+			return false, nil
+		}
+		for _, constraint := range versionRange {
+			if !constraint.matches(version) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// versionConstraint is a comparison with a version, like ">=1.15".
+type versionConstraint struct {
+	op      string
+	version []int
+}
+
+func (c versionConstraint) matches(version []int) bool {
+	cmp := compareVersions(version, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// parseVersionRange parses a list of version constraints separated by commas or spaces, like ">=1.15.0, <2".
+func parseVersionRange(text string) ([]versionConstraint, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 {
+		return nil, errors.New("empty version range")
+	}
+	constraints := make([]versionConstraint, 0, len(fields))
+	for _, field := range fields {
+		op := "="
+		for _, candidate := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+			if strings.HasPrefix(field, candidate) {
+				op = candidate
+				field = field[len(candidate):]
+				break
+			}
+		}
+		if op == "==" {
+			op = "="
+		}
+		version, err := parseVersion(field)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, versionConstraint{op, version})
+	}
+	return constraints, nil
+}
+
+// parseVersion parses a version like "1.15.0" or "v1.15.0-beta" into its numeric components.
+// Pre-release and build suffixes are ignored.
+func parseVersion(text string) ([]int, error) {
+	text = strings.TrimPrefix(text, "v")
+	if cut := strings.IndexAny(text, "-+"); cut >= 0 {
+		text = text[:cut]
+	}
+	if text == "" {
+		return nil, errors.New("version must not be empty")
+	}
+	parts := strings.Split(text, ".")
+	version := make([]int, len(parts))
+	for i, part := range parts {
+		number, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", text)
+		}
+		version[i] = int(number)
+	}
+	return version, nil
+}
+
+// compareVersions compares versions component by component, treating missing components as zero.
+func compareVersions(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var aPart, bPart int
+		if i < len(a) {
+			aPart = a[i]
+		}
+		if i < len(b) {
+			bPart = b[i]
+		}
+		if aPart != bPart {
+			return aPart - bPart
+		}
+	}
+	return 0
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"github.com/stretchr/testify/require"
+)
+
+func parseMatchName(ctx context.Context, env MatchEnvironment, configText string) (string, error) {
+	node, err := configyaml.ParseConfigYAML(configText)
+	if err != nil {
+		return "", err
+	}
+	ctx = WithMatchEnvironment(ctx, env)
+	return parseMatch(ctx, node.(map[string]any), func(ctx context.Context, input configyaml.ConfigNode) (string, error) {
+		if input == nil {
+			return "<nil>", nil
+		}
+		return input.(string), nil
+	})
+}
+
+func TestParseMatch(t *testing.T) {
+	config := `
+cases:
+  - os: [linux, windows]
+    arch: [amd64]
+    config: desktop-amd64
+  - os: [android, ios]
+    network: [cellular]
+    config: mobile-cellular
+  - os: [android, ios]
+    client_version: ">=1.15, <2"
+    config: mobile-new
+default: fallback`
+
+	tests := []struct {
+		name     string
+		env      MatchEnvironment
+		expected string
+	}{
+		{"desktop", MatchEnvironment{GOOS: "linux", GOARCH: "amd64"}, "desktop-amd64"},
+		{"desktop other arch", MatchEnvironment{GOOS: "windows", GOARCH: "arm64"}, "fallback"},
+		{"mobile cellular", MatchEnvironment{GOOS: "ios", GOARCH: "arm64", NetworkType: "Cellular"}, "mobile-cellular"},
+		{"mobile wifi new", MatchEnvironment{GOOS: "android", GOARCH: "arm64", NetworkType: "wifi", ClientVersion: "1.15.0"}, "mobile-new"},
+		{"mobile no network new", MatchEnvironment{GOOS: "android", GOARCH: "arm64", ClientVersion: "1.16.2-beta"}, "mobile-new"},
+		{"mobile old", MatchEnvironment{GOOS: "android", GOARCH: "arm64", ClientVersion: "1.14.9"}, "fallback"},
+		{"mobile too new", MatchEnvironment{GOOS: "android", GOARCH: "arm64", ClientVersion: "2.0"}, "fallback"},
+		{"mobile unknown version", MatchEnvironment{GOOS: "android", GOARCH: "arm64"}, "fallback"},
+		{"mobile invalid version", MatchEnvironment{GOOS: "android", GOARCH: "arm64", ClientVersion: "dev"}, "fallback"},
+		{"other", MatchEnvironment{GOOS: "darwin", GOARCH: "arm64"}, "fallback"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := parseMatchName(context.Background(), tc.env, config)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, name)
+		})
+	}
+}
+
+func TestParseMatch_Default(t *testing.T) {
+	env := MatchEnvironment{GOOS: "linux", GOARCH: "amd64"}
+
+	name, err := parseMatchName(context.Background(), env, `
+cases:
+  - os: [android]
+    config: mobile
+default: null`)
+	require.NoError(t, err)
+	require.Equal(t, "<nil>", name)
+
+	_, err = parseMatchName(context.Background(), env, `
+cases:
+  - os: [android]
+    config: mobile`)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestParseMatch_DefaultEnvironment(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`
+cases:
+  - os: [` + runtime.GOOS + `]
+    arch: [` + runtime.GOARCH + `]
+    config: current
+default: other`)
+	require.NoError(t, err)
+	name, err := parseMatch(context.Background(), node.(map[string]any), func(ctx context.Context, input configyaml.ConfigNode) (string, error) {
+		return input.(string), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "current", name)
+}
+
+func TestParseMatch_InvalidVersionRange(t *testing.T) {
+	_, err := parseMatchName(context.Background(), MatchEnvironment{GOOS: "linux"}, `
+cases:
+  - os: [android]
+    client_version: ">=one"
+    config: mobile
+default: other`)
+	require.ErrorContains(t, err, "invalid case 0: invalid client_version")
+}
+
+func TestCompareVersionRange(t *testing.T) {
+	tests := []struct {
+		versionRange string
+		version      string
+		expected     bool
+	}{
+		{"1.15", "1.15.0", true},
+		{"=1.15.1", "1.15.0", false},
+		{"==v1.15.0", "1.15", true},
+		{"!=1.15", "1.15.0", false},
+		{">1.9", "1.10", true},
+		{">1.10", "1.10.0", false},
+		{"<=1.10", "1.10.0", true},
+		{"<1.10", "1.9.99", true},
+		{">=1.2 <1.3", "1.2.5", true},
+		{">=1.2,<1.3", "1.3.0", false},
+	}
+	for _, tc := range tests {
+		constraints, err := parseVersionRange(tc.versionRange)
+		require.NoError(t, err, tc.versionRange)
+		version, err := parseVersion(tc.version)
+		require.NoError(t, err, tc.version)
+		matches := true
+		for _, constraint := range constraints {
+			matches = matches && constraint.matches(version)
+		}
+		require.Equal(t, tc.expected, matches, "%v in %v", tc.version, tc.versionRange)
+	}
+
+	_, err := parseVersionRange(" , ")
+	require.Error(t, err)
+}
+
+func TestRegisterMatch(t *testing.T) {
+	provider := newTestTransportProvider()
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: match
+  cases:
+    - os: [android, ios]
+      config:
+        $type: shadowsocks
+        endpoint: mobile.example.com:443
+        cipher: chacha20-ietf-poly1305
+        secret: SECRET
+  default:
+    $type: shadowsocks
+    endpoint: desktop.example.com:443
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET
+udp:
+  $type: first-supported
+  options:
+    - $type: match
+      cases:
+        - network: [cellular]
+          config: {$type: direct}
+    - $type: shadowsocks
+      endpoint: desktop.example.com:443
+      cipher: chacha20-ietf-poly1305
+      secret: SECRET`)
+	require.NoError(t, err)
+
+	ctx := WithMatchEnvironment(context.Background(), MatchEnvironment{GOOS: "android", NetworkType: "cellular"})
+	transportPair, err := provider.Parse(ctx, node)
+	require.NoError(t, err)
+	require.Equal(t, "mobile.example.com:443", transportPair.StreamDialer.FirstHop)
+	require.Equal(t, ConnTypeDirect, transportPair.PacketProxy.ConnType)
+
+	ctx = WithMatchEnvironment(context.Background(), MatchEnvironment{GOOS: "linux"})
+	transportPair, err = provider.Parse(ctx, node)
+	require.NoError(t, err)
+	require.Equal(t, "desktop.example.com:443", transportPair.StreamDialer.FirstHop)
+	require.Equal(t, "desktop.example.com:443", transportPair.PacketProxy.FirstHop)
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/mux"
+	"golang.getoutline.org/sdk/transport"
+)
+
+const (
+	defaultMuxMaxStreams  = 8
+	defaultMuxIdleTimeout = time.Minute
+)
+
+// MuxConfig is the format for the Mux Stream Dialer config.
+type MuxConfig struct {
+	// Dialer is the stream dialer to connect to the mux server. If absent, the default dialer is used.
+	Dialer configyaml.ConfigNode
+	// Address is the address of the mux server, as seen by the dialer.
+	Address string
+	// Max_Streams is the maximum number of streams per connection. Defaults to 8.
+	Max_Streams int
+	// Idle_Timeout is how long a connection without streams is kept open. Defaults to 1m.
+	Idle_Timeout string
+}
+
+func NewMuxStreamDialerSubParser(parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		return parseMuxStreamDialer(ctx, input, parseSD)
+	}
+}
+
+func parseMuxStreamDialer(ctx context.Context, configMap map[string]any, parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) (*Dialer[transport.StreamConn], error) {
+	var config MuxConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if config.Address == "" {
+		return nil, errors.New("mux address must be specified")
+	}
+	if config.Max_Streams == 0 {
+		config.Max_Streams = defaultMuxMaxStreams
+	}
+	idleTimeout, err := parseDurationOrDefault(config.Idle_Timeout, defaultMuxIdleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse idle_timeout: %w", err)
+	}
+
+	sd, err := parseSD(configyaml.WithPath(ctx, "dialer"), config.Dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nested stream dialer: %w", err)
+	}
+	if sd == nil {
+		return nil, errors.New("stream dialer is not available")
+	}
+
+	muxSD, err := mux.NewStreamDialer(transport.FuncStreamDialer(sd.Dial), config.Address, mux.Options{
+		MaxStreams:  config.Max_Streams,
+		IdleTimeout: idleTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid mux config: %w", err)
+	}
+	return &Dialer[transport.StreamConn]{sd.ConnectionProviderInfo, muxSD.DialStream}, nil
+}
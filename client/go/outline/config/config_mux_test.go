@@ -0,0 +1,69 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMuxStreamDialer_Errors(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"no address", "max_streams: 4", "mux address must be specified"},
+		{"negative max_streams", "address: mux.example:1\n  max_streams: -1", "max streams must be positive, found -1"},
+		{"bad idle_timeout", "address: mux.example:1\n  idle_timeout: soon", "failed to parse idle_timeout"},
+		{"unknown field", "address: mux.example:1\n  foo: bar", "invalid config format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML("$type: tcpudp\ntcp:\n  $type: mux\n  " + tt.config)
+			require.NoError(t, err)
+			_, err = provider.Parse(context.Background(), node)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRegisterMux(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: mux
+  address: mux.outline.invalid:443
+  max_streams: 16
+  idle_timeout: 30s
+  dialer:
+    $type: shadowsocks
+    endpoint: example.com:4321
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "example.com:4321", transportPair.StreamDialer.FirstHop)
+}
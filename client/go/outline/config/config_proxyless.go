@@ -25,13 +25,16 @@ import (
 	"golang.getoutline.org/sdk/transport/tlsfrag"
 )
 
+// Default range of the TLS split length.
+// splitLength includes 5 bytes of TLS header
 const (
 	MIN_SPLIT int = 6
 	MAX_SPLIT int = 64
 )
 
 type BasicAccessConfig struct {
-	// TODO: for now we do not parse any config, once DNS is implemented we will parse it.
+	// TODO: parse the DNS config once DNS is implemented.
+	TLSFragOptions `yaml:",inline"`
 }
 
 // Random number in the range [MIN_SPLIT, MAX_SPLIT]
@@ -55,14 +58,19 @@ func parseProxylessTransportPair(ctx context.Context, configMap map[string]any,
 		return nil, fmt.Errorf("invalid config format: %w", err)
 	}
 
-	splitLength := randomSplitLength()
-
-	sd, err := tlsfrag.NewFixedLenStreamDialer(&transport.TCPDialer{}, splitLength)
+	var sd transport.StreamDialer
+	var err error
+	if config.TLSFragOptions == (TLSFragOptions{}) {
+		// Without options, a single random split length is used for all the connections.
+		sd, err = tlsfrag.NewFixedLenStreamDialer(&transport.TCPDialer{}, randomSplitLength())
+	} else {
+		sd, err = newTLSFragStreamDialer(&transport.TCPDialer{}, config.TLSFragOptions)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create StreamDialer: %w", err)
 	}
 
-	pl := &PacketListener{ConnectionProviderInfo{ConnTypeDirect, ""}, &transport.UDPListener{}}
+	pl := &PacketListener{ConnectionProviderInfo{ConnType: ConnTypeDirect}, &transport.UDPListener{}}
 	pp, err := network.NewPacketProxyFromPacketListener(pl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create PacketProxy: %w", err)
@@ -73,6 +81,6 @@ func parseProxylessTransportPair(ctx context.Context, configMap map[string]any,
 			ConnectionProviderInfo: ConnectionProviderInfo{ConnType: ConnTypeDirect},
 			Dial:                   sd.DialStream,
 		},
-		PacketProxy: &PacketProxy{ConnectionProviderInfo{ConnTypeDirect, ""}, pp, nil},
+		PacketProxy: &PacketProxy{ConnectionProviderInfo{ConnType: ConnTypeDirect}, pp, nil},
 	}, nil
 }
@@ -36,3 +36,25 @@ func TestParseProxyless(t *testing.T) {
 	require.Equal(t, ConnTypeDirect, transportPair.StreamDialer.ConnType)
 	require.Equal(t, ConnTypeDirect, transportPair.PacketProxy.ConnType)
 }
+
+func TestParseProxyless_TLSFragOptions(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: basic-access
+mode: sni
+min_length: 2
+max_length: 8`)
+	require.NoError(t, err)
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeDirect, transportPair.StreamDialer.ConnType)
+
+	node, err = configyaml.ParseConfigYAML(`
+$type: basic-access
+length: 5
+max_length: 8`)
+	require.NoError(t, err)
+	_, err = provider.Parse(context.Background(), node)
+	require.ErrorContains(t, err, "tlsfrag length cannot be used with min_length or max_length")
+}
@@ -0,0 +1,89 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/race"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// defaultRaceDelay is the connection attempt delay recommended by Happy Eyeballs (RFC 8305).
+const defaultRaceDelay = 250 * time.Millisecond
+
+// RaceConfig is the format for the Race Stream Dialer config.
+type RaceConfig struct {
+	// Options is the list of stream dialers to race, in order of preference.
+	Options []configyaml.ConfigNode
+	// Delay is the time to wait before starting the next attempt. Defaults to 250ms.
+	Delay string
+}
+
+func NewRaceStreamDialerSubParser(parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		return parseRaceStreamDialer(ctx, input, parseSD)
+	}
+}
+
+func parseRaceStreamDialer(ctx context.Context, configMap map[string]any, parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) (*Dialer[transport.StreamConn], error) {
+	var config RaceConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if len(config.Options) == 0 {
+		return nil, errors.New("empty list of options")
+	}
+	delay, err := parseDurationOrDefault(config.Delay, defaultRaceDelay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse delay: %w", err)
+	}
+
+	sds := make([]*Dialer[transport.StreamConn], 0, len(config.Options))
+	baseSDs := make([]transport.StreamDialer, 0, len(config.Options))
+	connTypes := newConnTypeAggregator()
+	for i, optionConfig := range config.Options {
+		sd, err := parseSD(configyaml.WithPath(ctx, fmt.Sprintf("options[%d]", i)), optionConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse option %d: %w", i, err)
+		}
+		if sd == nil {
+			return nil, fmt.Errorf("option %d is not available", i)
+		}
+		sds = append(sds, sd)
+		baseSDs = append(baseSDs, transport.FuncStreamDialer(sd.Dial))
+		connTypes.add(sd.ConnType)
+	}
+	connType := connTypes.connType()
+
+	raceSD, err := race.NewStreamDialer(baseSDs, delay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid race config: %w", err)
+	}
+	configPath := configyaml.PathFromContext(ctx)
+	logStatsDuringSession(ctx, func() {
+		for i, stats := range raceSD.Stats() {
+			slog.Info("race option stats", "path", configPath, "option", i, "attempts", stats.Attempts,
+				"wins", stats.Wins, "failures", stats.Failures, "winRate", stats.WinRate(), "averageLatency", stats.AverageLatency())
+		}
+	})
+	firstHop, firstHops := dialersFirstHops(sds)
+	return &Dialer[transport.StreamConn]{ConnectionProviderInfo{connType, firstHop, firstHops}, raceSD.DialStream}, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRaceStreamDialer(t *testing.T) {
+	fakes := newFakeDialers()
+	fakes.setFailing("a", true)
+	node, err := configyaml.ParseConfigYAML(`
+options: [a, b]
+delay: 1h`)
+	require.NoError(t, err)
+	dialer, err := parseRaceStreamDialer(context.Background(), node.(map[string]any), fakes.parse)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, dialer.ConnType)
+	require.Equal(t, "", dialer.FirstHop)
+	require.Equal(t, []string{"a:443", "b:443"}, dialer.FirstHops)
+
+	// The failure of the first option starts the second one without waiting for the delay.
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, fakes.takeCalls())
+
+	fakes.setFailing("b", true)
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.ErrorContains(t, err, "all race options failed")
+}
+
+// recordingHandler is a [slog.Handler] that sends the records to a channel.
+type recordingHandler struct {
+	records chan slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	h.records <- record
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler      { return h }
+
+// recordLogs sends the logs to the returned channel until the test ends.
+func recordLogs(t *testing.T) <-chan slog.Record {
+	handler := &recordingHandler{make(chan slog.Record, 100)}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	return handler.records
+}
+
+func TestParseRaceStreamDialer_LogsStats(t *testing.T) {
+	fakes := newFakeDialers()
+	node, err := configyaml.ParseConfigYAML(`options: [a, b]`)
+	require.NoError(t, err)
+	hooks := &SessionHooks{}
+	ctx := WithSessionHooks(configyaml.WithPath(context.Background(), "transport"), hooks)
+	dialer, err := parseRaceStreamDialer(ctx, node.(map[string]any), fakes.parse)
+	require.NoError(t, err)
+	_, err = dialer.Dial(context.Background(), "example.com:443")
+	require.NoError(t, err)
+
+	records := recordLogs(t)
+	sessionCtx, endSession := context.WithCancel(context.Background())
+	require.NoError(t, hooks.Start(sessionCtx))
+	// The stats are logged when the session ends.
+	endSession()
+	for option, wins := range []int64{1, 0} {
+		record := <-records
+		require.Equal(t, "race option stats", record.Message)
+		attrs := make(map[string]slog.Value)
+		record.Attrs(func(attr slog.Attr) bool {
+			attrs[attr.Key] = attr.Value
+			return true
+		})
+		require.Equal(t, "transport", attrs["path"].String())
+		require.Equal(t, int64(option), attrs["option"].Int64())
+		require.Equal(t, wins, attrs["wins"].Int64())
+	}
+}
+
+func TestParseRaceStreamDialer_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		errMessage string
+	}{
+		{"no options", `options: []`, "empty list of options"},
+		{"unavailable option", `options: [a, unavailable]`, "option 1 is not available"},
+		{"invalid delay", `{options: [a], delay: soon}`, "failed to parse delay"},
+		{"negative delay", `{options: [a], delay: -1s}`, "failed to parse delay"},
+		{"unknown field", `{options: [a], timeout: 1s}`, "invalid config format"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML(tc.config)
+			require.NoError(t, err)
+			_, err = parseRaceStreamDialer(context.Background(), node.(map[string]any), newFakeDialers().parse)
+			require.ErrorContains(t, err, tc.errMessage)
+		})
+	}
+}
+
+func TestRegisterRace(t *testing.T) {
+	provider := newTestTransportProvider()
+	server := startTCPEchoServer(t)
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: race
+  delay: 10ms
+  options:
+    - $type: block
+    - $type: direct`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeDirect, transportPair.StreamDialer.ConnType)
+
+	conn, err := transportPair.StreamDialer.Dial(context.Background(), server.Addr().String())
+	require.NoError(t, err)
+	requireEcho(t, conn)
+}
@@ -19,12 +19,15 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
 
 	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/shadowsocks2022"
+	"localhost/client/go/outline/sip003"
 	"golang.getoutline.org/sdk/transport"
 	"golang.getoutline.org/sdk/transport/shadowsocks"
 )
@@ -35,6 +38,16 @@ type ShadowsocksConfig struct {
 	Cipher   string
 	Secret   string
 	Prefix   string
+	// Plugin is the SIP003 plugin executable to relay the stream connections through.
+	Plugin      string
+	Plugin_Opts string
+}
+
+// ShadowsocksTransportConfig is the format for the Shadowsocks config when used as a transport.
+type ShadowsocksTransportConfig struct {
+	ShadowsocksConfig `yaml:",inline"`
+	// DNS configures the resolvers for the DNS queries. Defaults to the Outline resolvers.
+	DNS *DNSConfig
 }
 
 // LegacyShadowsocksConfig is the legacy format for the Shadowsocks config.
@@ -44,6 +57,8 @@ type LegacyShadowsocksConfig struct {
 	Method      string
 	Password    string
 	Prefix      string
+	Plugin      string
+	Plugin_Opts string
 }
 
 func NewShadowsocksStreamDialerSubParser(parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
@@ -65,28 +80,29 @@ func NewShadowsocksPacketListenerSubParser(parsePE configyaml.ParseFunc[*Endpoin
 }
 
 func parseShadowsocksTransport(ctx context.Context, config configyaml.ConfigNode, parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]], parsePE configyaml.ParseFunc[*Endpoint[net.Conn]]) (*TransportPair, error) {
+	config, dnsConfig, err := cutShadowsocksTransportDNS(config)
+	if err != nil {
+		return nil, err
+	}
 	params, err := parseShadowsocksParams(config)
 	if err != nil {
 		return nil, err
 	}
 
-	se, err := parseSE(ctx, params.Endpoint)
+	se, err := parseShadowsocksStreamEndpoint(ctx, params, parseSE)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create StreamEndpoint: %w", err)
 	}
-	sd, err := shadowsocks.NewStreamDialer(transport.FuncStreamEndpoint(se.Connect), params.Key)
+	sd, err := newShadowsocksStreamDialer(transport.FuncStreamEndpoint(se.Connect), params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create StreamDialer: %w", err)
 	}
-	if params.SaltGenerator != nil {
-		sd.SaltGenerator = params.SaltGenerator
-	}
 
-	pe, err := parsePE(ctx, params.Endpoint)
+	pe, err := parsePE(configyaml.WithPath(ctx, params.EndpointPath), params.Endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create PacketEndpoint: %w", err)
 	}
-	pl, err := shadowsocks.NewPacketListener(transport.FuncPacketEndpoint(pe.Connect), params.Key)
+	pl, err := newShadowsocksPacketListener(transport.FuncPacketEndpoint(pe.Connect), params, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create PacketListener: %w", err)
 	}
@@ -94,30 +110,50 @@ func parseShadowsocksTransport(ctx context.Context, config configyaml.ConfigNode
 	// For the Shadowsocks transport, the prefix only applies to TCP. To use a prefix with UDP, one needs to
 	// specify it in the PacketListener config explicitly. This is to ensure backwards-compatibility.
 	return wrapTransportPairWithOutlineDNS(
-		&Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnTypeTunneled, se.FirstHop}, sd.DialStream},
-		&PacketListener{ConnectionProviderInfo{ConnTypeTunneled, pe.FirstHop}, pl},
+		ctx,
+		&Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnTypeTunneled, se.FirstHop, se.FirstHops}, sd.DialStream},
+		&PacketListener{ConnectionProviderInfo{ConnTypeTunneled, pe.FirstHop, pe.FirstHops}, pl},
+		dnsConfig,
 	)
 }
 
+// cutShadowsocksTransportDNS returns the Shadowsocks config without the DNS config of a [ShadowsocksTransportConfig],
+// and the DNS config, which is nil if absent.
+func cutShadowsocksTransportDNS(node configyaml.ConfigNode) (configyaml.ConfigNode, *DNSConfig, error) {
+	configMap, ok := node.(map[string]any)
+	if !ok {
+		return node, nil, nil
+	}
+	_, hasEndpoint := configMap["endpoint"]
+	_, hasDNS := configMap["dns"]
+	if !hasEndpoint || !hasDNS {
+		return node, nil, nil
+	}
+	var transportConfig ShadowsocksTransportConfig
+	if err := configyaml.MapToAny(map[string]any{"dns": configMap["dns"]}, &transportConfig); err != nil {
+		return nil, nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	ssConfigMap := maps.Clone(configMap)
+	delete(ssConfigMap, "dns")
+	return ssConfigMap, transportConfig.DNS, nil
+}
+
 func parseShadowsocksStreamDialer(ctx context.Context, config configyaml.ConfigNode, parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) (*Dialer[transport.StreamConn], error) {
 	params, err := parseShadowsocksParams(config)
 	if err != nil {
 		return nil, err
 	}
 
-	se, err := parseSE(ctx, params.Endpoint)
+	se, err := parseShadowsocksStreamEndpoint(ctx, params, parseSE)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create StreamEndpoint: %w", err)
 	}
-	sd, err := shadowsocks.NewStreamDialer(transport.FuncStreamEndpoint(se.Connect), params.Key)
+	sd, err := newShadowsocksStreamDialer(transport.FuncStreamEndpoint(se.Connect), params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create StreamDialer: %w", err)
 	}
-	if params.SaltGenerator != nil {
-		sd.SaltGenerator = params.SaltGenerator
-	}
 
-	return &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnTypeTunneled, se.FirstHop}, sd.DialStream}, nil
+	return &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnTypeTunneled, se.FirstHop, se.FirstHops}, sd.DialStream}, nil
 }
 
 func parseShadowsocksPacketDialer(ctx context.Context, config configyaml.ConfigNode, parsePE configyaml.ParseFunc[*Endpoint[net.Conn]]) (*Dialer[net.Conn], error) {
@@ -126,7 +162,7 @@ func parseShadowsocksPacketDialer(ctx context.Context, config configyaml.ConfigN
 		return nil, err
 	}
 	pd := transport.PacketListenerDialer{Listener: pl}
-	return &Dialer[net.Conn]{ConnectionProviderInfo{ConnTypeTunneled, pl.FirstHop}, pd.DialPacket}, nil
+	return &Dialer[net.Conn]{ConnectionProviderInfo{ConnTypeTunneled, pl.FirstHop, pl.FirstHops}, pd.DialPacket}, nil
 }
 
 func parseShadowsocksPacketListener(ctx context.Context, config configyaml.ConfigNode, parsePE configyaml.ParseFunc[*Endpoint[net.Conn]]) (*PacketListener, error) {
@@ -134,24 +170,86 @@ func parseShadowsocksPacketListener(ctx context.Context, config configyaml.Confi
 	if err != nil {
 		return nil, err
 	}
-	pe, err := parsePE(ctx, params.Endpoint)
+	pe, err := parsePE(configyaml.WithPath(ctx, params.EndpointPath), params.Endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create PacketEndpoint: %w", err)
 	}
-	pl, err := shadowsocks.NewPacketListener(transport.FuncPacketEndpoint(pe.Connect), params.Key)
+	pl, err := newShadowsocksPacketListener(transport.FuncPacketEndpoint(pe.Connect), params, params.SaltGenerator)
 	if err != nil {
 		return nil, err
 	}
-	if params.SaltGenerator != nil {
-		pl.SetSaltGenerator(params.SaltGenerator)
+	return &PacketListener{ConnectionProviderInfo{ConnTypeTunneled, pe.FirstHop, pe.FirstHops}, pl}, nil
+}
+
+// parseShadowsocksStreamEndpoint returns the endpoint for the Shadowsocks stream connections. If there's a
+// plugin, it connects to the plugin, which runs with the client session, or is started on demand without one.
+func parseShadowsocksStreamEndpoint(ctx context.Context, params *shadowsocksParams, parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) (*Endpoint[transport.StreamConn], error) {
+	remote, err := parseSE(configyaml.WithPath(ctx, params.EndpointPath), params.Endpoint)
+	if err != nil || params.Plugin == "" {
+		return remote, err
+	}
+	// The plugin connects to the server through the remote endpoint, so its traffic uses the configured
+	// dialers, which are protected from the VPN.
+	plugin, err := sip003.NewPlugin(params.Plugin, params.PluginOptions, transport.FuncStreamEndpoint(remote.Connect))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shadowsocks plugin: %w", err)
 	}
-	return &PacketListener{ConnectionProviderInfo{ConnTypeTunneled, pe.FirstHop}, pl}, nil
+	if hooks := sessionHooksFromContext(ctx); hooks != nil {
+		hooks.OnStart(plugin.Run)
+	}
+	return &Endpoint[transport.StreamConn]{remote.ConnectionProviderInfo, plugin.ConnectStream}, nil
 }
 
 type shadowsocksParams struct {
-	Endpoint      configyaml.ConfigNode
-	Key           *shadowsocks.EncryptionKey
+	Endpoint configyaml.ConfigNode
+	// EndpointPath is the config path of the endpoint relative to the Shadowsocks config.
+	// It's empty if the endpoint is not a field in the config, like in URLs.
+	EndpointPath string
+	Key          *shadowsocks.EncryptionKey
+	// Key2022 is set instead of Key for Shadowsocks 2022 ciphers.
+	Key2022       *shadowsocks2022.Key
 	SaltGenerator shadowsocks.SaltGenerator
+	// Plugin and PluginOptions specify the SIP003 plugin for stream connections, if any.
+	Plugin        string
+	PluginOptions string
+}
+
+func newShadowsocksStreamDialer(se transport.StreamEndpoint, params *shadowsocksParams) (transport.StreamDialer, error) {
+	if params.Key2022 != nil {
+		sd, err := shadowsocks2022.NewStreamDialer(se, params.Key2022)
+		if err != nil {
+			return nil, err
+		}
+		sd.SaltGenerator = params.SaltGenerator
+		return sd, nil
+	}
+	sd, err := shadowsocks.NewStreamDialer(se, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	if params.SaltGenerator != nil {
+		sd.SaltGenerator = params.SaltGenerator
+	}
+	return sd, nil
+}
+
+// newShadowsocksPacketListener creates the Shadowsocks PacketListener. The salt generator is optional.
+func newShadowsocksPacketListener(pe transport.PacketEndpoint, params *shadowsocksParams, saltGenerator shadowsocks.SaltGenerator) (transport.PacketListener, error) {
+	if params.Key2022 != nil {
+		// Shadowsocks 2022 packets have no salt, so a prefix cannot be applied.
+		if saltGenerator != nil {
+			return nil, errors.New("prefix is not supported for Shadowsocks 2022 packets")
+		}
+		return shadowsocks2022.NewPacketListener(pe, params.Key2022)
+	}
+	pl, err := shadowsocks.NewPacketListener(pe, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	if saltGenerator != nil {
+		pl.SetSaltGenerator(saltGenerator)
+	}
+	return pl, nil
 }
 
 func parseShadowsocksConfig(node configyaml.ConfigNode) (*ShadowsocksConfig, error) {
@@ -177,10 +275,12 @@ func parseShadowsocksConfig(node configyaml.ConfigNode) (*ShadowsocksConfig, err
 				return nil, err
 			}
 			return &ShadowsocksConfig{
-				Endpoint: net.JoinHostPort(config.Server, strconv.FormatUint(uint64(config.Server_Port), 10)),
-				Cipher:   config.Method,
-				Secret:   config.Password,
-				Prefix:   config.Prefix,
+				Endpoint:    net.JoinHostPort(config.Server, strconv.FormatUint(uint64(config.Server_Port), 10)),
+				Cipher:      config.Method,
+				Secret:      config.Password,
+				Prefix:      config.Prefix,
+				Plugin:      config.Plugin,
+				Plugin_Opts: config.Plugin_Opts,
 			}, nil
 		} else {
 			return nil, fmt.Errorf("shadowsocks config missing endpoint")
@@ -204,9 +304,21 @@ func parseShadowsocksParams(node configyaml.ConfigNode) (*shadowsocksParams, err
 	}
 
 	params := &shadowsocksParams{
-		Endpoint: config.Endpoint,
+		Endpoint:      config.Endpoint,
+		Plugin:        config.Plugin,
+		PluginOptions: config.Plugin_Opts,
+	}
+	if configMap, ok := node.(map[string]any); ok && configMap["endpoint"] != nil {
+		params.EndpointPath = "endpoint"
+	}
+	if params.Plugin == "" && params.PluginOptions != "" {
+		return nil, errors.New("plugin_opts requires a plugin")
+	}
+	if shadowsocks2022.IsCipher(config.Cipher) {
+		params.Key2022, err = shadowsocks2022.NewKey(config.Cipher, config.Secret)
+	} else {
+		params.Key, err = shadowsocks.NewEncryptionKey(config.Cipher, config.Secret)
 	}
-	params.Key, err = shadowsocks.NewEncryptionKey(config.Cipher, config.Secret)
 	if err != nil {
 		return nil, fmt.Errorf("invalid cipher: %w", err)
 	}
@@ -287,11 +399,14 @@ func parseShadowsocksLegacyBase64URL(url url.URL) (*ShadowsocksConfig, error) {
 		return nil, fmt.Errorf("failed to parse config part: %w", err)
 	}
 
+	plugin, pluginOpts := parseSIP002Plugin(newURL.Query())
 	return &ShadowsocksConfig{
-		Endpoint: newURL.Host,
-		Cipher:   cipherName,
-		Secret:   secret,
-		Prefix:   newURL.Query().Get("prefix"),
+		Endpoint:    newURL.Host,
+		Cipher:      cipherName,
+		Secret:      secret,
+		Prefix:      newURL.Query().Get("prefix"),
+		Plugin:      plugin,
+		Plugin_Opts: pluginOpts,
 	}, nil
 }
 
@@ -327,10 +442,166 @@ func parseShadowsocksSIP002URL(url url.URL) (*ShadowsocksConfig, error) {
 			return nil, errors.New("invalid cipher info: no secret")
 		}
 	}
+	plugin, pluginOpts := parseSIP002Plugin(url.Query())
 	return &ShadowsocksConfig{
-		Endpoint: url.Host,
-		Cipher:   cipherName,
-		Secret:   secret,
-		Prefix:   url.Query().Get("prefix"),
+		Endpoint:    url.Host,
+		Cipher:      cipherName,
+		Secret:      secret,
+		Prefix:      url.Query().Get("prefix"),
+		Plugin:      plugin,
+		Plugin_Opts: pluginOpts,
 	}, nil
 }
+
+// parseSIP002Plugin returns the plugin name and options from the SIP002 "plugin" query parameter,
+// which has the "name;opt1=value1;opt2=value2" format. The options can also be in the
+// "plugin-opts" parameter.
+func parseSIP002Plugin(query url.Values) (plugin string, options string) {
+	plugin, options, _ = strings.Cut(query.Get("plugin"), ";")
+	if options == "" {
+		options = query.Get("plugin-opts")
+	}
+	return plugin, options
+}
+
+// NormalizeTransportConfig returns the transport config in the advanced format. The legacy Shadowsocks formats
+// (ss:// URLs, legacy JSON and Shadowsocks configs without a $type) are expanded into the equivalent
+// tcpudp config. The prefix and plugin only apply to TCP, as in the legacy formats. Other configs are
+// returned unchanged.
+func NormalizeTransportConfig(node configyaml.ConfigNode) (configyaml.ConfigNode, error) {
+	if configMap, ok := node.(map[string]any); ok {
+		if _, ok := configMap[configyaml.ConfigTypeKey]; ok {
+			return node, nil
+		}
+		if _, ok := configMap[configyaml.ConfigRefKey]; ok {
+			return node, nil
+		}
+	}
+	ssNode, dnsConfig, err := cutShadowsocksTransportDNS(node)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseShadowsocksConfig(ssNode)
+	if err != nil {
+		return nil, err
+	}
+	tcp := map[string]any{
+		configyaml.ConfigTypeKey: "shadowsocks",
+		"endpoint":               config.Endpoint,
+		"cipher":                 config.Cipher,
+		"secret":                 config.Secret,
+	}
+	if config.Prefix != "" {
+		tcp["prefix"] = config.Prefix
+	}
+	if config.Plugin != "" {
+		tcp["plugin"] = config.Plugin
+	}
+	if config.Plugin_Opts != "" {
+		tcp["plugin_opts"] = config.Plugin_Opts
+	}
+	udp := map[string]any{
+		configyaml.ConfigTypeKey: "shadowsocks",
+		"endpoint":               config.Endpoint,
+		"cipher":                 config.Cipher,
+		"secret":                 config.Secret,
+	}
+	transportMap := map[string]any{configyaml.ConfigTypeKey: "tcpudp", "tcp": tcp, "udp": udp}
+	if dnsConfig != nil {
+		// Keep the DNS config as specified.
+		transportMap["dns"] = node.(map[string]any)["dns"]
+	}
+	return transportMap, nil
+}
+
+// SimpleShadowsocksConfig returns the Shadowsocks config of a transport that can be represented as an ss:// URL.
+// That is the case for the legacy Shadowsocks formats, and for their normalized config, as returned by
+// [NormalizeTransportConfig]. It returns false for other transports.
+func SimpleShadowsocksConfig(node configyaml.ConfigNode) (*ShadowsocksConfig, bool) {
+	node, err := NormalizeTransportConfig(node)
+	if err != nil {
+		return nil, false
+	}
+	transportMap, ok := node.(map[string]any)
+	if !ok || transportMap[configyaml.ConfigTypeKey] != "tcpudp" || len(transportMap) != 3 {
+		return nil, false
+	}
+	tcp, ok := stringMap(transportMap["tcp"])
+	if !ok || tcp[configyaml.ConfigTypeKey] != "shadowsocks" {
+		return nil, false
+	}
+	udp, ok := stringMap(transportMap["udp"])
+	if !ok || len(udp) != 4 {
+		return nil, false
+	}
+	for _, key := range []string{configyaml.ConfigTypeKey, "endpoint", "cipher", "secret"} {
+		if _, ok := tcp[key]; !ok || udp[key] != tcp[key] {
+			return nil, false
+		}
+	}
+	for key := range tcp {
+		switch key {
+		case configyaml.ConfigTypeKey, "endpoint", "cipher", "secret", "prefix", "plugin", "plugin_opts":
+		default:
+			return nil, false
+		}
+	}
+	if tcp["plugin_opts"] != "" && tcp["plugin"] == "" {
+		return nil, false
+	}
+	return &ShadowsocksConfig{
+		Endpoint:    tcp["endpoint"],
+		Cipher:      tcp["cipher"],
+		Secret:      tcp["secret"],
+		Prefix:      tcp["prefix"],
+		Plugin:      tcp["plugin"],
+		Plugin_Opts: tcp["plugin_opts"],
+	}, true
+}
+
+// stringMap returns the map if all its values are strings.
+func stringMap(node configyaml.ConfigNode) (map[string]string, bool) {
+	configMap, ok := node.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	result := make(map[string]string, len(configMap))
+	for key, value := range configMap {
+		text, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		result[key] = text
+	}
+	return result, true
+}
+
+// ShadowsocksURL returns the SIP002 ss:// URL for the config, which must have an address endpoint.
+// See https://shadowsocks.org/doc/sip002.html.
+func ShadowsocksURL(config *ShadowsocksConfig) (string, error) {
+	endpoint, ok := config.Endpoint.(string)
+	if !ok {
+		return "", errors.New("endpoint must be an address")
+	}
+	ssURL := url.URL{Scheme: "ss", Host: endpoint, Path: "/"}
+	if shadowsocks2022.IsCipher(config.Cipher) {
+		// Shadowsocks 2022 user info must be percent-encoded rather than Base64URL-encoded.
+		ssURL.User = url.UserPassword(config.Cipher, config.Secret)
+	} else {
+		encoding := base64.URLEncoding.WithPadding(base64.NoPadding)
+		ssURL.User = url.User(encoding.EncodeToString([]byte(config.Cipher + ":" + config.Secret)))
+	}
+	query := url.Values{}
+	if config.Prefix != "" {
+		query.Set("prefix", config.Prefix)
+	}
+	if config.Plugin != "" {
+		plugin := config.Plugin
+		if config.Plugin_Opts != "" {
+			plugin += ";" + config.Plugin_Opts
+		}
+		query.Set("plugin", plugin)
+	}
+	ssURL.RawQuery = query.Encode()
+	return ssURL.String(), nil
+}
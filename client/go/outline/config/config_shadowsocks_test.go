@@ -17,9 +17,14 @@ package config
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"localhost/client/go/configyaml"
@@ -94,6 +99,21 @@ func TestParseShadowsocksConfig_URL(t *testing.T) {
 		require.Equal(t, "SECRET/!@#", config.Secret)
 	})
 
+	t.Run("SIP003 Plugin", func(t *testing.T) {
+		config, err := parseFromYAMLText("ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:1234/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dexample.org#outline-123")
+		require.NoError(t, err)
+		require.Equal(t, "example.com:1234", config.Endpoint)
+		require.Equal(t, "obfs-local", config.Plugin)
+		require.Equal(t, "obfs=http;obfs-host=example.org", config.Plugin_Opts)
+	})
+
+	t.Run("SIP003 Plugin Opts Parameter", func(t *testing.T) {
+		config, err := parseFromYAMLText("ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:1234/?plugin=v2ray-plugin&plugin-opts=tls%3Bhost%3Dexample.org")
+		require.NoError(t, err)
+		require.Equal(t, "v2ray-plugin", config.Plugin)
+		require.Equal(t, "tls;host=example.org", config.Plugin_Opts)
+	})
+
 	t.Run("Invalid Cipher Fails", func(t *testing.T) {
 		configString := "ss://chacha20-ietf-poly13051234567@example.com:1234"
 		_, err := parseShadowsocksParams(configString)
@@ -205,3 +225,221 @@ func TestParseShadowsocksConfig_YAML(t *testing.T) {
 		require.Equal(t, "SSH-2.0\r\n", config.Prefix)
 	})
 }
+
+func TestParseShadowsocksConfig_2022(t *testing.T) {
+	const psk = "YctPZ6U7xPPcU+gp3u+mXcJhoZSY6fFnhTDc1T9p3xc="
+
+	t.Run("SIP002 URL", func(t *testing.T) {
+		configString := fmt.Sprintf("ss://2022-blake3-aes-256-gcm:%s@example.com:1234#outline-123", url.QueryEscape(psk))
+		config, err := parseFromYAMLText(configString)
+		require.NoError(t, err)
+		require.Equal(t, "example.com:1234", config.Endpoint)
+		require.Equal(t, "2022-blake3-aes-256-gcm", config.Cipher)
+		require.Equal(t, psk, config.Secret)
+
+		params, err := parseShadowsocksParams(configString)
+		require.NoError(t, err)
+		require.Nil(t, params.Key)
+		require.NotNil(t, params.Key2022)
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		params, err := parseShadowsocksParams(map[string]any{
+			"endpoint": "example.com:1234",
+			"cipher":   "2022-blake3-aes-128-gcm",
+			"secret":   "AAAAAAAAAAAAAAAAAAAAAA==",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, params.Key2022)
+		require.Equal(t, 16, params.Key2022.SaltSize())
+	})
+
+	t.Run("Invalid PSK Fails", func(t *testing.T) {
+		_, err := parseShadowsocksParams(map[string]any{
+			"endpoint": "example.com:1234",
+			"cipher":   "2022-blake3-aes-128-gcm",
+			"secret":   psk,
+		})
+		require.ErrorContains(t, err, "pre-shared key must be 16 bytes")
+	})
+
+	t.Run("Unsupported Cipher Fails", func(t *testing.T) {
+		_, err := parseShadowsocksParams(map[string]any{
+			"endpoint": "example.com:1234",
+			"cipher":   "2022-blake3-chacha20-poly1305",
+			"secret":   psk,
+		})
+		require.ErrorContains(t, err, "unsupported cipher")
+	})
+
+	t.Run("Transport", func(t *testing.T) {
+		provider := newTestTransportProvider()
+		node, err := configyaml.ParseConfigYAML(fmt.Sprintf(`
+$type: tcpudp
+tcp: &shared
+  $type: shadowsocks
+  endpoint: example.com:1234
+  cipher: 2022-blake3-aes-256-gcm
+  secret: %s
+udp: *shared`, psk))
+		require.NoError(t, err)
+		transportPair, err := provider.Parse(context.Background(), node)
+		require.NoError(t, err)
+		require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+		require.Equal(t, ConnTypeTunneled, transportPair.PacketProxy.ConnType)
+	})
+
+	t.Run("Packet Prefix Fails", func(t *testing.T) {
+		packetEndpoints := configyaml.NewTypeParser(func(ctx context.Context, config configyaml.ConfigNode) (*Endpoint[net.Conn], error) {
+			return &Endpoint[net.Conn]{}, nil
+		})
+		_, err := parseShadowsocksPacketListener(context.Background(), map[string]any{
+			"endpoint": "example.com:1234",
+			"cipher":   "2022-blake3-aes-256-gcm",
+			"secret":   psk,
+			"prefix":   "POST ",
+		}, packetEndpoints.Parse)
+		require.ErrorContains(t, err, "prefix is not supported for Shadowsocks 2022 packets")
+	})
+}
+
+func TestParseShadowsocksStreamDialer_PluginNotInstalled(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	provider := newTestTransportProvider()
+	ctx := WithSessionHooks(context.Background(), &SessionHooks{})
+
+	node, err := configyaml.ParseConfigYAML(`ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:1234/?plugin=obfs-local`)
+	require.NoError(t, err)
+	_, err = provider.Parse(ctx, node)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+
+	// Configs with unsupported plugins are skipped by first-supported.
+	node, err = configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: first-supported
+  options:
+    - $type: shadowsocks
+      endpoint: example.com:1234
+      cipher: chacha20-ietf-poly1305
+      secret: SECRET
+      plugin: obfs-local
+    - $type: shadowsocks
+      endpoint: example.com:4321
+      cipher: chacha20-ietf-poly1305
+      secret: SECRET`)
+	require.NoError(t, err)
+	transportPair, err := provider.Parse(ctx, node)
+	require.NoError(t, err)
+	require.Equal(t, "example.com:4321", transportPair.StreamDialer.FirstHop)
+}
+
+func TestParseShadowsocksParams_PluginOptsWithoutPlugin(t *testing.T) {
+	_, err := parseShadowsocksParams(map[string]any{
+		"endpoint":    "example.com:1234",
+		"cipher":      "chacha20-ietf-poly1305",
+		"secret":      "SECRET",
+		"plugin_opts": "obfs=http",
+	})
+	require.ErrorContains(t, err, "plugin_opts requires a plugin")
+}
+
+func TestParseShadowsocksStreamDialer_UnknownPlugin(t *testing.T) {
+	node, err := configyaml.ParseConfigYAML(`ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:1234/?plugin=%2Fbin%2Fsh`)
+	require.NoError(t, err)
+	_, err = newTestTransportProvider().Parse(WithSessionHooks(context.Background(), &SessionHooks{}), node)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestParseShadowsocksStreamDialer_Plugin(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SIP003 plugins are only supported on Linux")
+	}
+	// Install a fake obfs-local plugin.
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+	pathDir := t.TempDir()
+	require.NoError(t, os.Symlink(truePath, filepath.Join(pathDir, "obfs-local")))
+	t.Setenv("PATH", pathDir)
+
+	provider := newTestTransportProvider()
+	node, err := configyaml.ParseConfigYAML(`ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:1234/?plugin=obfs-local`)
+	require.NoError(t, err)
+
+	hooks := &SessionHooks{}
+	transportPair, err := provider.Parse(WithSessionHooks(context.Background(), hooks), node)
+	require.NoError(t, err)
+	require.Equal(t, "example.com:1234", transportPair.StreamDialer.FirstHop)
+	require.Equal(t, "example.com:1234", transportPair.PacketProxy.FirstHop)
+	// The plugin and the DNS cache stats run with the session.
+	require.Len(t, hooks.onStart, 2)
+
+	// Without a session, like in connectivity checks, the plugin starts on demand. The fake plugin exits
+	// right away.
+	transportPair, err = provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	_, err = transportPair.StreamDialer.Dial(context.Background(), "example.com:443")
+	require.ErrorContains(t, err, "exited")
+}
+
+func TestShadowsocksURL(t *testing.T) {
+	ssURL, err := ShadowsocksURL(&ShadowsocksConfig{
+		Endpoint:    "example.com:4321",
+		Cipher:      "chacha20-ietf-poly1305",
+		Secret:      "SECRET",
+		Prefix:      "POST ",
+		Plugin:      "obfs-local",
+		Plugin_Opts: "obfs=http;obfs-host=example.org",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:4321/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dexample.org&prefix=POST+", ssURL)
+
+	ssURL, err = ShadowsocksURL(&ShadowsocksConfig{
+		Endpoint: "[::1]:443",
+		Cipher:   "2022-blake3-aes-128-gcm",
+		Secret:   "AAAAAAAAAAAAAAAAAAAA+w==",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "ss://2022-blake3-aes-128-gcm:AAAAAAAAAAAAAAAAAAAA+w==@[::1]:443/", ssURL)
+
+	_, err = ShadowsocksURL(&ShadowsocksConfig{Endpoint: map[string]any{"$type": "dial"}})
+	require.Error(t, err)
+}
+
+func TestSimpleShadowsocksConfig(t *testing.T) {
+	ssConfig, ok := SimpleShadowsocksConfig(map[string]any{
+		"server": "example.com", "server_port": uint64(4321), "method": "chacha20-ietf-poly1305", "password": "SECRET", "prefix": "POST ",
+	})
+	require.True(t, ok)
+	require.Equal(t, &ShadowsocksConfig{Endpoint: "example.com:4321", Cipher: "chacha20-ietf-poly1305", Secret: "SECRET", Prefix: "POST "}, ssConfig)
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp: &shared
+  $type: shadowsocks
+  endpoint: example.com:4321
+  cipher: chacha20-ietf-poly1305
+  secret: SECRET
+udp: *shared`)
+	require.NoError(t, err)
+	ssConfig, ok = SimpleShadowsocksConfig(node)
+	require.True(t, ok)
+	require.Equal(t, &ShadowsocksConfig{Endpoint: "example.com:4321", Cipher: "chacha20-ietf-poly1305", Secret: "SECRET"}, ssConfig)
+
+	for _, config := range []string{
+		// UDP prefix.
+		`{$type: tcpudp, tcp: &s {$type: shadowsocks, endpoint: a:1, cipher: c, secret: s, prefix: p}, udp: *s}`,
+		// Different UDP endpoint.
+		`{$type: tcpudp, tcp: {$type: shadowsocks, endpoint: a:1, cipher: c, secret: s}, udp: {$type: shadowsocks, endpoint: b:1, cipher: c, secret: s}}`,
+		// Endpoint is not an address.
+		`{$type: tcpudp, tcp: &s {$type: shadowsocks, endpoint: {$type: dial, address: a:1}, cipher: c, secret: s}, udp: *s}`,
+		// Not Shadowsocks.
+		`{$type: tcpudp, tcp: {$type: split, sizes: [1], dialer: ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@a:1}, udp: {$type: shadowsocks, endpoint: a:1, cipher: c, secret: s}}`,
+		`{$ref: proxy}`,
+	} {
+		node, err := configyaml.ParseConfigYAML(config)
+		require.NoError(t, err)
+		_, ok := SimpleShadowsocksConfig(node)
+		require.False(t, ok, config)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/transport/socks5"
+)
+
+// SOCKS5Config is the format for the SOCKS5 config. It can specify a StreamDialer or a PacketListener.
+type SOCKS5Config struct {
+	// Endpoint is the stream endpoint of the SOCKS5 server.
+	Endpoint configyaml.ConfigNode
+	// Username and Password enable the username/password authentication method (RFC 1929).
+	Username string
+	Password string
+	// Packet_Dialer is used to send packets to the UDP relay returned by UDP ASSOCIATE.
+	// If absent, the default packet dialer is used.
+	Packet_Dialer configyaml.ConfigNode
+}
+
+func NewSOCKS5StreamDialerSubParser(parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		return parseSOCKS5StreamDialer(ctx, input, parseSE)
+	}
+}
+
+func NewSOCKS5PacketListenerSubParser(
+	parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]],
+	parsePD configyaml.ParseFunc[*Dialer[net.Conn]],
+) func(ctx context.Context, input map[string]any) (*PacketListener, error) {
+	return func(ctx context.Context, input map[string]any) (*PacketListener, error) {
+		return parseSOCKS5PacketListener(ctx, input, parseSE, parsePD)
+	}
+}
+
+func parseSOCKS5StreamDialer(ctx context.Context, configMap map[string]any, parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) (*Dialer[transport.StreamConn], error) {
+	client, se, _, err := newSOCKS5Client(ctx, configMap, parseSE)
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnTypeTunneled, se.FirstHop, se.FirstHops}, client.DialStream}, nil
+}
+
+func parseSOCKS5PacketListener(
+	ctx context.Context,
+	configMap map[string]any,
+	parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]],
+	parsePD configyaml.ParseFunc[*Dialer[net.Conn]],
+) (*PacketListener, error) {
+	client, se, config, err := newSOCKS5Client(ctx, configMap, parseSE)
+	if err != nil {
+		return nil, err
+	}
+
+	pd, err := parsePD(configyaml.WithPath(ctx, "packet_dialer"), config.Packet_Dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse packet dialer: %w", err)
+	}
+	if pd == nil {
+		return nil, errors.New("packet dialer is not available")
+	}
+	client.EnablePacket(transport.FuncPacketDialer(pd.Dial))
+
+	return &PacketListener{ConnectionProviderInfo{ConnTypeTunneled, se.FirstHop, se.FirstHops}, client}, nil
+}
+
+func newSOCKS5Client(ctx context.Context, configMap map[string]any, parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) (*socks5.Client, *Endpoint[transport.StreamConn], *SOCKS5Config, error) {
+	var config SOCKS5Config
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if config.Endpoint == nil {
+		return nil, nil, nil, errors.New("socks5 endpoint must be specified")
+	}
+	if config.Username == "" && config.Password != "" {
+		return nil, nil, nil, errors.New("socks5 password requires a username")
+	}
+
+	se, err := parseSE(configyaml.WithPath(ctx, "endpoint"), config.Endpoint)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create StreamEndpoint: %w", err)
+	}
+
+	client, err := socks5.NewClient(transport.FuncStreamEndpoint(se.Connect))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create SOCKS5 client: %w", err)
+	}
+	if config.Username != "" {
+		if err := client.SetCredentials([]byte(config.Username), []byte(config.Password)); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid socks5 credentials: %w", err)
+		}
+	}
+	return client, se, &config, nil
+}
@@ -0,0 +1,395 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// socks5TestServer is a minimal in-process SOCKS5 server (RFC 1928) that supports
+// the CONNECT and UDP ASSOCIATE commands, and optionally username/password authentication (RFC 1929).
+type socks5TestServer struct {
+	listener net.Listener
+	username string
+	password string
+}
+
+func startSOCKS5TestServer(t *testing.T, username, password string) *socks5TestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &socks5TestServer{listener: listener, username: username, password: password}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				server.handle(conn)
+			}()
+		}
+	}()
+	return server
+}
+
+func (s *socks5TestServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *socks5TestServer) handle(conn net.Conn) error {
+	// Method negotiation.
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+	wantMethod := byte(0x00)
+	if s.username != "" {
+		wantMethod = 0x02
+	}
+	if !bytes.Contains(methods, []byte{wantMethod}) {
+		conn.Write([]byte{5, 0xff})
+		return errors.New("no acceptable method")
+	}
+	conn.Write([]byte{5, wantMethod})
+
+	if wantMethod == 0x02 {
+		var version [1]byte
+		if _, err := io.ReadFull(conn, version[:]); err != nil {
+			return err
+		}
+		username, err := readSOCKS5String(conn)
+		if err != nil {
+			return err
+		}
+		password, err := readSOCKS5String(conn)
+		if err != nil {
+			return err
+		}
+		if username != s.username || password != s.password {
+			conn.Write([]byte{1, 1})
+			return errors.New("invalid credentials")
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	// Request.
+	var request [3]byte
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return err
+	}
+	dstAddr, err := readSOCKS5Addr(conn)
+	if err != nil {
+		return err
+	}
+	switch request[1] {
+	case 0x01: // CONNECT
+		target, err := net.Dial("tcp", dstAddr)
+		if err != nil {
+			conn.Write(appendSOCKS5Addr([]byte{5, 0x05, 0}, netip.IPv4Unspecified().String()+":0"))
+			return err
+		}
+		defer target.Close()
+		conn.Write(appendSOCKS5Addr([]byte{5, 0, 0}, target.LocalAddr().String()))
+		go io.Copy(target, conn)
+		_, err = io.Copy(conn, target)
+		return err
+
+	case 0x03: // UDP ASSOCIATE
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return err
+		}
+		defer relay.Close()
+		conn.Write(appendSOCKS5Addr([]byte{5, 0, 0}, relay.LocalAddr().String()))
+		go s.relayUDP(relay)
+		// The association lasts as long as the control connection.
+		_, err = io.Copy(io.Discard, conn)
+		return err
+
+	default:
+		conn.Write(appendSOCKS5Addr([]byte{5, 0x07, 0}, netip.IPv4Unspecified().String()+":0"))
+		return fmt.Errorf("unsupported command %d", request[1])
+	}
+}
+
+func (s *socks5TestServer) relayUDP(relay *net.UDPConn) {
+	var clientAddr net.Addr
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if clientAddr == nil || addr.String() == clientAddr.String() {
+			// Client to destination.
+			clientAddr = addr
+			reader := bytes.NewReader(buf[3:n])
+			dstAddr, err := readSOCKS5Addr(reader)
+			if err != nil {
+				continue
+			}
+			dstUDPAddr, err := net.ResolveUDPAddr("udp", dstAddr)
+			if err != nil {
+				continue
+			}
+			payload, _ := io.ReadAll(reader)
+			relay.WriteTo(payload, dstUDPAddr)
+		} else {
+			// Destination to client.
+			packet := appendSOCKS5Addr([]byte{0, 0, 0}, addr.String())
+			relay.WriteTo(append(packet, buf[:n]...), clientAddr)
+		}
+	}
+}
+
+func readSOCKS5String(r io.Reader) (string, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", err
+	}
+	value := make([]byte, length[0])
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func readSOCKS5Addr(r io.Reader) (string, error) {
+	var addrType [1]byte
+	if _, err := io.ReadFull(r, addrType[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch addrType[0] {
+	case 0x01:
+		var ip [4]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return "", err
+		}
+		host = netip.AddrFrom4(ip).String()
+	case 0x04:
+		var ip [16]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return "", err
+		}
+		host = netip.AddrFrom16(ip).String()
+	case 0x03:
+		name, err := readSOCKS5String(r)
+		if err != nil {
+			return "", err
+		}
+		host = name
+	default:
+		return "", fmt.Errorf("invalid address type %d", addrType[0])
+	}
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+func appendSOCKS5Addr(b []byte, address string) []byte {
+	addrPort := netip.MustParseAddrPort(address)
+	if addrPort.Addr().Unmap().Is4() {
+		b = append(b, 0x01)
+		b = append(b, addrPort.Addr().Unmap().AsSlice()...)
+	} else {
+		b = append(b, 0x04)
+		b = append(b, addrPort.Addr().AsSlice()...)
+	}
+	return binary.BigEndian.AppendUint16(b, addrPort.Port())
+}
+
+func startTCPEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func startUDPEchoServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func directTestStreamEndpoints(ctx context.Context, input configyaml.ConfigNode) (*Endpoint[transport.StreamConn], error) {
+	address, ok := input.(string)
+	if !ok {
+		return nil, fmt.Errorf("endpoint must be a string, found %T", input)
+	}
+	return &Endpoint[transport.StreamConn]{
+		ConnectionProviderInfo: ConnectionProviderInfo{ConnType: ConnTypeDirect, FirstHop: address},
+		Connect:                (&transport.TCPEndpoint{Address: address}).ConnectStream,
+	}, nil
+}
+
+func directTestPacketDialers(ctx context.Context, input configyaml.ConfigNode) (*Dialer[net.Conn], error) {
+	return &Dialer[net.Conn]{ConnectionProviderInfo{ConnType: ConnTypeDirect}, (&transport.UDPDialer{}).DialPacket}, nil
+}
+
+func TestParseSOCKS5StreamDialer(t *testing.T) {
+	echoServer := startTCPEchoServer(t)
+
+	for _, auth := range []struct{ username, password string }{{"", ""}, {"user", "pass"}} {
+		t.Run(fmt.Sprintf("auth=%v", auth.username != ""), func(t *testing.T) {
+			proxy := startSOCKS5TestServer(t, auth.username, auth.password)
+
+			dialer, err := parseSOCKS5StreamDialer(context.Background(), map[string]any{
+				"endpoint": proxy.Addr(),
+				"username": auth.username,
+				"password": auth.password,
+			}, directTestStreamEndpoints)
+			require.NoError(t, err)
+			require.Equal(t, ConnTypeTunneled, dialer.ConnType)
+			require.Equal(t, proxy.Addr(), dialer.FirstHop)
+
+			conn, err := dialer.Dial(context.Background(), echoServer.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(buf))
+		})
+	}
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		proxy := startSOCKS5TestServer(t, "user", "pass")
+
+		dialer, err := parseSOCKS5StreamDialer(context.Background(), map[string]any{
+			"endpoint": proxy.Addr(),
+			"username": "user",
+			"password": "wrong",
+		}, directTestStreamEndpoints)
+		require.NoError(t, err)
+
+		_, err = dialer.Dial(context.Background(), echoServer.Addr().String())
+		require.Error(t, err)
+	})
+}
+
+func TestParseSOCKS5PacketListener(t *testing.T) {
+	echoServer := startUDPEchoServer(t)
+	proxy := startSOCKS5TestServer(t, "user", "pass")
+
+	pl, err := parseSOCKS5PacketListener(context.Background(), map[string]any{
+		"endpoint": proxy.Addr(),
+		"username": "user",
+		"password": "pass",
+	}, directTestStreamEndpoints, directTestPacketDialers)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, pl.ConnType)
+	require.Equal(t, proxy.Addr(), pl.FirstHop)
+
+	conn, err := pl.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.WriteTo([]byte("ping"), echoServer.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, addr, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf[:n]))
+	require.Equal(t, echoServer.LocalAddr().String(), addr.String())
+}
+
+func TestParseSOCKS5Errors(t *testing.T) {
+	_, err := parseSOCKS5StreamDialer(context.Background(), map[string]any{}, directTestStreamEndpoints)
+	require.ErrorContains(t, err, "socks5 endpoint must be specified")
+
+	_, err = parseSOCKS5StreamDialer(context.Background(), map[string]any{
+		"endpoint": "127.0.0.1:1080",
+		"password": "pass",
+	}, directTestStreamEndpoints)
+	require.ErrorContains(t, err, "socks5 password requires a username")
+
+	_, err = parseSOCKS5StreamDialer(context.Background(), map[string]any{
+		"endpoint": "127.0.0.1:1080",
+		"unknown":  "field",
+	}, directTestStreamEndpoints)
+	require.ErrorContains(t, err, "invalid config format")
+}
+
+func TestRegisterSOCKS5(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp: &shared
+  $type: socks5
+  endpoint: proxy.example.com:1080
+  username: user
+  password: pass
+udp: *shared`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "proxy.example.com:1080", transportPair.StreamDialer.FirstHop)
+	require.Equal(t, ConnTypeTunneled, transportPair.PacketProxy.ConnType)
+	require.Equal(t, "proxy.example.com:1080", transportPair.PacketProxy.FirstHop)
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/split"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// SplitConfig is the format for the Split Stream Dialer config.
+type SplitConfig struct {
+	// Dialer is the stream dialer to wrap. If absent, the default dialer is used.
+	Dialer configyaml.ConfigNode
+	// Sizes are the sizes of the segments to split the first bytes of a write into.
+	Sizes []int
+	// Delay is the time to wait between segments, e.g. "10ms".
+	Delay string
+	// Writes is the number of writes to split. Defaults to 1.
+	Writes int
+}
+
+func NewSplitStreamDialerSubParser(parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		return parseSplitStreamDialer(ctx, input, parseSD)
+	}
+}
+
+func parseSplitStreamDialer(ctx context.Context, configMap map[string]any, parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) (*Dialer[transport.StreamConn], error) {
+	var config SplitConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+
+	var delay time.Duration
+	if config.Delay != "" {
+		var err error
+		delay, err = time.ParseDuration(config.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse delay: %w", err)
+		}
+	}
+	if config.Writes == 0 {
+		config.Writes = 1
+	}
+
+	sd, err := parseSD(configyaml.WithPath(ctx, "dialer"), config.Dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nested stream dialer: %w", err)
+	}
+	if sd == nil {
+		return nil, errors.New("stream dialer is not available")
+	}
+
+	splitSD, err := split.NewStreamDialer(transport.FuncStreamDialer(sd.Dial), config.Sizes, delay, config.Writes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid split config: %w", err)
+	}
+	return &Dialer[transport.StreamConn]{sd.ConnectionProviderInfo, splitSD.DialStream}, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSplitStreamDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	dialer, err := parseSplitStreamDialer(context.Background(), map[string]any{
+		"sizes":  []any{1, 2},
+		"delay":  "1ms",
+		"writes": 2,
+	}, func(ctx context.Context, input configyaml.ConfigNode) (*Dialer[transport.StreamConn], error) {
+		require.Nil(t, input)
+		return &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnType: ConnTypeDirect}, (&transport.TCPDialer{}).DialStream}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeDirect, dialer.ConnType)
+
+	conn, err := dialer.Dial(context.Background(), listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, conn.CloseWrite())
+	conn.Close()
+
+	select {
+	case data := <-received:
+		require.Equal(t, "hello world", string(data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for data")
+	}
+}
+
+func TestParseSplitStreamDialer_Errors(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"no sizes", "delay: 1ms", "sizes must not be empty"},
+		{"zero size", "sizes: [1, 0]", "segment size must be positive, found 0"},
+		{"bad delay", "sizes: [1]\n  delay: soon", "failed to parse delay"},
+		{"negative writes", "sizes: [1]\n  writes: -1", "writes must be positive, found -1"},
+		{"unknown field", "sizes: [1]\n  foo: bar", "invalid config format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML("$type: tcpudp\ntcp:\n  $type: split\n  " + tt.config)
+			require.NoError(t, err)
+			_, err = provider.Parse(context.Background(), node)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRegisterSplit(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: shadowsocks
+  endpoint:
+    $type: dial
+    address: example.com:4321
+    dialer:
+      $type: split
+      sizes: [1, 5]
+      delay: 10ms
+  cipher: chacha20-ietf-poly1305
+  secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "example.com:4321", transportPair.StreamDialer.FirstHop)
+}
@@ -27,6 +27,8 @@ import (
 type TCPUDPConfig struct {
 	TCP configyaml.ConfigNode
 	UDP configyaml.ConfigNode
+	// DNS configures the resolvers for the DNS queries. Defaults to the Outline resolvers.
+	DNS *DNSConfig
 }
 
 func NewTCPUDPTransportPairSubParser(
@@ -43,15 +45,15 @@ func parseTCPUDPTransportPair(ctx context.Context, configMap map[string]any, par
 		return nil, fmt.Errorf("invalid config format: %w", err)
 	}
 
-	sd, err := parseSD(ctx, config.TCP)
+	sd, err := parseSD(configyaml.WithPath(ctx, "tcp"), config.TCP)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse StreamDialer: %w", err)
 	}
 
-	pl, err := parsePL(ctx, config.UDP)
+	pl, err := parsePL(configyaml.WithPath(ctx, "udp"), config.UDP)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse PacketListener: %w", err)
 	}
 
-	return wrapTransportPairWithOutlineDNS(sd, pl)
+	return wrapTransportPairWithOutlineDNS(ctx, sd, pl, config.DNS)
 }
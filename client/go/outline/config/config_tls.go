@@ -0,0 +1,245 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/platerrors"
+	"golang.getoutline.org/sdk/transport"
+	sdktls "golang.getoutline.org/sdk/transport/tls"
+)
+
+// TLSEndpointConfig is the format for the TLS Stream Endpoint config.
+type TLSEndpointConfig struct {
+	// Endpoint is the stream endpoint to establish the TLS connection over.
+	Endpoint configyaml.ConfigNode
+	// SNI is the server name used for the handshake and certificate verification.
+	// It defaults to the endpoint host.
+	SNI string
+	// ALPN is the list of application protocols to offer.
+	ALPN []string
+	// Min_Version is the minimum TLS version to accept, e.g. "1.2" or "1.3".
+	Min_Version string
+	// CA_Bundle is a PEM bundle of the certificate authorities to trust instead of the system ones.
+	CA_Bundle string
+	// SPKI_Pins is a list of base64-encoded SHA-256 hashes of the Subject Public Key Info.
+	// If present, the verified chain must include a certificate with one of the pinned keys.
+	SPKI_Pins []string
+}
+
+func NewTLSStreamEndpointSubParser(parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Endpoint[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Endpoint[transport.StreamConn], error) {
+		return parseTLSStreamEndpoint(ctx, input, parseSE)
+	}
+}
+
+func parseTLSStreamEndpoint(ctx context.Context, configMap map[string]any, parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) (*Endpoint[transport.StreamConn], error) {
+	var config TLSEndpointConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+	if config.Endpoint == nil {
+		return nil, errors.New("tls endpoint must be specified")
+	}
+
+	serverName, err := tlsServerName(config.SNI, config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var options []sdktls.ClientOption
+	if len(config.ALPN) > 0 {
+		options = append(options, sdktls.WithALPN(config.ALPN))
+	}
+	var minVersion uint16
+	if config.Min_Version != "" {
+		minVersion, err = parseTLSVersion(config.Min_Version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var roots *x509.CertPool
+	if config.CA_Bundle != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(config.CA_Bundle)) {
+			return nil, errors.New("tls ca_bundle has no valid PEM certificates")
+		}
+	}
+	if len(config.SPKI_Pins) > 0 {
+		verifier, err := newSPKIPinVerifier(serverName, roots, config.SPKI_Pins)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktls.WithCertVerifier(verifier))
+	} else if roots != nil {
+		options = append(options, sdktls.WithCertVerifier(&sdktls.StandardCertVerifier{CertificateName: serverName, Roots: roots}))
+	}
+
+	se, err := parseSE(configyaml.WithPath(ctx, "endpoint"), config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create StreamEndpoint: %w", err)
+	}
+	endpoint := newTLSStreamEndpoint(transport.FuncStreamEndpoint(se.Connect), serverName, options...)
+	if minVersion != 0 {
+		endpoint = withMinTLSVersion(endpoint, minVersion)
+	}
+	return &Endpoint[transport.StreamConn]{
+		ConnectionProviderInfo: se.ConnectionProviderInfo,
+		Connect:                endpoint.ConnectStream,
+	}, nil
+}
+
+// tlsServerName returns the SNI if set. Otherwise it returns the host of the endpoint, which must be an address.
+func tlsServerName(sni string, endpoint configyaml.ConfigNode) (string, error) {
+	if sni != "" {
+		return sni, nil
+	}
+	address, ok := endpoint.(string)
+	if !ok {
+		return "", errors.New("sni must be specified when the endpoint is not an address")
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint address: %w", err)
+	}
+	return host, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q", version)
+	}
+}
+
+// spkiPinVerifier is a [sdktls.CertVerifier] that verifies the certificate chain like
+// [sdktls.StandardCertVerifier], and checks that a verified chain includes a certificate whose
+// public key matches one of the pins. It verifies the chain itself, since the pinned key may be
+// the one of a root that the server doesn't send.
+type spkiPinVerifier struct {
+	serverName string
+	// roots are the trusted certificate authorities, or nil for the system ones.
+	roots  *x509.CertPool
+	hashes [][]byte
+}
+
+var _ sdktls.CertVerifier = (*spkiPinVerifier)(nil)
+
+func newSPKIPinVerifier(serverName string, roots *x509.CertPool, pins []string) (*spkiPinVerifier, error) {
+	hashes := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid spki pin %q: %w", pin, err)
+		}
+		if len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid spki pin %q: must be a SHA-256 hash", pin)
+		}
+		hashes = append(hashes, hash)
+	}
+	return &spkiPinVerifier{serverName: serverName, roots: roots, hashes: hashes}, nil
+}
+
+// VerifyCertificate implements [sdktls.CertVerifier].
+func (v *spkiPinVerifier) VerifyCertificate(info *sdktls.CertVerificationContext) error {
+	if len(info.PeerCertificates) == 0 {
+		return errors.New("server sent no certificates")
+	}
+	opts := x509.VerifyOptions{DNSName: v.serverName, Roots: v.roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range info.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := info.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			certHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, hash := range v.hashes {
+				if bytes.Equal(certHash[:], hash) {
+					return nil
+				}
+			}
+		}
+	}
+	return platerrors.PlatformError{
+		Code:    platerrors.CertificatePinMismatch,
+		Message: "server certificate doesn't match any of the pinned public keys",
+		Details: platerrors.ErrorDetails{"sni": v.serverName},
+	}
+}
+
+// newTLSStreamEndpoint returns a [transport.StreamEndpoint] that performs a TLS handshake for the
+// server name over the connections established by the given endpoint, using the SDK TLS client.
+func newTLSStreamEndpoint(endpoint transport.StreamEndpoint, serverName string, options ...sdktls.ClientOption) transport.StreamEndpoint {
+	return transport.FuncStreamEndpoint(func(ctx context.Context) (transport.StreamConn, error) {
+		innerConn, err := endpoint.ConnectStream(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := sdktls.WrapConn(ctx, innerConn, serverName, options...)
+		if err != nil {
+			innerConn.Close()
+			// Surface platform errors, such as pin mismatches, as is.
+			var platErr platerrors.PlatformError
+			if errors.As(err, &platErr) {
+				return nil, platErr
+			}
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		return conn, nil
+	})
+}
+
+// withMinTLSVersion returns an endpoint that rejects the TLS connections of the given endpoint that
+// negotiated a version older than minVersion. The SDK TLS client has no option for the minimum version,
+// so it's checked after the handshake.
+func withMinTLSVersion(endpoint transport.StreamEndpoint, minVersion uint16) transport.StreamEndpoint {
+	return transport.FuncStreamEndpoint(func(ctx context.Context) (transport.StreamConn, error) {
+		conn, err := endpoint.ConnectStream(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stater, ok := conn.(interface{ ConnectionState() tls.ConnectionState })
+		if !ok {
+			conn.Close()
+			return nil, errors.New("TLS handshake failed: failed to get the TLS version")
+		}
+		if version := stater.ConnectionState().Version; version < minVersion {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: negotiated %v, below the minimum %v", tls.VersionName(version), tls.VersionName(minVersion))
+		}
+		return conn, nil
+	})
+}
@@ -0,0 +1,197 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/httpconnect"
+	"localhost/client/go/outline/platerrors"
+	"golang.getoutline.org/sdk/transport"
+	sdktls "golang.getoutline.org/sdk/transport/tls"
+	"github.com/stretchr/testify/require"
+)
+
+// startTLSEchoServer starts a TLS echo server that uses the httptest certificate, which is valid for example.com.
+// It returns the server address and the certificate.
+func startTLSEchoServer(t *testing.T, config *tls.Config) (string, *x509.Certificate) {
+	certServer := httptest.NewUnstartedServer(nil)
+	certServer.StartTLS()
+	defer certServer.Close()
+	config.Certificates = certServer.TLS.Certificates
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String(), certServer.Certificate()
+}
+
+func certificatePEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func spkiPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestParseTLSStreamEndpoint(t *testing.T) {
+	address, cert := startTLSEchoServer(t, &tls.Config{NextProtos: []string{"h2", "test-proto"}})
+
+	endpoint, err := parseTLSStreamEndpoint(context.Background(), map[string]any{
+		"endpoint":    address,
+		"sni":         "example.com",
+		"alpn":        []any{"test-proto"},
+		"min_version": "1.2",
+		"ca_bundle":   certificatePEM(cert),
+		"spki_pins":   []any{"sha256/" + spkiPin(cert)},
+	}, directTestStreamEndpoints)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeDirect, endpoint.ConnType)
+	require.Equal(t, address, endpoint.FirstHop)
+
+	conn, err := endpoint.Connect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "test-proto", conn.(interface{ ConnectionState() tls.ConnectionState }).ConnectionState().NegotiatedProtocol)
+	requireEcho(t, conn)
+}
+
+func TestParseTLSStreamEndpoint_Untrusted(t *testing.T) {
+	address, _ := startTLSEchoServer(t, &tls.Config{})
+
+	endpoint, err := parseTLSStreamEndpoint(context.Background(), map[string]any{
+		"endpoint": address,
+		"sni":      "example.com",
+	}, directTestStreamEndpoints)
+	require.NoError(t, err)
+
+	_, err = endpoint.Connect(context.Background())
+	require.ErrorContains(t, err, "TLS handshake failed")
+}
+
+func TestParseTLSStreamEndpoint_PinMismatch(t *testing.T) {
+	address, cert := startTLSEchoServer(t, &tls.Config{})
+
+	endpoint, err := parseTLSStreamEndpoint(context.Background(), map[string]any{
+		"endpoint":  address,
+		"sni":       "example.com",
+		"ca_bundle": certificatePEM(cert),
+		"spki_pins": []any{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))},
+	}, directTestStreamEndpoints)
+	require.NoError(t, err)
+
+	_, err = endpoint.Connect(context.Background())
+	perr := platerrors.ToPlatformError(err)
+	require.Equal(t, platerrors.CertificatePinMismatch, perr.Code)
+	require.Equal(t, "example.com", perr.Details["sni"])
+}
+
+func TestParseTLSStreamEndpoint_MinVersion(t *testing.T) {
+	address, cert := startTLSEchoServer(t, &tls.Config{MaxVersion: tls.VersionTLS12})
+
+	endpoint, err := parseTLSStreamEndpoint(context.Background(), map[string]any{
+		"endpoint":    address,
+		"sni":         "example.com",
+		"min_version": "1.3",
+		"ca_bundle":   certificatePEM(cert),
+	}, directTestStreamEndpoints)
+	require.NoError(t, err)
+
+	_, err = endpoint.Connect(context.Background())
+	require.ErrorContains(t, err, "TLS handshake failed")
+}
+
+func TestParseTLSStreamEndpoint_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantErr string
+	}{
+		{"no endpoint", map[string]any{}, "tls endpoint must be specified"},
+		{"no sni", map[string]any{"endpoint": map[string]any{"$type": "dial", "address": "example.com:443"}}, "sni must be specified when the endpoint is not an address"},
+		{"bad version", map[string]any{"endpoint": "example.com:443", "min_version": "2.0"}, `unsupported TLS version "2.0"`},
+		{"bad ca bundle", map[string]any{"endpoint": "example.com:443", "ca_bundle": "not a certificate"}, "tls ca_bundle has no valid PEM certificates"},
+		{"bad pin", map[string]any{"endpoint": "example.com:443", "spki_pins": []any{"abc"}}, `invalid spki pin "abc"`},
+		{"short pin", map[string]any{"endpoint": "example.com:443", "spki_pins": []any{"YWJj"}}, "must be a SHA-256 hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTLSStreamEndpoint(context.Background(), tt.config, directTestStreamEndpoints)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNewTLSStreamEndpoint_HTTPConnect(t *testing.T) {
+	echoServer := startTCPEchoServer(t)
+	proxy := httptest.NewTLSServer(newHTTPConnectTestHandler(nil))
+	defer proxy.Close()
+
+	verifier := &sdktls.StandardCertVerifier{
+		CertificateName: "example.com",
+		Roots:           proxy.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+	endpoint := newTLSStreamEndpoint(&transport.TCPEndpoint{Address: proxy.Listener.Addr().String()}, "example.com", sdktls.WithCertVerifier(verifier))
+	dialer, err := httpconnect.NewStreamDialer(endpoint, nil)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), echoServer.Addr().String())
+	require.NoError(t, err)
+	requireEcho(t, conn)
+}
+
+func TestRegisterTLS(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: shadowsocks
+  endpoint:
+    $type: tls
+    endpoint: front.example.com:443
+    sni: cdn.example.com
+    alpn: [http/1.1]
+  cipher: chacha20-ietf-poly1305
+  secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "front.example.com:443", transportPair.StreamDialer.FirstHop)
+}
@@ -0,0 +1,173 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/transport/tlsfrag"
+)
+
+// TLSFragOptions specifies how the first TLS record (the Client Hello) is split.
+// If no option is set, a random length in the range [MIN_SPLIT, MAX_SPLIT] is used.
+type TLSFragOptions struct {
+	// Length is a fixed split length. It cannot be used with Min_Length and Max_Length.
+	Length int
+	// Min_Length and Max_Length specify a range to pick a random split length from for each connection.
+	Min_Length int
+	Max_Length int
+	// Mode is "length" (the default) to split at the configured length, or "sni" to split in the
+	// middle of the server name. If the server name is not found, it falls back to the configured length.
+	Mode string
+}
+
+// TLSFragConfig is the format for the TLS Fragmentation Stream Dialer config.
+type TLSFragConfig struct {
+	Dialer         configyaml.ConfigNode
+	TLSFragOptions `yaml:",inline"`
+}
+
+func NewTLSFragStreamDialerSubParser(parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	return func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+		return parseTLSFragStreamDialer(ctx, input, parseSD)
+	}
+}
+
+func parseTLSFragStreamDialer(ctx context.Context, configMap map[string]any, parseSD configyaml.ParseFunc[*Dialer[transport.StreamConn]]) (*Dialer[transport.StreamConn], error) {
+	var config TLSFragConfig
+	if err := configyaml.MapToAny(configMap, &config); err != nil {
+		return nil, fmt.Errorf("invalid config format: %w", err)
+	}
+
+	sd, err := parseSD(configyaml.WithPath(ctx, "dialer"), config.Dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nested stream dialer: %w", err)
+	}
+	if sd == nil {
+		return nil, errors.New("stream dialer is not available")
+	}
+
+	fragSD, err := newTLSFragStreamDialer(transport.FuncStreamDialer(sd.Dial), config.TLSFragOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer[transport.StreamConn]{sd.ConnectionProviderInfo, fragSD.DialStream}, nil
+}
+
+// newTLSFragStreamDialer creates a [tlsfrag] StreamDialer according to the options.
+func newTLSFragStreamDialer(base transport.StreamDialer, options TLSFragOptions) (transport.StreamDialer, error) {
+	splitLength, err := newSplitLengthFunc(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var frag tlsfrag.FragFunc
+	switch options.Mode {
+	case "", "length":
+		if options.Length != 0 {
+			return tlsfrag.NewFixedLenStreamDialer(base, options.Length)
+		}
+		frag = splitLength
+	case "sni":
+		frag = func(record []byte) int {
+			if n := sniSplitPoint(record); n > 0 {
+				return n
+			}
+			return splitLength(record)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported tlsfrag mode %q", options.Mode)
+	}
+	return tlsfrag.NewStreamDialerFunc(base, frag)
+}
+
+// newSplitLengthFunc returns a [tlsfrag.FragFunc] that splits the record at the configured length,
+// or at a random length in the configured range.
+func newSplitLengthFunc(options TLSFragOptions) (tlsfrag.FragFunc, error) {
+	if options.Length != 0 {
+		if options.Min_Length != 0 || options.Max_Length != 0 {
+			return nil, errors.New("tlsfrag length cannot be used with min_length or max_length")
+		}
+		if options.Length < 0 {
+			return nil, errors.New("tlsfrag length must be positive")
+		}
+		return func(record []byte) int { return options.Length }, nil
+	}
+
+	minLength, maxLength := MIN_SPLIT, MAX_SPLIT
+	if options.Min_Length != 0 || options.Max_Length != 0 {
+		minLength, maxLength = options.Min_Length, options.Max_Length
+	}
+	if minLength <= 0 || maxLength < minLength {
+		return nil, fmt.Errorf("tlsfrag length range [%d, %d] is invalid", minLength, maxLength)
+	}
+	return func(record []byte) int {
+		return minLength + rand.Intn(maxLength+1-minLength)
+	}, nil
+}
+
+// sniSplitPoint returns the index in the middle of the server name in the given Client Hello
+// handshake record content, or 0 if it's not found.
+func sniSplitPoint(record []byte) int {
+	const extensionServerName = 0
+	// Handshake header: type (1), length (3).
+	// Client Hello: version (2), random (32), session ID (1+), cipher suites (2+), compression methods (1+), extensions (2+).
+	if len(record) < 4 || record[0] != 1 {
+		return 0
+	}
+	offset := 4 + 2 + 32
+	if offset >= len(record) {
+		return 0
+	}
+	offset += 1 + int(record[offset])
+	if offset+2 > len(record) {
+		return 0
+	}
+	offset += 2 + int(binary.BigEndian.Uint16(record[offset:]))
+	if offset >= len(record) {
+		return 0
+	}
+	offset += 1 + int(record[offset])
+	if offset+2 > len(record) {
+		return 0
+	}
+	offset += 2
+	for offset+4 <= len(record) {
+		extType := binary.BigEndian.Uint16(record[offset:])
+		extLen := int(binary.BigEndian.Uint16(record[offset+2:]))
+		offset += 4
+		if extType != extensionServerName {
+			offset += extLen
+			continue
+		}
+		// Server name list: list length (2), name type (1), name length (2), name.
+		if extLen < 5 || offset+5 > len(record) {
+			return 0
+		}
+		nameLen := int(binary.BigEndian.Uint16(record[offset+3:]))
+		nameStart := offset + 5
+		if nameLen == 0 || nameStart+nameLen > len(record) {
+			return 0
+		}
+		return nameStart + nameLen/2
+	}
+	return 0
+}
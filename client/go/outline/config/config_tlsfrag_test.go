@@ -0,0 +1,132 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"github.com/stretchr/testify/require"
+)
+
+// clientHelloRecord returns the content of the first TLS record sent by a client, excluding the record header.
+func clientHelloRecord(t *testing.T, serverName string) []byte {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		defer clientConn.Close()
+		tls.Client(clientConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}()
+
+	header := make([]byte, 5)
+	_, err := io.ReadFull(serverConn, header)
+	require.NoError(t, err)
+	record := make([]byte, int(header[3])<<8|int(header[4]))
+	_, err = io.ReadFull(serverConn, record)
+	require.NoError(t, err)
+	return record
+}
+
+func TestSNISplitPoint(t *testing.T) {
+	record := clientHelloRecord(t, "www.example.com")
+	n := sniSplitPoint(record)
+	require.Greater(t, n, 0)
+	require.Equal(t, "www.exa", string(record[n-7:n]))
+	require.Equal(t, "mple.com", string(record[n:n+8]))
+
+	// No server name.
+	require.Equal(t, 0, sniSplitPoint(clientHelloRecord(t, "")))
+	// Not a Client Hello.
+	require.Equal(t, 0, sniSplitPoint([]byte{2, 0, 0, 0}))
+	// Truncated.
+	require.Equal(t, 0, sniSplitPoint(record[:n]))
+}
+
+func TestNewSplitLengthFunc(t *testing.T) {
+	fixed, err := newSplitLengthFunc(TLSFragOptions{Length: 10})
+	require.NoError(t, err)
+	require.Equal(t, 10, fixed(nil))
+
+	ranged, err := newSplitLengthFunc(TLSFragOptions{Min_Length: 3, Max_Length: 5})
+	require.NoError(t, err)
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		n := ranged(nil)
+		require.GreaterOrEqual(t, n, 3)
+		require.LessOrEqual(t, n, 5)
+		seen[n] = true
+	}
+	require.Len(t, seen, 3)
+
+	defaultRange, err := newSplitLengthFunc(TLSFragOptions{})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		n := defaultRange(nil)
+		require.GreaterOrEqual(t, n, MIN_SPLIT)
+		require.LessOrEqual(t, n, MAX_SPLIT)
+	}
+}
+
+func TestParseTLSFragStreamDialer(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: tlsfrag
+  min_length: 2
+  max_length: 10
+  mode: sni
+  dialer:
+    $type: shadowsocks
+    endpoint: example.com:4321
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, ConnTypeTunneled, transportPair.StreamDialer.ConnType)
+	require.Equal(t, "example.com:4321", transportPair.StreamDialer.FirstHop)
+}
+
+func TestParseTLSFragStreamDialer_Errors(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"length and range", "length: 5\nmin_length: 2", "tlsfrag length cannot be used with min_length or max_length"},
+		{"negative length", "length: -1", "tlsfrag length must be positive"},
+		{"invalid range", "min_length: 10\nmax_length: 5", "tlsfrag length range [10, 5] is invalid"},
+		{"unknown mode", "mode: foo", `unsupported tlsfrag mode "foo"`},
+		{"unknown field", "foo: bar", "invalid config format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := configyaml.ParseConfigYAML("$type: tcpudp\ntcp:\n  $type: tlsfrag\n  " + strings.ReplaceAll(tt.config, "\n", "\n  "))
+			require.NoError(t, err)
+			_, err = provider.Parse(context.Background(), node)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
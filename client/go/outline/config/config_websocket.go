@@ -16,10 +16,14 @@ package config
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"localhost/client/go/configyaml"
 	"localhost/client/go/outline/useragent"
@@ -27,9 +31,21 @@ import (
 	"golang.getoutline.org/sdk/x/websocket"
 )
 
+// websocketURLToken is the placeholder in the URL path or query that is replaced by a random
+// token for each connection.
+const websocketURLToken = "{token}"
+
 type WebsocketEndpointConfig struct {
 	URL      string
 	Endpoint any
+	// Headers are HTTP headers to add to the handshake request, including User-Agent.
+	Headers map[string]string
+	// Host is the Host header, if different from the URL host.
+	Host string
+	// SNI is the TLS server name, if different from the URL host.
+	SNI string
+	// Subprotocols are the Websocket subprotocols to offer, in order of preference.
+	Subprotocols []string
 }
 
 func NewWebsocketStreamEndpointSubParser(parseSE configyaml.ParseFunc[*Endpoint[transport.StreamConn]]) func(ctx context.Context, input map[string]any) (*Endpoint[transport.StreamConn], error) {
@@ -67,25 +83,99 @@ func parseWebsocketEndpoint[ConnType any](ctx context.Context, configMap map[str
 			port = "80"
 		}
 	}
+	serverName := url.Hostname()
+	if config.SNI != "" {
+		if url.Scheme != "wss" && url.Scheme != "https" {
+			return nil, errors.New("websocket sni requires a wss url")
+		}
+		serverName = config.SNI
+	}
 
 	if config.Endpoint == nil {
+		// The SNI only changes the TLS handshake, the server is still the URL host.
 		config.Endpoint = net.JoinHostPort(url.Hostname(), port)
 	}
-	se, err := parseSE(ctx, config.Endpoint)
+	se, err := parseSE(configyaml.WithPath(ctx, "endpoint"), config.Endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse websocket endpoint: %w", err)
 	}
+	wsSE := transport.StreamEndpoint(transport.FuncStreamEndpoint(se.Connect))
 
-	headers := http.Header(map[string][]string{
-		"User-Agent": {useragent.GetOutlineUserAgent()},
-	})
-	connect, err := newWE(url.String(), transport.FuncStreamEndpoint(se.Connect), websocket.WithHTTPHeaders(headers))
+	if config.Host != "" {
+		if url.Port() != "" {
+			url.Host = net.JoinHostPort(config.Host, url.Port())
+		} else {
+			url.Host = config.Host
+		}
+	}
+	if url.Hostname() != serverName && (url.Scheme == "wss" || url.Scheme == "https") {
+		// The Websocket library uses the URL host for both the Host header and the TLS server name,
+		// so we do the TLS handshake ourselves and use a plaintext Websocket over it.
+		wsSE = newTLSStreamEndpoint(wsSE, serverName)
+		url.Scheme = "ws"
+	}
+
+	headers, err := websocketHeaders(config.Headers, config.Subprotocols)
 	if err != nil {
 		return nil, err
 	}
+	opts := websocket.WithHTTPHeaders(headers)
+
+	var connect func(context.Context) (ConnType, error)
+	if strings.Contains(url.Path, websocketURLToken) || strings.Contains(url.RawQuery, websocketURLToken) {
+		// Validate the URL once with a token.
+		if _, err := newWE(renderWebsocketURL(url), wsSE, opts); err != nil {
+			return nil, err
+		}
+		connect = func(ctx context.Context) (ConnType, error) {
+			connectWE, err := newWE(renderWebsocketURL(url), wsSE, opts)
+			if err != nil {
+				var zero ConnType
+				return zero, err
+			}
+			return connectWE(ctx)
+		}
+	} else {
+		connect, err = newWE(url.String(), wsSE, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &Endpoint[ConnType]{
 		ConnectionProviderInfo: se.ConnectionProviderInfo,
 		Connect:                connect,
 	}, nil
 }
+
+// websocketHeaders returns the headers for the Websocket handshake.
+func websocketHeaders(configHeaders map[string]string, subprotocols []string) (http.Header, error) {
+	headers := http.Header(map[string][]string{
+		"User-Agent": {useragent.GetOutlineUserAgent()},
+	})
+	for name, value := range configHeaders {
+		switch http.CanonicalHeaderKey(name) {
+		case "Host":
+			return nil, errors.New("use the websocket host field to set the Host header")
+		case "Sec-Websocket-Protocol":
+			return nil, errors.New("use the websocket subprotocols field to set the subprotocols")
+		}
+		headers.Set(name, value)
+	}
+	if len(subprotocols) > 0 {
+		headers.Set("Sec-WebSocket-Protocol", strings.Join(subprotocols, ", "))
+	}
+	return headers, nil
+}
+
+// renderWebsocketURL returns the URL with the token placeholders replaced by a new random token.
+func renderWebsocketURL(template *url.URL) string {
+	var tokenBytes [16]byte
+	rand.Read(tokenBytes[:])
+	token := hex.EncodeToString(tokenBytes[:])
+	rendered := *template
+	rendered.Path = strings.ReplaceAll(template.Path, websocketURLToken, token)
+	rendered.RawPath = ""
+	rendered.RawQuery = strings.ReplaceAll(template.RawQuery, websocketURLToken, token)
+	return rendered.String()
+}
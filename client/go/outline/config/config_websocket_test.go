@@ -0,0 +1,181 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"regexp"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWebsocketEndpoint is a fake [newWebsocketEndpoint] that records the URLs and connects
+// directly with the stream endpoint.
+type recordingWebsocketEndpoint struct {
+	urls []string
+}
+
+func (r *recordingWebsocketEndpoint) newEndpoint(urlStr string, se transport.StreamEndpoint, opts ...websocket.Option) (func(context.Context) (transport.StreamConn, error), error) {
+	r.urls = append(r.urls, urlStr)
+	return se.ConnectStream, nil
+}
+
+func TestParseWebsocketEndpoint(t *testing.T) {
+	recorder := &recordingWebsocketEndpoint{}
+	endpoint, err := parseWebsocketEndpoint(context.Background(), map[string]any{
+		"url": "https://example.com/ws",
+	}, func(ctx context.Context, input configyaml.ConfigNode) (*Endpoint[transport.StreamConn], error) {
+		require.Equal(t, "example.com:443", input)
+		return directTestStreamEndpoints(ctx, input)
+	}, recorder.newEndpoint)
+	require.NoError(t, err)
+	require.Equal(t, "example.com:443", endpoint.FirstHop)
+	require.Equal(t, []string{"wss://example.com/ws"}, recorder.urls)
+}
+
+func TestParseWebsocketEndpoint_URLToken(t *testing.T) {
+	server := startTCPEchoServer(t)
+	recorder := &recordingWebsocketEndpoint{}
+	endpoint, err := parseWebsocketEndpoint(context.Background(), map[string]any{
+		"url":      "wss://example.com/ws/{token}?session={token}&v=1",
+		"endpoint": server.Addr().String(),
+	}, directTestStreamEndpoints, recorder.newEndpoint)
+	require.NoError(t, err)
+
+	for range 2 {
+		conn, err := endpoint.Connect(context.Background())
+		require.NoError(t, err)
+		conn.Close()
+	}
+	// The first URL is from the validation at parse time.
+	require.Len(t, recorder.urls, 3)
+	urlPattern := regexp.MustCompile(`^wss://example\.com/ws/([0-9a-f]{32})\?session=([0-9a-f]{32})&v=1$`)
+	var tokens []string
+	for _, url := range recorder.urls {
+		match := urlPattern.FindStringSubmatch(url)
+		require.NotNil(t, match, url)
+		require.Equal(t, match[1], match[2])
+		tokens = append(tokens, match[1])
+	}
+	require.NotEqual(t, tokens[1], tokens[2])
+}
+
+func TestParseWebsocketEndpoint_HostAndSNI(t *testing.T) {
+	serverNames := make(chan string, 1)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	recorder := &recordingWebsocketEndpoint{}
+	endpoint, err := parseWebsocketEndpoint(context.Background(), map[string]any{
+		"url":      "wss://front.example.com:8443/ws",
+		"host":     "hidden.example.com",
+		"endpoint": listener.Addr().String(),
+	}, directTestStreamEndpoints, recorder.newEndpoint)
+	require.NoError(t, err)
+	// The Websocket goes over our own TLS connection.
+	require.Equal(t, []string{"ws://hidden.example.com:8443/ws"}, recorder.urls)
+
+	// The server has no certificate, so the handshake fails after the Client Hello.
+	_, err = endpoint.Connect(context.Background())
+	require.ErrorContains(t, err, "TLS handshake failed")
+	require.Equal(t, "front.example.com", <-serverNames)
+}
+
+func TestParseWebsocketEndpoint_SNI(t *testing.T) {
+	recorder := &recordingWebsocketEndpoint{}
+	endpoint, err := parseWebsocketEndpoint(context.Background(), map[string]any{
+		"url": "wss://hidden.example.com/ws",
+		"sni": "front.example.com",
+	}, directTestStreamEndpoints, recorder.newEndpoint)
+	require.NoError(t, err)
+	// The default endpoint is the URL host, the SNI only changes the TLS handshake.
+	require.Equal(t, "hidden.example.com:443", endpoint.FirstHop)
+	require.Equal(t, []string{"ws://hidden.example.com/ws"}, recorder.urls)
+}
+
+func TestParseWebsocketEndpoint_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantErr string
+	}{
+		{"token in host", map[string]any{"url": "wss://{token}.example.com/"}, "url is invalid"},
+		{"sni without tls", map[string]any{"url": "ws://example.com/", "sni": "front.example.com"}, "websocket sni requires a wss url"},
+		{"host header", map[string]any{"url": "wss://example.com/", "headers": map[string]any{"host": "a.example.com"}}, "use the websocket host field"},
+		{"subprotocol header", map[string]any{"url": "wss://example.com/", "headers": map[string]any{"Sec-WebSocket-Protocol": "chat"}}, "use the websocket subprotocols field"},
+		{"unknown field", map[string]any{"url": "wss://example.com/", "path": "/ws"}, "invalid config format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseWebsocketEndpoint(context.Background(), tt.config, directTestStreamEndpoints, (&recordingWebsocketEndpoint{}).newEndpoint)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestWebsocketHeaders(t *testing.T) {
+	headers, err := websocketHeaders(nil, nil)
+	require.NoError(t, err)
+	require.Contains(t, headers.Get("User-Agent"), "Outline")
+
+	headers, err = websocketHeaders(map[string]string{"user-agent": "Mozilla/5.0", "X-Custom": "value"}, []string{"v2.chat", "v1.chat"})
+	require.NoError(t, err)
+	require.Equal(t, "Mozilla/5.0", headers.Get("User-Agent"))
+	require.Equal(t, "value", headers.Get("X-Custom"))
+	require.Equal(t, "v2.chat, v1.chat", headers.Get("Sec-WebSocket-Protocol"))
+}
+
+func TestRegisterWebsocket(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp:
+  $type: shadowsocks
+  endpoint:
+    $type: websocket
+    url: wss://hidden.example.com/tcp/{token}
+    endpoint: front.example.com:443
+    sni: front.example.com
+    host: hidden.example.com
+    headers:
+      User-Agent: Mozilla/5.0
+    subprotocols: [chat]
+  cipher: chacha20-ietf-poly1305
+  secret: SECRET`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, "front.example.com:443", transportPair.StreamDialer.FirstHop)
+}
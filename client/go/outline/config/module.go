@@ -29,7 +29,14 @@ func newTypeParser[T any](fallbackHandler func(context.Context, configyaml.Confi
 	parser := configyaml.NewTypeParser(fallbackHandler)
 
 	// Registrations that should apply to all supported type.
-	parser.RegisterSubParser("first-supported", NewFirstSupportedSubParser(parser.Parse))
+	parser.RegisterSubParserWithSchema("first-supported", &configyaml.Schema{
+		Config: FirstSupportedConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"options": parser},
+	}, NewFirstSupportedSubParser(parser.Parse))
+	parser.RegisterSubParserWithSchema("match", &configyaml.Schema{
+		Config: MatchConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"cases.config": parser, "default": parser},
+	}, NewMatchSubParser(parser.Parse))
 
 	return parser
 }
@@ -41,7 +48,7 @@ func NewDefaultTransportProvider(directSD transport.StreamDialer, directPD trans
 
 	var directWrappedSD *Dialer[transport.StreamConn]
 	if directSD != nil {
-		directWrappedSD = &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnTypeDirect, ""}, directSD.DialStream}
+		directWrappedSD = &Dialer[transport.StreamConn]{ConnectionProviderInfo{ConnType: ConnTypeDirect}, directSD.DialStream}
 	}
 	streamDialers := newTypeParser(func(ctx context.Context, input configyaml.ConfigNode) (*Dialer[transport.StreamConn], error) {
 		switch input.(type) {
@@ -58,7 +65,7 @@ func NewDefaultTransportProvider(directSD transport.StreamDialer, directPD trans
 
 	var directWrappedPD *Dialer[net.Conn]
 	if directPD != nil {
-		directWrappedPD = &Dialer[net.Conn]{ConnectionProviderInfo{ConnTypeDirect, ""}, directPD.DialPacket}
+		directWrappedPD = &Dialer[net.Conn]{ConnectionProviderInfo{ConnType: ConnTypeDirect}, directPD.DialPacket}
 	}
 	packetDialers := newTypeParser(func(ctx context.Context, input configyaml.ConfigNode) (*Dialer[net.Conn], error) {
 		switch input.(type) {
@@ -73,7 +80,7 @@ func NewDefaultTransportProvider(directSD transport.StreamDialer, directPD trans
 		}
 	})
 
-	directWrappedPL := &PacketListener{ConnectionProviderInfo{ConnTypeDirect, ""}, &transport.UDPListener{}}
+	directWrappedPL := &PacketListener{ConnectionProviderInfo{ConnType: ConnTypeDirect}, &transport.UDPListener{}}
 	packetListeners := newTypeParser(func(ctx context.Context, input configyaml.ConfigNode) (*PacketListener, error) {
 		switch input.(type) {
 		case nil:
@@ -98,38 +105,151 @@ func NewDefaultTransportProvider(directSD transport.StreamDialer, directPD trans
 		return parseShadowsocksTransport(ctx, input, streamEndpoints.Parse, packetEndpoints.Parse)
 	})
 
+	// Schemas of the configs without a $type, which are handled by the fallback handlers.
+	nullSchema := &configyaml.Schema{JSON: map[string]any{"type": "null"}}
+	addressSchema := &configyaml.Schema{JSON: map[string]any{"type": "string", "description": "host:port address"}}
+	shadowsocksURLSchema := &configyaml.Schema{JSON: map[string]any{"type": "string", "pattern": "^ss://"}}
+	streamEndpoints.SetSchema("StreamEndpoint", addressSchema, &configyaml.Schema{
+		Config: DialEndpointConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"dialer": streamDialers},
+	})
+	packetEndpoints.SetSchema("PacketEndpoint", addressSchema, &configyaml.Schema{
+		Config: DialEndpointConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"dialer": packetDialers},
+	})
+	streamDialers.SetSchema("StreamDialer", nullSchema, shadowsocksURLSchema)
+	packetDialers.SetSchema("PacketDialer", nullSchema, shadowsocksURLSchema)
+	packetListeners.SetSchema("PacketListener", nullSchema)
+	// The legacy Shadowsocks endpoint is used for both stream and packet connections, so we don't restrict it.
+	transports.SetSchema("Transport", shadowsocksURLSchema, &configyaml.Schema{Config: ShadowsocksTransportConfig{}}, &configyaml.Schema{Config: LegacyShadowsocksConfig{}})
+
 	// Stream endpoints.
-	streamEndpoints.RegisterSubParser("dial", NewDialEndpointSubParser(streamDialers.Parse))
-	streamEndpoints.RegisterSubParser("websocket", NewWebsocketStreamEndpointSubParser(streamEndpoints.Parse))
+	streamEndpoints.RegisterSubParserWithSchema("dial", &configyaml.Schema{
+		Config: DialEndpointConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"dialer": streamDialers},
+	}, NewDialEndpointSubParser(streamDialers.Parse))
+	streamEndpoints.RegisterSubParserWithSchema("tls", &configyaml.Schema{
+		Config: TLSEndpointConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": streamEndpoints},
+	}, NewTLSStreamEndpointSubParser(streamEndpoints.Parse))
+	streamEndpoints.RegisterSubParserWithSchema("websocket", &configyaml.Schema{
+		Config: WebsocketEndpointConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": streamEndpoints},
+	}, NewWebsocketStreamEndpointSubParser(streamEndpoints.Parse))
 
 	// Packet endpoints.
-	packetEndpoints.RegisterSubParser("dial", NewDialEndpointSubParser(packetDialers.Parse))
-	packetEndpoints.RegisterSubParser("websocket", NewWebsocketPacketEndpointSubParser(streamEndpoints.Parse))
+	packetEndpoints.RegisterSubParserWithSchema("dial", &configyaml.Schema{
+		Config: DialEndpointConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"dialer": packetDialers},
+	}, NewDialEndpointSubParser(packetDialers.Parse))
+	packetEndpoints.RegisterSubParserWithSchema("websocket", &configyaml.Schema{
+		Config: WebsocketEndpointConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": streamEndpoints},
+	}, NewWebsocketPacketEndpointSubParser(streamEndpoints.Parse))
 
 	// Stream dialers.
-	streamDialers.RegisterSubParser("block", NewBlockDialerSubParser[transport.StreamConn]())
-	streamDialers.RegisterSubParser("direct", func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
+	streamDialers.RegisterSubParserWithSchema("balance", &configyaml.Schema{
+		Config: BalanceConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"options": streamDialers},
+	}, NewBalanceStreamDialerSubParser(streamDialers.Parse))
+	streamDialers.RegisterSubParserWithSchema("block", &configyaml.Schema{}, NewBlockDialerSubParser[transport.StreamConn]())
+	streamDialers.RegisterSubParserWithSchema("direct", &configyaml.Schema{}, func(ctx context.Context, input map[string]any) (*Dialer[transport.StreamConn], error) {
 		return directWrappedSD, nil
 	})
-	streamDialers.RegisterSubParser("iptable", NewIPTableStreamDialerSubParser(streamDialers.Parse))
-	streamDialers.RegisterSubParser("shadowsocks", NewShadowsocksStreamDialerSubParser(streamEndpoints.Parse))
+	streamDialers.RegisterSubParserWithSchema("domaintable", &configyaml.Schema{
+		Config: domainTableRootConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"table.dialer": streamDialers, "fallback": streamDialers},
+	}, NewDomainTableStreamDialerSubParser(streamDialers.Parse))
+	streamDialers.RegisterSubParserWithSchema("fallback", &configyaml.Schema{
+		Config: FallbackConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"options": streamDialers},
+	}, NewFallbackDialerSubParser(streamDialers.Parse))
+	streamDialers.RegisterSubParserWithSchema("http-connect", &configyaml.Schema{
+		Config: HTTPConnectConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": streamEndpoints},
+	}, NewHTTPConnectStreamDialerSubParser(streamEndpoints.Parse))
+	streamDialers.RegisterSubParserWithSchema("iptable", &configyaml.Schema{
+		Config: ipTableRootConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"table.dialer": streamDialers, "fallback": streamDialers},
+	}, NewIPTableStreamDialerSubParser(streamDialers.Parse))
+	streamDialers.RegisterSubParserWithSchema("mux", &configyaml.Schema{
+		Config: MuxConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"dialer": streamDialers},
+	}, NewMuxStreamDialerSubParser(streamDialers.Parse))
+	streamDialers.RegisterSubParserWithSchema("race", &configyaml.Schema{
+		Config: RaceConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"options": streamDialers},
+	}, NewRaceStreamDialerSubParser(streamDialers.Parse))
+	streamDialers.RegisterSubParserWithSchema("shadowsocks", &configyaml.Schema{
+		Config: ShadowsocksConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": streamEndpoints},
+	}, NewShadowsocksStreamDialerSubParser(streamEndpoints.Parse))
+	streamDialers.RegisterSubParserWithSchema("socks5", &configyaml.Schema{
+		Config: SOCKS5Config{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": streamEndpoints, "packet_dialer": packetDialers},
+	}, NewSOCKS5StreamDialerSubParser(streamEndpoints.Parse))
+	streamDialers.RegisterSubParserWithSchema("split", &configyaml.Schema{
+		Config: SplitConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"dialer": streamDialers},
+	}, NewSplitStreamDialerSubParser(streamDialers.Parse))
+	streamDialers.RegisterSubParserWithSchema("tlsfrag", &configyaml.Schema{
+		Config: TLSFragConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"dialer": streamDialers},
+	}, NewTLSFragStreamDialerSubParser(streamDialers.Parse))
 
 	// Packet dialers.
-	packetDialers.RegisterSubParser("block", NewBlockDialerSubParser[net.Conn]())
-	packetDialers.RegisterSubParser("direct", func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
+	packetDialers.RegisterSubParserWithSchema("balance", &configyaml.Schema{
+		Config: BalanceConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"options": packetDialers},
+	}, NewBalancePacketDialerSubParser(packetDialers.Parse))
+	packetDialers.RegisterSubParserWithSchema("block", &configyaml.Schema{}, NewBlockDialerSubParser[net.Conn]())
+	packetDialers.RegisterSubParserWithSchema("direct", &configyaml.Schema{}, func(ctx context.Context, input map[string]any) (*Dialer[net.Conn], error) {
 		return directWrappedPD, nil
 	})
-	packetDialers.RegisterSubParser("shadowsocks", NewShadowsocksPacketDialerSubParser(packetEndpoints.Parse))
+	packetDialers.RegisterSubParserWithSchema("domaintable", &configyaml.Schema{
+		Config: domainTableRootConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"table.dialer": packetDialers, "fallback": packetDialers},
+	}, NewDomainTablePacketDialerSubParser(packetDialers.Parse))
+	packetDialers.RegisterSubParserWithSchema("fallback", &configyaml.Schema{
+		Config: FallbackConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"options": packetDialers},
+	}, NewFallbackDialerSubParser(packetDialers.Parse))
+	packetDialers.RegisterSubParserWithSchema("iptable", &configyaml.Schema{
+		Config: ipTableRootConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"table.dialer": packetDialers, "fallback": packetDialers},
+	}, NewIPTablePacketDialerSubParser(packetDialers.Parse))
+	packetDialers.RegisterSubParserWithSchema("shadowsocks", &configyaml.Schema{
+		Config: ShadowsocksConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": packetEndpoints},
+	}, NewShadowsocksPacketDialerSubParser(packetEndpoints.Parse))
 
 	// Packet listeners.
-	packetListeners.RegisterSubParser("direct", func(ctx context.Context, input map[string]any) (*PacketListener, error) {
+	packetListeners.RegisterSubParserWithSchema("direct", &configyaml.Schema{}, func(ctx context.Context, input map[string]any) (*PacketListener, error) {
 		return directWrappedPL, nil
 	})
-	packetListeners.RegisterSubParser("shadowsocks", NewShadowsocksPacketListenerSubParser(packetEndpoints.Parse))
+	packetListeners.RegisterSubParserWithSchema("iptable", &configyaml.Schema{
+		Config: ipTableRootConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"table.dialer": packetListeners, "fallback": packetListeners},
+	}, NewIPTablePacketListenerSubParser(packetListeners.Parse))
+	packetListeners.RegisterSubParserWithSchema("shadowsocks", &configyaml.Schema{
+		Config: ShadowsocksConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": packetEndpoints},
+	}, NewShadowsocksPacketListenerSubParser(packetEndpoints.Parse))
+	packetListeners.RegisterSubParserWithSchema("socks5", &configyaml.Schema{
+		Config: SOCKS5Config{},
+		Refs:   map[string]configyaml.SchemaRef{"endpoint": streamEndpoints, "packet_dialer": packetDialers},
+	}, NewSOCKS5PacketListenerSubParser(streamEndpoints.Parse, packetDialers.Parse))
 
 	// Transport pairs.
-	transports.RegisterSubParser("tcpudp", NewTCPUDPTransportPairSubParser(streamDialers.Parse, packetListeners.Parse))
-	transports.RegisterSubParser("basic-access", NewProxylessTransportPairSubParser(streamDialers.Parse))
+	transports.RegisterSubParserWithSchema("tcpudp", &configyaml.Schema{
+		Config: TCPUDPConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"tcp": streamDialers, "udp": packetListeners},
+	}, NewTCPUDPTransportPairSubParser(streamDialers.Parse, packetListeners.Parse))
+	transports.RegisterSubParserWithSchema("basic-access", &configyaml.Schema{Config: BasicAccessConfig{}}, NewProxylessTransportPairSubParser(streamDialers.Parse))
+	transports.RegisterSubParserWithSchema("first-working", &configyaml.Schema{
+		Config: FirstWorkingConfig{},
+		Refs:   map[string]configyaml.SchemaRef{"options": transports},
+	}, NewFirstWorkingTransportPairSubParser(transports.Parse))
 
 	return transports
 }
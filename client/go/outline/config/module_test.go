@@ -16,6 +16,7 @@ package config
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"testing"
@@ -177,3 +178,64 @@ udp: null`)
 		require.Equal(t, "blocked by config", err.Error())
 	})
 }
+
+func TestParseIPTableUDP(t *testing.T) {
+	provider := newTestTransportProvider()
+
+	node, err := configyaml.ParseConfigYAML(`
+$type: tcpudp
+tcp: null
+udp:
+  $type: iptable
+  table:
+    - ips:
+        - 10.0.0.0/8
+      dialer:
+        $type: shadowsocks
+        endpoint: example.com:1234
+        cipher: chacha20-ietf-poly1305
+        secret: SECRET
+  fallback:
+    $type: direct`)
+	require.NoError(t, err)
+
+	transportPair, err := provider.Parse(context.Background(), node)
+	require.NoError(t, err)
+	require.NotNil(t, transportPair.PacketProxy)
+	require.Equal(t, ConnTypeDirect, transportPair.StreamDialer.ConnType)
+	require.Equal(t, ConnTypePartial, transportPair.PacketProxy.ConnType)
+}
+
+func TestDefaultTransportProviderJSONSchema(t *testing.T) {
+	schema, err := newTestTransportProvider().JSONSchema()
+	require.NoError(t, err)
+	_, err = json.Marshal(schema)
+	require.NoError(t, err)
+
+	require.Equal(t, "#/$defs/Transport", schema["$ref"])
+	defs := schema["$defs"].(map[string]any)
+	for _, name := range []string{
+		"Transport", "Transport.tcpudp", "Transport.basic-access", "Transport.first-supported",
+		"StreamDialer", "StreamDialer.shadowsocks", "StreamDialer.iptable", "StreamDialer.direct",
+		"PacketDialer", "PacketDialer.shadowsocks", "PacketListener", "PacketListener.socks5",
+		"StreamEndpoint", "StreamEndpoint.websocket", "PacketEndpoint", "PacketEndpoint.dial", "Reference",
+	} {
+		require.Contains(t, defs, name)
+	}
+
+	properties := func(defName string) map[string]any {
+		return defs[defName].(map[string]any)["properties"].(map[string]any)
+	}
+	ref := func(defName string) map[string]any {
+		return map[string]any{"$ref": "#/$defs/" + defName}
+	}
+	require.Equal(t, ref("StreamDialer"), properties("Transport.tcpudp")["tcp"])
+	require.Equal(t, ref("PacketListener"), properties("Transport.tcpudp")["udp"])
+	require.Equal(t, ref("StreamEndpoint"), properties("StreamDialer.shadowsocks")["endpoint"])
+	require.Equal(t, ref("PacketEndpoint"), properties("PacketDialer.shadowsocks")["endpoint"])
+	require.Equal(t, map[string]any{"type": "string"}, properties("StreamDialer.shadowsocks")["plugin_opts"])
+	require.Equal(t, ref("PacketDialer"), properties("PacketListener.socks5")["packet_dialer"])
+	require.Equal(t, ref("StreamEndpoint"), properties("PacketEndpoint.websocket")["endpoint"])
+	tableEntry := properties("StreamDialer.iptable")["table"].(map[string]any)["items"].(map[string]any)
+	require.Equal(t, ref("StreamDialer"), tableEntry["properties"].(map[string]any)["dialer"])
+}
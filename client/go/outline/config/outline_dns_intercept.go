@@ -15,11 +15,12 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"math/rand/v2"
 	"net/netip"
 
+	"localhost/client/go/configyaml"
 	"localhost/client/go/outline/connectivity"
 	"localhost/client/go/outline/dnsintercept"
 	"golang.getoutline.org/sdk/network"
@@ -39,20 +40,87 @@ var outlineDNSResolvers = []netip.AddrPort{
 // TODO: make this configurable via a new VpnConfig
 var linkLocalDNS = netip.MustParseAddrPort("169.254.113.53:53")
 
-// wrapTransportPairWithOutlineDNS intercepts DNS over TCP and UDP at a link-local address and forwards them to the remote resolver.
+// defaultDNSCacheSize is the maximum number of DNS responses cached for a transport.
+const defaultDNSCacheSize = 1024
+
+// The fake IP prefixes, from the ranges reserved for benchmarking and unique local addresses.
+var (
+	fakeIPPrefix4 = netip.MustParsePrefix("198.18.0.0/15")
+	fakeIPPrefix6 = netip.MustParsePrefix("fdfe:dcba:9876::/64")
+)
+
+// defaultFakeIPPoolSize is the maximum number of domains with fake IPs for a transport.
+const defaultFakeIPPoolSize = 65536
+
+// wrapTransportPairWithOutlineDNS intercepts DNS over TCP and UDP at a link-local address and forwards them to the remote
+// resolvers in the DNS config, or to the Outline resolvers if the config is nil. The responses are cached for both
+// TCP and UDP, and the cache is flushed when the network changes.
 //
-// It also checks for UDP connectivity.
-//   - If UDP is available, it forwards DNS queries to the specified resolverAddr.
-//   - If UDP is blocked, it sends back a truncated DNS response.
-//     This forces the OS to retry the DNS query over TCP.
-func wrapTransportPairWithOutlineDNS(sd *Dialer[transport.StreamConn], pl *PacketListener) (*TransportPair, error) {
-	// Randomly selects a DNS resolver for the VPN session
-	remoteDNS := outlineDNSResolvers[rand.IntN(len(outlineDNSResolvers))]
+// DNS over TCP, and DoH and DoT resolvers, are answered locally, with upstream connections through the StreamDialer.
+// DoH and DoT resolvers also answer DNS over UDP, so they don't depend on UDP. Otherwise, it checks for UDP connectivity.
+//   - If UDP is available, it forwards DNS queries to the UDP resolvers.
+//   - If UDP is blocked, or there are no UDP resolvers, it converts the DNS queries over UDP to DNS over TCP,
+//     with pipelined connections through the StreamDialer, and sends back a UDP response.
+//
+// In fake IP mode, A and AAAA queries are answered with fake IPs, and the connections to fake IPs are made to the
+// domain names instead.
+func wrapTransportPairWithOutlineDNS(ctx context.Context, sd *Dialer[transport.StreamConn], pl *PacketListener, dnsConfig *DNSConfig) (*TransportPair, error) {
+	resolvers, err := newDNSResolvers(dnsConfig, transport.FuncStreamDialer(sd.Dial))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS config: %w", err)
+	}
+	if dnsConfig == nil || !dnsConfig.Fake_IP {
+		return interceptDNS(ctx, sd, pl, resolvers)
+	}
+
+	pool, err := dnsintercept.NewFakeIPPool(fakeIPPrefix4, fakeIPPrefix6, defaultFakeIPPoolSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake IP pool: %w", err)
+	}
+	plFake, err := dnsintercept.WrapFakeIPPacketListener(pl.PacketListener, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake IP PacketListener: %w", err)
+	}
+	tp, err := interceptDNS(ctx, sd, &PacketListener{pl.ConnectionProviderInfo, plFake}, resolvers)
+	if err != nil {
+		return nil, err
+	}
+	sdFake, err := dnsintercept.WrapFakeIPStreamDialer(transport.FuncStreamDialer(tp.StreamDialer.Dial), linkLocalDNS, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake IP StreamDialer: %w", err)
+	}
+	ppFake, err := dnsintercept.WrapFakeIPPacketProxy(tp.PacketProxy.PacketProxy, linkLocalDNS, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake IP PacketProxy: %w", err)
+	}
+	return &TransportPair{
+		&Dialer[transport.StreamConn]{tp.StreamDialer.ConnectionProviderInfo, sdFake.DialStream},
+		&PacketProxy{tp.PacketProxy.ConnectionProviderInfo, ppFake, tp.PacketProxy.NotifyNetworkChanged},
+	}, nil
+}
+
+// interceptDNS intercepts DNS over TCP and UDP at a link-local address and answers them with the resolvers.
+// The cache stats are logged during the session.
+func interceptDNS(ctx context.Context, sd *Dialer[transport.StreamConn], pl *PacketListener, resolvers *dnsResolvers) (*TransportPair, error) {
+	cache, err := dnsintercept.NewCache(defaultDNSCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS cache: %w", err)
+	}
+	configPath := configyaml.PathFromContext(ctx)
+	logStatsDuringSession(ctx, func() {
+		stats := cache.Stats()
+		slog.Info("DNS cache stats", "path", configPath, "hits", stats.Hits, "staleHits", stats.StaleHits, "misses", stats.Misses)
+	})
+	flushCache := func() {
+		stats := cache.Stats()
+		slog.Debug("flushing DNS cache", "hits", stats.Hits, "staleHits", stats.StaleHits, "misses", stats.Misses)
+		cache.Flush()
+	}
 
 	// Intercept DNS for StreamDialer
-	sdForward, err := dnsintercept.WrapForwardStreamDialer(transport.FuncStreamDialer(sd.Dial), linkLocalDNS, remoteDNS)
+	sdResolve, err := dnsintercept.WrapResolveStreamDialer(transport.FuncStreamDialer(sd.Dial), linkLocalDNS, resolvers.stream, resolvers.upstreams, cache)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create DNS redirect StreamDialer: %w", err)
+		return nil, fmt.Errorf("failed to create DNS resolve StreamDialer: %w", err)
 	}
 
 	// Intercept DNS for PacketProxy
@@ -60,33 +128,41 @@ func wrapTransportPairWithOutlineDNS(sd *Dialer[transport.StreamConn], pl *Packe
 	if err != nil {
 		return nil, fmt.Errorf("failed to create PacketProxy: %w", err)
 	}
-	ppForward, err := dnsintercept.WrapForwardPacketProxy(ppBase, linkLocalDNS, remoteDNS)
+	// The TCP resolvers also answer DNS over UDP when the UDP resolvers can't be used.
+	ppResolve, err := dnsintercept.WrapResolvePacketProxy(ppBase, linkLocalDNS, resolvers.stream, resolvers.upstreams, cache)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create DNS redirect PacketProxy: %w", err)
+		return nil, fmt.Errorf("failed to create DNS resolve PacketProxy: %w", err)
 	}
-	ppTrunc, err := dnsintercept.WrapTruncatePacketProxy(ppBase, linkLocalDNS)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create always-truncate DNS PacketProxy: %w", err)
+	if resolvers.encrypted || resolvers.packet == nil {
+		return &TransportPair{
+			&Dialer[transport.StreamConn]{sd.ConnectionProviderInfo, sdResolve.DialStream},
+			&PacketProxy{pl.ConnectionProviderInfo, ppResolve, flushCache},
+		}, nil
 	}
-	ppMain, err := network.NewDelegatePacketProxy(ppTrunc)
+	ppMain, err := network.NewDelegatePacketProxy(ppResolve)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create indirect PacketProxy: %w", err)
 	}
+	ppForward, err := dnsintercept.WrapForwardPacketProxy(ppBase, linkLocalDNS, resolvers.packet, cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS redirect PacketProxy: %w", err)
+	}
 
 	onNetworkChanged := func() {
+		flushCache()
 		go func() {
 			if err := connectivity.CheckUDPConnectivity(pl); err == nil {
 				slog.Info("remote device UDP is healthy")
 				ppMain.SetProxy(ppForward)
 			} else {
 				slog.Warn("remote device UDP is not healthy", "err", err)
-				ppMain.SetProxy(ppTrunc)
+				ppMain.SetProxy(ppResolve)
 			}
 		}()
 	}
 
 	return &TransportPair{
-		&Dialer[transport.StreamConn]{sd.ConnectionProviderInfo, sdForward.DialStream},
+		&Dialer[transport.StreamConn]{sd.ConnectionProviderInfo, sdResolve.DialStream},
 		&PacketProxy{pl.ConnectionProviderInfo, ppMain, onNetworkChanged},
 	}, nil
 }
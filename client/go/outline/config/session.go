@@ -0,0 +1,93 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// statsLogInterval is how often the diagnostics stats of the transport are logged while a session is active.
+const statsLogInterval = 10 * time.Minute
+
+// SessionHooks collects the functions that need to run while a client session is active, such as
+// helper processes. Parsers register the hooks with the SessionHooks in the parse context.
+type SessionHooks struct {
+	mu      sync.Mutex
+	onStart []func(ctx context.Context) error
+}
+
+type sessionHooksKey struct{}
+
+// WithSessionHooks returns a copy of ctx that carries hooks, for use as the parse context.
+func WithSessionHooks(ctx context.Context, hooks *SessionHooks) context.Context {
+	return context.WithValue(ctx, sessionHooksKey{}, hooks)
+}
+
+// sessionHooksFromContext returns the SessionHooks in the parse context, or nil if there's none.
+func sessionHooksFromContext(ctx context.Context) *SessionHooks {
+	hooks, _ := ctx.Value(sessionHooksKey{}).(*SessionHooks)
+	return hooks
+}
+
+// OnStart registers a function to call when the session starts. The context passed to the
+// function is canceled when the session ends.
+func (h *SessionHooks) OnStart(start func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onStart = append(h.onStart, start)
+}
+
+// Start calls the registered functions in order, and stops at the first error.
+func (h *SessionHooks) Start(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	onStart := append([]func(context.Context) error(nil), h.onStart...)
+	h.mu.Unlock()
+	for _, start := range onStart {
+		if err := start(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logStatsDuringSession registers logStats with the SessionHooks in the parse context, to log diagnostics stats
+// every statsLogInterval while a session is active, and once more when it ends.
+func logStatsDuringSession(ctx context.Context, logStats func()) {
+	hooks := sessionHooksFromContext(ctx)
+	if hooks == nil {
+		return
+	}
+	hooks.OnStart(func(ctx context.Context) error {
+		go func() {
+			ticker := time.NewTicker(statsLogInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					logStats()
+				case <-ctx.Done():
+					logStats()
+					return
+				}
+			}
+		}()
+		return nil
+	})
+}
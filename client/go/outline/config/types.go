@@ -17,6 +17,7 @@ package config
 import (
 	"context"
 	"encoding/json"
+	"slices"
 
 	"golang.getoutline.org/sdk/network"
 	"golang.getoutline.org/sdk/transport"
@@ -58,12 +59,75 @@ func (c ConnType) MarshalJSON() ([]byte, error) {
 	return json.Marshal(s)
 }
 
+// connTypeAggregator computes the [ConnType] of a provider that routes connections over several nested providers.
+// Blocked providers do not contribute to the aggregated type, unless all of them are blocked.
+type connTypeAggregator struct {
+	allConnTunnelled bool
+	allConnDirect    bool
+	allConnBlocked   bool
+}
+
+func newConnTypeAggregator() *connTypeAggregator {
+	return &connTypeAggregator{allConnTunnelled: true, allConnDirect: true, allConnBlocked: true}
+}
+
+// add accounts for a nested provider of the given type.
+func (a *connTypeAggregator) add(connType ConnType) {
+	if connType != ConnTypeBlocked {
+		a.allConnBlocked = false
+		if connType != ConnTypeTunneled {
+			a.allConnTunnelled = false
+		}
+		if connType != ConnTypeDirect {
+			a.allConnDirect = false
+		}
+	}
+}
+
+// connType returns the aggregated type. It's [ConnTypeBlocked] if no provider was added.
+func (a *connTypeAggregator) connType() ConnType {
+	if a.allConnBlocked {
+		return ConnTypeBlocked
+	} else if a.allConnTunnelled {
+		return ConnTypeTunneled
+	} else if a.allConnDirect {
+		return ConnTypeDirect
+	}
+	return ConnTypePartial
+}
+
 // ConnProviderConfig represents a dialer or endpoint that can create connections.
 type ConnectionProviderInfo struct {
 	// The type of the connections that are provided
 	ConnType ConnType
-	// The address of the first hop.
+	// The address of the first hop, if all the connections go through the same one.
 	FirstHop string
+	// The addresses of the first hops, if the connections are spread across several of them.
+	// FirstHop is empty in that case.
+	FirstHops []string
+}
+
+// MergeFirstHops returns the FirstHop and FirstHops of a provider whose connections go through any of the
+// given providers. Providers without a first hop, like direct or blocked ones, are ignored.
+func MergeFirstHops(infos ...ConnectionProviderInfo) (string, []string) {
+	var hops []string
+	for _, info := range infos {
+		if len(info.FirstHops) > 0 {
+			hops = append(hops, info.FirstHops...)
+		} else if info.FirstHop != "" {
+			hops = append(hops, info.FirstHop)
+		}
+	}
+	slices.Sort(hops)
+	hops = slices.Compact(hops)
+	switch len(hops) {
+	case 0:
+		return "", nil
+	case 1:
+		return hops[0], nil
+	default:
+		return "", hops
+	}
 }
 
 // PacketListener is a [transport.PacketListener] with embedded ConnectionProviderInfo.
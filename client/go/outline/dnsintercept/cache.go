@@ -0,0 +1,262 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"container/list"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// maxCacheTTL limits how long a response is considered fresh.
+	maxCacheTTL = 24 * time.Hour
+	// maxCacheStale is how long an expired response can be served while it's revalidated.
+	maxCacheStale = time.Hour
+	// staleAnswerTTL is the TTL of the records in stale responses, as recommended by RFC 8767.
+	staleAnswerTTL = 30
+	// revalidateBackoff is how long to wait before revalidating an expired response again.
+	revalidateBackoff = 5 * time.Second
+)
+
+// CacheStats are the counters of a [Cache].
+type CacheStats struct {
+	// Hits is the number of queries answered with a fresh response.
+	Hits uint64
+	// StaleHits is the number of queries answered with an expired response.
+	StaleHits uint64
+	// Misses is the number of queries not in the cache.
+	Misses uint64
+}
+
+// Cache is a DNS response cache that respects the record TTLs. Negative responses are cached for the
+// SOA TTL, as specified in RFC 2308, and expired responses can be served while they are revalidated, as
+// specified in RFC 8767. The least recently used responses are evicted when the cache is full.
+// It's safe for concurrent use.
+type Cache struct {
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	// lru has the *cacheEntry values, from the most to the least recently used.
+	lru *list.List
+
+	hits      atomic.Uint64
+	staleHits atomic.Uint64
+	misses    atomic.Uint64
+}
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+	// edns is whether the message has an OPT record, and dnssecOK whether it has the DO bit set, since that
+	// changes the response.
+	edns     bool
+	dnssecOK bool
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	response dnsmessage.Message
+	stored   time.Time
+	expires  time.Time
+	// revalidateAfter is when the entry can be revalidated again after it expires.
+	revalidateAfter time.Time
+}
+
+// NewCache creates a [Cache] with up to maxEntries responses.
+func NewCache(maxEntries int) (*Cache, error) {
+	if maxEntries <= 0 {
+		return nil, errors.New("cache size must be positive")
+	}
+	return &Cache{
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[cacheKey]*list.Element),
+		lru:        list.New(),
+	}, nil
+}
+
+// Stats returns the cache counters.
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), StaleHits: c.staleHits.Load(), Misses: c.misses.Load()}
+}
+
+// Flush removes all the responses. It should be called when the network changes, since the answers may
+// depend on the network.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// Get returns the cached response to the query, with the query ID and the record TTLs reduced by the time in
+// the cache. It returns false if there's no response. If the response is expired, revalidate is true the
+// first time it's returned, and the caller should send the query upstream and [Cache.Put] the response.
+func (c *Cache) Get(query []byte) (response []byte, revalidate bool, ok bool) {
+	var queryMsg dnsmessage.Message
+	if err := queryMsg.Unpack(query); err != nil {
+		return nil, false, false
+	}
+	key, ok := newCacheKey(&queryMsg)
+	if !ok || queryMsg.Header.Response || queryMsg.Header.OpCode != 0 {
+		return nil, false, false
+	}
+
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil, false, false
+	}
+	entry := element.Value.(*cacheEntry)
+	now := c.now()
+	if now.After(entry.expires.Add(maxCacheStale)) {
+		c.removeLocked(element)
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil, false, false
+	}
+	c.lru.MoveToFront(element)
+	msg := copyMessage(&entry.response)
+	stale := now.After(entry.expires)
+	if stale && !now.Before(entry.revalidateAfter) {
+		revalidate = true
+		entry.revalidateAfter = now.Add(revalidateBackoff)
+	}
+	age := now.Sub(entry.stored)
+	c.mu.Unlock()
+
+	if stale {
+		c.staleHits.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+	msg.Header.ID = queryMsg.Header.ID
+	// Use the query name, which may have a different case.
+	msg.Questions = queryMsg.Questions
+	for _, section := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range section {
+			header := &section[i].Header
+			if header.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if stale {
+				header.TTL = staleAnswerTTL
+			} else {
+				header.TTL -= min(header.TTL, uint32(age/time.Second))
+			}
+		}
+	}
+	response, err := msg.Pack()
+	if err != nil {
+		return nil, false, false
+	}
+	return response, revalidate, true
+}
+
+// Put stores the response, if it's cacheable.
+func (c *Cache) Put(response []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		return
+	}
+	key, ok := newCacheKey(&msg)
+	if !ok || !msg.Header.Response || msg.Header.Truncated || msg.Header.OpCode != 0 {
+		return
+	}
+	ttl, ok := cacheTTL(&msg)
+	if !ok || ttl <= 0 {
+		return
+	}
+	now := c.now()
+	entry := &cacheEntry{key: key, response: msg, stored: now, expires: now.Add(min(ttl, maxCacheTTL))}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *Cache) removeLocked(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// newCacheKey returns the key for a message with a single question.
+func newCacheKey(msg *dnsmessage.Message) (cacheKey, bool) {
+	if len(msg.Questions) != 1 {
+		return cacheKey{}, false
+	}
+	question := msg.Questions[0]
+	key := cacheKey{name: strings.ToLower(question.Name.String()), qtype: question.Type, class: question.Class}
+	for _, additional := range msg.Additionals {
+		if additional.Header.Type == dnsmessage.TypeOPT {
+			key.edns = true
+			key.dnssecOK = additional.Header.DNSSECAllowed()
+		}
+	}
+	return key, true
+}
+
+// cacheTTL returns how long the response can be cached. Successful responses with answers are cached for
+// their lowest TTL. Negative responses are cached for the SOA record TTL, capped by its minimum field.
+// Other responses are not cached.
+func cacheTTL(msg *dnsmessage.Message) (time.Duration, bool) {
+	switch msg.Header.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return 0, false
+	}
+	if msg.Header.RCode == dnsmessage.RCodeSuccess && len(msg.Answers) > 0 {
+		ttl := msg.Answers[0].Header.TTL
+		for _, answer := range msg.Answers {
+			ttl = min(ttl, answer.Header.TTL)
+		}
+		return time.Duration(ttl) * time.Second, true
+	}
+	// Negative response: NXDOMAIN or NODATA.
+	for _, authority := range msg.Authorities {
+		if soa, ok := authority.Body.(*dnsmessage.SOAResource); ok {
+			return time.Duration(min(authority.Header.TTL, soa.MinTTL)) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// copyMessage returns a copy of the message that can be modified without changing the original.
+func copyMessage(msg *dnsmessage.Message) dnsmessage.Message {
+	msgCopy := *msg
+	msgCopy.Answers = append([]dnsmessage.Resource(nil), msg.Answers...)
+	msgCopy.Authorities = append([]dnsmessage.Resource(nil), msg.Authorities...)
+	msgCopy.Additionals = append([]dnsmessage.Resource(nil), msg.Additionals...)
+	return msgCopy
+}
@@ -0,0 +1,200 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestCache(t *testing.T, maxEntries int) (*Cache, *fakeClock) {
+	cache, err := NewCache(maxEntries)
+	require.NoError(t, err)
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cache.now = clock.Now
+	return cache, clock
+}
+
+// newTestNegativeResponse answers the query with NXDOMAIN and an SOA record, if soaTTL is not zero.
+func newTestNegativeResponse(t *testing.T, query []byte, soaTTL uint32, minTTL uint32) []byte {
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(query))
+	msg.Header.Response = true
+	msg.Header.RCode = dnsmessage.RCodeNameError
+	if soaTTL != 0 {
+		msg.Authorities = append(msg.Authorities, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: soaTTL},
+			Body: &dnsmessage.SOAResource{
+				NS: dnsmessage.MustNewName("ns.example."), MBox: dnsmessage.MustNewName("admin.example."), MinTTL: minTTL,
+			},
+		})
+	}
+	response, err := msg.Pack()
+	require.NoError(t, err)
+	return response
+}
+
+func TestNewCache(t *testing.T) {
+	_, err := NewCache(0)
+	require.Error(t, err)
+}
+
+func TestCache_TTL(t *testing.T) {
+	cache, clock := newTestCache(t, 10)
+	query := newTestQuery(t, 1, "example.com.")
+
+	_, _, ok := cache.Get(query)
+	require.False(t, ok)
+
+	cache.Put(newTestResponse(t, query, [4]byte{192, 0, 2, 1}))
+	clock.now = clock.now.Add(15 * time.Second)
+
+	// The response has the query ID and name, and the remaining TTL.
+	response, revalidate, ok := cache.Get(newTestQuery(t, 2, "EXAMPLE.com."))
+	require.True(t, ok)
+	require.False(t, revalidate)
+	msg := parseTestResponse(t, response)
+	require.Equal(t, uint16(2), msg.Header.ID)
+	require.Equal(t, "EXAMPLE.com.", msg.Questions[0].Name.String())
+	require.Equal(t, uint32(45), msg.Answers[0].Header.TTL)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}, msg.Answers[0].Body)
+
+	// Other types are not answered.
+	aaaa := dnsmessage.Message{Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET}}}
+	aaaaQuery, err := aaaa.Pack()
+	require.NoError(t, err)
+	_, _, ok = cache.Get(aaaaQuery)
+	require.False(t, ok)
+
+	require.Equal(t, CacheStats{Hits: 1, Misses: 2}, cache.Stats())
+}
+
+func TestCache_Stale(t *testing.T) {
+	cache, clock := newTestCache(t, 10)
+	query := newTestQuery(t, 1, "example.com.")
+	cache.Put(newTestResponse(t, query, [4]byte{192, 0, 2, 1}))
+
+	// The expired response is served, and revalidated once.
+	clock.now = clock.now.Add(61 * time.Second)
+	response, revalidate, ok := cache.Get(query)
+	require.True(t, ok)
+	require.True(t, revalidate)
+	require.Equal(t, uint32(staleAnswerTTL), parseTestResponse(t, response).Answers[0].Header.TTL)
+	_, revalidate, ok = cache.Get(query)
+	require.True(t, ok)
+	require.False(t, revalidate)
+
+	// Revalidation is retried if it fails.
+	clock.now = clock.now.Add(revalidateBackoff)
+	_, revalidate, ok = cache.Get(query)
+	require.True(t, ok)
+	require.True(t, revalidate)
+
+	// The revalidated response is fresh.
+	cache.Put(newTestResponse(t, query, [4]byte{192, 0, 2, 2}))
+	response, revalidate, ok = cache.Get(query)
+	require.True(t, ok)
+	require.False(t, revalidate)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}, parseTestResponse(t, response).Answers[0].Body)
+
+	// The response is dropped after the stale period.
+	clock.now = clock.now.Add(time.Minute + maxCacheStale + time.Second)
+	_, _, ok = cache.Get(query)
+	require.False(t, ok)
+
+	require.Equal(t, CacheStats{Hits: 1, StaleHits: 3, Misses: 1}, cache.Stats())
+}
+
+func TestCache_Negative(t *testing.T) {
+	cache, clock := newTestCache(t, 10)
+
+	// The negative TTL is the lower of the SOA TTL and minimum.
+	query := newTestQuery(t, 1, "missing.example.")
+	cache.Put(newTestNegativeResponse(t, query, 300, 30))
+	response, _, ok := cache.Get(query)
+	require.True(t, ok)
+	require.Equal(t, dnsmessage.RCodeNameError, parseTestResponse(t, response).Header.RCode)
+	clock.now = clock.now.Add(31 * time.Second)
+	_, revalidate, ok := cache.Get(query)
+	require.True(t, ok)
+	require.True(t, revalidate)
+
+	// Without SOA, the response is not cached.
+	query = newTestQuery(t, 1, "nosoa.example.")
+	cache.Put(newTestNegativeResponse(t, query, 0, 0))
+	_, _, ok = cache.Get(query)
+	require.False(t, ok)
+}
+
+func TestCache_NotCacheable(t *testing.T) {
+	cache, _ := newTestCache(t, 10)
+	query := newTestQuery(t, 1, "example.com.")
+
+	servfail, err := serverFailureResponse(query)
+	require.NoError(t, err)
+	cache.Put(servfail)
+	_, _, ok := cache.Get(query)
+	require.False(t, ok)
+
+	truncated, err := truncateResponse(newTestResponse(t, query, [4]byte{192, 0, 2, 1}), 0)
+	require.NoError(t, err)
+	cache.Put(truncated)
+	_, _, ok = cache.Get(query)
+	require.False(t, ok)
+
+	cache.Put(query)
+	_, _, ok = cache.Get(query)
+	require.False(t, ok)
+
+	cache.Put([]byte("not-a-dns-packet"))
+	_, _, ok = cache.Get([]byte("not-a-dns-packet"))
+	require.False(t, ok)
+}
+
+func TestCache_EvictionAndFlush(t *testing.T) {
+	cache, _ := newTestCache(t, 2)
+	queryA := newTestQuery(t, 1, "a.example.")
+	queryB := newTestQuery(t, 1, "b.example.")
+	queryC := newTestQuery(t, 1, "c.example.")
+	cache.Put(newTestResponse(t, queryA, [4]byte{192, 0, 2, 1}))
+	cache.Put(newTestResponse(t, queryB, [4]byte{192, 0, 2, 2}))
+
+	// A is used, so B is the least recently used.
+	_, _, ok := cache.Get(queryA)
+	require.True(t, ok)
+	cache.Put(newTestResponse(t, queryC, [4]byte{192, 0, 2, 3}))
+	_, _, ok = cache.Get(queryB)
+	require.False(t, ok)
+	_, _, ok = cache.Get(queryA)
+	require.True(t, ok)
+	_, _, ok = cache.Get(queryC)
+	require.True(t, ok)
+
+	cache.Flush()
+	_, _, ok = cache.Get(queryA)
+	require.False(t, ok)
+}
@@ -0,0 +1,331 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golang.getoutline.org/sdk/network"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// fakeIPTTL is the TTL of the fake IP answers. It's short so the system doesn't keep using an address after
+// it's reassigned.
+const fakeIPTTL = 1
+
+// FakeIPPool assigns fake IP addresses to domain names, so connections to a fake IP can be dialed by domain name.
+// Each domain gets an IPv4 and an IPv6 address, on demand. The least recently used domains are evicted
+// when the pool is full, and their addresses are reused. It's safe for concurrent use.
+type FakeIPPool struct {
+	prefix4    netip.Prefix
+	prefix6    netip.Prefix
+	maxEntries int
+
+	mu       sync.Mutex
+	byDomain map[string]*list.Element
+	byIP     map[netip.Addr]*list.Element
+	// lru has the *fakeIPEntry values, from the most to the least recently used.
+	lru   *list.List
+	next4 netip.Addr
+	next6 netip.Addr
+}
+
+type fakeIPEntry struct {
+	domain string
+	// ip4 and ip6 are the assigned addresses, or invalid if not assigned yet.
+	ip4 netip.Addr
+	ip6 netip.Addr
+}
+
+// NewFakeIPPool creates a [FakeIPPool] that assigns the addresses in the IPv4 and IPv6 prefixes to up to
+// maxEntries domains. Each prefix must have more than maxEntries addresses.
+func NewFakeIPPool(prefix4, prefix6 netip.Prefix, maxEntries int) (*FakeIPPool, error) {
+	if !prefix4.IsValid() || !prefix4.Addr().Is4() {
+		return nil, fmt.Errorf("invalid IPv4 prefix %v", prefix4)
+	}
+	if !prefix6.IsValid() || !prefix6.Addr().Is6() || prefix6.Addr().Is4In6() {
+		return nil, fmt.Errorf("invalid IPv6 prefix %v", prefix6)
+	}
+	if maxEntries <= 0 {
+		return nil, errors.New("pool size must be positive")
+	}
+	for _, prefix := range []netip.Prefix{prefix4, prefix6} {
+		// The first address of the prefix is not used.
+		size := new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits()))
+		if size.Cmp(big.NewInt(int64(maxEntries)+1)) <= 0 {
+			return nil, fmt.Errorf("prefix %v is too small for %v entries", prefix, maxEntries)
+		}
+	}
+	prefix4, prefix6 = prefix4.Masked(), prefix6.Masked()
+	return &FakeIPPool{
+		prefix4:    prefix4,
+		prefix6:    prefix6,
+		maxEntries: maxEntries,
+		byDomain:   make(map[string]*list.Element),
+		byIP:       make(map[netip.Addr]*list.Element),
+		lru:        list.New(),
+		next4:      prefix4.Addr().Next(),
+		next6:      prefix6.Addr().Next(),
+	}, nil
+}
+
+// Contains returns whether the address belongs to the pool prefixes.
+func (p *FakeIPPool) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return p.prefix4.Contains(ip) || p.prefix6.Contains(ip)
+}
+
+// Assign returns the fake IPv4 address of the domain, or the IPv6 address if ipv6 is true, assigning
+// it if needed.
+func (p *FakeIPPool) Assign(domain string, ipv6 bool) netip.Addr {
+	domain = normalizeDomain(domain)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	element, ok := p.byDomain[domain]
+	if ok {
+		p.lru.MoveToFront(element)
+	} else {
+		element = p.lru.PushFront(&fakeIPEntry{domain: domain})
+		p.byDomain[domain] = element
+		for p.lru.Len() > p.maxEntries {
+			p.removeLocked(p.lru.Back())
+		}
+	}
+	entry := element.Value.(*fakeIPEntry)
+	if ipv6 {
+		if !entry.ip6.IsValid() {
+			entry.ip6 = p.nextFreeLocked(p.prefix6, &p.next6)
+			p.byIP[entry.ip6] = element
+		}
+		return entry.ip6
+	}
+	if !entry.ip4.IsValid() {
+		entry.ip4 = p.nextFreeLocked(p.prefix4, &p.next4)
+		p.byIP[entry.ip4] = element
+	}
+	return entry.ip4
+}
+
+// Lookup returns the domain for the fake IP address, if it's assigned.
+func (p *FakeIPPool) Lookup(ip netip.Addr) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	element, ok := p.byIP[ip.Unmap()]
+	if !ok {
+		return "", false
+	}
+	p.lru.MoveToFront(element)
+	return element.Value.(*fakeIPEntry).domain, true
+}
+
+// nextFreeLocked returns the next address of the prefix that is not assigned, wrapping around the prefix.
+// There's always one, since the prefix has more addresses than entries.
+func (p *FakeIPPool) nextFreeLocked(prefix netip.Prefix, next *netip.Addr) netip.Addr {
+	for {
+		ip := *next
+		*next = ip.Next()
+		if !prefix.Contains(*next) {
+			*next = prefix.Addr().Next()
+		}
+		if _, ok := p.byIP[ip]; !ok {
+			return ip
+		}
+	}
+}
+
+func (p *FakeIPPool) removeLocked(element *list.Element) {
+	entry := p.lru.Remove(element).(*fakeIPEntry)
+	delete(p.byDomain, entry.domain)
+	if entry.ip4.IsValid() {
+		delete(p.byIP, entry.ip4)
+	}
+	if entry.ip6.IsValid() {
+		delete(p.byIP, entry.ip6)
+	}
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// hostAddress returns the host:port address for the fake IP address, if it's assigned.
+func (p *FakeIPPool) hostAddress(addr netip.AddrPort) (string, bool) {
+	domain, ok := p.Lookup(addr.Addr())
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(domain, strconv.Itoa(int(addr.Port()))), true
+}
+
+// fakeIPResponse answers A and AAAA queries with the fake IPs of the domain. It returns false for other queries.
+func (p *FakeIPPool) fakeIPResponse(query []byte) ([]byte, bool) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, false
+	}
+	if msg.Header.Response || msg.Header.OpCode != 0 || len(msg.Questions) != 1 {
+		return nil, false
+	}
+	question := msg.Questions[0]
+	if question.Class != dnsmessage.ClassINET || (question.Type != dnsmessage.TypeA && question.Type != dnsmessage.TypeAAAA) {
+		return nil, false
+	}
+	header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: question.Class, TTL: fakeIPTTL}
+	var body dnsmessage.ResourceBody
+	if question.Type == dnsmessage.TypeA {
+		body = &dnsmessage.AResource{A: p.Assign(question.Name.String(), false).As4()}
+	} else {
+		body = &dnsmessage.AAAAResource{AAAA: p.Assign(question.Name.String(), true).As16()}
+	}
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 msg.Header.ID,
+			Response:           true,
+			Authoritative:      true,
+			RecursionDesired:   msg.Header.RecursionDesired,
+			RecursionAvailable: true,
+		},
+		Questions: msg.Questions,
+		Answers:   []dnsmessage.Resource{{Header: header, Body: body}},
+	}
+	packed, err := response.Pack()
+	if err != nil {
+		return nil, false
+	}
+	return packed, true
+}
+
+// fakeIPUpstream answers A and AAAA queries with fake IPs, and sends the other queries to the base upstream.
+type fakeIPUpstream struct {
+	pool *FakeIPPool
+	base Upstream
+}
+
+// Exchange implements [Upstream].
+func (u *fakeIPUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if response, ok := u.pool.fakeIPResponse(query); ok {
+		return response, nil
+	}
+	return u.base.Exchange(ctx, query)
+}
+
+// ----- PacketProxy -----
+
+type fakeIPPacketProxy struct {
+	base  network.PacketProxy
+	local netip.AddrPort
+	pool  *FakeIPPool
+}
+
+type fakeIPPacketReqSender struct {
+	network.PacketRequestSender
+	fpp  *fakeIPPacketProxy
+	resp network.PacketResponseReceiver
+}
+
+var _ network.PacketProxy = (*fakeIPPacketProxy)(nil)
+
+// WrapFakeIPPacketProxy creates a PacketProxy to answer UDP based DNS queries for A and AAAA records with fake IPs
+// from `pool`. It intercepts the A and AAAA queries to `localAddr` and answers them from `localAddr`.
+//
+// All other UDP packets, including other DNS queries, are passed through to the `base` PacketProxy. Packets to
+// fake IPs must be sent to their domain by the `base` PacketProxy, for instance by creating it from a
+// PacketListener wrapped with [WrapFakeIPPacketListener].
+func WrapFakeIPPacketProxy(base network.PacketProxy, localAddr netip.AddrPort, pool *FakeIPPool) (network.PacketProxy, error) {
+	if base == nil {
+		return nil, errors.New("base PacketProxy must be provided")
+	}
+	if pool == nil {
+		return nil, errors.New("fake IP pool must be provided")
+	}
+	return &fakeIPPacketProxy{base: base, local: localAddr, pool: pool}, nil
+}
+
+// NewSession implements PacketProxy.NewSession.
+func (fpp *fakeIPPacketProxy) NewSession(resp network.PacketResponseReceiver) (_ network.PacketRequestSender, err error) {
+	base, err := fpp.base.NewSession(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeIPPacketReqSender{base, fpp, resp}, nil
+}
+
+// WriteTo answers the A and AAAA queries to the local address. Otherwise, it passes the packet to the base proxy.
+func (req *fakeIPPacketReqSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	if isEquivalentAddrPort(destination, req.fpp.local) {
+		if response, ok := req.fpp.pool.fakeIPResponse(p); ok {
+			req.resp.WriteFrom(response, net.UDPAddrFromAddrPort(req.fpp.local))
+			return len(p), nil
+		}
+	}
+	return req.PacketRequestSender.WriteTo(p, destination)
+}
+
+// ----- StreamDialer -----
+
+// WrapFakeIPStreamDialer creates a StreamDialer that dials the connections to fake IPs from `pool` by domain name,
+// and answers the TCP based DNS queries for A and AAAA records to `localAddr` with fake IPs. Other DNS queries
+// are sent to `localAddr` with the `base` StreamDialer.
+//
+// All other connections are passed through to the `base` StreamDialer. Connections to fake IPs that are not
+// assigned fail.
+func WrapFakeIPStreamDialer(base transport.StreamDialer, localAddr netip.AddrPort, pool *FakeIPPool) (transport.StreamDialer, error) {
+	if base == nil {
+		return nil, errors.New("base StreamDialer must be provided")
+	}
+	if pool == nil {
+		return nil, errors.New("fake IP pool must be provided")
+	}
+	baseUpstream, err := NewTCPUpstream(base, localAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	resolvers, err := NewResolverSet([]string{localAddr.String()}, FailoverPolicy)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := newResolver(resolvers, []Upstream{&fakeIPUpstream{pool, baseUpstream}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		dst, err := netip.ParseAddrPort(addr)
+		if err != nil {
+			return base.DialStream(ctx, addr)
+		}
+		if isEquivalentAddrPort(dst, localAddr) {
+			return newResolveStreamConn(resolver, localAddr), nil
+		}
+		if !pool.Contains(dst.Addr()) {
+			return base.DialStream(ctx, addr)
+		}
+		hostAddr, ok := pool.hostAddress(dst)
+		if !ok {
+			return nil, fmt.Errorf("fake IP %v is not assigned", dst.Addr())
+		}
+		return base.DialStream(ctx, hostAddr)
+	}), nil
+}
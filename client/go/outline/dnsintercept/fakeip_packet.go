@@ -0,0 +1,270 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// fakeIPPacketQueueSize is the number of received packets a fake IP PacketConn can hold before reading stops.
+const fakeIPPacketQueueSize = 32
+
+// hostAddr is a [net.Addr] for a host name destination.
+type hostAddr string
+
+func (a hostAddr) Network() string { return "udp" }
+func (a hostAddr) String() string  { return string(a) }
+
+type fakeIPPacketListener struct {
+	base transport.PacketListener
+	pool *FakeIPPool
+}
+
+var _ transport.PacketListener = (*fakeIPPacketListener)(nil)
+
+// WrapFakeIPPacketListener creates a PacketListener that sends the packets to fake IPs from `pool` by domain name.
+// The `base` PacketListener must support host:port destinations, like Shadowsocks does. The packets to each fake
+// IP are sent on a separate `base` PacketConn, so the responses, which come from the resolved IP, are received
+// from the fake IP. Packets to fake IPs that are not assigned are dropped.
+func WrapFakeIPPacketListener(base transport.PacketListener, pool *FakeIPPool) (transport.PacketListener, error) {
+	if base == nil {
+		return nil, errors.New("base PacketListener must be provided")
+	}
+	if pool == nil {
+		return nil, errors.New("fake IP pool must be provided")
+	}
+	return &fakeIPPacketListener{base, pool}, nil
+}
+
+// ListenPacket implements [transport.PacketListener].
+func (l *fakeIPPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	base, err := l.base.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn := &fakeIPPacketConn{
+		base:     base,
+		listener: l.base,
+		pool:     l.pool,
+		packets:  make(chan fakeIPPacket, fakeIPPacketQueueSize),
+		closed:   make(chan struct{}),
+		subConns: make(map[netip.AddrPort]net.PacketConn),
+
+		readDeadlineChanged: make(chan struct{}),
+	}
+	go conn.readLoop(base, nil)
+	return conn, nil
+}
+
+// fakeIPPacketConn is a PacketConn that sends the packets to each fake IP on a separate PacketConn.
+type fakeIPPacketConn struct {
+	// base is the PacketConn for the addresses that are not fake.
+	base     net.PacketConn
+	listener transport.PacketListener
+	pool     *FakeIPPool
+	// packets has the packets received by all the PacketConns.
+	packets   chan fakeIPPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu           sync.Mutex
+	subConns     map[netip.AddrPort]net.PacketConn
+	readDeadline time.Time
+	// readDeadlineChanged is closed and replaced when the read deadline changes, to wake up the blocked reads.
+	readDeadlineChanged chan struct{}
+}
+
+var _ net.PacketConn = (*fakeIPPacketConn)(nil)
+
+type fakeIPPacket struct {
+	payload []byte
+	source  net.Addr
+	err     error
+}
+
+// readLoop reads the packets from the PacketConn into the queue. If fakeSource is not nil, it's used as the
+// source of the packets.
+func (c *fakeIPPacketConn) readLoop(conn net.PacketConn, fakeSource net.Addr) {
+	buf := make([]byte, 65536)
+	for {
+		n, source, err := conn.ReadFrom(buf)
+		var packet fakeIPPacket
+		if err != nil {
+			if fakeSource != nil {
+				// The PacketConn for a fake IP is only used for that fake IP, so the error is not reported.
+				c.removeSubConn(conn)
+				return
+			}
+			packet.err = err
+		} else {
+			packet.payload = slices.Clone(buf[:n])
+			packet.source = source
+			if fakeSource != nil {
+				packet.source = fakeSource
+			}
+		}
+		select {
+		case c.packets <- packet:
+		case <-c.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *fakeIPPacketConn) removeSubConn(conn net.PacketConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for fakeAddr, subConn := range c.subConns {
+		if subConn == conn {
+			delete(c.subConns, fakeAddr)
+			break
+		}
+	}
+	conn.Close()
+}
+
+// ReadFrom reads a packet from any of the PacketConns.
+func (c *fakeIPPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+	for {
+		c.mu.Lock()
+		deadline, deadlineChanged := c.readDeadline, c.readDeadlineChanged
+		c.mu.Unlock()
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case packet := <-c.packets:
+			stopTimer(timer)
+			if packet.err != nil {
+				return 0, nil, packet.err
+			}
+			return copy(p, packet.payload), packet.source, nil
+		case <-c.closed:
+			stopTimer(timer)
+			return 0, nil, net.ErrClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-deadlineChanged:
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// WriteTo sends the packets to fake IPs on their PacketConn, with the domain name as the destination.
+func (c *fakeIPPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || !c.pool.Contains(udpAddr.AddrPort().Addr()) {
+		return c.base.WriteTo(p, addr)
+	}
+	fakeAddr := udpAddr.AddrPort()
+	fakeAddr = netip.AddrPortFrom(fakeAddr.Addr().Unmap(), fakeAddr.Port())
+	host, ok := c.pool.hostAddress(fakeAddr)
+	if !ok {
+		// Like an unreachable destination.
+		return len(p), nil
+	}
+	subConn, err := c.subConn(fakeAddr)
+	if err != nil {
+		return 0, err
+	}
+	return subConn.WriteTo(p, hostAddr(host))
+}
+
+// subConn returns the PacketConn for the fake IP, creating it if needed.
+func (c *fakeIPPacketConn) subConn(fakeAddr netip.AddrPort) (net.PacketConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+	if subConn, ok := c.subConns[fakeAddr]; ok {
+		return subConn, nil
+	}
+	subConn, err := c.listener.ListenPacket(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PacketConn for fake IP: %w", err)
+	}
+	c.subConns[fakeAddr] = subConn
+	go c.readLoop(subConn, net.UDPAddrFromAddrPort(fakeAddr))
+	return subConn, nil
+}
+
+// Close closes all the PacketConns.
+func (c *fakeIPPacketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		close(c.closed)
+		subConns := c.subConns
+		c.subConns = nil
+		c.mu.Unlock()
+		for _, subConn := range subConns {
+			subConn.Close()
+		}
+		err = c.base.Close()
+	})
+	return err
+}
+
+func (c *fakeIPPacketConn) LocalAddr() net.Addr {
+	return c.base.LocalAddr()
+}
+
+func (c *fakeIPPacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *fakeIPPacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	close(c.readDeadlineChanged)
+	c.readDeadlineChanged = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline is a no-op, since writes don't block.
+func (c *fakeIPPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
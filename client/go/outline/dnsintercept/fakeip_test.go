@@ -0,0 +1,361 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testFakePrefix4 = netip.MustParsePrefix("198.18.0.0/15")
+	testFakePrefix6 = netip.MustParsePrefix("fdfe:dcba:9876::/64")
+)
+
+func newTestFakeIPPool(t *testing.T, maxEntries int) *FakeIPPool {
+	pool, err := NewFakeIPPool(testFakePrefix4, testFakePrefix6, maxEntries)
+	require.NoError(t, err)
+	return pool
+}
+
+func newTestQueryType(t *testing.T, id uint16, name string, qtype dnsmessage.Type) []byte {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	query, err := msg.Pack()
+	require.NoError(t, err)
+	return query
+}
+
+func TestNewFakeIPPool(t *testing.T) {
+	_, err := NewFakeIPPool(testFakePrefix6, testFakePrefix6, 10)
+	require.Error(t, err)
+	_, err = NewFakeIPPool(testFakePrefix4, testFakePrefix4, 10)
+	require.Error(t, err)
+	_, err = NewFakeIPPool(testFakePrefix4, testFakePrefix6, 0)
+	require.Error(t, err)
+	_, err = NewFakeIPPool(netip.MustParsePrefix("198.18.0.0/29"), testFakePrefix6, 7)
+	require.Error(t, err)
+	_, err = NewFakeIPPool(netip.MustParsePrefix("198.18.0.0/29"), testFakePrefix6, 6)
+	require.NoError(t, err)
+}
+
+func TestFakeIPPool_Assign(t *testing.T) {
+	pool := newTestFakeIPPool(t, 10)
+
+	ip4 := pool.Assign("Example.com.", false)
+	require.Equal(t, netip.MustParseAddr("198.18.0.1"), ip4)
+	require.Equal(t, ip4, pool.Assign("example.com", false))
+	ip6 := pool.Assign("example.com.", true)
+	require.Equal(t, netip.MustParseAddr("fdfe:dcba:9876::1"), ip6)
+	require.Equal(t, netip.MustParseAddr("198.18.0.2"), pool.Assign("other.example.", false))
+
+	domain, ok := pool.Lookup(ip4)
+	require.True(t, ok)
+	require.Equal(t, "example.com", domain)
+	domain, ok = pool.Lookup(netip.AddrFrom16(ip4.As16()))
+	require.True(t, ok)
+	require.Equal(t, "example.com", domain)
+	domain, ok = pool.Lookup(ip6)
+	require.True(t, ok)
+	require.Equal(t, "example.com", domain)
+	_, ok = pool.Lookup(netip.MustParseAddr("198.18.0.3"))
+	require.False(t, ok)
+
+	require.True(t, pool.Contains(netip.MustParseAddr("198.19.255.255")))
+	require.True(t, pool.Contains(ip6))
+	require.False(t, pool.Contains(netip.MustParseAddr("198.20.0.1")))
+}
+
+func TestFakeIPPool_Eviction(t *testing.T) {
+	pool, err := NewFakeIPPool(netip.MustParsePrefix("198.18.0.0/30"), testFakePrefix6, 2)
+	require.NoError(t, err)
+
+	ipA := pool.Assign("a.example", false)
+	ipB := pool.Assign("b.example", false)
+	// A is used, so B is the least recently used.
+	_, ok := pool.Lookup(ipA)
+	require.True(t, ok)
+	ipC := pool.Assign("c.example", false)
+	require.Equal(t, netip.MustParseAddr("198.18.0.3"), ipC)
+	_, ok = pool.Lookup(ipB)
+	require.False(t, ok)
+
+	// The address of A is reused after it's evicted.
+	ipD := pool.Assign("d.example", false)
+	require.Equal(t, ipA, ipD)
+	domain, ok := pool.Lookup(ipD)
+	require.True(t, ok)
+	require.Equal(t, "d.example", domain)
+	domain, ok = pool.Lookup(ipC)
+	require.True(t, ok)
+	require.Equal(t, "c.example", domain)
+}
+
+func TestWrapFakeIPPacketProxy(t *testing.T) {
+	pp := &packetProxyWithGivenRequestSender{req: &lastDestPacketRequestSender{}}
+	resp := &lastSourcePacketResponseReceiver{}
+	local := netip.MustParseAddrPort("192.0.2.2:53")
+	pool := newTestFakeIPPool(t, 10)
+
+	_, err := WrapFakeIPPacketProxy(nil, local, pool)
+	require.Error(t, err)
+	_, err = WrapFakeIPPacketProxy(pp, local, nil)
+	require.Error(t, err)
+
+	fpp, err := WrapFakeIPPacketProxy(pp, local, pool)
+	require.NoError(t, err)
+	req, err := fpp.NewSession(resp)
+	require.NoError(t, err)
+
+	_, err = req.WriteTo(newTestQueryType(t, 1, "example.com.", dnsmessage.TypeA), local)
+	require.NoError(t, err)
+	require.False(t, pp.req.lastDst.IsValid())
+	require.Equal(t, net.UDPAddrFromAddrPort(local), resp.lastSrc)
+	msg := parseTestResponse(t, resp.lastPacket)
+	require.Equal(t, uint16(1), msg.Header.ID)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{198, 18, 0, 1}}, msg.Answers[0].Body)
+	require.Equal(t, uint32(fakeIPTTL), msg.Answers[0].Header.TTL)
+
+	_, err = req.WriteTo(newTestQueryType(t, 2, "example.com.", dnsmessage.TypeAAAA), local)
+	require.NoError(t, err)
+	msg = parseTestResponse(t, resp.lastPacket)
+	require.Equal(t, &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("fdfe:dcba:9876::1").As16()}, msg.Answers[0].Body)
+
+	// Other queries go to the base PacketProxy.
+	_, err = req.WriteTo(newTestQueryType(t, 3, "example.com.", dnsmessage.TypeMX), local)
+	require.NoError(t, err)
+	require.Equal(t, local, pp.req.lastDst)
+
+	require.NoError(t, req.Close())
+	require.True(t, pp.req.closed)
+}
+
+type recordingStreamDialer struct {
+	mu     sync.Mutex
+	dialed []string
+	dial   func(ctx context.Context, addr string) (transport.StreamConn, error)
+}
+
+func (d *recordingStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, addr)
+	d.mu.Unlock()
+	if d.dial != nil {
+		return d.dial(ctx, addr)
+	}
+	return nil, errors.New("not used in test")
+}
+
+func TestWrapFakeIPStreamDialer(t *testing.T) {
+	local := netip.MustParseAddrPort("192.0.2.1:53")
+	pool := newTestFakeIPPool(t, 10)
+	ip4 := pool.Assign("example.com.", false)
+	ip6 := pool.Assign("example.com.", true)
+	sd := &recordingStreamDialer{}
+
+	_, err := WrapFakeIPStreamDialer(nil, local, pool)
+	require.Error(t, err)
+
+	dialer, err := WrapFakeIPStreamDialer(sd, local, pool)
+	require.NoError(t, err)
+
+	// Fake IPs are dialed by domain name.
+	_, err = dialer.DialStream(context.Background(), netip.AddrPortFrom(ip4, 443).String())
+	require.Error(t, err)
+	_, err = dialer.DialStream(context.Background(), netip.AddrPortFrom(ip6, 80).String())
+	require.Error(t, err)
+	_, err = dialer.DialStream(context.Background(), "198.51.100.1:443")
+	require.Error(t, err)
+	_, err = dialer.DialStream(context.Background(), "example.net:443")
+	require.Error(t, err)
+	require.Equal(t, []string{"example.com:443", "example.com:80", "198.51.100.1:443", "example.net:443"}, sd.dialed)
+
+	// Fake IPs that are not assigned fail.
+	_, err = dialer.DialStream(context.Background(), "198.18.0.99:443")
+	require.ErrorContains(t, err, "not assigned")
+	require.Len(t, sd.dialed, 4)
+}
+
+func TestWrapFakeIPStreamDialer_DNS(t *testing.T) {
+	local := netip.MustParseAddrPort("192.0.2.1:53")
+	pool := newTestFakeIPPool(t, 10)
+	resolvers, err := NewResolverSet([]string{"dns.example:53"}, FailoverPolicy)
+	require.NoError(t, err)
+	resolve, err := WrapResolveStreamDialer(&lastAddrStreamDialer{}, local, resolvers, []Upstream{answeringUpstream(t, [4]byte{192, 0, 2, 5})}, nil)
+	require.NoError(t, err)
+	sd := &recordingStreamDialer{dial: resolve.DialStream}
+	dialer, err := WrapFakeIPStreamDialer(sd, local, pool)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), local.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	exchange := func(query []byte) dnsmessage.Message {
+		_, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...))
+		require.NoError(t, err)
+		response, err := readStreamMessage(conn)
+		require.NoError(t, err)
+		return parseTestResponse(t, response)
+	}
+
+	// A queries are answered with fake IPs.
+	msg := exchange(newTestQueryType(t, 1, "example.com.", dnsmessage.TypeA))
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{198, 18, 0, 1}}, msg.Answers[0].Body)
+	require.Empty(t, sd.dialed)
+
+	// Other queries go to the base StreamDialer.
+	msg = exchange(newTestQueryType(t, 2, "example.com.", dnsmessage.TypeTXT))
+	require.Equal(t, uint16(2), msg.Header.ID)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 5}}, msg.Answers[0].Body)
+	require.Equal(t, []string{local.String()}, sd.dialed)
+}
+
+// ----- fake IP PacketListener tests -----
+
+type fakePacketWrite struct {
+	conn    *fakePacketConn
+	payload string
+	addr    string
+}
+
+type fakePacketListener struct {
+	writes chan fakePacketWrite
+	conns  chan *fakePacketConn
+}
+
+func newFakePacketListener() *fakePacketListener {
+	return &fakePacketListener{writes: make(chan fakePacketWrite, 10), conns: make(chan *fakePacketConn, 10)}
+}
+
+func (l *fakePacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn := &fakePacketConn{listener: l, inbox: make(chan fakeIPPacket, 10), closed: make(chan struct{})}
+	l.conns <- conn
+	return conn, nil
+}
+
+type fakePacketConn struct {
+	net.PacketConn
+	listener  *fakePacketListener
+	inbox     chan fakeIPPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *fakePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.listener.writes <- fakePacketWrite{c, string(p), addr.String()}
+	return len(p), nil
+}
+
+func (c *fakePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case packet := <-c.inbox:
+		return copy(p, packet.payload), packet.source, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *fakePacketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestWrapFakeIPPacketListener(t *testing.T) {
+	pool := newTestFakeIPPool(t, 10)
+	fakeIP := pool.Assign("example.com.", false)
+	fakeAddr := net.UDPAddrFromAddrPort(netip.AddrPortFrom(fakeIP, 53))
+	realAddr := net.UDPAddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:123"))
+	listener := newFakePacketListener()
+
+	_, err := WrapFakeIPPacketListener(nil, pool)
+	require.Error(t, err)
+
+	fakeIPListener, err := WrapFakeIPPacketListener(listener, pool)
+	require.NoError(t, err)
+	conn, err := fakeIPListener.ListenPacket(context.Background())
+	require.NoError(t, err)
+	baseConn := <-listener.conns
+
+	// Packets to other addresses use the base PacketConn.
+	_, err = conn.WriteTo([]byte("real"), realAddr)
+	require.NoError(t, err)
+	write := <-listener.writes
+	require.Equal(t, fakePacketWrite{baseConn, "real", realAddr.String()}, write)
+	baseConn.inbox <- fakeIPPacket{payload: []byte("real response"), source: realAddr}
+	buf := make([]byte, 100)
+	n, source, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "real response", string(buf[:n]))
+	require.Equal(t, realAddr, source)
+
+	// Packets to fake IPs use a separate PacketConn, with the domain name.
+	_, err = conn.WriteTo([]byte("fake"), fakeAddr)
+	require.NoError(t, err)
+	subConn := <-listener.conns
+	write = <-listener.writes
+	require.Equal(t, fakePacketWrite{subConn, "fake", "example.com:53"}, write)
+	_, err = conn.WriteTo([]byte("fake again"), fakeAddr)
+	require.NoError(t, err)
+	write = <-listener.writes
+	require.Equal(t, fakePacketWrite{subConn, "fake again", "example.com:53"}, write)
+
+	// Responses come from the fake IP.
+	subConn.inbox <- fakeIPPacket{payload: []byte("fake response"), source: net.UDPAddrFromAddrPort(netip.MustParseAddrPort("93.184.215.14:53"))}
+	n, source, err = conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "fake response", string(buf[:n]))
+	require.Equal(t, fakeAddr, source)
+
+	// Packets to fake IPs that are not assigned are dropped.
+	_, err = conn.WriteTo([]byte("unknown"), net.UDPAddrFromAddrPort(netip.MustParseAddrPort("198.18.0.99:53")))
+	require.NoError(t, err)
+	require.Empty(t, listener.writes)
+
+	// Reads time out.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, _, err = conn.ReadFrom(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// A new deadline applies to the blocked reads.
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(buf)
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, conn.SetReadDeadline(time.Now()))
+	require.ErrorIs(t, <-readErr, os.ErrDeadlineExceeded)
+
+	require.NoError(t, conn.Close())
+	<-baseConn.closed
+	<-subConn.closed
+	_, _, err = conn.ReadFrom(buf)
+	require.ErrorIs(t, err, net.ErrClosed)
+}
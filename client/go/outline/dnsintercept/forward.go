@@ -15,83 +15,243 @@
 package dnsintercept
 
 import (
-	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"math/rand/v2"
 	"net"
 	"net/netip"
+	"slices"
+	"sync"
+	"time"
 
 	"golang.getoutline.org/sdk/network"
-	"golang.getoutline.org/sdk/transport"
 )
 
-// WrapForwardStreamDialer creates a StreamDialer to intercept and redirect TCP based DNS connections.
-// It intercepts all TCP connection for `localIP:53` and redirects them to `resolverAddr` via the `base` StreamDialer.
-func WrapForwardStreamDialer(base transport.StreamDialer, localAddr, resolverAddr netip.AddrPort) (transport.StreamDialer, error) {
-	if base == nil {
-		return nil, errors.New("base StreamDialer must be provided")
-	}
-	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
-		if dst, err := netip.ParseAddrPort(addr); err == nil && isEquivalentAddrPort(dst, localAddr) {
-			addr = resolverAddr.String()
-		}
-		return base.DialStream(ctx, addr)
-	}), nil
-}
-
 // forwardPacketProxy wraps another PacketProxy to intercept and redirect DNS packets.
 type forwardPacketProxy struct {
-	base          network.PacketProxy
-	local, resolv netip.AddrPort
+	base      network.PacketProxy
+	local     netip.AddrPort
+	resolvers *ResolverSet
+	// resolvAddrs are the parsed addresses of the resolvers, by index.
+	resolvAddrs []netip.AddrPort
+	// timeout is how long to wait for a response before reporting the resolver as failed.
+	timeout time.Duration
+	// cache is optional.
+	cache *Cache
+}
+
+// forwardPacketSession keeps track of the queries pending a response in a session, to detect failed resolvers.
+type forwardPacketSession struct {
+	fpp *forwardPacketProxy
+	// resp receives the responses answered from the cache.
+	resp    network.PacketResponseReceiver
+	mu      sync.Mutex
+	pending map[pendingQueryKey]*pendingQuery
+}
+
+type pendingQueryKey struct {
+	resolver int
+	id       uint16
+}
+
+type pendingQuery struct {
+	sent  time.Time
+	timer *time.Timer
+	// revalidate is whether the query only refreshes the cache, so the response must not be delivered.
+	revalidate bool
+	// clientID is the ID of the client query that triggered the revalidation, which is sent with a fresh ID.
+	clientID uint16
 }
 
 type forwardPacketReqSender struct {
 	network.PacketRequestSender
-	fpp *forwardPacketProxy
+	session *forwardPacketSession
 }
 
 type forwardPacketRespReceiver struct {
 	network.PacketResponseReceiver
-	fpp *forwardPacketProxy
+	session *forwardPacketSession
 }
 
 var _ network.PacketProxy = (*forwardPacketProxy)(nil)
 
 // WrapForwardPacketProxy creates a PacketProxy to intercept and redirect UDP based DNS packets.
-// It intercepts all packets to `localAddr` and redirecrs them to `resolverAddr` via the `base` PacketProxy.
-func WrapForwardPacketProxy(base network.PacketProxy, localAddr, resolverAddr netip.AddrPort) (network.PacketProxy, error) {
+// It intercepts all packets to `localAddr` and redirects them to a resolver selected from `resolvers` via the
+// `base` PacketProxy. The resolver addresses must be IP addresses. Resolvers that don't respond in time are
+// reported as failed, so the next queries switch to another resolver. If `cache` is not nil, the responses
+// are cached, and the queries in the cache are answered locally.
+func WrapForwardPacketProxy(base network.PacketProxy, localAddr netip.AddrPort, resolvers *ResolverSet, cache *Cache) (network.PacketProxy, error) {
 	if base == nil {
 		return nil, errors.New("base PacketProxy must be provided")
 	}
+	if resolvers == nil {
+		return nil, errors.New("resolvers must be provided")
+	}
+	resolvAddrs := make([]netip.AddrPort, 0, resolvers.Len())
+	for _, addr := range resolvers.addrs {
+		resolvAddr, err := netip.ParseAddrPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("resolver address must be an IP address: %w", err)
+		}
+		resolvAddrs = append(resolvAddrs, resolvAddr)
+	}
 	return &forwardPacketProxy{
-		base:   base,
-		local:  localAddr,
-		resolv: resolverAddr,
+		base:        base,
+		local:       localAddr,
+		resolvers:   resolvers,
+		resolvAddrs: resolvAddrs,
+		timeout:     resolverTimeout,
+		cache:       cache,
 	}, nil
 }
 
 // NewSession implements PacketProxy.NewSession.
 func (fpp *forwardPacketProxy) NewSession(resp network.PacketResponseReceiver) (_ network.PacketRequestSender, err error) {
-	base, err := fpp.base.NewSession(&forwardPacketRespReceiver{resp, fpp})
+	session := &forwardPacketSession{fpp: fpp, resp: resp, pending: make(map[pendingQueryKey]*pendingQuery)}
+	base, err := fpp.base.NewSession(&forwardPacketRespReceiver{resp, session})
 	if err != nil {
 		return nil, err
 	}
-	return &forwardPacketReqSender{base, fpp}, nil
+	return &forwardPacketReqSender{base, session}, nil
 }
 
 // WriteTo intercepts outgoing DNS request packets.
-// If a packet is destined for the local resolver, it remaps the destination to the remote resolver.
+// If a packet is destined for the local resolver, it answers it from the cache, or remaps the destination to
+// the selected remote resolver.
 func (req *forwardPacketReqSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
-	if isEquivalentAddrPort(destination, req.fpp.local) {
-		destination = req.fpp.resolv
+	if !isEquivalentAddrPort(destination, req.session.fpp.local) {
+		return req.PacketRequestSender.WriteTo(p, destination)
+	}
+	if cache := req.session.fpp.cache; cache != nil {
+		if response, revalidate, ok := cache.Get(p); ok {
+			if revalidate {
+				index, _ := req.session.fpp.resolvers.Select()
+				if query := req.session.trackRevalidation(index, p); query != nil {
+					req.PacketRequestSender.WriteTo(query, req.session.fpp.resolvAddrs[index])
+				}
+			}
+			if response, err := truncateResponse(response, maxUDPPayloadSize(p)); err == nil {
+				req.session.resp.WriteFrom(response, net.UDPAddrFromAddrPort(req.session.fpp.local))
+			}
+			return len(p), nil
+		}
 	}
-	return req.PacketRequestSender.WriteTo(p, destination)
+	index, _ := req.session.fpp.resolvers.Select()
+	req.session.trackQuery(index, p)
+	return req.PacketRequestSender.WriteTo(p, req.session.fpp.resolvAddrs[index])
+}
+
+// Close stops tracking the pending queries, and closes the base sender.
+func (req *forwardPacketReqSender) Close() error {
+	req.session.mu.Lock()
+	for key, query := range req.session.pending {
+		query.timer.Stop()
+		delete(req.session.pending, key)
+	}
+	req.session.mu.Unlock()
+	return req.PacketRequestSender.Close()
 }
 
 // ReadFrom intercepts incoming DNS response packets.
-// If a packet is received from the remote resolver, it remaps the source address to be the local resolver.
+// If a packet is received from a remote resolver, it caches it and remaps the source address to be the local
+// resolver. Responses to revalidation queries are only cached.
 func (resp *forwardPacketRespReceiver) WriteFrom(p []byte, source net.Addr) (int, error) {
-	if addr, ok := source.(*net.UDPAddr); ok && isEquivalentAddrPort(addr.AddrPort(), resp.fpp.resolv) {
-		source = net.UDPAddrFromAddrPort(resp.fpp.local)
+	if addr, ok := source.(*net.UDPAddr); ok {
+		for index, resolvAddr := range resp.session.fpp.resolvAddrs {
+			if isEquivalentAddrPort(addr.AddrPort(), resolvAddr) {
+				pending := resp.session.completeQuery(index, p)
+				if pending != nil && pending.revalidate {
+					if cache := resp.session.fpp.cache; cache != nil {
+						response := slices.Clone(p)
+						binary.BigEndian.PutUint16(response, pending.clientID)
+						cache.Put(response)
+					}
+					return len(p), nil
+				}
+				if cache := resp.session.fpp.cache; cache != nil {
+					cache.Put(p)
+				}
+				source = net.UDPAddrFromAddrPort(resp.session.fpp.local)
+				break
+			}
+		}
 	}
 	return resp.PacketResponseReceiver.WriteFrom(p, source)
 }
+
+// trackQuery starts waiting for the response to the query, identified by its DNS message ID.
+func (s *forwardPacketSession) trackQuery(resolver int, query []byte) {
+	if len(query) < 2 {
+		return
+	}
+	key := pendingQueryKey{resolver, binary.BigEndian.Uint16(query)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[key]; ok {
+		// Retransmission of the same query. Keep waiting for the original deadline.
+		return
+	}
+	s.addPending(key, &pendingQuery{sent: time.Now()})
+}
+
+// trackRevalidation returns a copy of the query with a fresh random ID that is not pending on the resolver,
+// and starts waiting for its response. The client may send other queries with the ID of the query that
+// triggered the revalidation, and their responses must still be delivered. It returns nil if the query is
+// too short.
+func (s *forwardPacketSession) trackRevalidation(resolver int, query []byte) []byte {
+	if len(query) < 2 {
+		return nil
+	}
+	pending := &pendingQuery{sent: time.Now(), revalidate: true, clientID: binary.BigEndian.Uint16(query)}
+	query = slices.Clone(query)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		key := pendingQueryKey{resolver, uint16(rand.Uint32())}
+		if _, ok := s.pending[key]; ok {
+			continue
+		}
+		binary.BigEndian.PutUint16(query, key.id)
+		s.addPending(key, pending)
+		return query
+	}
+}
+
+// addPending adds the pending query, and reports the resolver as failed if the response doesn't arrive in
+// time. Must be called with the session lock held.
+func (s *forwardPacketSession) addPending(key pendingQueryKey, pending *pendingQuery) {
+	pending.timer = time.AfterFunc(s.fpp.timeout, func() {
+		s.mu.Lock()
+		timedOut := s.pending[key] == pending
+		if timedOut {
+			delete(s.pending, key)
+		}
+		s.mu.Unlock()
+		if timedOut {
+			s.fpp.resolvers.ReportFailure(key.resolver)
+		}
+	})
+	s.pending[key] = pending
+}
+
+// completeQuery reports the resolver as successful if the response matches a pending query.
+// It returns the pending query, or nil if there's none.
+func (s *forwardPacketSession) completeQuery(resolver int, response []byte) *pendingQuery {
+	if len(response) < 2 {
+		return nil
+	}
+	key := pendingQueryKey{resolver, binary.BigEndian.Uint16(response)}
+	s.mu.Lock()
+	pending, ok := s.pending[key]
+	if ok {
+		pending.timer.Stop()
+		delete(s.pending, key)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	s.fpp.resolvers.ReportSuccess(resolver, time.Since(pending.sent))
+	return pending
+}
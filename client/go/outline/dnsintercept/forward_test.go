@@ -15,49 +15,17 @@
 package dnsintercept
 
 import (
-	"context"
-	"errors"
 	"net"
 	"net/netip"
 	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
 
 	"golang.getoutline.org/sdk/network"
-	"golang.getoutline.org/sdk/transport"
 	"github.com/stretchr/testify/require"
 )
 
-// ----- forward StreamDialer tests -----
-
-type lastAddrStreamDialer struct {
-	transport.StreamDialer
-	dialedAddr string
-}
-
-func (d *lastAddrStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
-	d.dialedAddr = addr
-	return nil, errors.New("not used in test")
-}
-
-func TestWrapForwardStreamDialer(t *testing.T) {
-	sd := &lastAddrStreamDialer{}
-	local := netip.MustParseAddrPort("192.0.2.1:53")
-	resolver := netip.MustParseAddrPort("8.8.8.8:53")
-
-	_, err := WrapForwardStreamDialer(nil, local, resolver)
-	require.Error(t, err)
-
-	dialer, err := WrapForwardStreamDialer(sd, local, resolver)
-	require.NoError(t, err)
-
-	_, err = dialer.DialStream(context.TODO(), "192.0.2.1:53")
-	require.Error(t, err)
-	require.Equal(t, "8.8.8.8:53", sd.dialedAddr)
-
-	_, err = dialer.DialStream(context.TODO(), "198.51.100.1:443")
-	require.Error(t, err)
-	require.Equal(t, "198.51.100.1:443", sd.dialedAddr)
-}
-
 // ----- forward PacketProxy tests -----
 
 type packetProxyWithGivenRequestSender struct {
@@ -72,12 +40,14 @@ func (p *packetProxyWithGivenRequestSender) NewSession(resp network.PacketRespon
 }
 
 type lastDestPacketRequestSender struct {
-	lastDst netip.AddrPort
-	closed  bool
+	lastDst    netip.AddrPort
+	lastPacket []byte
+	closed     bool
 }
 
 func (s *lastDestPacketRequestSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
 	s.lastDst = destination
+	s.lastPacket = p
 	return len(p), nil
 }
 
@@ -112,10 +82,13 @@ func TestWrapForwardPacketProxy(t *testing.T) {
 	nonResolver := netip.MustParseAddrPort("203.0.113.10:123")
 	nonResolverUDPAddr := net.UDPAddrFromAddrPort(nonResolver)
 
-	_, err := WrapForwardPacketProxy(nil, local, resolver)
+	resolvers, err := NewResolverSet([]string{resolver.String()}, FailoverPolicy)
+	require.NoError(t, err)
+
+	_, err = WrapForwardPacketProxy(nil, local, resolvers, nil)
 	require.Error(t, err)
 
-	fpp, err := WrapForwardPacketProxy(pp, local, resolver)
+	fpp, err := WrapForwardPacketProxy(pp, local, resolvers, nil)
 	require.NoError(t, err)
 
 	req, err := fpp.NewSession(resp)
@@ -145,3 +118,111 @@ func TestWrapForwardPacketProxy(t *testing.T) {
 	require.NoError(t, req.Close())
 	require.True(t, pp.req.closed)
 }
+
+func TestWrapForwardPacketProxy_Failover(t *testing.T) {
+	pp := &packetProxyWithGivenRequestSender{req: &lastDestPacketRequestSender{}}
+	resp := &lastSourcePacketResponseReceiver{}
+	local := netip.MustParseAddrPort("192.0.2.2:53")
+	resolvers, err := NewResolverSet([]string{"8.8.4.4:53", "[2001:db8::1]:53"}, FailoverPolicy)
+	require.NoError(t, err)
+
+	_, err = WrapForwardPacketProxy(pp, local, &ResolverSet{addrs: []string{"dns.example:53"}}, nil)
+	require.Error(t, err)
+
+	fpp, err := WrapForwardPacketProxy(pp, local, resolvers, nil)
+	require.NoError(t, err)
+	fpp.(*forwardPacketProxy).timeout = 10 * time.Millisecond
+	req, err := fpp.NewSession(resp)
+	require.NoError(t, err)
+
+	// Answered query keeps the resolver.
+	_, err = req.WriteTo([]byte{0, 1, 2}, local)
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("8.8.4.4:53"), pp.req.lastDst)
+	_, err = pp.resp.WriteFrom([]byte{0, 1, 3}, net.UDPAddrFromAddrPort(netip.MustParseAddrPort("8.8.4.4:53")))
+	require.NoError(t, err)
+	require.Equal(t, net.UDPAddrFromAddrPort(local), resp.lastSrc)
+	time.Sleep(20 * time.Millisecond)
+	_, err = req.WriteTo([]byte{0, 2, 2}, local)
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("8.8.4.4:53"), pp.req.lastDst)
+
+	// Unanswered query switches resolvers.
+	require.Eventually(t, func() bool {
+		index, _ := resolvers.Select()
+		return index == 1
+	}, time.Second, 5*time.Millisecond)
+	_, err = req.WriteTo([]byte{0, 3, 2}, local)
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:53"), pp.req.lastDst)
+	_, err = pp.resp.WriteFrom([]byte{0, 3, 3}, net.UDPAddrFromAddrPort(netip.MustParseAddrPort("[2001:db8::1]:53")))
+	require.NoError(t, err)
+	require.Equal(t, net.UDPAddrFromAddrPort(local), resp.lastSrc)
+
+	require.NoError(t, req.Close())
+}
+
+func TestWrapForwardPacketProxy_Cache(t *testing.T) {
+	pp := &packetProxyWithGivenRequestSender{req: &lastDestPacketRequestSender{}}
+	resp := &lastSourcePacketResponseReceiver{}
+	local := netip.MustParseAddrPort("192.0.2.2:53")
+	resolver := netip.MustParseAddrPort("8.8.4.4:53")
+	resolvers, err := NewResolverSet([]string{resolver.String()}, FailoverPolicy)
+	require.NoError(t, err)
+	cache, clock := newTestCache(t, 10)
+
+	fpp, err := WrapForwardPacketProxy(pp, local, resolvers, cache)
+	require.NoError(t, err)
+	req, err := fpp.NewSession(resp)
+	require.NoError(t, err)
+	defer req.Close()
+
+	// The first query goes to the resolver, and the response is cached.
+	query := newTestQuery(t, 1, "example.com.")
+	_, err = req.WriteTo(query, local)
+	require.NoError(t, err)
+	require.Equal(t, resolver, pp.req.lastDst)
+	_, err = pp.resp.WriteFrom(newTestResponse(t, query, [4]byte{192, 0, 2, 1}), net.UDPAddrFromAddrPort(resolver))
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), parseTestResponse(t, resp.lastPacket).Header.ID)
+
+	// The next query is answered locally.
+	pp.req.lastDst = netip.AddrPort{}
+	_, err = req.WriteTo(newTestQuery(t, 2, "example.com."), local)
+	require.NoError(t, err)
+	require.False(t, pp.req.lastDst.IsValid())
+	require.Equal(t, net.UDPAddrFromAddrPort(local), resp.lastSrc)
+	msg := parseTestResponse(t, resp.lastPacket)
+	require.Equal(t, uint16(2), msg.Header.ID)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}, msg.Answers[0].Body)
+
+	// The expired response is answered locally, and revalidated without delivering the new response.
+	clock.now = clock.now.Add(2 * time.Minute)
+	query = newTestQuery(t, 3, "example.com.")
+	_, err = req.WriteTo(query, local)
+	require.NoError(t, err)
+	require.Equal(t, resolver, pp.req.lastDst)
+	require.Equal(t, uint16(3), parseTestResponse(t, resp.lastPacket).Header.ID)
+	revalidation := pp.req.lastPacket
+
+	// The revalidation has its own ID, so a new client query with the same ID still gets its response.
+	otherQuery := newTestQuery(t, 3, "other.example.")
+	_, err = req.WriteTo(otherQuery, local)
+	require.NoError(t, err)
+	_, err = pp.resp.WriteFrom(newTestResponse(t, otherQuery, [4]byte{192, 0, 2, 3}), net.UDPAddrFromAddrPort(resolver))
+	require.NoError(t, err)
+	require.Equal(t, "other.example.", parseTestResponse(t, resp.lastPacket).Questions[0].Name.String())
+
+	resp.lastPacket = nil
+	_, err = pp.resp.WriteFrom(newTestResponse(t, revalidation, [4]byte{192, 0, 2, 2}), net.UDPAddrFromAddrPort(resolver))
+	require.NoError(t, err)
+	require.Nil(t, resp.lastPacket)
+
+	response, revalidate, ok := cache.Get(newTestQuery(t, 4, "example.com."))
+	require.True(t, ok)
+	require.False(t, revalidate)
+	msg = parseTestResponse(t, response)
+	require.Equal(t, uint16(4), msg.Header.ID)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}, msg.Answers[0].Body)
+	require.Equal(t, CacheStats{Hits: 2, StaleHits: 1, Misses: 2}, cache.Stats())
+}
@@ -0,0 +1,398 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golang.getoutline.org/sdk/network"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// upstreamTimeout is how long to wait for an upstream exchange before considering the resolver failed.
+// It's longer than resolverTimeout to account for the connection and TLS handshake.
+const upstreamTimeout = 5 * time.Second
+
+// defaultUDPPayloadSize is the maximum size of a DNS response over UDP if the query doesn't specify one.
+const defaultUDPPayloadSize = 512
+
+// resolver exchanges DNS queries with the upstreams, switching to the next resolver on failure.
+type resolver struct {
+	resolvers *ResolverSet
+	upstreams []Upstream
+	// cache is optional.
+	cache *Cache
+}
+
+func newResolver(resolvers *ResolverSet, upstreams []Upstream, cache *Cache) (*resolver, error) {
+	if resolvers == nil {
+		return nil, errors.New("resolvers must be provided")
+	}
+	if len(upstreams) != resolvers.Len() {
+		return nil, fmt.Errorf("got %v upstreams for %v resolvers", len(upstreams), resolvers.Len())
+	}
+	return &resolver{resolvers, upstreams, cache}, nil
+}
+
+// exchange answers the query from the cache, or from the upstreams. Expired cached responses are revalidated
+// in the background. It returns a SERVFAIL response if all the resolvers fail.
+func (r *resolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if r.cache == nil {
+		return r.exchangeUpstream(ctx, query)
+	}
+	if response, revalidate, ok := r.cache.Get(query); ok {
+		if revalidate {
+			go r.exchangeUpstream(context.Background(), query)
+		}
+		return response, nil
+	}
+	return r.exchangeUpstream(ctx, query)
+}
+
+// exchangeUpstream sends the query to the selected resolver, and retries with the next resolver on failure.
+// Responses are stored in the cache. It returns a SERVFAIL response if all the resolvers fail.
+func (r *resolver) exchangeUpstream(ctx context.Context, query []byte) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt < r.resolvers.Len(); attempt++ {
+		index, addr := r.resolvers.Select()
+		exchangeCtx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+		start := time.Now()
+		response, err := r.upstreams[index].Exchange(exchangeCtx, query)
+		cancel()
+		if err == nil {
+			r.resolvers.ReportSuccess(index, time.Since(start))
+			if r.cache != nil {
+				r.cache.Put(response)
+			}
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Debug("DNS resolver failed", "resolver", addr, "err", err)
+		r.resolvers.ReportFailure(index)
+		lastErr = err
+	}
+	slog.Warn("all DNS resolvers failed", "err", lastErr)
+	return serverFailureResponse(query)
+}
+
+// ----- PacketProxy -----
+
+// resolvePacketProxy wraps another PacketProxy to answer the DNS packets to the local address.
+type resolvePacketProxy struct {
+	base     network.PacketProxy
+	local    netip.AddrPort
+	resolver *resolver
+}
+
+type resolvePacketReqSender struct {
+	network.PacketRequestSender
+	rpp  *resolvePacketProxy
+	resp network.PacketResponseReceiver
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// mu guards closed, so no responses are written after the session is closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+var _ network.PacketProxy = (*resolvePacketProxy)(nil)
+
+// WrapResolvePacketProxy creates a PacketProxy to answer UDP based DNS packets locally.
+// It intercepts all packets to `localAddr` and sends the query to an upstream selected from `resolvers`, where
+// `upstreams` has the [Upstream] for each resolver. The response is sent back from `localAddr`, and truncated
+// if it doesn't fit the UDP payload size of the query. The queries don't go through the `base` PacketProxy,
+// so they don't depend on UDP connectivity. If `cache` is not nil, the responses are cached.
+//
+// With the upstreams from [NewTCPUpstream], it converts DNS over UDP to DNS over TCP, which works when UDP is
+// blocked, unlike a truncated response that relies on the system to retry over TCP.
+//
+// All other UDP packets are passed through to the `base` PacketProxy.
+func WrapResolvePacketProxy(base network.PacketProxy, localAddr netip.AddrPort, resolvers *ResolverSet, upstreams []Upstream, cache *Cache) (network.PacketProxy, error) {
+	if base == nil {
+		return nil, errors.New("base PacketProxy must be provided")
+	}
+	resolver, err := newResolver(resolvers, upstreams, cache)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvePacketProxy{base: base, local: localAddr, resolver: resolver}, nil
+}
+
+// NewSession implements PacketProxy.NewSession.
+func (rpp *resolvePacketProxy) NewSession(resp network.PacketResponseReceiver) (_ network.PacketRequestSender, err error) {
+	base, err := rpp.base.NewSession(resp)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &resolvePacketReqSender{PacketRequestSender: base, rpp: rpp, resp: resp, ctx: ctx, cancel: cancel}, nil
+}
+
+// WriteTo answers the DNS queries to the local address asynchronously. Otherwise, it passes the packet to the
+// base proxy.
+func (req *resolvePacketReqSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	if !isEquivalentAddrPort(destination, req.rpp.local) {
+		return req.PacketRequestSender.WriteTo(p, destination)
+	}
+	query := make([]byte, len(p))
+	copy(query, p)
+	go func() {
+		response, err := req.rpp.resolver.exchange(req.ctx, query)
+		if err != nil {
+			slog.Debug("failed to resolve DNS query", "err", err)
+			return
+		}
+		response, err = truncateResponse(response, maxUDPPayloadSize(query))
+		if err != nil {
+			slog.Debug("failed to truncate DNS response", "err", err)
+			return
+		}
+		req.mu.RLock()
+		defer req.mu.RUnlock()
+		if req.closed {
+			return
+		}
+		req.resp.WriteFrom(response, net.UDPAddrFromAddrPort(req.rpp.local))
+	}()
+	return len(p), nil
+}
+
+// Close cancels the pending queries, and closes the base sender.
+func (req *resolvePacketReqSender) Close() error {
+	req.cancel()
+	req.mu.Lock()
+	req.closed = true
+	req.mu.Unlock()
+	return req.PacketRequestSender.Close()
+}
+
+// ----- StreamDialer -----
+
+// WrapResolveStreamDialer creates a StreamDialer to answer TCP based DNS connections locally.
+// It intercepts all TCP connections for `localAddr` and sends each query on the connection to an upstream
+// selected from `resolvers`, where `upstreams` has the [Upstream] for each resolver. If `cache` is not nil, the
+// responses are cached.
+//
+// All other connections are passed through to the `base` StreamDialer.
+func WrapResolveStreamDialer(base transport.StreamDialer, localAddr netip.AddrPort, resolvers *ResolverSet, upstreams []Upstream, cache *Cache) (transport.StreamDialer, error) {
+	if base == nil {
+		return nil, errors.New("base StreamDialer must be provided")
+	}
+	resolver, err := newResolver(resolvers, upstreams, cache)
+	if err != nil {
+		return nil, err
+	}
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		if dst, err := netip.ParseAddrPort(addr); err != nil || !isEquivalentAddrPort(dst, localAddr) {
+			return base.DialStream(ctx, addr)
+		}
+		return newResolveStreamConn(resolver, localAddr), nil
+	}), nil
+}
+
+// resolveStreamConn is an in-memory DNS over TCP connection, answered by a resolver. It's the client end of
+// a [net.Pipe], which provides the deadlines, with the half-closes on top.
+type resolveStreamConn struct {
+	net.Conn
+	// server is the end of the pipe that reads the queries and writes the responses.
+	server      net.Conn
+	local       net.Addr
+	cancel      context.CancelFunc
+	readClosed  atomic.Bool
+	writeClosed atomic.Bool
+}
+
+var _ transport.StreamConn = (*resolveStreamConn)(nil)
+
+func newResolveStreamConn(resolver *resolver, localAddr netip.AddrPort) *resolveStreamConn {
+	client, server := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &resolveStreamConn{
+		Conn:   client,
+		server: server,
+		local:  net.TCPAddrFromAddrPort(localAddr),
+		cancel: cancel,
+	}
+	go conn.serveQueries(ctx, resolver)
+	return conn
+}
+
+// serveQueries answers the queries read from the server end concurrently, and writes the responses to it.
+// It closes the pipe after the queries are answered and the client is done writing.
+func (c *resolveStreamConn) serveQueries(ctx context.Context, resolver *resolver) {
+	var wg sync.WaitGroup
+	var writeMu sync.Mutex
+	for {
+		query, err := readStreamMessage(c.server)
+		if err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := resolver.exchange(ctx, query)
+			if err != nil {
+				slog.Debug("failed to resolve DNS query", "err", err)
+				return
+			}
+			msg := make([]byte, 2+len(response))
+			binary.BigEndian.PutUint16(msg, uint16(len(response)))
+			copy(msg[2:], response)
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			c.server.Write(msg)
+		}()
+	}
+	wg.Wait()
+	c.server.Close()
+}
+
+// Read reads the DNS responses.
+func (c *resolveStreamConn) Read(b []byte) (int, error) {
+	if c.readClosed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	return c.Conn.Read(b)
+}
+
+// Write writes the DNS queries.
+func (c *resolveStreamConn) Write(b []byte) (int, error) {
+	if c.writeClosed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	return c.Conn.Write(b)
+}
+
+// CloseRead discards the pending responses.
+func (c *resolveStreamConn) CloseRead() error {
+	c.readClosed.Store(true)
+	// Fail the blocked and future response writes.
+	return c.server.SetWriteDeadline(time.Now())
+}
+
+// CloseWrite ends the queries. The connection can still be read for the pending responses.
+func (c *resolveStreamConn) CloseWrite() error {
+	c.writeClosed.Store(true)
+	// Pipe writes return once the data is read, so the queries that were written are already read, and
+	// the blocked read of the next query can end.
+	return c.server.SetReadDeadline(time.Now())
+}
+
+// Close cancels the pending queries and closes the connection.
+func (c *resolveStreamConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+func (c *resolveStreamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *resolveStreamConn) RemoteAddr() net.Addr {
+	return c.local
+}
+
+// ----- DNS messages -----
+
+// maxUDPPayloadSize returns the maximum size of the UDP response to the query, which is the size advertised
+// in the EDNS(0) OPT record, or 512 bytes.
+func maxUDPPayloadSize(query []byte) int {
+	var p dnsmessage.Parser
+	if _, err := p.Start(query); err != nil {
+		return defaultUDPPayloadSize
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return defaultUDPPayloadSize
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return defaultUDPPayloadSize
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return defaultUDPPayloadSize
+	}
+	for {
+		header, err := p.AdditionalHeader()
+		if err != nil {
+			return defaultUDPPayloadSize
+		}
+		if header.Type == dnsmessage.TypeOPT {
+			// The OPT record class is the UDP payload size.
+			return max(int(header.Class), defaultUDPPayloadSize)
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return defaultUDPPayloadSize
+		}
+	}
+}
+
+// truncateResponse returns the response with only the header and questions, and the truncated flag set, if it's
+// larger than maxSize. That makes the client retry over TCP.
+func truncateResponse(response []byte, maxSize int) ([]byte, error) {
+	if len(response) <= maxSize {
+		return response, nil
+	}
+	var p dnsmessage.Parser
+	header, err := p.Start(response)
+	if err != nil {
+		return nil, err
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	header.Truncated = true
+	msg := dnsmessage.Message{Header: header, Questions: questions}
+	return msg.Pack()
+}
+
+// serverFailureResponse returns a SERVFAIL response to the query.
+func serverFailureResponse(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS query: %w", err)
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS query: %w", err)
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 header.ID,
+			Response:           true,
+			OpCode:             header.OpCode,
+			RecursionDesired:   header.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              dnsmessage.RCodeServerFailure,
+		},
+		Questions: questions,
+	}
+	return msg.Pack()
+}
@@ -0,0 +1,342 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUpstream answers the queries with the given function.
+type fakeUpstream func(query []byte) ([]byte, error)
+
+func (f fakeUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	return f(query)
+}
+
+func failingUpstream() Upstream {
+	return fakeUpstream(func(query []byte) ([]byte, error) { return nil, errors.New("upstream failed") })
+}
+
+func answeringUpstream(t *testing.T, ips ...[4]byte) Upstream {
+	return fakeUpstream(func(query []byte) ([]byte, error) { return newTestResponse(t, query, ips...), nil })
+}
+
+type chanPacketResponseReceiver struct {
+	packets chan []byte
+	sources chan net.Addr
+}
+
+func newChanPacketResponseReceiver() *chanPacketResponseReceiver {
+	return &chanPacketResponseReceiver{packets: make(chan []byte, 10), sources: make(chan net.Addr, 10)}
+}
+
+func (r *chanPacketResponseReceiver) WriteFrom(p []byte, source net.Addr) (int, error) {
+	r.packets <- append([]byte(nil), p...)
+	r.sources <- source
+	return len(p), nil
+}
+
+func (r *chanPacketResponseReceiver) Close() error {
+	return nil
+}
+
+func parseTestResponse(t *testing.T, response []byte) dnsmessage.Message {
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(response))
+	require.True(t, msg.Header.Response)
+	return msg
+}
+
+func TestWrapResolvePacketProxy(t *testing.T) {
+	pp := &packetProxyWithGivenRequestSender{req: &lastDestPacketRequestSender{}}
+	resp := newChanPacketResponseReceiver()
+	local := netip.MustParseAddrPort("192.0.2.2:53")
+	nonResolver := netip.MustParseAddrPort("203.0.113.10:123")
+	resolvers, err := NewResolverSet([]string{"https://dns.example/dns-query", "dot.example:853"}, FailoverPolicy)
+	require.NoError(t, err)
+	upstreams := []Upstream{failingUpstream(), answeringUpstream(t, [4]byte{192, 0, 2, 3})}
+
+	_, err = WrapResolvePacketProxy(nil, local, resolvers, upstreams, nil)
+	require.Error(t, err)
+	_, err = WrapResolvePacketProxy(pp, local, resolvers, upstreams[:1], nil)
+	require.Error(t, err)
+
+	rpp, err := WrapResolvePacketProxy(pp, local, resolvers, upstreams, nil)
+	require.NoError(t, err)
+	req, err := rpp.NewSession(resp)
+	require.NoError(t, err)
+
+	// The query is answered locally, by the resolver that works.
+	n, err := req.WriteTo(newTestQuery(t, 10, "example.com."), local)
+	require.NoError(t, err)
+	require.Greater(t, n, 0)
+	msg := parseTestResponse(t, <-resp.packets)
+	require.Equal(t, uint16(10), msg.Header.ID)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 3}}, msg.Answers[0].Body)
+	require.Equal(t, net.UDPAddrFromAddrPort(local), <-resp.sources)
+	require.False(t, pp.req.lastDst.IsValid())
+	index, _ := resolvers.Select()
+	require.Equal(t, 1, index)
+
+	// Other packets go to the base PacketProxy.
+	_, err = req.WriteTo([]byte("request"), nonResolver)
+	require.NoError(t, err)
+	require.Equal(t, nonResolver, pp.req.lastDst)
+
+	require.NoError(t, req.Close())
+	require.True(t, pp.req.closed)
+}
+
+func TestWrapResolvePacketProxy_ServerFailure(t *testing.T) {
+	pp := &packetProxyWithGivenRequestSender{req: &lastDestPacketRequestSender{}}
+	resp := newChanPacketResponseReceiver()
+	local := netip.MustParseAddrPort("192.0.2.2:53")
+	resolvers, err := NewResolverSet([]string{"dot.example:853"}, FailoverPolicy)
+	require.NoError(t, err)
+	rpp, err := WrapResolvePacketProxy(pp, local, resolvers, []Upstream{failingUpstream()}, nil)
+	require.NoError(t, err)
+	req, err := rpp.NewSession(resp)
+	require.NoError(t, err)
+	defer req.Close()
+
+	_, err = req.WriteTo(newTestQuery(t, 11, "example.com."), local)
+	require.NoError(t, err)
+	msg := parseTestResponse(t, <-resp.packets)
+	require.Equal(t, uint16(11), msg.Header.ID)
+	require.Equal(t, dnsmessage.RCodeServerFailure, msg.Header.RCode)
+	require.Equal(t, "example.com.", msg.Questions[0].Name.String())
+}
+
+func TestWrapResolvePacketProxy_Truncate(t *testing.T) {
+	pp := &packetProxyWithGivenRequestSender{req: &lastDestPacketRequestSender{}}
+	resp := newChanPacketResponseReceiver()
+	local := netip.MustParseAddrPort("192.0.2.2:53")
+	resolvers, err := NewResolverSet([]string{"dot.example:853"}, FailoverPolicy)
+	require.NoError(t, err)
+	ips := make([][4]byte, 100)
+	for i := range ips {
+		ips[i] = [4]byte{192, 0, 2, byte(i)}
+	}
+	rpp, err := WrapResolvePacketProxy(pp, local, resolvers, []Upstream{answeringUpstream(t, ips...)}, nil)
+	require.NoError(t, err)
+	req, err := rpp.NewSession(resp)
+	require.NoError(t, err)
+	defer req.Close()
+
+	// The response doesn't fit in 512 bytes.
+	_, err = req.WriteTo(newTestQuery(t, 12, "example.com."), local)
+	require.NoError(t, err)
+	msg := parseTestResponse(t, <-resp.packets)
+	require.True(t, msg.Header.Truncated)
+	require.Empty(t, msg.Answers)
+	require.Len(t, msg.Questions, 1)
+
+	// The response fits in the EDNS(0) payload size.
+	var query dnsmessage.Message
+	require.NoError(t, query.Unpack(newTestQuery(t, 13, "example.com.")))
+	var opt dnsmessage.ResourceHeader
+	require.NoError(t, opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false))
+	query.Additionals = append(query.Additionals, dnsmessage.Resource{Header: opt, Body: &dnsmessage.OPTResource{}})
+	queryBytes, err := query.Pack()
+	require.NoError(t, err)
+	_, err = req.WriteTo(queryBytes, local)
+	require.NoError(t, err)
+	msg = parseTestResponse(t, <-resp.packets)
+	require.False(t, msg.Header.Truncated)
+	require.Len(t, msg.Answers, 100)
+}
+
+func TestWrapResolvePacketProxy_TCPUpstream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					query, err := readStreamMessage(conn)
+					if err != nil {
+						return
+					}
+					response := newTestResponse(t, query, [4]byte{192, 0, 2, 4})
+					if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	pp := &packetProxyWithGivenRequestSender{req: &lastDestPacketRequestSender{}}
+	resp := newChanPacketResponseReceiver()
+	local := netip.MustParseAddrPort("192.0.2.2:53")
+	sd := &countingStreamDialer{}
+	resolvers, err := NewResolverSet([]string{listener.Addr().String()}, FailoverPolicy)
+	require.NoError(t, err)
+	upstream, err := NewTCPUpstream(sd, listener.Addr().String())
+	require.NoError(t, err)
+	rpp, err := WrapResolvePacketProxy(pp, local, resolvers, []Upstream{upstream}, nil)
+	require.NoError(t, err)
+	req, err := rpp.NewSession(resp)
+	require.NoError(t, err)
+	defer req.Close()
+
+	// The queries over UDP get normal answers over UDP, exchanged on a shared TCP connection.
+	_, err = req.WriteTo(newTestQuery(t, 20, "example.com."), local)
+	require.NoError(t, err)
+	msg := parseTestResponse(t, <-resp.packets)
+	require.Equal(t, uint16(20), msg.Header.ID)
+	require.False(t, msg.Header.Truncated)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 4}}, msg.Answers[0].Body)
+
+	for id := range uint16(5) {
+		_, err = req.WriteTo(newTestQuery(t, id, "example.com."), local)
+		require.NoError(t, err)
+	}
+	ids := make(map[uint16]bool)
+	for range 5 {
+		msg := parseTestResponse(t, <-resp.packets)
+		require.False(t, msg.Header.Truncated)
+		require.Len(t, msg.Answers, 1)
+		ids[msg.Header.ID] = true
+	}
+	require.Len(t, ids, 5)
+	require.Equal(t, 1, sd.dials)
+	require.False(t, pp.req.lastDst.IsValid())
+}
+
+type lastAddrStreamDialer struct {
+	transport.StreamDialer
+	dialedAddr string
+}
+
+func (d *lastAddrStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	d.dialedAddr = addr
+	return nil, errors.New("not used in test")
+}
+
+func TestWrapResolveStreamDialer(t *testing.T) {
+	sd := &lastAddrStreamDialer{}
+	local := netip.MustParseAddrPort("192.0.2.1:53")
+	resolvers, err := NewResolverSet([]string{"dot.example:853"}, FailoverPolicy)
+	require.NoError(t, err)
+	upstreams := []Upstream{answeringUpstream(t, [4]byte{192, 0, 2, 4})}
+
+	_, err = WrapResolveStreamDialer(nil, local, resolvers, upstreams, nil)
+	require.Error(t, err)
+
+	dialer, err := WrapResolveStreamDialer(sd, local, resolvers, upstreams, nil)
+	require.NoError(t, err)
+
+	_, err = dialer.DialStream(context.Background(), "198.51.100.1:443")
+	require.Error(t, err)
+	require.Equal(t, "198.51.100.1:443", sd.dialedAddr)
+
+	conn, err := dialer.DialStream(context.Background(), "192.0.2.1:53")
+	require.NoError(t, err)
+	defer conn.Close()
+	// The connection is answered locally.
+	require.Equal(t, "198.51.100.1:443", sd.dialedAddr)
+	// Clients like the system resolver set deadlines, which are accepted.
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Minute)))
+
+	for _, id := range []uint16{20, 21} {
+		query := newTestQuery(t, id, "example.com.")
+		_, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...))
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.CloseWrite())
+
+	ids := make(map[uint16]bool)
+	for range 2 {
+		response, err := readStreamMessage(conn)
+		require.NoError(t, err)
+		msg := parseTestResponse(t, response)
+		require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 4}}, msg.Answers[0].Body)
+		ids[msg.Header.ID] = true
+	}
+	require.Equal(t, map[uint16]bool{20: true, 21: true}, ids)
+
+	// The connection ends after the queries are answered.
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	// Reads time out when there are no responses.
+	conn, err = dialer.DialStream(context.Background(), "192.0.2.1:53")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestWrapResolveStreamDialer_Cache(t *testing.T) {
+	local := netip.MustParseAddrPort("192.0.2.1:53")
+	resolvers, err := NewResolverSet([]string{"dns.example:53"}, FailoverPolicy)
+	require.NoError(t, err)
+	var exchanges atomic.Int32
+	upstream := fakeUpstream(func(query []byte) ([]byte, error) {
+		n := exchanges.Add(1)
+		return newTestResponse(t, query, [4]byte{192, 0, 2, byte(n)}), nil
+	})
+	cache, clock := newTestCache(t, 10)
+	dialer, err := WrapResolveStreamDialer(&lastAddrStreamDialer{}, local, resolvers, []Upstream{upstream}, cache)
+	require.NoError(t, err)
+
+	resolve := func(id uint16) dnsmessage.Message {
+		conn, err := dialer.DialStream(context.Background(), local.String())
+		require.NoError(t, err)
+		defer conn.Close()
+		query := newTestQuery(t, id, "example.com.")
+		_, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...))
+		require.NoError(t, err)
+		response, err := readStreamMessage(conn)
+		require.NoError(t, err)
+		return parseTestResponse(t, response)
+	}
+
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}, resolve(1).Answers[0].Body)
+	msg := resolve(2)
+	require.Equal(t, uint16(2), msg.Header.ID)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}, msg.Answers[0].Body)
+	require.Equal(t, int32(1), exchanges.Load())
+
+	// The expired response is served while it's revalidated in the background.
+	clock.now = clock.now.Add(2 * time.Minute)
+	require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}, resolve(3).Answers[0].Body)
+	require.Eventually(t, func() bool {
+		response, revalidate, ok := cache.Get(newTestQuery(t, 4, "example.com."))
+		return ok && !revalidate && parseTestResponse(t, response).Answers[0].Body.(*dnsmessage.AResource).A == [4]byte{192, 0, 2, 2}
+	}, time.Second, 5*time.Millisecond)
+}
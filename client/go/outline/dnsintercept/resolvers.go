@@ -0,0 +1,170 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ResolverPolicy is the strategy a [ResolverSet] uses to select a resolver.
+type ResolverPolicy int
+
+const (
+	// RandomPolicy selects a random resolver, and keeps it until it fails.
+	RandomPolicy ResolverPolicy = iota
+	// FailoverPolicy selects the first resolver in order that is not failing.
+	FailoverPolicy
+	// FastestPolicy selects the resolver with the lowest latency that is not failing.
+	// Resolvers without a latency measurement are tried first.
+	FastestPolicy
+)
+
+const (
+	// resolverEjectionTime is how long a failed resolver is not selected for.
+	resolverEjectionTime = 30 * time.Second
+	// resolverTimeout is how long to wait for a UDP response before considering the resolver failed.
+	resolverTimeout = 2 * time.Second
+)
+
+// ResolverSet selects the resolver to forward DNS queries to, among a list of resolver addresses.
+// Failed resolvers are ejected for some time, so the next selection switches to another resolver.
+// It's safe for concurrent use.
+type ResolverSet struct {
+	addrs  []string
+	policy ResolverPolicy
+
+	mu       sync.Mutex
+	selected int
+	health   []resolverHealth
+	now      func() time.Time
+}
+
+type resolverHealth struct {
+	ejectedUntil time.Time
+	// latency is the smoothed latency of the resolver, or zero if it hasn't been measured.
+	latency time.Duration
+}
+
+// NewResolverSet creates a [ResolverSet] for the given host:port resolver addresses.
+func NewResolverSet(addrs []string, policy ResolverPolicy) (*ResolverSet, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("resolver list must not be empty")
+	}
+	switch policy {
+	case RandomPolicy, FailoverPolicy, FastestPolicy:
+	default:
+		return nil, fmt.Errorf("invalid resolver policy %v", policy)
+	}
+	set := &ResolverSet{
+		addrs:  addrs,
+		policy: policy,
+		health: make([]resolverHealth, len(addrs)),
+		now:    time.Now,
+	}
+	if policy == RandomPolicy {
+		set.selected = rand.IntN(len(addrs))
+	}
+	return set, nil
+}
+
+// Len returns the number of resolvers in the set.
+func (s *ResolverSet) Len() int {
+	return len(s.addrs)
+}
+
+// Select returns the index and address of the resolver to use.
+func (s *ResolverSet) Select() (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	available := func(i int) bool { return !now.Before(s.health[i].ejectedUntil) }
+
+	switch s.policy {
+	case RandomPolicy:
+		if !available(s.selected) {
+			var candidates []int
+			for i := range s.addrs {
+				if available(i) {
+					candidates = append(candidates, i)
+				}
+			}
+			if len(candidates) > 0 {
+				s.selected = candidates[rand.IntN(len(candidates))]
+			} else {
+				s.selected = s.leastEjected()
+			}
+		}
+	case FailoverPolicy:
+		s.selected = -1
+		for i := range s.addrs {
+			if available(i) {
+				s.selected = i
+				break
+			}
+		}
+		if s.selected == -1 {
+			s.selected = s.leastEjected()
+		}
+	case FastestPolicy:
+		s.selected = -1
+		for i := range s.addrs {
+			if available(i) && (s.selected == -1 || s.health[i].latency < s.health[s.selected].latency) {
+				s.selected = i
+			}
+		}
+		if s.selected == -1 {
+			s.selected = s.leastEjected()
+		}
+	}
+	return s.selected, s.addrs[s.selected]
+}
+
+// leastEjected returns the resolver that will be available first. It's used when all resolvers are ejected.
+func (s *ResolverSet) leastEjected() int {
+	best := 0
+	for i := range s.health {
+		if s.health[i].ejectedUntil.Before(s.health[best].ejectedUntil) {
+			best = i
+		}
+	}
+	return best
+}
+
+// ReportSuccess records a successful exchange with the resolver, and how long it took.
+func (s *ResolverSet) ReportSuccess(index int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	health := &s.health[index]
+	health.ejectedUntil = time.Time{}
+	// Make sure measured resolvers are never confused with unmeasured ones.
+	latency = max(latency, time.Nanosecond)
+	if health.latency == 0 {
+		health.latency = latency
+	} else {
+		// Exponentially weighted moving average, like TCP's smoothed RTT.
+		health.latency = (7*health.latency + latency) / 8
+	}
+}
+
+// ReportFailure records a failed exchange with the resolver, which ejects it.
+func (s *ResolverSet) ReportFailure(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health[index].ejectedUntil = s.now().Add(resolverEjectionTime)
+}
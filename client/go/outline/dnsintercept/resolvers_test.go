@@ -0,0 +1,106 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResolverSet(t *testing.T, policy ResolverPolicy) (*ResolverSet, *time.Time) {
+	set, err := NewResolverSet([]string{"a:53", "b:53", "c:53"}, policy)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	set.now = func() time.Time { return now }
+	return set, &now
+}
+
+func TestNewResolverSet_Errors(t *testing.T) {
+	_, err := NewResolverSet(nil, RandomPolicy)
+	require.Error(t, err)
+	_, err = NewResolverSet([]string{"a:53"}, ResolverPolicy(10))
+	require.Error(t, err)
+}
+
+func TestResolverSet_Failover(t *testing.T) {
+	set, now := newTestResolverSet(t, FailoverPolicy)
+	index, addr := set.Select()
+	require.Equal(t, 0, index)
+	require.Equal(t, "a:53", addr)
+
+	set.ReportFailure(0)
+	_, addr = set.Select()
+	require.Equal(t, "b:53", addr)
+
+	set.ReportFailure(1)
+	_, addr = set.Select()
+	require.Equal(t, "c:53", addr)
+
+	// When all resolvers are failing, it uses the one that failed first.
+	*now = now.Add(time.Second)
+	set.ReportFailure(2)
+	_, addr = set.Select()
+	require.Equal(t, "a:53", addr)
+
+	// Failed resolvers are retried after the ejection time.
+	*now = now.Add(resolverEjectionTime)
+	_, addr = set.Select()
+	require.Equal(t, "a:53", addr)
+	set.ReportFailure(0)
+	_, addr = set.Select()
+	require.Equal(t, "b:53", addr)
+}
+
+func TestResolverSet_Random(t *testing.T) {
+	set, _ := newTestResolverSet(t, RandomPolicy)
+	first, _ := set.Select()
+	// Sticks with the selected resolver while it works.
+	for i := 0; i < 10; i++ {
+		index, _ := set.Select()
+		require.Equal(t, first, index)
+		set.ReportSuccess(index, time.Millisecond)
+	}
+
+	set.ReportFailure(first)
+	second, _ := set.Select()
+	require.NotEqual(t, first, second)
+	set.ReportFailure(second)
+	third, _ := set.Select()
+	require.NotEqual(t, first, third)
+	require.NotEqual(t, second, third)
+}
+
+func TestResolverSet_Fastest(t *testing.T) {
+	set, _ := newTestResolverSet(t, FastestPolicy)
+	// Unmeasured resolvers are tried first.
+	for i, latency := range []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond} {
+		index, _ := set.Select()
+		require.Equal(t, i, index)
+		set.ReportSuccess(index, latency)
+	}
+	_, addr := set.Select()
+	require.Equal(t, "b:53", addr)
+
+	// The latency is smoothed.
+	set.ReportSuccess(1, 100*time.Millisecond)
+	_, addr = set.Select()
+	require.Equal(t, "c:53", addr)
+
+	set.ReportFailure(2)
+	_, addr = set.Select()
+	require.Equal(t, "b:53", addr)
+}
@@ -0,0 +1,455 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// maxDNSMessageSize is the largest DNS message that can be framed for DNS over TCP.
+const maxDNSMessageSize = 65535
+
+// dnsMessageMediaType is the media type for DNS messages in DoH, as defined in RFC 8484.
+const dnsMessageMediaType = "application/dns-message"
+
+// Upstream exchanges DNS messages with a remote resolver.
+type Upstream interface {
+	// Exchange sends the DNS query message and returns the response message.
+	Exchange(ctx context.Context, query []byte) ([]byte, error)
+}
+
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+var _ Upstream = (*dohUpstream)(nil)
+
+// NewDoHUpstream creates an [Upstream] for a DNS-over-HTTPS resolver, as specified in RFC 8484.
+// The connections to the resolver are established with the given StreamDialer.
+func NewDoHUpstream(sd transport.StreamDialer, resolverURL string) (Upstream, error) {
+	if sd == nil {
+		return nil, errors.New("StreamDialer must be provided")
+	}
+	parsedURL, err := url.Parse(resolverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver URL: %w", err)
+	}
+	if parsedURL.Scheme != "https" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("resolver URL must be an https URL with a host: %v", resolverURL)
+	}
+	httpTransport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if network != "tcp" {
+				return nil, net.UnknownNetworkError(network)
+			}
+			return sd.DialStream(ctx, addr)
+		},
+		// A custom dialer disables HTTP/2 unless it's explicitly requested.
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   time.Minute,
+	}
+	return &dohUpstream{url: parsedURL.String(), client: &http.Client{Transport: httpTransport}}, nil
+}
+
+// Exchange implements [Upstream].
+func (u *dohUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, errors.New("DNS query is too short")
+	}
+	// RFC 8484 recommends a DNS ID of 0 to maximize the HTTP cache friendliness.
+	id := binary.BigEndian.Uint16(query)
+	query = bytes.Clone(query)
+	binary.BigEndian.PutUint16(query, 0)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageMediaType)
+	req.Header.Set("Accept", dnsMessageMediaType)
+	httpResp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH request failed with status %v", httpResp.Status)
+	}
+	if contentType := httpResp.Header.Get("Content-Type"); contentType != dnsMessageMediaType {
+		return nil, fmt.Errorf("unexpected DoH response content type %q", contentType)
+	}
+	response, err := io.ReadAll(io.LimitReader(httpResp.Body, maxDNSMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DoH response: %w", err)
+	}
+	if len(response) < 2 || len(response) > maxDNSMessageSize {
+		return nil, fmt.Errorf("invalid DoH response size %v", len(response))
+	}
+	binary.BigEndian.PutUint16(response, id)
+	return response, nil
+}
+
+const (
+	// maxStreamConns is the number of DNS over TCP or TLS connections to a resolver above which the queries
+	// share the existing connections, even if they are busy.
+	maxStreamConns = 2
+	// maxPipelinedQueries is the number of pending queries on a connection above which a new connection is opened.
+	maxPipelinedQueries = 16
+	// streamIdleTimeout is how long a connection without pending queries is kept open.
+	streamIdleTimeout = 30 * time.Second
+)
+
+// streamUpstream is an [Upstream] for DNS over TCP, or over TLS if tlsConfig is set. The queries are pipelined
+// on a small pool of connections, as recommended by RFC 7766.
+type streamUpstream struct {
+	sd        transport.StreamDialer
+	addr      string
+	tlsConfig *tls.Config
+
+	mu    sync.Mutex
+	conns []*streamConn
+}
+
+var _ Upstream = (*streamUpstream)(nil)
+
+// NewTCPUpstream creates an [Upstream] for a plain DNS resolver at the given host:port address, using DNS over
+// TCP. The connections to the resolver are established with the given StreamDialer, and are shared by concurrent
+// queries.
+func NewTCPUpstream(sd transport.StreamDialer, addr string) (Upstream, error) {
+	if sd == nil {
+		return nil, errors.New("StreamDialer must be provided")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid resolver address: %w", err)
+	}
+	return &streamUpstream{sd: sd, addr: addr}, nil
+}
+
+// NewDoTUpstream creates an [Upstream] for a DNS-over-TLS resolver at the given host:port address, as
+// specified in RFC 7858. The connections to the resolver are established with the given StreamDialer, and
+// the resolver certificate is verified for the given server name. Connections are shared by concurrent queries.
+func NewDoTUpstream(sd transport.StreamDialer, addr string, serverName string) (Upstream, error) {
+	if sd == nil {
+		return nil, errors.New("StreamDialer must be provided")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid resolver address: %w", err)
+	}
+	if serverName == "" {
+		return nil, errors.New("server name must not be empty")
+	}
+	return &streamUpstream{
+		sd:        sd,
+		addr:      addr,
+		tlsConfig: &tls.Config{ServerName: serverName},
+	}, nil
+}
+
+// Exchange implements [Upstream].
+func (u *streamUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 2 || len(query) > maxDNSMessageSize {
+		return nil, fmt.Errorf("invalid DNS query size %v", len(query))
+	}
+	conn, connected := u.acquireConn()
+	response, err := conn.exchange(ctx, query)
+	u.releaseConn(conn)
+	if err == nil || !connected || ctx.Err() != nil {
+		return response, err
+	}
+	// The resolver may have closed the connection. Retry with a new one.
+	conn, _ = u.acquireConn()
+	defer u.releaseConn(conn)
+	return conn.exchange(ctx, query)
+}
+
+// acquireConn returns the connection with the fewest active queries, or a new connection if they are all busy.
+// It also returns whether the connection was already established.
+func (u *streamUpstream) acquireConn() (*streamConn, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var conn *streamConn
+	for _, c := range u.conns {
+		if conn == nil || c.active < conn.active {
+			conn = c
+		}
+	}
+	if conn == nil || (conn.active >= maxPipelinedQueries && len(u.conns) < maxStreamConns) {
+		conn = &streamConn{
+			ready:   make(chan struct{}),
+			done:    make(chan struct{}),
+			pending: make(map[uint16]chan []byte),
+		}
+		u.conns = append(u.conns, conn)
+		go u.connect(conn)
+	}
+	conn.active++
+	if conn.idleTimer != nil {
+		conn.idleTimer.Stop()
+	}
+	select {
+	case <-conn.ready:
+		return conn, true
+	default:
+		return conn, false
+	}
+}
+
+// releaseConn marks the end of a query on the connection, and schedules it to be closed if it becomes idle.
+func (u *streamUpstream) releaseConn(conn *streamConn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	conn.active--
+	if conn.active > 0 {
+		return
+	}
+	if conn.idleTimer == nil {
+		conn.idleTimer = time.AfterFunc(streamIdleTimeout, func() { u.closeIdleConn(conn) })
+	} else {
+		conn.idleTimer.Reset(streamIdleTimeout)
+	}
+}
+
+func (u *streamUpstream) closeIdleConn(conn *streamConn) {
+	u.mu.Lock()
+	if conn.active > 0 {
+		u.mu.Unlock()
+		return
+	}
+	u.mu.Unlock()
+	u.closeConn(conn, errors.New("connection closed after being idle"))
+}
+
+// closeConn removes the connection from the pool and closes it. The pending queries fail with err.
+func (u *streamUpstream) closeConn(conn *streamConn, err error) {
+	u.mu.Lock()
+	u.conns = slices.DeleteFunc(u.conns, func(c *streamConn) bool { return c == conn })
+	u.mu.Unlock()
+	conn.close(err)
+}
+
+// connect establishes the connection, and then reads the responses until the connection fails.
+func (u *streamUpstream) connect(conn *streamConn) {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	netConn, err := u.dial(ctx)
+	cancel()
+	if err != nil {
+		u.closeConn(conn, err)
+		return
+	}
+	if !conn.setConn(netConn) {
+		netConn.Close()
+		return
+	}
+	for {
+		response, err := readStreamMessage(netConn)
+		if err != nil {
+			u.closeConn(conn, fmt.Errorf("failed to read response: %w", err))
+			return
+		}
+		if len(response) < 2 {
+			u.closeConn(conn, errors.New("DNS response is too short"))
+			return
+		}
+		conn.deliver(response)
+	}
+}
+
+func (u *streamUpstream) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := u.sd.DialStream(ctx, u.addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.tlsConfig == nil {
+		return conn, nil
+	}
+	tlsConn := tls.Client(conn, u.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		tlsConn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// streamConn is a DNS over TCP or TLS connection with pipelined queries. Since concurrent queries may have the
+// same ID, each query is sent with an ID that is unique on the connection, and the response gets the original ID.
+type streamConn struct {
+	// ready is closed when the connection is established.
+	ready chan struct{}
+	// done is closed when the connection fails or is closed.
+	done chan struct{}
+
+	// active is the number of queries using the connection. It's guarded by the streamUpstream mutex, like
+	// idleTimer.
+	active    int
+	idleTimer *time.Timer
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	conn    net.Conn
+	err     error
+	pending map[uint16]chan []byte
+	nextID  uint16
+}
+
+// setConn sets the established connection. It returns false if the connection was already closed.
+func (c *streamConn) setConn(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return false
+	}
+	c.conn = conn
+	close(c.ready)
+	return true
+}
+
+// close fails the pending queries with err, and closes the connection.
+func (c *streamConn) close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// exchange sends the query on the connection, and waits for the response with the same ID.
+func (c *streamConn) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	select {
+	case <-c.ready:
+	case <-c.done:
+		return nil, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	id, responses, err := c.addPending()
+	if err != nil {
+		return nil, err
+	}
+	defer c.removePending(id)
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	binary.BigEndian.PutUint16(msg[2:], id)
+	if err := c.write(ctx, msg); err != nil {
+		// A partial write breaks the framing of the connection.
+		err = fmt.Errorf("failed to write query: %w", err)
+		c.close(err)
+		return nil, err
+	}
+	select {
+	case response := <-responses:
+		binary.BigEndian.PutUint16(response, binary.BigEndian.Uint16(query))
+		return response, nil
+	case <-c.done:
+		return nil, c.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// The resolver is not answering on this connection, so it's replaced, like after a write error.
+			// Canceled queries, like the ones that lost a race, leave it open.
+			c.close(fmt.Errorf("query timed out: %w", ctx.Err()))
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (c *streamConn) write(ctx context.Context, msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// addPending allocates an ID for a query, and returns the channel for its response.
+func (c *streamConn) addPending() (uint16, chan []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	if len(c.pending) > math.MaxUint16 {
+		return 0, nil, errors.New("too many pending queries")
+	}
+	for {
+		id := c.nextID
+		c.nextID++
+		if _, ok := c.pending[id]; !ok {
+			responses := make(chan []byte, 1)
+			c.pending[id] = responses
+			return id, responses, nil
+		}
+	}
+}
+
+func (c *streamConn) removePending(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// deliver sends the response to the pending query with the same ID. Responses for queries that are not
+// pending anymore, like the ones that timed out, are dropped.
+func (c *streamConn) deliver(response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	responses, ok := c.pending[binary.BigEndian.Uint16(response)]
+	if !ok {
+		return
+	}
+	select {
+	case responses <- response:
+	default:
+		// Duplicate response.
+	}
+}
+
+// readStreamMessage reads a DNS message with the 2-byte length prefix of DNS over TCP.
+func readStreamMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
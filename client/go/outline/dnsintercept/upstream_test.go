@@ -0,0 +1,359 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsintercept
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQuery(t *testing.T, id uint16, name string) []byte {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	query, err := msg.Pack()
+	require.NoError(t, err)
+	return query
+}
+
+// newTestResponse answers the query with an A record for each of the given IPs.
+func newTestResponse(t *testing.T, query []byte, ips ...[4]byte) []byte {
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(query))
+	msg.Header.Response = true
+	for _, ip := range ips {
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: ip},
+		})
+	}
+	response, err := msg.Pack()
+	require.NoError(t, err)
+	return response
+}
+
+func TestNewDoHUpstream(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/dns-query", r.URL.Path)
+		require.Equal(t, dnsMessageMediaType, r.Header.Get("Content-Type"))
+		query, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		// The ID is zero for cache friendliness.
+		require.Equal(t, uint16(0), binary.BigEndian.Uint16(query))
+		w.Header().Set("Content-Type", dnsMessageMediaType)
+		w.Write(newTestResponse(t, query, [4]byte{192, 0, 2, 1}))
+	}))
+	defer server.Close()
+
+	_, err := NewDoHUpstream(nil, server.URL+"/dns-query")
+	require.Error(t, err)
+	_, err = NewDoHUpstream(&transport.TCPDialer{}, "http://dns.example/dns-query")
+	require.Error(t, err)
+
+	sd := &countingStreamDialer{}
+	upstream, err := NewDoHUpstream(sd, server.URL+"/dns-query")
+	require.NoError(t, err)
+	upstream.(*dohUpstream).client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for id := uint16(1); id <= 2; id++ {
+		response, err := upstream.Exchange(context.Background(), newTestQuery(t, id, "example.com."))
+		require.NoError(t, err)
+		var msg dnsmessage.Message
+		require.NoError(t, msg.Unpack(response))
+		require.Equal(t, id, msg.Header.ID)
+		require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}, msg.Answers[0].Body)
+	}
+	// The connection is reused.
+	require.Equal(t, 1, sd.dials)
+}
+
+func TestNewDoHUpstream_BadStatus(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	upstream, err := NewDoHUpstream(&transport.TCPDialer{}, server.URL+"/dns-query")
+	require.NoError(t, err)
+	upstream.(*dohUpstream).client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	_, err = upstream.Exchange(context.Background(), newTestQuery(t, 1, "example.com."))
+	require.ErrorContains(t, err, "400")
+}
+
+type countingStreamDialer struct {
+	transport.TCPDialer
+	dials int
+}
+
+func (d *countingStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	d.dials++
+	return d.TCPDialer.DialStream(ctx, addr)
+}
+
+// startDoTServer starts a DoT server that answers the queries on each connection, until closeConns is called.
+func startDoTServer(t *testing.T) (addr string, rootCAs *x509.CertPool, closeConns func()) {
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(certServer.Close)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", certServer.TLS)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	rootCAs = x509.NewCertPool()
+	rootCAs.AddCert(certServer.Certificate())
+
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go func() {
+				defer conn.Close()
+				for {
+					query, err := readStreamMessage(conn)
+					if err != nil {
+						return
+					}
+					response := newTestResponse(t, query, [4]byte{192, 0, 2, 2})
+					msg := binary.BigEndian.AppendUint16(nil, uint16(len(response)))
+					if _, err := conn.Write(append(msg, response...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	closeConns = func() {
+		for {
+			select {
+			case conn := <-conns:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	}
+	return listener.Addr().String(), rootCAs, closeConns
+}
+
+func TestNewDoTUpstream(t *testing.T) {
+	addr, rootCAs, closeConns := startDoTServer(t)
+
+	_, err := NewDoTUpstream(nil, addr, "example.com")
+	require.Error(t, err)
+	_, err = NewDoTUpstream(&transport.TCPDialer{}, "example.com", "example.com")
+	require.Error(t, err)
+
+	sd := &countingStreamDialer{}
+	upstream, err := NewDoTUpstream(sd, addr, "example.com")
+	require.NoError(t, err)
+	upstream.(*streamUpstream).tlsConfig.RootCAs = rootCAs
+
+	exchange := func(id uint16) {
+		response, err := upstream.Exchange(context.Background(), newTestQuery(t, id, "example.com."))
+		require.NoError(t, err)
+		var msg dnsmessage.Message
+		require.NoError(t, msg.Unpack(response))
+		require.Equal(t, id, msg.Header.ID)
+		require.Equal(t, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}, msg.Answers[0].Body)
+	}
+	exchange(1)
+	exchange(2)
+	require.Equal(t, 1, sd.dials)
+
+	// A connection closed by the server is replaced.
+	closeConns()
+	exchange(3)
+	require.Equal(t, 2, sd.dials)
+}
+
+func TestNewDoTUpstream_BadCertificate(t *testing.T) {
+	addr, rootCAs, _ := startDoTServer(t)
+	upstream, err := NewDoTUpstream(&transport.TCPDialer{}, addr, "wrong.example")
+	require.NoError(t, err)
+	upstream.(*streamUpstream).tlsConfig.RootCAs = rootCAs
+
+	_, err = upstream.Exchange(context.Background(), newTestQuery(t, 1, "example.com."))
+	require.ErrorContains(t, err, "TLS handshake failed")
+}
+
+func TestNewTCPUpstream_Pipelining(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Wait for both queries, and answer them in reverse order.
+		var queries [][]byte
+		for range 2 {
+			query, err := readStreamMessage(conn)
+			if err != nil {
+				return
+			}
+			queries = append(queries, query)
+		}
+		for i := len(queries) - 1; i >= 0; i-- {
+			var msg dnsmessage.Message
+			require.NoError(t, msg.Unpack(queries[i]))
+			ip := [4]byte{192, 0, 2, 1}
+			if msg.Questions[0].Name.String() == "b.example." {
+				ip[3] = 2
+			}
+			response := newTestResponse(t, queries[i], ip)
+			if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	sd := &countingStreamDialer{}
+	upstream, err := NewTCPUpstream(sd, listener.Addr().String())
+	require.NoError(t, err)
+
+	// Both queries have the same ID, so the upstream must tell the responses apart.
+	var wg sync.WaitGroup
+	for _, tc := range []struct {
+		name string
+		ip   [4]byte
+	}{{"a.example.", [4]byte{192, 0, 2, 1}}, {"b.example.", [4]byte{192, 0, 2, 2}}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := upstream.Exchange(context.Background(), newTestQuery(t, 7, tc.name))
+			require.NoError(t, err)
+			var msg dnsmessage.Message
+			require.NoError(t, msg.Unpack(response))
+			require.Equal(t, uint16(7), msg.Header.ID)
+			require.Equal(t, tc.name, msg.Questions[0].Name.String())
+			require.Equal(t, &dnsmessage.AResource{A: tc.ip}, msg.Answers[0].Body)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, sd.dials)
+}
+
+type blockingStreamDialer struct {
+	unblock chan struct{}
+}
+
+func (d *blockingStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	<-d.unblock
+	return nil, errors.New("dial failed")
+}
+
+func TestStreamUpstream_ConnPool(t *testing.T) {
+	sd := &blockingStreamDialer{make(chan struct{})}
+	t.Cleanup(func() { close(sd.unblock) })
+	upstream, err := NewTCPUpstream(sd, "192.0.2.1:53")
+	require.NoError(t, err)
+	u := upstream.(*streamUpstream)
+
+	first, connected := u.acquireConn()
+	require.False(t, connected)
+	for range maxPipelinedQueries - 1 {
+		conn, _ := u.acquireConn()
+		require.Same(t, first, conn)
+	}
+	// The first connection is busy, so a new one is opened.
+	second, _ := u.acquireConn()
+	require.NotSame(t, first, second)
+	for range maxPipelinedQueries * 2 {
+		u.acquireConn()
+	}
+	require.Len(t, u.conns, maxStreamConns)
+
+	// Released queries make the first connection the least busy.
+	for range 3 {
+		u.releaseConn(first)
+	}
+	conn, _ := u.acquireConn()
+	require.Same(t, first, conn)
+}
+
+func TestStreamUpstream_Timeout(t *testing.T) {
+	// The server reads the queries, but never answers.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	upstream, err := NewTCPUpstream(&transport.TCPDialer{}, listener.Addr().String())
+	require.NoError(t, err)
+	u := upstream.(*streamUpstream)
+	numConns := func() int {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return len(u.conns)
+	}
+
+	// A canceled query leaves the connection open.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = upstream.Exchange(ctx, newTestQuery(t, 1, "example.com."))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, numConns())
+
+	// A timed out query closes it.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = upstream.Exchange(ctx, newTestQuery(t, 2, "example.com."))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Eventually(t, func() bool { return numConns() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestStreamUpstream_DialError(t *testing.T) {
+	sd := &blockingStreamDialer{make(chan struct{})}
+	close(sd.unblock)
+	upstream, err := NewTCPUpstream(sd, "192.0.2.1:53")
+	require.NoError(t, err)
+
+	_, err = upstream.Exchange(context.Background(), newTestQuery(t, 1, "example.com."))
+	require.ErrorContains(t, err, "dial failed")
+	require.Empty(t, upstream.(*streamUpstream).conns)
+}
@@ -0,0 +1,74 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package domaintable
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func namedStreamDialer(name string) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		return nil, fmt.Errorf("dialer '%s' called for address '%s'", name, addr)
+	})
+}
+
+func namedPacketDialer(name string) transport.PacketDialer {
+	return transport.FuncPacketDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return nil, fmt.Errorf("dialer '%s' called for address '%s'", name, addr)
+	})
+}
+
+func TestStreamDialer_DialStream(t *testing.T) {
+	table := NewDomainTable[transport.StreamDialer]()
+	require.NoError(t, table.AddDomain("*.example.com", namedStreamDialer("wildcard")))
+
+	withFallback, err := NewStreamDialer(table, namedStreamDialer("fallback"))
+	require.NoError(t, err)
+	noFallback, err := NewStreamDialer(table, nil)
+	require.NoError(t, err)
+
+	_, err = withFallback.DialStream(context.Background(), "www.example.com:443")
+	require.EqualError(t, err, "dialer 'wildcard' called for address 'www.example.com:443'")
+
+	_, err = withFallback.DialStream(context.Background(), "other.org:443")
+	require.EqualError(t, err, "dialer 'fallback' called for address 'other.org:443'")
+
+	// IP literals always go to the fallback.
+	_, err = withFallback.DialStream(context.Background(), "192.0.2.1:443")
+	require.EqualError(t, err, "dialer 'fallback' called for address '192.0.2.1:443'")
+
+	_, err = noFallback.DialStream(context.Background(), "other.org:443")
+	require.EqualError(t, err, "no dialer available for address other.org:443")
+}
+
+func TestPacketDialer_DialPacket(t *testing.T) {
+	table := NewDomainTable[transport.PacketDialer]()
+	require.NoError(t, table.AddDomain("dns.example.com", namedPacketDialer("exact")))
+
+	d, err := NewPacketDialer(table, nil)
+	require.NoError(t, err)
+
+	_, err = d.DialPacket(context.Background(), "dns.example.com:53")
+	require.EqualError(t, err, "dialer 'exact' called for address 'dns.example.com:53'")
+
+	_, err = d.DialPacket(context.Background(), "example.com:53")
+	require.EqualError(t, err, "no dialer available for address example.com:53")
+}
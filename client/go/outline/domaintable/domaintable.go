@@ -0,0 +1,178 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package domaintable
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DomainTable maps domain names to values.
+//
+// Lookups prefer, in order:
+//   - an exact domain match (e.g. "example.com"),
+//   - the longest matching wildcard suffix (e.g. "*.example.com" matches "www.example.com", but not "example.com"),
+//   - the first added keyword that is contained in the domain (e.g. "google" matches "www.google.co.uk").
+type DomainTable[V any] interface {
+	// AddDomain adds an exact domain, or a wildcard suffix if the domain starts with "*.".
+	AddDomain(domain string, value V) error
+	// AddKeyword adds a keyword rule that matches any domain containing it.
+	AddKeyword(keyword string, value V) error
+	// Lookup returns the value for the given domain, or the zero value if there is no match.
+	Lookup(domain string) V
+}
+
+// Compile-time check
+var _ DomainTable[any] = (*domainTable[any])(nil)
+
+// trieNode is a node in a trie of domain labels, indexed from the top-level domain down.
+// Children are stored in a sorted slice rather than a map to keep large tables compact.
+type trieNode[V any] struct {
+	label    string
+	children []*trieNode[V]
+
+	exact       V
+	hasExact    bool
+	wildcard    V
+	hasWildcard bool
+}
+
+type keywordRule[V any] struct {
+	keyword string
+	value   V
+}
+
+// "V" is typically expected to be a dialer of some kind
+type domainTable[V any] struct {
+	root     trieNode[V]
+	keywords []keywordRule[V]
+}
+
+func NewDomainTable[V any]() DomainTable[V] {
+	return &domainTable[V]{}
+}
+
+// normalizeDomain lower-cases the domain and removes the trailing dot of fully-qualified names.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+func (node *trieNode[V]) child(label string) (*trieNode[V], int) {
+	lo, hi := 0, len(node.children)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if node.children[mid].label < label {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < len(node.children) && node.children[lo].label == label {
+		return node.children[lo], lo
+	}
+	return nil, lo
+}
+
+func (node *trieNode[V]) getOrAddChild(label string) *trieNode[V] {
+	found, i := node.child(label)
+	if found != nil {
+		return found
+	}
+	newChild := &trieNode[V]{label: label}
+	node.children = append(node.children, nil)
+	copy(node.children[i+1:], node.children[i:])
+	node.children[i] = newChild
+	return newChild
+}
+
+func (table *domainTable[V]) AddDomain(domain string, value V) error {
+	domain = normalizeDomain(domain)
+	isWildcard := false
+	if suffix, found := strings.CutPrefix(domain, "*."); found {
+		isWildcard = true
+		domain = suffix
+	}
+	if domain == "" {
+		return errors.New("domain must not be empty")
+	}
+	if strings.Contains(domain, "*") {
+		return fmt.Errorf("domain '%s' may only have a wildcard as the first label", domain)
+	}
+
+	labels := strings.Split(domain, ".")
+	node := &table.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] == "" {
+			return fmt.Errorf("domain '%s' has an empty label", domain)
+		}
+		node = node.getOrAddChild(labels[i])
+	}
+	if isWildcard {
+		node.wildcard, node.hasWildcard = value, true
+	} else {
+		node.exact, node.hasExact = value, true
+	}
+	return nil
+}
+
+func (table *domainTable[V]) AddKeyword(keyword string, value V) error {
+	keyword = strings.ToLower(keyword)
+	if keyword == "" {
+		return errors.New("keyword must not be empty")
+	}
+	table.keywords = append(table.keywords, keywordRule[V]{keyword, value})
+	return nil
+}
+
+func (table *domainTable[V]) Lookup(domain string) V {
+	domain = normalizeDomain(domain)
+
+	var wildcardMatch *trieNode[V]
+	node := &table.root
+	rest := domain
+	for node != nil && rest != "" {
+		var label string
+		if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+			label, rest = rest[i+1:], rest[:i]
+		} else {
+			label, rest = rest, ""
+		}
+		node, _ = node.child(label)
+		if node == nil {
+			break
+		}
+		if rest == "" {
+			if node.hasExact {
+				return node.exact
+			}
+		} else if node.hasWildcard {
+			// Keep going to find the longest wildcard suffix.
+			wildcardMatch = node
+		}
+	}
+	if wildcardMatch != nil {
+		return wildcardMatch.wildcard
+	}
+
+	for _, rule := range table.keywords {
+		if strings.Contains(domain, rule.keyword) {
+			return rule.value
+		}
+	}
+
+	var zeroV V
+	return zeroV
+}
@@ -0,0 +1,73 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package domaintable
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomainTable_Lookup(t *testing.T) {
+	table := NewDomainTable[string]()
+	require.NoError(t, table.AddDomain("example.com", "exact"))
+	require.NoError(t, table.AddDomain("*.example.com", "wildcard"))
+	require.NoError(t, table.AddDomain("*.api.example.com", "api-wildcard"))
+	require.NoError(t, table.AddDomain("Mixed.Case.ORG.", "mixed"))
+	require.NoError(t, table.AddKeyword("google", "keyword-google"))
+	require.NoError(t, table.AddKeyword("goo", "keyword-goo"))
+
+	testCases := []struct {
+		domain   string
+		expected string
+	}{
+		{"example.com", "exact"},
+		{"EXAMPLE.COM.", "exact"},
+		{"www.example.com", "wildcard"},
+		{"a.b.example.com", "wildcard"},
+		{"api.example.com", "wildcard"},
+		{"v1.api.example.com", "api-wildcard"},
+		{"mixed.case.org", "mixed"},
+		{"www.mixed.case.org", ""},
+		{"notexample.com", ""},
+		{"com", ""},
+		{"www.google.co.uk", "keyword-google"},
+		{"goo.gl", "keyword-goo"},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.domain, func(t *testing.T) {
+			require.Equal(t, tc.expected, table.Lookup(tc.domain))
+		})
+	}
+}
+
+func TestDomainTable_ExactOverridesKeyword(t *testing.T) {
+	table := NewDomainTable[string]()
+	require.NoError(t, table.AddKeyword("tube", "keyword"))
+	require.NoError(t, table.AddDomain("*.youtube.com", "wildcard"))
+
+	require.Equal(t, "wildcard", table.Lookup("www.youtube.com"))
+	require.Equal(t, "keyword", table.Lookup("youtube.com"))
+}
+
+func TestDomainTable_InvalidRules(t *testing.T) {
+	table := NewDomainTable[string]()
+	require.Error(t, table.AddDomain("", "v"))
+	require.Error(t, table.AddDomain("*.", "v"))
+	require.Error(t, table.AddDomain("www.*.example.com", "v"))
+	require.Error(t, table.AddDomain("a..b", "v"))
+	require.Error(t, table.AddKeyword("", "v"))
+}
@@ -66,8 +66,9 @@ var args struct {
 
 	adapterIndex *int
 
-	keyID        *string
-	clientConfig *string
+	keyID         *string
+	clientConfig  *string
+	clientVersion *string
 
 	logLevel          *string
 	checkConnectivity *bool
@@ -103,6 +104,7 @@ func main() {
 	// Proxy client config
 	args.keyID = flag.String("keyID", "", "The ID of the key being used")
 	args.clientConfig = flag.String("client", "", "A JSON object containing the client config, UTF8-encoded")
+	args.clientVersion = flag.String("clientVersion", "", "The version of the app, for configs that depend on it")
 
 	// Check connectivity of clientConfig and exit
 	args.checkConnectivity = flag.Bool("checkConnectivity", false, "Check the proxy TCP and UDP connectivity and exit.")
@@ -124,7 +126,7 @@ func main() {
 		printErrorAndExit(platerrors.PlatformError{Code: platerrors.InvalidConfig, Message: "client config missing"}, exitCodeFailure)
 	}
 
-	clientConfig := outline.ClientConfig{}
+	clientConfig := outline.ClientConfig{ClientVersion: *args.clientVersion}
 	if *args.adapterIndex >= 0 {
 		tcp, udp, err := newBaseDialersWithAdapter(*args.adapterIndex)
 		if err != nil {
@@ -0,0 +1,100 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/config"
+	"localhost/client/go/outline/platerrors"
+)
+
+// EncodedTunnelConfig is the canonical encoding of a tunnel config.
+type EncodedTunnelConfig struct {
+	// YAML is the tunnel config in the advanced YAML format.
+	YAML string
+	// ShadowsocksURL is the SIP002 ss:// URL of the tunnel config if its transport is a simple
+	// Shadowsocks transport, or empty otherwise.
+	ShadowsocksURL string
+}
+
+// encodedTunnelConfigJSON is the output of [MethodEncodeTunnelConfig].
+type encodedTunnelConfigJSON struct {
+	YAML           string `json:"yaml"`
+	ShadowsocksURL string `json:"ssUrl,omitempty"`
+}
+
+// EncodeTunnelConfig normalizes a tunnel config in any of the formats accepted by [MethodParseTunnelConfig]
+// into the canonical advanced YAML. Legacy Shadowsocks transports are expanded into the equivalent tcpudp
+// transport, and map keys are sorted.
+func EncodeTunnelConfig(input string) (*EncodedTunnelConfig, error) {
+	input = strings.TrimSpace(input)
+	clientConfigMap, inputIsTransport, platErr := parseTunnelConfigInput(input)
+	if platErr != nil {
+		return nil, platErr
+	}
+	// Make sure the config is valid.
+	if _, _, platErr := newTunnelClient(input, clientConfigMap, inputIsTransport); platErr != nil {
+		return nil, platErr
+	}
+
+	encoded := &EncodedTunnelConfig{}
+	transportConfig := clientConfigMap["transport"]
+	if ssConfig, ok := config.SimpleShadowsocksConfig(transportConfig); ok {
+		ssURL, err := config.ShadowsocksURL(ssConfig)
+		if err == nil {
+			encoded.ShadowsocksURL = ssURL
+		}
+	}
+	transportConfig, err := config.NormalizeTransportConfig(transportConfig)
+	if err != nil {
+		return nil, &platerrors.PlatformError{
+			Code:    platerrors.InvalidConfig,
+			Message: fmt.Sprintf("failed to normalize transport config: %s", err),
+		}
+	}
+	clientConfigMap["transport"] = transportConfig
+	encoded.YAML, err = configyaml.FormatConfigYAML(clientConfigMap)
+	if err != nil {
+		return nil, &platerrors.PlatformError{
+			Code:    platerrors.InvalidConfig,
+			Message: fmt.Sprintf("failed to encode config: %s", err),
+		}
+	}
+	return encoded, nil
+}
+
+func doEncodeTunnelConfig(input string) *InvokeMethodResult {
+	encoded, err := EncodeTunnelConfig(input)
+	if err != nil {
+		return &InvokeMethodResult{Error: platerrors.ToPlatformError(err)}
+	}
+	responseBytes, err := json.Marshal(encodedTunnelConfigJSON{
+		YAML:           encoded.YAML,
+		ShadowsocksURL: encoded.ShadowsocksURL,
+	})
+	if err != nil {
+		return &InvokeMethodResult{
+			Error: &platerrors.PlatformError{
+				Code:    platerrors.InternalError,
+				Message: fmt.Sprintf("failed to serialize JSON response: %v", err),
+			},
+		}
+	}
+	return &InvokeMethodResult{Value: string(responseBytes)}
+}
@@ -0,0 +1,241 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outline
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"testing"
+
+	"localhost/client/go/configyaml"
+	"localhost/client/go/outline/config"
+	"localhost/client/go/outline/platerrors"
+	"github.com/stretchr/testify/require"
+)
+
+const encodedShadowsocksYAML = `transport:
+  $type: tcpudp
+  tcp:
+    $type: shadowsocks
+    cipher: chacha20-ietf-poly1305
+    endpoint: example.com:4321
+    prefix: "SSH-2.0\r\n"
+    secret: SECRET
+  udp:
+    $type: shadowsocks
+    cipher: chacha20-ietf-poly1305
+    endpoint: example.com:4321
+    secret: SECRET
+`
+
+const encodedShadowsocksURL = "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:4321/?prefix=SSH-2.0%0D%0A"
+
+func TestEncodeTunnelConfig_Shadowsocks(t *testing.T) {
+	for _, input := range []string{
+		encodedShadowsocksURL,
+		`{
+    "server": "example.com",
+    "server_port": 4321,
+    "method": "chacha20-ietf-poly1305",
+    "password": "SECRET",
+    "prefix": "SSH-2.0\r\n"
+}`,
+		`transport: {endpoint: example.com:4321, cipher: chacha20-ietf-poly1305, secret: SECRET, prefix: "SSH-2.0\r\n"}`,
+		encodedShadowsocksYAML,
+	} {
+		encoded, err := EncodeTunnelConfig(input)
+		require.NoError(t, err, input)
+		require.Equal(t, encodedShadowsocksYAML, encoded.YAML, input)
+		require.Equal(t, encodedShadowsocksURL, encoded.ShadowsocksURL, input)
+	}
+}
+
+func TestEncodeTunnelConfig_Advanced(t *testing.T) {
+	encoded, err := EncodeTunnelConfig(`
+transport:
+  $type: tcpudp
+  tcp: &shared
+    $type: shadowsocks
+    endpoint: example.com:80
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET
+    prefix: "POST "
+  udp: *shared`)
+	require.NoError(t, err)
+	// The UDP prefix doesn't have an ss:// equivalent.
+	require.Empty(t, encoded.ShadowsocksURL)
+	require.Equal(t, `transport:
+  $type: tcpudp
+  tcp:
+    $type: shadowsocks
+    cipher: chacha20-ietf-poly1305
+    endpoint: example.com:80
+    prefix: "POST "
+    secret: SECRET
+  udp:
+    $type: shadowsocks
+    cipher: chacha20-ietf-poly1305
+    endpoint: example.com:80
+    prefix: "POST "
+    secret: SECRET
+`, encoded.YAML)
+
+	encoded, err = EncodeTunnelConfig(`
+transport:
+  $type: tcpudp
+  tcp:
+    $type: split
+    sizes: [1, 2]
+    dialer: ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:4321/
+  udp:
+    $type: shadowsocks
+    endpoint: {$type: dial, address: example.com:4321}
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET`)
+	require.NoError(t, err)
+	require.Empty(t, encoded.ShadowsocksURL)
+	require.Equal(t, `transport:
+  $type: tcpudp
+  tcp:
+    $type: split
+    dialer: ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:4321/
+    sizes:
+      - 1
+      - 2
+  udp:
+    $type: shadowsocks
+    cipher: chacha20-ietf-poly1305
+    endpoint:
+      $type: dial
+      address: example.com:4321
+    secret: SECRET
+`, encoded.YAML)
+}
+
+func TestEncodeTunnelConfig_Errors(t *testing.T) {
+	_, err := EncodeTunnelConfig(`transport: {$type: unknown}`)
+	require.Equal(t, platerrors.InvalidConfig, platerrors.ToPlatformError(err).Code)
+
+	_, err = EncodeTunnelConfig(`error: {message: "Unavailable"}`)
+	require.Equal(t, platerrors.ProviderError, platerrors.ToPlatformError(err).Code)
+}
+
+func TestInvokeMethod_EncodeTunnelConfig(t *testing.T) {
+	result := InvokeMethod(MethodEncodeTunnelConfig, encodedShadowsocksURL)
+	require.Nil(t, result.Error)
+	var response map[string]string
+	require.NoError(t, json.Unmarshal([]byte(result.Value), &response))
+	require.Equal(t, map[string]string{"yaml": encodedShadowsocksYAML, "ssUrl": encodedShadowsocksURL}, response)
+}
+
+// randomShadowsocksConfig returns a random valid Shadowsocks config with an address endpoint.
+func randomShadowsocksConfig(rng *rand.Rand) *config.ShadowsocksConfig {
+	randomString := func(alphabet []rune, maxLength int) string {
+		runes := make([]rune, rng.IntN(maxLength+1))
+		for i := range runes {
+			runes[i] = alphabet[rng.IntN(len(alphabet))]
+		}
+		return string(runes)
+	}
+	hosts := []string{"example.com", "1.2.3.4", "::1", "xn--bcher-kva.example"}
+	ssConfig := &config.ShadowsocksConfig{
+		Endpoint: net.JoinHostPort(hosts[rng.IntN(len(hosts))], strconv.Itoa(1+rng.IntN(65535))),
+	}
+	switch rng.IntN(3) {
+	case 0:
+		ssConfig.Cipher = "2022-blake3-aes-128-gcm"
+		key := make([]byte, 16)
+		for i := range key {
+			key[i] = byte(rng.Uint32())
+		}
+		ssConfig.Secret = base64.StdEncoding.EncodeToString(key)
+	case 1:
+		ssConfig.Cipher = "aes-256-gcm"
+		ssConfig.Secret = "x" + randomString([]rune("aZ09 :@/?#[]!$&'()*+,;=%\"\\ü"), 20)
+	default:
+		ssConfig.Cipher = "chacha20-ietf-poly1305"
+		ssConfig.Secret = "x" + randomString([]rune("aZ09-_~."), 20)
+	}
+	prefixAlphabet := make([]rune, 256)
+	for i := range prefixAlphabet {
+		prefixAlphabet[i] = rune(i)
+	}
+	ssConfig.Prefix = randomString(prefixAlphabet, 8)
+	if rng.IntN(4) == 0 {
+		ssConfig.Plugin = "obfs-local"
+		ssConfig.Plugin_Opts = randomString([]rune("obfs=http;host-&+ "), 10)
+	}
+	return ssConfig
+}
+
+func TestEncodeTunnelConfig_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 200; i++ {
+		ssConfig := randomShadowsocksConfig(rng)
+		if ssConfig.Plugin != "" {
+			// Plugins need to be installed to create the client, so we only test the encoding functions.
+			ssURL, err := config.ShadowsocksURL(ssConfig)
+			require.NoError(t, err)
+			transportConfig, err := config.NormalizeTransportConfig(ssURL)
+			require.NoError(t, err)
+			decoded, ok := config.SimpleShadowsocksConfig(transportConfig)
+			require.True(t, ok, ssURL)
+			require.Equal(t, ssConfig, decoded, ssURL)
+			continue
+		}
+
+		ssURL, err := config.ShadowsocksURL(ssConfig)
+		require.NoError(t, err)
+		encoded, err := EncodeTunnelConfig(ssURL)
+		require.NoError(t, err, ssURL)
+		require.Equal(t, ssURL, encoded.ShadowsocksURL)
+
+		// The YAML is equivalent to the URL, and encodes to itself.
+		node, err := configyaml.ParseConfigYAML(encoded.YAML)
+		require.NoError(t, err, encoded.YAML)
+		decoded, ok := config.SimpleShadowsocksConfig(node.(map[string]any)["transport"])
+		require.True(t, ok, encoded.YAML)
+		require.Equal(t, ssConfig, decoded, encoded.YAML)
+		reencoded, err := EncodeTunnelConfig(encoded.YAML)
+		require.NoError(t, err, encoded.YAML)
+		require.Equal(t, encoded, reencoded)
+
+		// The legacy JSON encodes to the same config.
+		host, port, err := net.SplitHostPort(ssConfig.Endpoint.(string))
+		require.NoError(t, err)
+		portNumber, err := strconv.Atoi(port)
+		require.NoError(t, err)
+		legacyJSON, err := json.Marshal(map[string]any{
+			"server":      host,
+			"server_port": portNumber,
+			"method":      ssConfig.Cipher,
+			"password":    ssConfig.Secret,
+			"prefix":      ssConfig.Prefix,
+		})
+		require.NoError(t, err)
+		reencoded, err = EncodeTunnelConfig(string(legacyJSON))
+		require.NoError(t, err, string(legacyJSON))
+		require.Equal(t, encoded, reencoded)
+
+		// The encoded configs parse to the same tunnel.
+		urlResult := parseFirstHopAndTunnelConfigJSON(t, doParseTunnelConfig(ssURL).Value)
+		yamlResult := doParseTunnelConfig(encoded.YAML)
+		require.Nil(t, yamlResult.Error)
+		require.Equal(t, urlResult.FirstHop, parseFirstHopAndTunnelConfigJSON(t, yamlResult.Value).FirstHop)
+	}
+}
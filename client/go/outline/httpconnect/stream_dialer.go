@@ -0,0 +1,153 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpconnect implements a [transport.StreamDialer] that tunnels connections
+// through an HTTP proxy using the HTTP/1.1 CONNECT method.
+package httpconnect
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"localhost/client/go/outline/platerrors"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// StreamDialer is a [transport.StreamDialer] that establishes a tunnel to the
+// destination by sending an HTTP CONNECT request to a proxy server.
+type StreamDialer struct {
+	proxy   transport.StreamEndpoint
+	headers http.Header
+}
+
+// NewStreamDialer creates a new [StreamDialer] that reaches the proxy server via the given
+// [transport.StreamEndpoint]. The headers, if any, are added to every CONNECT request.
+func NewStreamDialer(proxy transport.StreamEndpoint, headers http.Header) (*StreamDialer, error) {
+	if proxy == nil {
+		return nil, errors.New("proxy endpoint must not be nil")
+	}
+	return &StreamDialer{
+		proxy:   proxy,
+		headers: headers.Clone(),
+	}, nil
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// DialStream connects to the proxy server and asks it to open a tunnel to the given address.
+// Proxy status codes are mapped to [platerrors.PlatformError]s: 407 results in
+// [platerrors.Unauthenticated] and 5xx results in [platerrors.ProxyServerUnreachable].
+func (d *StreamDialer) DialStream(ctx context.Context, address string) (transport.StreamConn, error) {
+	conn, err := d.proxy.ConnectStream(ctx)
+	if err != nil {
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.ProxyServerUnreachable,
+			Message: "failed to connect to the HTTP proxy",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+
+	reader, err := d.connect(ctx, conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reader.Buffered() > 0 {
+		// The proxy may have sent data from the destination right after the response.
+		return transport.WrapConn(conn, reader, conn), nil
+	}
+	return conn, nil
+}
+
+// connect performs the CONNECT handshake on conn. It returns the reader used to parse the
+// response, which may hold data past the response headers.
+func (d *StreamDialer) connect(ctx context.Context, conn transport.StreamConn, address string) (*bufio.Reader, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	// Unblock the handshake if the context is canceled.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	req := &http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Opaque: address},
+		Host:       address,
+		Header:     d.headers.Clone(),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if err := req.Write(conn); err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.ProxyServerWriteFailed,
+			Message: "failed to write CONNECT request to the HTTP proxy",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, platerrors.PlatformError{
+			Code:    platerrors.ProxyServerReadFailed,
+			Message: "failed to read CONNECT response from the HTTP proxy",
+			Cause:   platerrors.ToPlatformError(err),
+		}
+	}
+	// The body of a successful CONNECT response is the tunnel itself, so we only close it on failures.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	return reader, nil
+}
+
+// statusError converts an unsuccessful CONNECT response into an error.
+func statusError(resp *http.Response) error {
+	details := platerrors.ErrorDetails{"status": resp.StatusCode}
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return platerrors.PlatformError{
+			Code:    platerrors.Unauthenticated,
+			Message: "HTTP proxy requires authentication",
+			Details: details,
+		}
+	case resp.StatusCode >= 500:
+		return platerrors.PlatformError{
+			Code:    platerrors.ProxyServerUnreachable,
+			Message: fmt.Sprintf("HTTP proxy failed to connect to the destination: %v", resp.Status),
+			Details: details,
+		}
+	default:
+		return fmt.Errorf("HTTP proxy rejected the CONNECT request: %v", resp.Status)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpconnect
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"localhost/client/go/outline/platerrors"
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// startProxy runs a minimal HTTP CONNECT proxy that calls handle for every request.
+// If handle returns a status other than 200, the response is sent and the connection closed.
+// Otherwise the proxy sends the greeting right after the response and echoes the tunnel data.
+func startProxy(t *testing.T, greeting string, handle func(req *http.Request) int) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+				status := handle(req)
+				resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+				if status != http.StatusOK {
+					resp.Write(conn)
+					return
+				}
+				// Send the response and the greeting in a single write to make sure the
+				// client has to deal with buffered data.
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n" + greeting))
+				io.Copy(conn, reader)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestDialStream(t *testing.T) {
+	var gotReq *http.Request
+	proxy := startProxy(t, "hi ", func(req *http.Request) int {
+		gotReq = req
+		return http.StatusOK
+	})
+	dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: proxy.Addr().String()}, http.Header{
+		"Proxy-Authorization": {"Bearer TOKEN"},
+		"X-Custom":            {"value"},
+	})
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	require.Equal(t, http.MethodConnect, gotReq.Method)
+	require.Equal(t, "example.com:443", gotReq.Host)
+	require.Equal(t, "example.com:443", gotReq.RequestURI)
+	require.Equal(t, "Bearer TOKEN", gotReq.Header.Get("Proxy-Authorization"))
+	require.Equal(t, "value", gotReq.Header.Get("X-Custom"))
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, len("hi hello"))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hi hello", string(buf))
+}
+
+func TestDialStream_StatusCodes(t *testing.T) {
+	tests := []struct {
+		status   int
+		wantCode platerrors.ErrorCode
+	}{
+		{http.StatusProxyAuthRequired, platerrors.Unauthenticated},
+		{http.StatusBadGateway, platerrors.ProxyServerUnreachable},
+		{http.StatusServiceUnavailable, platerrors.ProxyServerUnreachable},
+		{http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			proxy := startProxy(t, "", func(req *http.Request) int { return tt.status })
+			dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: proxy.Addr().String()}, nil)
+			require.NoError(t, err)
+
+			_, err = dialer.DialStream(context.Background(), "example.com:443")
+			require.Error(t, err)
+			var perr platerrors.PlatformError
+			if tt.wantCode == "" {
+				require.False(t, errors.As(err, &perr))
+				return
+			}
+			require.ErrorAs(t, err, &perr)
+			require.Equal(t, tt.wantCode, perr.Code)
+			require.Equal(t, tt.status, perr.Details["status"])
+		})
+	}
+}
+
+func TestDialStream_ProxyUnreachable(t *testing.T) {
+	dialer, err := NewStreamDialer(transport.FuncStreamEndpoint(func(ctx context.Context) (transport.StreamConn, error) {
+		return nil, errors.New("connection refused")
+	}), nil)
+	require.NoError(t, err)
+
+	_, err = dialer.DialStream(context.Background(), "example.com:443")
+	var perr platerrors.PlatformError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, platerrors.ProxyServerUnreachable, perr.Code)
+}
+
+func TestDialStream_Cancel(t *testing.T) {
+	// A server that accepts connections but never responds.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: listener.Addr().String()}, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = dialer.DialStream(ctx, "example.com:443")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNewStreamDialer_NilEndpoint(t *testing.T) {
+	_, err := NewStreamDialer(nil, nil)
+	require.Error(t, err)
+}
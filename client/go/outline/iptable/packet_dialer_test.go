@@ -0,0 +1,110 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptable
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIPTablePacketDialer(t *testing.T) {
+	t.Run("Nil Table", func(t *testing.T) {
+		d, err := NewPacketDialer(nil, nil)
+		require.NoError(t, err)
+		require.NotNil(t, d)
+		assert.NotNil(t, d.table)
+	})
+
+	t.Run("Valid Fallback", func(t *testing.T) {
+		defaultDialer := NewMockPacketDialer("default")
+		d, err := NewPacketDialer(nil, defaultDialer)
+		require.NoError(t, err)
+		require.NotNil(t, d)
+		assert.Equal(t, defaultDialer, d.fallback)
+	})
+}
+
+func TestIPTablePacketDialer_DialPacket(t *testing.T) {
+	defaultDialer := NewMockPacketDialer("default")
+	routeV4Dialer := NewMockPacketDialer("routeV4")
+	routeV6Dialer := NewMockPacketDialer("routeV6")
+
+	table := NewIPTable[transport.PacketDialer]()
+	table.AddPrefix(netip.MustParsePrefix("192.0.2.0/24"), routeV4Dialer)
+	table.AddPrefix(netip.MustParsePrefix("2001:db8:cafe::/48"), routeV6Dialer)
+
+	withFallback, err := NewPacketDialer(table, defaultDialer)
+	require.NoError(t, err)
+	noFallback, err := NewPacketDialer(table, nil)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		dialer       *PacketDialer
+		address      string
+		expectDialer *MockPacketDialer
+		expectErrMsg string
+	}{
+		{name: "WithFallback_IPv4 in table", dialer: withFallback, address: "192.0.2.100:53", expectDialer: routeV4Dialer},
+		{name: "WithFallback_IPv4 not in table", dialer: withFallback, address: "10.0.0.1:53", expectDialer: defaultDialer},
+		{name: "WithFallback_IPv6 in table", dialer: withFallback, address: "[2001:db8:cafe::1]:443", expectDialer: routeV6Dialer},
+		{name: "WithFallback_Hostname", dialer: withFallback, address: "example.com:443", expectDialer: defaultDialer},
+		{name: "NoFallback_IPv4 in table", dialer: noFallback, address: "192.0.2.100:53", expectDialer: routeV4Dialer},
+		{name: "NoFallback_IPv4 not in table", dialer: noFallback, address: "10.0.0.1:53", expectErrMsg: "no dialer available for address 10.0.0.1:53"},
+		{name: "NoFallback_Hostname", dialer: noFallback, address: "example.com:443", expectErrMsg: "no dialer available for address example.com:443"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			allMocks := []*MockPacketDialer{defaultDialer, routeV4Dialer, routeV6Dialer}
+			for _, mock := range allMocks {
+				mock.Reset()
+			}
+
+			conn, err := tc.dialer.DialPacket(context.Background(), tc.address)
+			if tc.expectErrMsg != "" {
+				require.EqualError(t, err, tc.expectErrMsg)
+				require.Nil(t, conn)
+			} else {
+				require.NoError(t, err)
+				require.Same(t, tc.expectDialer.ReturnConn, conn)
+			}
+
+			for _, mock := range allMocks {
+				if mock == tc.expectDialer {
+					assert.True(t, mock.WasCalled, "Expected dialer %s to be called", mock.Name)
+					assert.Equal(t, tc.address, mock.DialedAddr)
+				} else {
+					assert.False(t, mock.WasCalled, "Dialer %s should NOT have been called", mock.Name)
+				}
+			}
+		})
+	}
+
+	t.Run("Dialer returns error", func(t *testing.T) {
+		dialErr := errors.New("mock dial failed")
+		routeV4Dialer.ReturnError = dialErr
+		defer func() { routeV4Dialer.ReturnError = nil }()
+
+		_, err := withFallback.DialPacket(context.Background(), "192.0.2.20:53")
+		require.ErrorIs(t, err, dialErr)
+	})
+}
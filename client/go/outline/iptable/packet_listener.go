@@ -0,0 +1,294 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// maxPacketSize is the largest UDP payload we are able to relay.
+const maxPacketSize = 65535
+
+// PacketListener is a [transport.PacketListener] that routes packets
+// based on the destination IP address using an [IPTable].
+// If a specific route is found in the table, the corresponding listener is used.
+// Otherwise, the default listener (if set) is used.
+//
+// The [net.PacketConn] returned by ListenPacket lazily opens one underlying
+// connection per selected listener, and merges the packets received from all of them.
+// The listeners are used as map keys, so they must be comparable (e.g. pointers).
+type PacketListener struct {
+	table    IPTable[transport.PacketListener]
+	fallback transport.PacketListener
+}
+
+// NewPacketListener creates a new [PacketListener].
+// If the provided table is nil, a new empty table will be created internally.
+// It returns the new listener and a nil error.
+func NewPacketListener(table IPTable[transport.PacketListener], fallback transport.PacketListener) (*PacketListener, error) {
+	if table == nil {
+		table = NewIPTable[transport.PacketListener]()
+	}
+	return &PacketListener{
+		table:    table,
+		fallback: fallback,
+	}, nil
+}
+
+// ListenPacket returns a [net.PacketConn] that sends each packet through the
+// [transport.PacketListener] selected by looking up the destination IP in the IP table.
+func (listener *PacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	return &packetConn{
+		listener: listener,
+		ctx:      context.WithoutCancel(ctx),
+		conns:    make(map[transport.PacketListener]net.PacketConn),
+		packets:  make(chan receivedPacket),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (listener *PacketListener) selectListener(address string) (transport.PacketListener, error) {
+	selectedListener := lookupInTable(listener.table, address)
+	if selectedListener == nil {
+		selectedListener = listener.fallback
+	}
+	if selectedListener == nil {
+		return nil, fmt.Errorf("no listener available for address %s", address)
+	}
+	return selectedListener, nil
+}
+
+type receivedPacket struct {
+	payload []byte
+	addr    net.Addr
+}
+
+// packetConn multiplexes the writes over the connections of the selected listeners,
+// and merges the reads from all of them.
+type packetConn struct {
+	listener *PacketListener
+	ctx      context.Context
+
+	mu            sync.Mutex
+	conns         map[transport.PacketListener]net.PacketConn
+	writeDeadline time.Time
+
+	packets      chan receivedPacket
+	readDeadline deadline
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+func (c *packetConn) getOrListen(selectedListener transport.PacketListener) (net.PacketConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	if conn, ok := c.conns[selectedListener]; ok {
+		return conn, nil
+	}
+	conn, err := selectedListener.ListenPacket(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !c.writeDeadline.IsZero() {
+		conn.SetWriteDeadline(c.writeDeadline)
+	}
+	c.conns[selectedListener] = conn
+	go c.readLoop(selectedListener, conn)
+	return conn, nil
+}
+
+func (c *packetConn) readLoop(selectedListener transport.PacketListener, conn net.PacketConn) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			// Drop the broken connection. The next write to this route will open a new one.
+			c.mu.Lock()
+			if c.conns[selectedListener] == conn {
+				delete(c.conns, selectedListener)
+			}
+			c.mu.Unlock()
+			conn.Close()
+			return
+		}
+		pkt := receivedPacket{payload: append([]byte(nil), buf[:n]...), addr: addr}
+		select {
+		case c.packets <- pkt:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *packetConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	selectedListener, err := c.listener.selectListener(addr.String())
+	if err != nil {
+		return 0, err
+	}
+	conn, err := c.getOrListen(selectedListener)
+	if err != nil {
+		return 0, err
+	}
+	return conn.WriteTo(p, addr)
+}
+
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case pkt := <-c.packets:
+		return copy(p, pkt.payload), pkt.addr, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *packetConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		close(c.done)
+		for _, conn := range c.conns {
+			err = errors.Join(err, conn.Close())
+		}
+		clear(c.conns)
+	})
+	return err
+}
+
+// LocalAddr returns the local address of one of the underlying connections, or
+// the unspecified address if none was opened yet.
+func (c *packetConn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.conns {
+		return conn.LocalAddr()
+	}
+	return &net.UDPAddr{IP: net.IPv4zero}
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	var err error
+	for _, conn := range c.conns {
+		err = errors.Join(err, conn.SetWriteDeadline(t))
+	}
+	return err
+}
+
+// deadline is an abstraction for handling timeouts, modeled after the one used by [net.Pipe].
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+// set sets the point in time when the deadline will time out.
+// A timeout event is signaled by closing the channel returned by wait.
+// Once a timeout has occurred, the deadline can be refreshed by specifying a
+// t value in the future.
+//
+// A zero value for t prevents timeout.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+
+	// Time is zero, then there is no deadline.
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	// Time in the future, setup a timer to cancel in the future.
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	// Time in the past, so close immediately.
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline is exceeded.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
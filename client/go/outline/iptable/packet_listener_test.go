@@ -0,0 +1,131 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptable
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+type countingPacketListener struct {
+	transport.UDPListener
+	listenCount atomic.Int32
+}
+
+func (l *countingPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	l.listenCount.Add(1)
+	return l.UDPListener.ListenPacket(ctx)
+}
+
+func startUDPEchoServer(t *testing.T) *net.UDPConn {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			server.WriteTo(buf[:n], addr)
+		}
+	}()
+	return server
+}
+
+func TestIPTablePacketListener_ListenPacket(t *testing.T) {
+	server := startUDPEchoServer(t)
+	serverAddr := server.LocalAddr().(*net.UDPAddr)
+
+	routeListener := &countingPacketListener{}
+	defaultListener := &countingPacketListener{}
+
+	table := NewIPTable[transport.PacketListener]()
+	table.AddPrefix(netip.MustParsePrefix("127.0.0.0/8"), routeListener)
+
+	t.Run("Routes by destination", func(t *testing.T) {
+		listener, err := NewPacketListener(table, defaultListener)
+		require.NoError(t, err)
+
+		conn, err := listener.ListenPacket(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		for i := 0; i < 2; i++ {
+			_, err = conn.WriteTo([]byte("ping"), serverAddr)
+			require.NoError(t, err)
+
+			buf := make([]byte, 16)
+			n, addr, err := conn.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, "ping", string(buf[:n]))
+			require.Equal(t, serverAddr.String(), addr.String())
+		}
+
+		// The underlying connection is reused across writes.
+		require.Equal(t, int32(1), routeListener.listenCount.Load())
+		require.Equal(t, int32(0), defaultListener.listenCount.Load())
+	})
+
+	t.Run("No route and no fallback", func(t *testing.T) {
+		listener, err := NewPacketListener(table, nil)
+		require.NoError(t, err)
+
+		conn, err := listener.ListenPacket(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 53})
+		require.EqualError(t, err, "no listener available for address 198.51.100.1:53")
+	})
+
+	t.Run("Read deadline", func(t *testing.T) {
+		listener, err := NewPacketListener(table, defaultListener)
+		require.NoError(t, err)
+
+		conn, err := listener.ListenPacket(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, _, err = conn.ReadFrom(make([]byte, 16))
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		listener, err := NewPacketListener(table, defaultListener)
+		require.NoError(t, err)
+
+		conn, err := listener.ListenPacket(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+
+		_, _, err = conn.ReadFrom(make([]byte, 16))
+		require.True(t, errors.Is(err, net.ErrClosed))
+		_, err = conn.WriteTo([]byte("ping"), serverAddr)
+		require.True(t, errors.Is(err, net.ErrClosed))
+	})
+}
@@ -33,6 +33,12 @@ const (
 	//  - Output: null
 	MethodCloseVPN = "CloseVPN"
 
+	// EncodeTunnelConfig normalizes the tunnel config into the canonical advanced YAML, and a SIP002 ss:// URL
+	// if it's a simple Shadowsocks config.
+	//  - Input: the tunnel config text, in any format accepted by ParseTunnelConfig
+	//  - Output: a JSON string of {"yaml": string, "ssUrl"?: string}
+	MethodEncodeTunnelConfig = "EncodeTunnelConfig"
+
 	// EraseServiceStorage erases all file storage for the given service.
 	//  - Input: the key ID of the service
 	//  - Output: null
@@ -54,6 +60,12 @@ const (
 	//  - Output: the TunnelConfigJson that Typescript needs
 	MethodParseTunnelConfig = "ParseTunnelConfig"
 
+	// SetClientVersion sets the app version used by the configs that depend on it. It's for hosts that
+	// can't set the [GoBackendConfig] directly, and must be called before parsing any config.
+	//  - Input: the app version, e.g. "1.15.0"
+	//  - Output: null
+	MethodSetClientVersion = "SetClientVersion"
+
 	// SetVPNStateChangeListener sets a callback to be invoked when the VPN state changes.
 	//
 	// We recommend the caller to set this listener at app startup to catch all VPN state changes.
@@ -82,6 +94,9 @@ func InvokeMethod(method string, input string) *InvokeMethodResult {
 			Error: platerrors.ToPlatformError(err),
 		}
 
+	case MethodEncodeTunnelConfig:
+		return doEncodeTunnelConfig(input)
+
 	case MethodEraseServiceStorage:
 		err := handleEraseServiceStorage(input)
 		return &InvokeMethodResult{
@@ -105,6 +120,10 @@ func InvokeMethod(method string, input string) *InvokeMethodResult {
 	case MethodParseTunnelConfig:
 		return doParseTunnelConfig(input)
 
+	case MethodSetClientVersion:
+		GetBackendConfig().ClientVersion = input
+		return &InvokeMethodResult{}
+
 	case MethodSetVPNStateChangeListener:
 		err := setVPNStateChangeListener(input)
 		return &InvokeMethodResult{
@@ -141,6 +160,8 @@ func handleEraseServiceStorage(keyID string) error {
 
 type GoBackendConfig struct {
 	DataDir string
+	// ClientVersion is the app version, passed to the clients created by the backend. See [ClientConfig].
+	ClientVersion string
 }
 
 var goConfig GoBackendConfig
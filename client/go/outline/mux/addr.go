@@ -0,0 +1,57 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	addrTypeIPv4   = 1
+	addrTypeDomain = 3
+	addrTypeIPv6   = 4
+)
+
+// appendSOCKSAddr appends the address in SOCKS format (type, address, port).
+func appendSOCKSAddr(b []byte, address string) ([]byte, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %w", err)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is4() || ip.Is4In6() {
+			b = append(b, addrTypeIPv4)
+			b = append(b, ip.Unmap().AsSlice()...)
+		} else {
+			b = append(b, addrTypeIPv6)
+			b = append(b, ip.AsSlice()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host name is too long: %d bytes", len(host))
+		}
+		b = append(b, addrTypeDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is a mux server that relays the logical streams to their destinations.
+type testServer struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	connections int
+	streams     int
+}
+
+// startTestServer starts a mux server and returns it. It's stopped when the test ends.
+func startTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	server := &testServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *testServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// stats returns the number of connections and logical streams the server has accepted.
+func (s *testServer) stats() (connections int, streams int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, s.streams
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	config := yamux.DefaultConfig()
+	config.LogOutput = io.Discard
+	session, err := yamux.Server(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer session.Close()
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.streams++
+		s.mu.Unlock()
+		go relayStream(stream)
+	}
+}
+
+func relayStream(stream *yamux.Stream) {
+	defer stream.Close()
+	address, err := readSOCKSAddr(stream)
+	if err != nil {
+		return
+	}
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	go func() {
+		io.Copy(target, stream)
+		target.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(stream, target)
+}
+
+func readSOCKSAddr(r io.Reader) (string, error) {
+	var addrType [1]byte
+	if _, err := io.ReadFull(r, addrType[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch addrType[0] {
+	case addrTypeIPv4, addrTypeIPv6:
+		ip := make([]byte, 4)
+		if addrType[0] == addrTypeIPv6 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		addr, _ := netip.AddrFromSlice(ip)
+		host = addr.String()
+	case addrTypeDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unknown address type %d", addrType[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// startEchoServer starts a TCP server that echoes what it receives.
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
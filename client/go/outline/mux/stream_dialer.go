@@ -0,0 +1,297 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mux implements a [transport.StreamDialer] that multiplexes logical streams over a small
+// pool of connections, to save the connection handshakes on high-latency links.
+//
+// The connections use the [yamux] framing, which has per-stream flow control. Each logical stream
+// starts with the destination address in SOCKS format, after which the server relays the stream
+// to the destination.
+//
+// [yamux]: https://github.com/hashicorp/yamux/blob/master/spec.md
+package mux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/hashicorp/yamux"
+)
+
+// Options configures a [StreamDialer].
+type Options struct {
+	// MaxStreams is the maximum number of logical streams on each connection.
+	MaxStreams int
+	// IdleTimeout is how long a connection without streams is kept in the pool.
+	IdleTimeout time.Duration
+	// MaxStreamWindowSize is the flow control window of each stream, in bytes. If zero,
+	// the yamux default of 256 KiB is used.
+	MaxStreamWindowSize uint32
+}
+
+// StreamDialer is a [transport.StreamDialer] that opens logical streams over connections to a mux server.
+type StreamDialer struct {
+	dialer        transport.StreamDialer
+	serverAddress string
+	options       Options
+	yamuxConfig   *yamux.Config
+
+	mu       sync.Mutex
+	sessions []*session
+	// pending is the dial of a new session in progress, if any, which concurrent dials wait for.
+	pending *pendingSession
+}
+
+// pendingSession is the dial of a new session.
+type pendingSession struct {
+	done chan struct{}
+	err  error
+	// canceled is whether the dial failed because its context was done, in which case the dials
+	// waiting for it try again.
+	canceled bool
+}
+
+// session is a multiplexed connection in the pool.
+type session struct {
+	*yamux.Session
+	// streams is the number of logical streams reserved or open on the session.
+	streams   int
+	idleTimer *time.Timer
+}
+
+// NewStreamDialer creates a [StreamDialer] that uses dialer to connect to the mux server at serverAddress.
+func NewStreamDialer(dialer transport.StreamDialer, serverAddress string, options Options) (*StreamDialer, error) {
+	if dialer == nil {
+		return nil, errors.New("dialer must not be nil")
+	}
+	if serverAddress == "" {
+		return nil, errors.New("server address must not be empty")
+	}
+	if options.MaxStreams <= 0 {
+		return nil, fmt.Errorf("max streams must be positive, found %d", options.MaxStreams)
+	}
+	if options.IdleTimeout < 0 {
+		return nil, fmt.Errorf("idle timeout must not be negative, found %v", options.IdleTimeout)
+	}
+	yamuxConfig := yamux.DefaultConfig()
+	yamuxConfig.LogOutput = io.Discard
+	if options.MaxStreamWindowSize != 0 {
+		yamuxConfig.MaxStreamWindowSize = options.MaxStreamWindowSize
+	}
+	if err := yamux.VerifyConfig(yamuxConfig); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	return &StreamDialer{
+		dialer:        dialer,
+		serverAddress: serverAddress,
+		options:       options,
+		yamuxConfig:   yamuxConfig,
+	}, nil
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// DialStream opens a logical stream to the address on a connection from the pool, or on a new
+// connection if all of them are at the stream limit.
+func (d *StreamDialer) DialStream(ctx context.Context, address string) (transport.StreamConn, error) {
+	header, err := appendSOCKSAddr(nil, address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+	s, err := d.reserveStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.OpenStream()
+	if err != nil {
+		d.releaseStream(s)
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	conn := &streamConn{Stream: stream, release: func() { d.releaseStream(s) }}
+	if _, err := stream.Write(header); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write stream header: %w", err)
+	}
+	return conn, nil
+}
+
+// reserveStream returns the session with the fewest streams that is below the stream limit,
+// with a stream reserved. It dials a new session if there's none, or waits for the session
+// that is being dialed.
+func (d *StreamDialer) reserveStream(ctx context.Context) (*session, error) {
+	for {
+		d.mu.Lock()
+		if s := d.bestSession(); s != nil {
+			s.reserve()
+			d.mu.Unlock()
+			return s, nil
+		}
+		if pending := d.pending; pending != nil {
+			d.mu.Unlock()
+			select {
+			case <-pending.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if pending.err != nil && !pending.canceled {
+				return nil, pending.err
+			}
+			// The new session may already be at the stream limit, so look again.
+			continue
+		}
+		pending := &pendingSession{done: make(chan struct{})}
+		d.pending = pending
+		d.mu.Unlock()
+
+		s, err := d.dialSession(ctx)
+		d.mu.Lock()
+		d.pending = nil
+		if err == nil {
+			s.reserve()
+			d.sessions = append(d.sessions, s)
+		}
+		d.mu.Unlock()
+		pending.err = err
+		pending.canceled = ctx.Err() != nil
+		close(pending.done)
+		return s, err
+	}
+}
+
+// bestSession removes the closed sessions from the pool, and returns the open session with
+// the fewest streams that is below the stream limit, or nil if there's none. Must be called
+// with the dialer lock held.
+func (d *StreamDialer) bestSession() *session {
+	var best *session
+	open := d.sessions[:0]
+	for _, s := range d.sessions {
+		if s.IsClosed() {
+			continue
+		}
+		open = append(open, s)
+		if s.streams < d.options.MaxStreams && (best == nil || s.streams < best.streams) {
+			best = s
+		}
+	}
+	clear(d.sessions[len(open):])
+	d.sessions = open
+	return best
+}
+
+// dialSession dials a new connection to the mux server.
+func (d *StreamDialer) dialSession(ctx context.Context) (*session, error) {
+	conn, err := d.dialer.DialStream(ctx, d.serverAddress)
+	if err != nil {
+		return nil, err
+	}
+	yamuxSession, err := yamux.Client(conn, d.yamuxConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create mux session: %w", err)
+	}
+	return &session{Session: yamuxSession}, nil
+}
+
+// reserve accounts for a new stream. Must be called with the dialer lock held.
+func (s *session) reserve() {
+	s.streams++
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+}
+
+// releaseStream accounts for a closed stream, and schedules the removal of the session
+// from the pool if it becomes idle.
+func (d *StreamDialer) releaseStream(s *session) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s.streams--
+	if s.streams > 0 {
+		return
+	}
+	s.idleTimer = time.AfterFunc(d.options.IdleTimeout, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if s.streams > 0 {
+			return
+		}
+		for i, pooled := range d.sessions {
+			if pooled == s {
+				d.sessions = append(d.sessions[:i], d.sessions[i+1:]...)
+				break
+			}
+		}
+		s.Close()
+	})
+}
+
+// numSessions returns the number of connections in the pool.
+func (d *StreamDialer) numSessions() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.sessions)
+}
+
+// streamConn is a [transport.StreamConn] for a logical stream.
+type streamConn struct {
+	*yamux.Stream
+	releaseOnce sync.Once
+	release     func()
+	// closed is set by Close. yamux closes a stream like CloseWrite does, and keeps serving reads
+	// until the server closes its side, so the reads are failed here instead.
+	closed atomic.Bool
+}
+
+var _ transport.StreamConn = (*streamConn)(nil)
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.Stream.Read(b)
+	if err != nil && c.closed.Load() {
+		return n, net.ErrClosed
+	}
+	return n, err
+}
+
+// CloseRead is a no-op, since yamux has no way to signal it to the server.
+func (c *streamConn) CloseRead() error {
+	return nil
+}
+
+// CloseWrite sends a FIN for the stream. Reads can continue until the server closes its side.
+func (c *streamConn) CloseWrite() error {
+	return c.Stream.Close()
+}
+
+// Close closes both directions of the stream. It sends a FIN, if CloseWrite didn't, and fails the
+// blocked and future reads. yamux has no way to reset a stream, so it resets the stream on the
+// server after its StreamCloseTimeout if the server doesn't close its side before.
+func (c *streamConn) Close() error {
+	c.releaseOnce.Do(c.release)
+	c.closed.Store(true)
+	err := c.Stream.Close()
+	// Wake up the blocked reads.
+	c.Stream.SetReadDeadline(time.Now())
+	return err
+}
@@ -0,0 +1,241 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDialer is a [transport.StreamDialer] that counts the connections it dials.
+type countingDialer struct {
+	mu    sync.Mutex
+	dials int
+}
+
+func (d *countingDialer) DialStream(ctx context.Context, address string) (transport.StreamConn, error) {
+	d.mu.Lock()
+	d.dials++
+	d.mu.Unlock()
+	return (&transport.TCPDialer{}).DialStream(ctx, address)
+}
+
+func (d *countingDialer) numDials() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+func requireEcho(t *testing.T, conn io.ReadWriter, message []byte) {
+	_, err := conn.Write(message)
+	require.NoError(t, err)
+	received := make([]byte, len(message))
+	_, err = io.ReadFull(conn, received)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(message, received))
+}
+
+func TestDialStream_Multiplexes(t *testing.T) {
+	server := startTestServer(t)
+	echo := startEchoServer(t)
+	base := &countingDialer{}
+	dialer, err := NewStreamDialer(base, server.Addr(), Options{MaxStreams: 2, IdleTimeout: time.Minute})
+	require.NoError(t, err)
+
+	var conns []transport.StreamConn
+	for range 3 {
+		conn, err := dialer.DialStream(context.Background(), echo.Addr().String())
+		require.NoError(t, err)
+		requireEcho(t, conn, []byte("hello"))
+		conns = append(conns, conn)
+	}
+	// The third stream needs a second connection.
+	require.Equal(t, 2, base.numDials())
+	connections, streams := server.stats()
+	require.Equal(t, 2, connections)
+	require.Equal(t, 3, streams)
+
+	// A released stream makes room in the pool.
+	require.NoError(t, conns[0].Close())
+	conn, err := dialer.DialStream(context.Background(), echo.Addr().String())
+	require.NoError(t, err)
+	requireEcho(t, conn, []byte("world"))
+	require.Equal(t, 2, base.numDials())
+
+	for _, c := range append(conns[1:], conn) {
+		c.Close()
+	}
+}
+
+func TestDialStream_FlowControl(t *testing.T) {
+	server := startTestServer(t)
+	echo := startEchoServer(t)
+	dialer, err := NewStreamDialer(&countingDialer{}, server.Addr(), Options{MaxStreams: 8, IdleTimeout: time.Minute})
+	require.NoError(t, err)
+
+	// Transfers larger than the stream window need window updates.
+	message := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	var wg sync.WaitGroup
+	for range 4 {
+		conn, err := dialer.DialStream(context.Background(), echo.Addr().String())
+		require.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			go func() {
+				conn.Write(message)
+				conn.CloseWrite()
+			}()
+			received, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			require.Equal(t, len(message), len(received))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, dialer.numSessions())
+}
+
+func TestDialStream_ConcurrentDials(t *testing.T) {
+	server := startTestServer(t)
+	echo := startEchoServer(t)
+	base := &countingDialer{}
+	dialer, err := NewStreamDialer(base, server.Addr(), Options{MaxStreams: 8, IdleTimeout: time.Minute})
+	require.NoError(t, err)
+
+	// Concurrent dials on an empty pool share the new connection.
+	conns := make(chan transport.StreamConn, 4)
+	for range 4 {
+		go func() {
+			conn, err := dialer.DialStream(context.Background(), echo.Addr().String())
+			require.NoError(t, err)
+			conns <- conn
+		}()
+	}
+	for range 4 {
+		conn := <-conns
+		requireEcho(t, conn, []byte("hello"))
+		conn.Close()
+	}
+	require.Equal(t, 1, base.numDials())
+}
+
+func TestStreamConn_Close(t *testing.T) {
+	server := startTestServer(t)
+	echo := startEchoServer(t)
+	dialer, err := NewStreamDialer(&countingDialer{}, server.Addr(), Options{MaxStreams: 1, IdleTimeout: time.Minute})
+	require.NoError(t, err)
+	conn, err := dialer.DialStream(context.Background(), echo.Addr().String())
+	require.NoError(t, err)
+
+	// Close fails the blocked read, even though the server didn't close its side.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-readErr:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("read is still blocked after Close")
+	}
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, net.ErrClosed)
+	_, err = conn.Write([]byte("hello"))
+	require.Error(t, err)
+}
+
+func TestDialStream_IdleShrink(t *testing.T) {
+	server := startTestServer(t)
+	echo := startEchoServer(t)
+	base := &countingDialer{}
+	dialer, err := NewStreamDialer(base, server.Addr(), Options{MaxStreams: 1, IdleTimeout: 20 * time.Millisecond})
+	require.NoError(t, err)
+
+	conn1, err := dialer.DialStream(context.Background(), echo.Addr().String())
+	require.NoError(t, err)
+	conn2, err := dialer.DialStream(context.Background(), echo.Addr().String())
+	require.NoError(t, err)
+	require.Equal(t, 2, dialer.numSessions())
+
+	conn1.Close()
+	require.Eventually(t, func() bool { return dialer.numSessions() == 1 }, time.Second, 5*time.Millisecond)
+
+	// The remaining connection is still usable.
+	requireEcho(t, conn2, []byte("hello"))
+	conn2.Close()
+	require.Eventually(t, func() bool { return dialer.numSessions() == 0 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, 2, base.numDials())
+}
+
+func TestDialStream_ReplacesClosedSession(t *testing.T) {
+	server := startTestServer(t)
+	echo := startEchoServer(t)
+	var baseConns []transport.StreamConn
+	base := transport.FuncStreamDialer(func(ctx context.Context, address string) (transport.StreamConn, error) {
+		conn, err := (&transport.TCPDialer{}).DialStream(ctx, address)
+		baseConns = append(baseConns, conn)
+		return conn, err
+	})
+	dialer, err := NewStreamDialer(base, server.Addr(), Options{MaxStreams: 4, IdleTimeout: time.Minute})
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), echo.Addr().String())
+	require.NoError(t, err)
+	baseConns[0].Close()
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	conn, err = dialer.DialStream(context.Background(), echo.Addr().String())
+	require.NoError(t, err)
+	requireEcho(t, conn, []byte("hello"))
+	require.Len(t, baseConns, 2)
+}
+
+func TestDialStream_DialError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	dialer, err := NewStreamDialer(&transport.TCPDialer{}, address, Options{MaxStreams: 1})
+	require.NoError(t, err)
+	_, err = dialer.DialStream(context.Background(), "example.com:443")
+	require.Error(t, err)
+	_, err = dialer.DialStream(context.Background(), "invalid address")
+	require.ErrorContains(t, err, "invalid address")
+}
+
+func TestNewStreamDialer_Errors(t *testing.T) {
+	_, err := NewStreamDialer(nil, "server:1", Options{MaxStreams: 1})
+	require.Error(t, err)
+	_, err = NewStreamDialer(&transport.TCPDialer{}, "", Options{MaxStreams: 1})
+	require.Error(t, err)
+	_, err = NewStreamDialer(&transport.TCPDialer{}, "server:1", Options{})
+	require.ErrorContains(t, err, "max streams must be positive")
+	_, err = NewStreamDialer(&transport.TCPDialer{}, "server:1", Options{MaxStreams: 1, MaxStreamWindowSize: 1024})
+	require.ErrorContains(t, err, "invalid options")
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"strings"
 
+	"localhost/client/go/configyaml"
 	"localhost/client/go/outline/config"
 	"localhost/client/go/outline/platerrors"
 	"github.com/goccy/go-yaml"
@@ -48,6 +49,7 @@ type ProviderTunnelConfig struct {
 type firstHopAndTunnelConfigJSON struct {
 	Client         string          `json:"client"`
 	FirstHop       string          `json:"firstHop"`
+	FirstHops      []string        `json:"firstHops,omitempty"`
 	ConnectionType config.ConnType `json:"connectionType"`
 }
 
@@ -74,95 +76,132 @@ func combinedConnectionType(streamConnType, packetConnType config.ConnType) conf
 	return config.ConnTypePartial
 }
 
-func doParseTunnelConfig(input string) *InvokeMethodResult {
-	input = strings.TrimSpace(input)
-	// Input may be one of:
-	// - ss:// link
-	// - Legacy Shadowsocks JSON (parsed as YAML)
-	// - Advanced YAML format
+// setInputPosition updates the line and column of a config error to refer to the input text, since the client
+// is created from the normalized config.
+func setInputPosition(platErr *platerrors.PlatformError, input string, inputIsTransport bool) {
+	configPath, ok := platErr.Details["path"].(string)
+	if !ok {
+		return
+	}
+	delete(platErr.Details, "line")
+	delete(platErr.Details, "column")
+	if inputIsTransport {
+		configPath, ok = strings.CutPrefix(configPath, "transport")
+		if !ok {
+			return
+		}
+		configPath = strings.TrimPrefix(configPath, ".")
+	}
+	if line, column, ok := configyaml.FindPosition(input, configPath); ok {
+		platErr.Details["line"] = line
+		platErr.Details["column"] = column
+	}
+}
+
+// parseTunnelConfigInput parses the tunnel config text into the client config map.
+// The input may be one of:
+// - ss:// link
+// - Legacy Shadowsocks JSON (parsed as YAML)
+// - Advanced YAML format
+//
+// inputIsTransport is whether the input is the transport config, rather than the full client config.
+// A provider error in the input is returned as the error.
+func parseTunnelConfigInput(input string) (clientConfigMap map[string]any, inputIsTransport bool, platErr *platerrors.PlatformError) {
 	var stringValue string
-	var clientConfigMap map[string]any
 	if err := yaml.Unmarshal([]byte(input), &stringValue); err == nil {
 		// Legacy URL format. Input is the transport config.
-		clientConfigMap = map[string]any{"transport": stringValue}
-	} else {
-		var yamlValue map[string]any
-		if err := yaml.Unmarshal([]byte(input), &yamlValue); err != nil {
-			return &InvokeMethodResult{
-				Error: &platerrors.PlatformError{
-					Code:    platerrors.InvalidConfig,
-					Message: fmt.Sprintf("failed to parse: %s", err),
-				},
-			}
+		return map[string]any{"transport": stringValue}, true, nil
+	}
+
+	var yamlValue map[string]any
+	if err := yaml.Unmarshal([]byte(input), &yamlValue); err != nil {
+		return nil, false, &platerrors.PlatformError{
+			Code:    platerrors.InvalidConfig,
+			Message: fmt.Sprintf("failed to parse: %s", err),
 		}
+	}
 
-		if hasKey(yamlValue, "transport") || hasKey(yamlValue, "error") {
-			// New format. Parse as tunnel config
-			providerConfig := providerConfig{}
-			if err := yaml.Unmarshal([]byte(input), &providerConfig); err != nil {
-				return &InvokeMethodResult{
-					Error: &platerrors.PlatformError{
-						Code:    platerrors.InvalidConfig,
-						Message: fmt.Sprintf("failed to parse: %s", err),
-					},
-				}
-			}
+	if !hasKey(yamlValue, "transport") && !hasKey(yamlValue, "error") {
+		// Legacy JSON format. Input is the transport config.
+		return map[string]any{"transport": yamlValue}, true, nil
+	}
 
-			// Process provider error, if present.
-			if providerConfig.Error != nil {
-				platErr := &platerrors.PlatformError{
-					Code:    platerrors.ProviderError,
-					Message: providerConfig.Error.Message,
-				}
-				if providerConfig.Error.Details != "" {
-					platErr.Details = map[string]any{
-						"details": providerConfig.Error.Details,
-					}
-				}
-				return &InvokeMethodResult{Error: platErr}
-			}
+	// New format. Parse as tunnel config
+	providerConfig := providerConfig{}
+	if err := yaml.Unmarshal([]byte(input), &providerConfig); err != nil {
+		return nil, false, &platerrors.PlatformError{
+			Code:    platerrors.InvalidConfig,
+			Message: fmt.Sprintf("failed to parse: %s", err),
+		}
+	}
 
-			// Extract client config.
-			clientConfigMap = yamlValue
-		} else {
-			// Legacy JSON format. Input is the transport config.
-			clientConfigMap = map[string]any{"transport": yamlValue}
+	// Process provider error, if present.
+	if providerConfig.Error != nil {
+		platErr := &platerrors.PlatformError{
+			Code:    platerrors.ProviderError,
+			Message: providerConfig.Error.Message,
+		}
+		if providerConfig.Error.Details != "" {
+			platErr.Details = map[string]any{
+				"details": providerConfig.Error.Details,
+			}
 		}
+		return nil, false, platErr
 	}
 
+	// Extract client config.
+	return yamlValue, false, nil
+}
+
+// newTunnelClient creates the client for the parsed tunnel config input, to validate it.
+// It returns the normalized client config text that was used to create the client.
+func newTunnelClient(input string, clientConfigMap map[string]any, inputIsTransport bool) (*Client, string, *platerrors.PlatformError) {
 	// Use JSON marshaling from the standard library because the YAML library is buggy.
 	// See https://github.com/Jigsaw-Code/outline-apps/issues/2576.
 	// JSON is a subset of YAML, so that's valid YAML.
 	clientConfigBytes, err := json.Marshal(clientConfigMap)
 	if err != nil {
-		return &InvokeMethodResult{
-			Error: &platerrors.PlatformError{
-				Code:    platerrors.InvalidConfig,
-				Message: fmt.Sprintf("failed to normalize config: %s", err),
-			},
+		return nil, "", &platerrors.PlatformError{
+			Code:    platerrors.InvalidConfig,
+			Message: fmt.Sprintf("failed to normalize config: %s", err),
 		}
 	}
 
 	result := (&ClientConfig{
-		DataDir: GetBackendConfig().DataDir,
+		DataDir:       GetBackendConfig().DataDir,
+		ClientVersion: GetBackendConfig().ClientVersion,
 	}).New("", string(clientConfigBytes))
 	if result.Error != nil {
-		return &InvokeMethodResult{
-			Error: result.Error,
-		}
+		setInputPosition(result.Error, input, inputIsTransport)
+		return nil, "", result.Error
+	}
+	return result.Client, string(clientConfigBytes), nil
+}
+
+func doParseTunnelConfig(input string) *InvokeMethodResult {
+	input = strings.TrimSpace(input)
+	clientConfigMap, inputIsTransport, platErr := parseTunnelConfigInput(input)
+	if platErr != nil {
+		return &InvokeMethodResult{Error: platErr}
+	}
+	client, clientConfigText, platErr := newTunnelClient(input, clientConfigMap, inputIsTransport)
+	if platErr != nil {
+		return &InvokeMethodResult{Error: platErr}
 	}
 	response := firstHopAndTunnelConfigJSON{
-		Client: string(clientConfigBytes),
+		Client: clientConfigText,
 	}
 
-	streamFirstHop := result.Client.sd.ConnectionProviderInfo.FirstHop
-	packetFirstHop := result.Client.pp.ConnectionProviderInfo.FirstHop
+	streamFirstHop := client.sd.ConnectionProviderInfo.FirstHop
+	packetFirstHop := client.pp.ConnectionProviderInfo.FirstHop
 	if streamFirstHop == packetFirstHop {
 		response.FirstHop = streamFirstHop
 	}
+	// Set if the connections go through several first hops.
+	_, response.FirstHops = config.MergeFirstHops(client.sd.ConnectionProviderInfo, client.pp.ConnectionProviderInfo)
 
-	streamConnType := result.Client.sd.ConnectionProviderInfo.ConnType
-	packetConnType := result.Client.pp.ConnectionProviderInfo.ConnType
+	streamConnType := client.sd.ConnectionProviderInfo.ConnType
+	packetConnType := client.pp.ConnectionProviderInfo.ConnType
 	response.ConnectionType = combinedConnectionType(streamConnType, packetConnType)
 
 	responseBytes, err := json.Marshal(response)
@@ -27,8 +27,9 @@ import (
 
 // parsedTunnelResultJSON is a helper struct to unmarshal the JSON output of doParseTunnelConfig.
 type parsedTunnelResultJSON struct {
-	Client   string `json:"client"`
-	FirstHop string `json:"firstHop"`
+	Client    string   `json:"client"`
+	FirstHop  string   `json:"firstHop"`
+	FirstHops []string `json:"firstHops"`
 }
 
 func parseFirstHopAndTunnelConfigJSON(t *testing.T, jsonStr string) parsedTunnelResultJSON {
@@ -201,6 +202,50 @@ func Test_doParseTunnelConfig_ProviderErrorJSON(t *testing.T) {
 	}, result.Error)
 }
 
+func Test_doParseTunnelConfig_ErrorLocation(t *testing.T) {
+	result := doParseTunnelConfig(`transport:
+  $type: tcpudp
+  tcp:
+    $type: shadowsocks
+    endpoint: example.com:80
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET
+  udp:
+    $type: shadowsocks
+    endpoint:
+      $type: dial
+      address: example.com:53
+      dialer: {$type: unknown}
+    cipher: chacha20-ietf-poly1305
+    secret: SECRET`)
+
+	require.NotNil(t, result.Error)
+	require.Equal(t, platerrors.InvalidConfig, result.Error.Code)
+	require.Equal(t, platerrors.ErrorDetails{
+		"path":   "transport.udp.endpoint.dialer",
+		"line":   13,
+		"column": 15,
+	}, result.Error.Details)
+}
+
+func Test_doParseTunnelConfig_LegacyJSON_ErrorLocation(t *testing.T) {
+	result := doParseTunnelConfig(`{
+    "server": "example.com",
+    "server_port": 4321,
+    "method": "chacha20-ietf-poly1305",
+    "password": "SECRET",
+    "prefx": "POST "
+}`)
+
+	require.NotNil(t, result.Error)
+	require.Equal(t, platerrors.InvalidConfig, result.Error.Code)
+	require.Equal(t, platerrors.ErrorDetails{
+		"path":   "transport.prefx",
+		"line":   6,
+		"column": 14,
+	}, result.Error.Details)
+}
+
 func TestParseConfig_SS_URL(t *testing.T) {
 	userInputConfig := "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@example.com:4321/"
 	expectedFirstHop := "example.com:4321"
@@ -504,6 +549,54 @@ udp:
 	matchTransportConfig(t, userInputConfig, result.Value)
 }
 
+func TestParseConfig_Transport_MultipleFirstHops(t *testing.T) {
+	userInputConfig := `
+$type: tcpudp
+tcp:
+    $type: balance
+    options:
+      - &server1
+        $type: shadowsocks
+        endpoint: server1.example.com:443
+        cipher: chacha20-ietf-poly1305
+        secret: SECRET
+      - &server2
+        $type: shadowsocks
+        endpoint: server2.example.com:443
+        cipher: chacha20-ietf-poly1305
+        secret: SECRET
+udp: *server1`
+	result := doParseTunnelConfig(userInputConfig)
+	require.Nil(t, result.Error, "doParseTunnelConfig failed: %v", result.Error)
+
+	// The single FirstHop is empty, so it can't be mistaken for one of the hops.
+	parsedOutput := parseFirstHopAndTunnelConfigJSON(t, result.Value)
+	require.Empty(t, parsedOutput.FirstHop)
+	require.Equal(t, []string{"server1.example.com:443", "server2.example.com:443"}, parsedOutput.FirstHops)
+}
+
+func TestParseConfig_Transport_ClientVersion(t *testing.T) {
+	userInputConfig := `
+$type: match
+cases:
+  - client_version: ">=1.15"
+    config: ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@new.example.com:443/
+default: ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTRUNSRVQ@old.example.com:443/`
+	defer func(clientVersion string) { GetBackendConfig().ClientVersion = clientVersion }(GetBackendConfig().ClientVersion)
+
+	result := InvokeMethod(MethodSetClientVersion, "1.14.2")
+	require.Nil(t, result.Error)
+	result = doParseTunnelConfig(userInputConfig)
+	require.Nil(t, result.Error, "doParseTunnelConfig failed: %v", result.Error)
+	require.Equal(t, "old.example.com:443", parseFirstHopAndTunnelConfigJSON(t, result.Value).FirstHop)
+
+	result = InvokeMethod(MethodSetClientVersion, "1.15.0")
+	require.Nil(t, result.Error)
+	result = doParseTunnelConfig(userInputConfig)
+	require.Nil(t, result.Error, "doParseTunnelConfig failed: %v", result.Error)
+	require.Equal(t, "new.example.com:443", parseFirstHopAndTunnelConfigJSON(t, result.Value).FirstHop)
+}
+
 func TestParseConfig_Transport_Unsupported(t *testing.T) {
 	userInputConfig := `$type: unsupported` // This is a transport config
 	result := doParseTunnelConfig(userInputConfig)
@@ -87,6 +87,10 @@ const (
 
 	// ProxyServerUDPUnsupported means the remote proxy doesn't support relaying UDP traffic.
 	ProxyServerUDPUnsupported ErrorCode = "ERR_PROXY_SERVER_UDP_NOT_SUPPORTED"
+
+	// CertificatePinMismatch means the TLS certificate presented by a remote server
+	// doesn't match any of the pinned public keys.
+	CertificatePinMismatch ErrorCode = "ERR_CERTIFICATE_PIN_MISMATCH"
 )
 
 //////////
@@ -0,0 +1,184 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package race implements a [transport.StreamDialer] that dials over multiple dialers in parallel
+// with a staggered start, in the style of Happy Eyeballs (RFC 8305), and keeps the first connection.
+package race
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// StreamDialer is a [transport.StreamDialer] that races connection attempts over its dialers.
+// The attempts are started in order, each one delay after the previous, or as soon as all
+// the previous attempts have failed. The first connection to be established wins and all
+// the other attempts are canceled or closed.
+type StreamDialer struct {
+	dialers []transport.StreamDialer
+	delay   time.Duration
+
+	mu    sync.Mutex
+	stats []OptionStats
+}
+
+// OptionStats holds the diagnostic counters for one of the dialers of a [StreamDialer].
+type OptionStats struct {
+	// Attempts is the number of dials started with the dialer.
+	Attempts int
+	// Wins is the number of dials in which the dialer connected first.
+	Wins int
+	// Failures is the number of dials that failed before the race was over.
+	Failures int
+	// TotalLatency is the sum of the connection times of the won dials.
+	TotalLatency time.Duration
+}
+
+// WinRate returns the ratio of won dials over attempts, or zero if there were no attempts.
+func (s OptionStats) WinRate() float64 {
+	if s.Attempts == 0 {
+		return 0
+	}
+	return float64(s.Wins) / float64(s.Attempts)
+}
+
+// AverageLatency returns the average connection time of the won dials, or zero if there were no wins.
+func (s OptionStats) AverageLatency() time.Duration {
+	if s.Wins == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Wins)
+}
+
+// NewStreamDialer creates a new [StreamDialer] that starts a dial with each of the dialers,
+// in order, waiting delay between them.
+func NewStreamDialer(dialers []transport.StreamDialer, delay time.Duration) (*StreamDialer, error) {
+	if len(dialers) == 0 {
+		return nil, errors.New("dialers must not be empty")
+	}
+	for i, dialer := range dialers {
+		if dialer == nil {
+			return nil, fmt.Errorf("dialer %d must not be nil", i)
+		}
+	}
+	if delay < 0 {
+		return nil, fmt.Errorf("delay must not be negative, found %v", delay)
+	}
+	return &StreamDialer{
+		dialers: append([]transport.StreamDialer(nil), dialers...),
+		delay:   delay,
+		stats:   make([]OptionStats, len(dialers)),
+	}, nil
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// Stats returns a snapshot of the diagnostic counters of each dialer, in the order they were given.
+func (d *StreamDialer) Stats() []OptionStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]OptionStats(nil), d.stats...)
+}
+
+func (d *StreamDialer) updateStats(option int, update func(*OptionStats)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	update(&d.stats[option])
+}
+
+type dialResult struct {
+	option  int
+	conn    transport.StreamConn
+	err     error
+	latency time.Duration
+}
+
+// DialStream implements [transport.StreamDialer].
+func (d *StreamDialer) DialStream(ctx context.Context, address string) (transport.StreamConn, error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(d.dialers))
+	nextOption := 0
+	startNext := func() {
+		option := nextOption
+		nextOption++
+		d.updateStats(option, func(s *OptionStats) { s.Attempts++ })
+		go func() {
+			start := time.Now()
+			conn, err := d.dialers[option].DialStream(raceCtx, address)
+			results <- dialResult{option, conn, err, time.Since(start)}
+		}()
+	}
+
+	startNext()
+	pending := 1
+	timer := time.NewTimer(d.delay)
+	defer timer.Stop()
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if nextOption < len(d.dialers) {
+				startNext()
+				pending++
+				timer.Reset(d.delay)
+			}
+
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				if raceCtx.Err() == nil {
+					d.updateStats(result.option, func(s *OptionStats) { s.Failures++ })
+				}
+				errs = append(errs, fmt.Errorf("option %d failed: %w", result.option, result.err))
+				// Don't wait for the delay if there are no other attempts in progress.
+				if pending == 0 && nextOption < len(d.dialers) {
+					startNext()
+					pending++
+					timer.Reset(d.delay)
+				}
+				continue
+			}
+
+			d.updateStats(result.option, func(s *OptionStats) {
+				s.Wins++
+				s.TotalLatency += result.latency
+			})
+			cancel()
+			go closeLosers(results, pending)
+			return result.conn, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	return nil, fmt.Errorf("all race options failed: %w", errors.Join(errs...))
+}
+
+// closeLosers waits for the pending attempts and closes the connections that were established
+// after the race was over.
+func closeLosers(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		result := <-results
+		if result.err == nil {
+			result.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package race
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// testConn is a [transport.StreamConn] that records whether it was closed.
+type testConn struct {
+	net.Conn
+	name   string
+	closed atomic.Bool
+}
+
+func (c *testConn) Close() error      { c.closed.Store(true); return nil }
+func (c *testConn) CloseRead() error  { return nil }
+func (c *testConn) CloseWrite() error { return nil }
+
+// newTestDialer returns a dialer that connects after the given latency, or fails if err is not nil.
+func newTestDialer(latency time.Duration, conn *testConn, err error) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	})
+}
+
+// newUncancelableDialer returns a dialer that connects after the given latency, ignoring cancelation.
+func newUncancelableDialer(latency time.Duration, conn *testConn) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		time.Sleep(latency)
+		return conn, nil
+	})
+}
+
+func TestDialStream_FirstWins(t *testing.T) {
+	dialer, err := NewStreamDialer([]transport.StreamDialer{
+		newTestDialer(0, &testConn{name: "a"}, nil),
+		newTestDialer(0, &testConn{name: "b"}, nil),
+	}, time.Second)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, "a", conn.(*testConn).name)
+
+	stats := dialer.Stats()
+	require.Equal(t, OptionStats{Attempts: 1, Wins: 1, TotalLatency: stats[0].TotalLatency}, stats[0])
+	require.Equal(t, OptionStats{}, stats[1])
+}
+
+func TestDialStream_FasterLaterOptionWins(t *testing.T) {
+	slow := &testConn{name: "slow"}
+	fast := &testConn{name: "fast"}
+	dialer, err := NewStreamDialer([]transport.StreamDialer{
+		newUncancelableDialer(200*time.Millisecond, slow),
+		newTestDialer(0, fast, nil),
+	}, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, "fast", conn.(*testConn).name)
+
+	// The connection that is established after the race is over gets closed.
+	require.Eventually(t, slow.closed.Load, time.Second, 10*time.Millisecond)
+	require.False(t, fast.closed.Load())
+
+	stats := dialer.Stats()
+	require.Equal(t, 1, stats[0].Attempts)
+	require.Equal(t, 0, stats[0].Wins)
+	require.Equal(t, 0, stats[0].Failures)
+	require.Equal(t, 1, stats[1].Attempts)
+	require.Equal(t, 1, stats[1].Wins)
+	require.Equal(t, 1.0, stats[1].WinRate())
+}
+
+func TestDialStream_FailureStartsNextImmediately(t *testing.T) {
+	dialer, err := NewStreamDialer([]transport.StreamDialer{
+		newTestDialer(0, nil, errors.New("failed a")),
+		newTestDialer(0, &testConn{name: "b"}, nil),
+	}, time.Hour)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), "example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, "b", conn.(*testConn).name)
+
+	stats := dialer.Stats()
+	require.Equal(t, 1, stats[0].Failures)
+	require.Equal(t, 0.0, stats[0].WinRate())
+	require.Equal(t, 1, stats[1].Wins)
+}
+
+func TestDialStream_AllFail(t *testing.T) {
+	dialer, err := NewStreamDialer([]transport.StreamDialer{
+		newTestDialer(0, nil, errors.New("failed a")),
+		newTestDialer(0, nil, errors.New("failed b")),
+	}, 0)
+	require.NoError(t, err)
+
+	_, err = dialer.DialStream(context.Background(), "example.com:443")
+	require.ErrorContains(t, err, "all race options failed")
+	require.ErrorContains(t, err, "failed a")
+	require.ErrorContains(t, err, "failed b")
+}
+
+func TestDialStream_Canceled(t *testing.T) {
+	dialer, err := NewStreamDialer([]transport.StreamDialer{
+		newTestDialer(time.Hour, nil, nil),
+		newTestDialer(time.Hour, nil, nil),
+	}, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = dialer.DialStream(ctx, "example.com:443")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	for _, stats := range dialer.Stats() {
+		require.Equal(t, 1, stats.Attempts)
+		require.Equal(t, 0, stats.Failures)
+	}
+}
+
+func TestOptionStats_AverageLatency(t *testing.T) {
+	require.Equal(t, time.Duration(0), OptionStats{}.AverageLatency())
+	require.Equal(t, 20*time.Millisecond, OptionStats{Attempts: 3, Wins: 2, TotalLatency: 40 * time.Millisecond}.AverageLatency())
+}
+
+func TestNewStreamDialer_Errors(t *testing.T) {
+	_, err := NewStreamDialer(nil, 0)
+	require.Error(t, err)
+	_, err = NewStreamDialer([]transport.StreamDialer{nil}, 0)
+	require.Error(t, err)
+	_, err = NewStreamDialer([]transport.StreamDialer{newTestDialer(0, nil, nil)}, -time.Second)
+	require.Error(t, err)
+}
@@ -0,0 +1,94 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks2022
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	addrTypeIPv4   = 1
+	addrTypeDomain = 3
+	addrTypeIPv6   = 4
+)
+
+// appendSOCKSAddr appends the address in SOCKS format (type, address, port).
+func appendSOCKSAddr(b []byte, address string) ([]byte, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %w", err)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is4() || ip.Is4In6() {
+			b = append(b, addrTypeIPv4)
+			b = append(b, ip.Unmap().AsSlice()...)
+		} else {
+			b = append(b, addrTypeIPv6)
+			b = append(b, ip.AsSlice()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host name is too long: %d bytes", len(host))
+		}
+		b = append(b, addrTypeDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// parseSOCKSAddr parses an address in SOCKS format and returns it and the number of bytes consumed.
+func parseSOCKSAddr(b []byte) (string, int, error) {
+	if len(b) < 1 {
+		return "", 0, errors.New("address is empty")
+	}
+	var host string
+	offset := 1
+	switch b[0] {
+	case addrTypeIPv4:
+		if len(b) < offset+4 {
+			return "", 0, errors.New("IPv4 address is too short")
+		}
+		host = netip.AddrFrom4([4]byte(b[offset : offset+4])).String()
+		offset += 4
+	case addrTypeIPv6:
+		if len(b) < offset+16 {
+			return "", 0, errors.New("IPv6 address is too short")
+		}
+		host = netip.AddrFrom16([16]byte(b[offset : offset+16])).String()
+		offset += 16
+	case addrTypeDomain:
+		if len(b) < offset+1 || len(b) < offset+1+int(b[offset]) {
+			return "", 0, errors.New("domain address is too short")
+		}
+		host = string(b[offset+1 : offset+1+int(b[offset])])
+		offset += 1 + int(b[offset])
+	default:
+		return "", 0, fmt.Errorf("unknown address type %d", b[0])
+	}
+	if len(b) < offset+2 {
+		return "", 0, errors.New("port is missing")
+	}
+	port := binary.BigEndian.Uint16(b[offset:])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), offset + 2, nil
+}
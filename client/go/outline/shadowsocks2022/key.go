@@ -0,0 +1,128 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shadowsocks2022 implements the client side of the Shadowsocks 2022 Edition protocol
+// (SIP022) for the AES-GCM ciphers. See https://github.com/Shadowsocks-NET/shadowsocks-specs.
+package shadowsocks2022
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"lukechampine.com/blake3"
+)
+
+const (
+	CipherAES128GCM = "2022-blake3-aes-128-gcm"
+	CipherAES256GCM = "2022-blake3-aes-256-gcm"
+)
+
+const (
+	headerTypeClientStream = 0
+	headerTypeServerStream = 1
+
+	headerTypeClientPacket = 0
+	headerTypeServerPacket = 1
+
+	// maxTimestampDifference is the maximum allowed difference between the timestamp in a
+	// header and the local time. Larger differences are treated as replays.
+	maxTimestampDifference = 30 * time.Second
+
+	sessionSubkeyContext = "shadowsocks 2022 session subkey"
+)
+
+// timeNow is replaceable in tests.
+var timeNow = time.Now
+
+// IsCipher reports whether the cipher name refers to a Shadowsocks 2022 cipher.
+// It doesn't mean the cipher is supported.
+func IsCipher(cipherName string) bool {
+	return strings.HasPrefix(strings.ToLower(cipherName), "2022-")
+}
+
+// Key is a Shadowsocks 2022 pre-shared key (PSK) for a given cipher.
+type Key struct {
+	psk   []byte
+	block cipher.Block
+}
+
+// NewKey creates a [Key] from the cipher name and the base64-encoded PSK.
+func NewKey(cipherName string, psk string) (*Key, error) {
+	var keySize int
+	switch strings.ToLower(cipherName) {
+	case CipherAES128GCM:
+		keySize = 16
+	case CipherAES256GCM:
+		keySize = 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher %v", cipherName)
+	}
+	if strings.Contains(psk, ":") {
+		return nil, fmt.Errorf("multi-user identity keys are not supported")
+	}
+	pskBytes, err := base64.StdEncoding.DecodeString(psk)
+	if err != nil {
+		return nil, fmt.Errorf("pre-shared key is not valid base64: %w", err)
+	}
+	if len(pskBytes) != keySize {
+		return nil, fmt.Errorf("pre-shared key must be %d bytes for %v, found %d", keySize, cipherName, len(pskBytes))
+	}
+	block, err := aes.NewCipher(pskBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{psk: pskBytes, block: block}, nil
+}
+
+// SaltSize is the size of the salt for stream connections, which is the same as the key size.
+func (k *Key) SaltSize() int {
+	return len(k.psk)
+}
+
+// sessionAEAD returns the AEAD for the session identified by the given salt or session ID.
+func (k *Key) sessionAEAD(salt []byte) (cipher.AEAD, error) {
+	material := make([]byte, 0, len(k.psk)+len(salt))
+	material = append(material, k.psk...)
+	material = append(material, salt...)
+	subkey := make([]byte, len(k.psk))
+	blake3.DeriveKey(subkey, sessionSubkeyContext, material)
+	block, err := aes.NewCipher(subkey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// checkTimestamp returns an error if the Unix timestamp is too far from the local time.
+func checkTimestamp(timestamp uint64) error {
+	diff := timeNow().Sub(time.Unix(int64(timestamp), 0))
+	if diff > maxTimestampDifference || diff < -maxTimestampDifference {
+		return fmt.Errorf("timestamp is off by %v", diff)
+	}
+	return nil
+}
+
+// incrementNonce increments the little-endian nonce counter.
+func incrementNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks2022
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+const (
+	// separateHeaderSize is the size of the AES-encrypted packet header: session ID (8) and packet ID (8).
+	separateHeaderSize = aes.BlockSize
+	// replayWindowSize is the number of packet IDs tracked for replay protection.
+	replayWindowSize = 64
+	// maxPacketSize is the maximum size of a UDP packet.
+	maxPacketSize = 65535
+)
+
+type packetListener struct {
+	endpoint transport.PacketEndpoint
+	key      *Key
+}
+
+var _ transport.PacketListener = (*packetListener)(nil)
+
+// NewPacketListener creates a [transport.PacketListener] that relays packets through the
+// Shadowsocks 2022 server at the given endpoint. Each packet connection is a new session.
+func NewPacketListener(endpoint transport.PacketEndpoint, key *Key) (transport.PacketListener, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if key == nil {
+		return nil, errors.New("argument key must not be nil")
+	}
+	return &packetListener{endpoint: endpoint, key: key}, nil
+}
+
+func (l *packetListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	var sessionID [8]byte
+	if _, err := rand.Read(sessionID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	aead, err := l.key.sessionAEAD(sessionID[:])
+	if err != nil {
+		return nil, err
+	}
+	proxyConn, err := l.endpoint.ConnectPacket(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to endpoint: %w", err)
+	}
+	return &packetConn{
+		Conn:      proxyConn,
+		key:       l.key,
+		sessionID: binary.BigEndian.Uint64(sessionID[:]),
+		aead:      aead,
+	}, nil
+}
+
+type packetConn struct {
+	net.Conn
+	key       *Key
+	sessionID uint64
+	aead      cipher.AEAD
+
+	writeMu  sync.Mutex
+	packetID uint64
+
+	readMu sync.Mutex
+	// The server may change its session ID, so we keep the current and the previous sessions.
+	serverSession     *serverSession
+	prevServerSession *serverSession
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+// serverSession holds the state of a server session.
+type serverSession struct {
+	id     uint64
+	aead   cipher.AEAD
+	filter replayFilter
+}
+
+// WriteTo encrypts b and sends it to the server, to be relayed to addr.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	packet := make([]byte, separateHeaderSize, separateHeaderSize+1+8+2+1+255+2+len(b)+c.aead.Overhead())
+	binary.BigEndian.PutUint64(packet, c.sessionID)
+	binary.BigEndian.PutUint64(packet[8:], c.packetID)
+	nonce := append([]byte(nil), packet[4:separateHeaderSize]...)
+
+	body := make([]byte, 0, 1+8+2+1+255+2+len(b))
+	body = append(body, headerTypeClientPacket)
+	body = binary.BigEndian.AppendUint64(body, uint64(timeNow().Unix()))
+	// No padding.
+	body = binary.BigEndian.AppendUint16(body, 0)
+	body, err := appendSOCKSAddr(body, addr.String())
+	if err != nil {
+		return 0, fmt.Errorf("invalid address: %w", err)
+	}
+	body = append(body, b...)
+
+	packet = c.aead.Seal(packet, nonce, body, nil)
+	c.key.block.Encrypt(packet[:separateHeaderSize], packet[:separateHeaderSize])
+	c.packetID++
+
+	if _, err := c.Conn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads a packet from the server, decrypts it and returns the payload and its source address.
+// Invalid packets are dropped.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		payload, addr, err := c.unpack(buf[:n])
+		if err != nil {
+			// Drop the packet.
+			continue
+		}
+		return copy(b, payload), addr, nil
+	}
+}
+
+// unpack decrypts and validates a server packet.
+func (c *packetConn) unpack(packet []byte) ([]byte, net.Addr, error) {
+	if len(packet) < separateHeaderSize+c.aead.Overhead() {
+		return nil, nil, errors.New("packet is too short")
+	}
+	header := packet[:separateHeaderSize]
+	c.key.block.Decrypt(header, header)
+	serverSessionID := binary.BigEndian.Uint64(header)
+	packetID := binary.BigEndian.Uint64(header[8:])
+
+	session, err := c.getServerSession(serverSessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := session.aead.Open(packet[separateHeaderSize:separateHeaderSize], header[4:], packet[separateHeaderSize:], nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt packet: %w", err)
+	}
+
+	// Header: type (1), timestamp (8), client session ID (8), padding length (2).
+	if len(body) < 1+8+8+2 {
+		return nil, nil, errors.New("packet header is too short")
+	}
+	if body[0] != headerTypeServerPacket {
+		return nil, nil, fmt.Errorf("invalid packet header type %d", body[0])
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(body[1:])); err != nil {
+		return nil, nil, err
+	}
+	if binary.BigEndian.Uint64(body[9:]) != c.sessionID {
+		return nil, nil, errors.New("packet is for a different client session")
+	}
+	offset := 1 + 8 + 8 + 2 + int(binary.BigEndian.Uint16(body[17:]))
+	if offset > len(body) {
+		return nil, nil, errors.New("padding is too long")
+	}
+	address, addrLen, err := parseSOCKSAddr(body[offset:])
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only record the packet ID and the session once the packet is authenticated.
+	if !session.filter.add(packetID) {
+		return nil, nil, errors.New("replayed packet")
+	}
+	c.addServerSession(session)
+	return body[offset+addrLen:], newAddr(address), nil
+}
+
+// getServerSession returns the known server session with the given ID, or a new one that is only
+// recorded by addServerSession once a packet is authenticated with it.
+func (c *packetConn) getServerSession(id uint64) (*serverSession, error) {
+	if c.serverSession != nil && c.serverSession.id == id {
+		return c.serverSession, nil
+	}
+	if c.prevServerSession != nil && c.prevServerSession.id == id {
+		return c.prevServerSession, nil
+	}
+	var idBytes [8]byte
+	binary.BigEndian.PutUint64(idBytes[:], id)
+	aead, err := c.key.sessionAEAD(idBytes[:])
+	if err != nil {
+		return nil, err
+	}
+	return &serverSession{id: id, aead: aead}, nil
+}
+
+// addServerSession records session as the current server session, if it's new.
+func (c *packetConn) addServerSession(session *serverSession) {
+	if session == c.serverSession || session == c.prevServerSession {
+		return
+	}
+	c.prevServerSession = c.serverSession
+	c.serverSession = session
+}
+
+// newAddr returns a [net.UDPAddr] for IP addresses, and a generic [net.Addr] for domain names.
+func newAddr(address string) net.Addr {
+	if addrPort, err := netip.ParseAddrPort(address); err == nil {
+		return net.UDPAddrFromAddrPort(addrPort)
+	}
+	return domainAddr(address)
+}
+
+type domainAddr string
+
+func (a domainAddr) Network() string { return "udp" }
+func (a domainAddr) String() string  { return string(a) }
+
+// replayFilter is a sliding window filter of packet IDs.
+type replayFilter struct {
+	// last is the highest packet ID seen plus one, so that zero means no packets.
+	last   uint64
+	window uint64
+}
+
+// add records the packet ID and reports whether it's new.
+func (f *replayFilter) add(packetID uint64) bool {
+	next := packetID + 1
+	if next > f.last {
+		shift := next - f.last
+		if shift >= replayWindowSize {
+			f.window = 0
+		} else {
+			f.window <<= shift
+		}
+		f.window |= 1
+		f.last = next
+		return true
+	}
+	age := f.last - next
+	if age >= replayWindowSize {
+		return false
+	}
+	mask := uint64(1) << age
+	if f.window&mask != 0 {
+		return false
+	}
+	f.window |= mask
+	return true
+}
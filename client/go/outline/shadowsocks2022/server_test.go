@@ -0,0 +1,282 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks2022
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is a minimal in-process Shadowsocks 2022 server that relays TCP and UDP traffic
+// to the requested destinations.
+type testServer struct {
+	key       *Key
+	listener  net.Listener
+	udpConn   net.PacketConn
+	seenSalts sync.Map
+	// clockSkew is added to the timestamps in the server headers.
+	clockSkew time.Duration
+}
+
+func startTestServer(t *testing.T, key *Key, clockSkew time.Duration) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { udpConn.Close() })
+
+	server := &testServer{key: key, listener: listener, udpConn: udpConn, clockSkew: clockSkew}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				server.handleStream(conn)
+			}()
+		}
+	}()
+	go server.serveUDP()
+	return server
+}
+
+func (s *testServer) timestamp() uint64 {
+	return uint64(timeNow().Add(s.clockSkew).Unix())
+}
+
+type testAEADReader struct {
+	reader io.Reader
+	aead   cipher.AEAD
+	nonce  []byte
+}
+
+func (r *testAEADReader) readChunk(size int) ([]byte, error) {
+	buf := make([]byte, size+r.aead.Overhead())
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return nil, err
+	}
+	plaintext, err := r.aead.Open(buf[:0], r.nonce, buf, nil)
+	incrementNonce(r.nonce)
+	return plaintext, err
+}
+
+type testAEADWriter struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func (w *testAEADWriter) seal(dst []byte, plaintext []byte) []byte {
+	dst = w.aead.Seal(dst, w.nonce, plaintext, nil)
+	incrementNonce(w.nonce)
+	return dst
+}
+
+func (s *testServer) handleStream(conn net.Conn) error {
+	requestSalt := make([]byte, s.key.SaltSize())
+	if _, err := io.ReadFull(conn, requestSalt); err != nil {
+		return err
+	}
+	if _, replayed := s.seenSalts.LoadOrStore(string(requestSalt), true); replayed {
+		return errors.New("replayed salt")
+	}
+	aead, err := s.key.sessionAEAD(requestSalt)
+	if err != nil {
+		return err
+	}
+	reader := &testAEADReader{reader: conn, aead: aead, nonce: make([]byte, aead.NonceSize())}
+
+	fixedHeader, err := reader.readChunk(1 + 8 + 2)
+	if err != nil {
+		return err
+	}
+	if fixedHeader[0] != headerTypeClientStream {
+		return fmt.Errorf("invalid header type %d", fixedHeader[0])
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(fixedHeader[1:])); err != nil {
+		return err
+	}
+	varHeader, err := reader.readChunk(int(binary.BigEndian.Uint16(fixedHeader[9:])))
+	if err != nil {
+		return err
+	}
+	address, addrLen, err := parseSOCKSAddr(varHeader)
+	if err != nil {
+		return err
+	}
+	paddingSize := int(binary.BigEndian.Uint16(varHeader[addrLen:]))
+	initialPayload := varHeader[addrLen+2+paddingSize:]
+	if len(initialPayload) == 0 && paddingSize == 0 {
+		return errors.New("missing padding")
+	}
+
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	if _, err := target.Write(initialPayload); err != nil {
+		return err
+	}
+
+	// Client to target.
+	go func() {
+		for {
+			lengthBytes, err := reader.readChunk(2)
+			if err != nil {
+				target.(*net.TCPConn).CloseWrite()
+				return
+			}
+			payload, err := reader.readChunk(int(binary.BigEndian.Uint16(lengthBytes)))
+			if err != nil {
+				return
+			}
+			target.Write(payload)
+		}
+	}()
+
+	// Target to client.
+	responseSalt := make([]byte, s.key.SaltSize())
+	rand.Read(responseSalt)
+	responseAEAD, err := s.key.sessionAEAD(responseSalt)
+	if err != nil {
+		return err
+	}
+	writer := &testAEADWriter{aead: responseAEAD, nonce: make([]byte, responseAEAD.NonceSize())}
+	buf := make([]byte, maxPayloadSize)
+	headerSent := false
+	for {
+		n, err := target.Read(buf)
+		if n > 0 {
+			var out []byte
+			if !headerSent {
+				header := []byte{headerTypeServerStream}
+				header = binary.BigEndian.AppendUint64(header, s.timestamp())
+				header = append(header, requestSalt...)
+				header = binary.BigEndian.AppendUint16(header, uint16(n))
+				out = append(out, responseSalt...)
+				out = writer.seal(out, header)
+				out = writer.seal(out, buf[:n])
+				headerSent = true
+			} else {
+				out = writer.seal(out, binary.BigEndian.AppendUint16(nil, uint16(n)))
+				out = writer.seal(out, buf[:n])
+			}
+			if _, err := conn.Write(out); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// serveUDP relays packets for a single client session. The server session ID is fixed.
+func (s *testServer) serveUDP() {
+	var serverSessionID [8]byte
+	rand.Read(serverSessionID[:])
+	serverAEAD, err := s.key.sessionAEAD(serverSessionID[:])
+	if err != nil {
+		return
+	}
+	var serverPacketID uint64
+
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer target.Close()
+
+	var mu sync.Mutex
+	var clientAddr net.Addr
+	var clientSessionID uint64
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, srcAddr, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			header := make([]byte, separateHeaderSize)
+			copy(header, serverSessionID[:])
+			binary.BigEndian.PutUint64(header[8:], serverPacketID)
+			serverPacketID++
+			body := []byte{headerTypeServerPacket}
+			body = binary.BigEndian.AppendUint64(body, s.timestamp())
+			mu.Lock()
+			body = binary.BigEndian.AppendUint64(body, clientSessionID)
+			dstAddr := clientAddr
+			mu.Unlock()
+			body = binary.BigEndian.AppendUint16(body, 3)
+			body = append(body, 0, 0, 0)
+			body, _ = appendSOCKSAddr(body, srcAddr.String())
+			body = append(body, buf[:n]...)
+			packet := serverAEAD.Seal(bytes.Clone(header), header[4:], body, nil)
+			s.key.block.Encrypt(packet[:separateHeaderSize], packet[:separateHeaderSize])
+			s.udpConn.WriteTo(packet, dstAddr)
+		}
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packet := buf[:n]
+		header := packet[:separateHeaderSize]
+		s.key.block.Decrypt(header, header)
+		sessionID := header[:8]
+		aead, err := s.key.sessionAEAD(sessionID)
+		if err != nil {
+			continue
+		}
+		body, err := aead.Open(nil, header[4:], packet[separateHeaderSize:], nil)
+		if err != nil || body[0] != headerTypeClientPacket {
+			continue
+		}
+		if checkTimestamp(binary.BigEndian.Uint64(body[1:])) != nil {
+			continue
+		}
+		offset := 1 + 8 + 2 + int(binary.BigEndian.Uint16(body[9:]))
+		address, addrLen, err := parseSOCKSAddr(body[offset:])
+		if err != nil {
+			continue
+		}
+		dst, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			continue
+		}
+		mu.Lock()
+		clientAddr = addr
+		clientSessionID = binary.BigEndian.Uint64(sessionID)
+		mu.Unlock()
+		target.WriteTo(body[offset+addrLen:], dst)
+	}
+}
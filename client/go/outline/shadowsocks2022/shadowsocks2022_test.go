@@ -0,0 +1,277 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks2022
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(t *testing.T, cipherName string, size int) *Key {
+	psk := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", size)))
+	key, err := NewKey(cipherName, psk)
+	require.NoError(t, err)
+	return key
+}
+
+func startTCPEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func startUDPEchoServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func TestNewKey(t *testing.T) {
+	_, err := NewKey(CipherAES128GCM, base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	require.NoError(t, err)
+	_, err = NewKey(CipherAES256GCM, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+
+	_, err = NewKey(CipherAES256GCM, base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	require.ErrorContains(t, err, "pre-shared key must be 32 bytes")
+	_, err = NewKey(CipherAES128GCM, "not base64!")
+	require.ErrorContains(t, err, "pre-shared key is not valid base64")
+	_, err = NewKey("2022-blake3-chacha20-poly1305", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.ErrorContains(t, err, "unsupported cipher")
+	_, err = NewKey(CipherAES128GCM, "AAAAAAAAAAAAAAAAAAAAAA==:AAAAAAAAAAAAAAAAAAAAAA==")
+	require.ErrorContains(t, err, "multi-user identity keys are not supported")
+}
+
+func TestIsCipher(t *testing.T) {
+	require.True(t, IsCipher(CipherAES128GCM))
+	require.True(t, IsCipher("2022-BLAKE3-AES-256-GCM"))
+	require.True(t, IsCipher("2022-blake3-chacha20-poly1305"))
+	require.False(t, IsCipher("chacha20-ietf-poly1305"))
+}
+
+func TestStreamDialer(t *testing.T) {
+	for _, tc := range []struct {
+		cipher string
+		size   int
+	}{{CipherAES128GCM, 16}, {CipherAES256GCM, 32}} {
+		t.Run(tc.cipher, func(t *testing.T) {
+			key := newTestKey(t, tc.cipher, tc.size)
+			server := startTestServer(t, key, 0)
+			echoServer := startTCPEchoServer(t)
+
+			dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: server.listener.Addr().String()}, key)
+			require.NoError(t, err)
+			conn, err := dialer.DialStream(context.Background(), echoServer.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			// The first write goes with the header, the second one in its own chunk.
+			large := []byte(strings.Repeat("x", 2*maxPayloadSize))
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			_, err = conn.Write(large)
+			require.NoError(t, err)
+
+			buf := make([]byte, 5+len(large))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(buf[:5]))
+			require.Equal(t, large, buf[5:])
+
+			require.NoError(t, conn.CloseWrite())
+			_, err = conn.Read(buf)
+			require.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestStreamDialer_ReadFirst(t *testing.T) {
+	key := newTestKey(t, CipherAES256GCM, 32)
+	server := startTestServer(t, key, 0)
+
+	// A server that speaks first.
+	greeter, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer greeter.Close()
+	go func() {
+		conn, err := greeter.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("welcome"))
+	}()
+
+	dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: server.listener.Addr().String()}, key)
+	require.NoError(t, err)
+	conn, err := dialer.DialStream(context.Background(), greeter.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Reading must send the header with padding.
+	buf := make([]byte, 7)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "welcome", string(buf))
+}
+
+func TestStreamDialer_WrongKey(t *testing.T) {
+	server := startTestServer(t, newTestKey(t, CipherAES256GCM, 32), 0)
+	echoServer := startTCPEchoServer(t)
+
+	wrongKey, err := NewKey(CipherAES256GCM, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	require.NoError(t, err)
+	dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: server.listener.Addr().String()}, wrongKey)
+	require.NoError(t, err)
+	conn, err := dialer.DialStream(context.Background(), echoServer.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 5))
+	require.Error(t, err)
+}
+
+func TestStreamDialer_StaleResponse(t *testing.T) {
+	key := newTestKey(t, CipherAES256GCM, 32)
+	server := startTestServer(t, key, -time.Minute)
+	echoServer := startTCPEchoServer(t)
+
+	dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: server.listener.Addr().String()}, key)
+	require.NoError(t, err)
+	conn, err := dialer.DialStream(context.Background(), echoServer.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 5))
+	require.ErrorContains(t, err, "timestamp is off by")
+}
+
+func TestPacketListener(t *testing.T) {
+	for _, tc := range []struct {
+		cipher string
+		size   int
+	}{{CipherAES128GCM, 16}, {CipherAES256GCM, 32}} {
+		t.Run(tc.cipher, func(t *testing.T) {
+			key := newTestKey(t, tc.cipher, tc.size)
+			server := startTestServer(t, key, 0)
+			echoServer := startUDPEchoServer(t)
+
+			listener, err := NewPacketListener(&transport.UDPEndpoint{Address: server.udpConn.LocalAddr().String()}, key)
+			require.NoError(t, err)
+			conn, err := listener.ListenPacket(context.Background())
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			for _, msg := range []string{"ping", "pong"} {
+				_, err = conn.WriteTo([]byte(msg), echoServer.LocalAddr())
+				require.NoError(t, err)
+				buf := make([]byte, 16)
+				n, addr, err := conn.ReadFrom(buf)
+				require.NoError(t, err)
+				require.Equal(t, msg, string(buf[:n]))
+				require.Equal(t, echoServer.LocalAddr().String(), addr.String())
+			}
+		})
+	}
+}
+
+func TestPacketListener_StaleResponse(t *testing.T) {
+	key := newTestKey(t, CipherAES256GCM, 32)
+	server := startTestServer(t, key, -time.Minute)
+	echoServer := startUDPEchoServer(t)
+
+	listener, err := NewPacketListener(&transport.UDPEndpoint{Address: server.udpConn.LocalAddr().String()}, key)
+	require.NoError(t, err)
+	conn, err := listener.ListenPacket(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.WriteTo([]byte("ping"), echoServer.LocalAddr())
+	require.NoError(t, err)
+	// The stale packet is dropped.
+	conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err = conn.ReadFrom(make([]byte, 16))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+	// The server session is only recorded for valid packets.
+	require.Nil(t, conn.(*packetConn).serverSession)
+}
+
+func TestReplayFilter(t *testing.T) {
+	var filter replayFilter
+	require.True(t, filter.add(0))
+	require.False(t, filter.add(0))
+	require.True(t, filter.add(2))
+	require.True(t, filter.add(1))
+	require.False(t, filter.add(1))
+	require.True(t, filter.add(100))
+	// Too old.
+	require.False(t, filter.add(100-replayWindowSize))
+	require.True(t, filter.add(100-replayWindowSize+1))
+	require.False(t, filter.add(100-replayWindowSize+1))
+}
+
+func TestSOCKSAddr(t *testing.T) {
+	for _, address := range []string{"192.0.2.1:53", "[2001:db8::1]:443", "example.com:80"} {
+		encoded, err := appendSOCKSAddr(nil, address)
+		require.NoError(t, err)
+		decoded, n, err := parseSOCKSAddr(append(encoded, 0xff))
+		require.NoError(t, err)
+		require.Equal(t, address, decoded)
+		require.Equal(t, len(encoded), n)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks2022
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/transport/shadowsocks"
+)
+
+const (
+	// maxPayloadSize is the maximum size of a payload chunk.
+	maxPayloadSize = 0xFFFF
+	// maxPaddingSize is the maximum size of the padding in the request header.
+	maxPaddingSize = 900
+)
+
+// StreamDialer is a [transport.StreamDialer] that connects to a Shadowsocks 2022 server.
+type StreamDialer struct {
+	endpoint transport.StreamEndpoint
+	key      *Key
+
+	// SaltGenerator is used by Shadowsocks to generate the connection salts.
+	// If nil, it uses a random generator.
+	SaltGenerator shadowsocks.SaltGenerator
+}
+
+// NewStreamDialer creates a [StreamDialer] that connects to the Shadowsocks 2022 server at the given endpoint.
+func NewStreamDialer(endpoint transport.StreamEndpoint, key *Key) (*StreamDialer, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if key == nil {
+		return nil, errors.New("argument key must not be nil")
+	}
+	return &StreamDialer{endpoint: endpoint, key: key}, nil
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// DialStream connects to the server and returns a connection to the given address.
+// The request header is sent along with the first write, or before the first read if
+// there are no writes, so that it's not sent in a packet of its own.
+func (d *StreamDialer) DialStream(ctx context.Context, address string) (transport.StreamConn, error) {
+	target, err := appendSOCKSAddr(nil, address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+	salt := make([]byte, d.key.SaltSize())
+	if d.SaltGenerator != nil {
+		err = d.SaltGenerator.GetSalt(salt)
+	} else {
+		_, err = rand.Read(salt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := d.key.sessionAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.endpoint.ConnectStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := &streamWriter{
+		conn:   conn,
+		aead:   aead,
+		nonce:  make([]byte, aead.NonceSize()),
+		salt:   salt,
+		target: target,
+	}
+	r := &streamReader{
+		reader:      conn,
+		key:         d.key,
+		requestSalt: salt,
+	}
+	return &streamConn{StreamConn: conn, reader: r, writer: w}, nil
+}
+
+type streamConn struct {
+	transport.StreamConn
+	reader *streamReader
+	writer *streamWriter
+}
+
+var _ transport.StreamConn = (*streamConn)(nil)
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	if err := c.writer.flushHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	return c.writer.Write(b)
+}
+
+func (c *streamConn) CloseWrite() error {
+	if err := c.writer.flushHeader(); err != nil {
+		return err
+	}
+	return c.StreamConn.CloseWrite()
+}
+
+// streamWriter encrypts the client stream.
+type streamWriter struct {
+	conn   io.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	salt   []byte
+	target []byte
+
+	mu         sync.Mutex
+	headerSent bool
+}
+
+func (w *streamWriter) seal(dst []byte, plaintext []byte) []byte {
+	dst = w.aead.Seal(dst, w.nonce, plaintext, nil)
+	incrementNonce(w.nonce)
+	return dst
+}
+
+// appendHeader appends the salt, the fixed-length header and the variable-length header to dst.
+// The variable-length header carries as much of the payload as it fits, and random padding if there's no payload.
+// It returns the payload bytes consumed.
+func (w *streamWriter) appendHeader(dst []byte, payload []byte) ([]byte, int, error) {
+	varHeader := bytes.Clone(w.target)
+	paddingSize := 0
+	if len(payload) == 0 {
+		n, err := rand.Int(rand.Reader, big.NewInt(maxPaddingSize))
+		if err != nil {
+			return nil, 0, err
+		}
+		paddingSize = int(n.Int64()) + 1
+	}
+	varHeader = binary.BigEndian.AppendUint16(varHeader, uint16(paddingSize))
+	varHeader = append(varHeader, make([]byte, paddingSize)...)
+	consumed := min(len(payload), maxPayloadSize-len(varHeader))
+	varHeader = append(varHeader, payload[:consumed]...)
+
+	fixedHeader := make([]byte, 0, 1+8+2)
+	fixedHeader = append(fixedHeader, headerTypeClientStream)
+	fixedHeader = binary.BigEndian.AppendUint64(fixedHeader, uint64(timeNow().Unix()))
+	fixedHeader = binary.BigEndian.AppendUint16(fixedHeader, uint16(len(varHeader)))
+
+	dst = append(dst, w.salt...)
+	dst = w.seal(dst, fixedHeader)
+	dst = w.seal(dst, varHeader)
+	return dst, consumed, nil
+}
+
+// flushHeader sends the request header with no payload, if it hasn't been sent yet.
+func (w *streamWriter) flushHeader() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.headerSent {
+		return nil
+	}
+	buf, _, err := w.appendHeader(nil, nil)
+	if err != nil {
+		return err
+	}
+	w.headerSent = true
+	_, err = w.conn.Write(buf)
+	return err
+}
+
+func (w *streamWriter) Write(payload []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var buf []byte
+	consumed := 0
+	if !w.headerSent {
+		var err error
+		buf, consumed, err = w.appendHeader(nil, payload)
+		if err != nil {
+			return 0, err
+		}
+		w.headerSent = true
+	}
+	for chunkStart := consumed; chunkStart < len(payload); chunkStart += maxPayloadSize {
+		chunk := payload[chunkStart:min(chunkStart+maxPayloadSize, len(payload))]
+		buf = w.seal(buf, binary.BigEndian.AppendUint16(nil, uint16(len(chunk))))
+		buf = w.seal(buf, chunk)
+	}
+	if _, err := w.conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// streamReader decrypts the server stream.
+type streamReader struct {
+	reader      io.Reader
+	key         *Key
+	requestSalt []byte
+
+	aead     cipher.AEAD
+	nonce    []byte
+	leftover []byte
+}
+
+// readChunk reads and decrypts a chunk of the given plaintext size.
+func (r *streamReader) readChunk(size int) ([]byte, error) {
+	buf := make([]byte, size+r.aead.Overhead())
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return nil, err
+	}
+	plaintext, err := r.aead.Open(buf[:0], r.nonce, buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk: %w", err)
+	}
+	incrementNonce(r.nonce)
+	return plaintext, nil
+}
+
+// readResponseHeader reads the salt, the response header and the first payload chunk.
+func (r *streamReader) readResponseHeader() ([]byte, error) {
+	salt := make([]byte, r.key.SaltSize())
+	if _, err := io.ReadFull(r.reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := r.key.sessionAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	r.aead = aead
+	r.nonce = make([]byte, aead.NonceSize())
+
+	header, err := r.readChunk(1 + 8 + len(r.requestSalt) + 2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response header: %w", err)
+	}
+	if header[0] != headerTypeServerStream {
+		return nil, fmt.Errorf("invalid response header type %d", header[0])
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(header[1:])); err != nil {
+		return nil, fmt.Errorf("invalid response header: %w", err)
+	}
+	if !bytes.Equal(header[9:9+len(r.requestSalt)], r.requestSalt) {
+		return nil, errors.New("response header doesn't match the request salt")
+	}
+	length := binary.BigEndian.Uint16(header[9+len(r.requestSalt):])
+	return r.readChunk(int(length))
+}
+
+func (r *streamReader) Read(b []byte) (int, error) {
+	for len(r.leftover) == 0 {
+		var err error
+		if r.aead == nil {
+			r.leftover, err = r.readResponseHeader()
+		} else {
+			var lengthBytes []byte
+			lengthBytes, err = r.readChunk(2)
+			if err == nil {
+				r.leftover, err = r.readChunk(int(binary.BigEndian.Uint16(lengthBytes)))
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, r.leftover)
+	r.leftover = r.leftover[n:]
+	return n, nil
+}
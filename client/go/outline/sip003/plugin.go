@@ -0,0 +1,223 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sip003 runs Shadowsocks plugins as specified by
+// [SIP003](https://shadowsocks.org/doc/sip003.html).
+//
+// A plugin is a separate process that listens on a local address and relays the connections to
+// the Shadowsocks server, transforming the traffic to evade censorship (e.g. obfs-local, v2ray-plugin).
+// The Shadowsocks client connects to the local address instead of the server.
+package sip003
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// startTimeout is how long to wait for the plugin to start listening.
+const startTimeout = 5 * time.Second
+
+// knownPlugins are the plugin executables that can be run. The plugin names come from access keys, so
+// they are restricted to known plugins, which are looked up in the PATH, instead of any executable path.
+// Plugins that connect to the server over UDP, like kcptun, are not supported, since the relay to the
+// server is TCP only.
+var knownPlugins = []string{"obfs-local", "v2ray-plugin", "xray-plugin", "ck-client", "gost-plugin"}
+
+// Plugin is a Shadowsocks plugin that relays the connections to a remote server. It implements
+// [transport.StreamEndpoint], connecting to the plugin process.
+//
+// The plugin doesn't connect to the server directly, but to a local relay that connects to the server with
+// the remote endpoint. That way the plugin traffic uses the client dialers, which can be protected from the VPN,
+// instead of looping back into the tunnel.
+type Plugin struct {
+	name    string
+	path    string
+	options string
+	remote  transport.StreamEndpoint
+
+	// startMu serializes the starts, so concurrent connections share one plugin process.
+	startMu sync.Mutex
+
+	mu sync.Mutex
+	// runCtx is the context of the last Run call. Processes started on demand stop when it's done.
+	runCtx context.Context
+	// stop stops the running process, if any.
+	stop  context.CancelFunc
+	local string
+}
+
+var _ transport.StreamEndpoint = (*Plugin)(nil)
+
+// NewPlugin creates a plugin that relays connections to the server at the remote endpoint. The name is
+// the plugin executable, which must be one of the known plugins, and options are the plugin options, in the
+// "key1=value1;key2=value2" format. It returns an error wrapping [errors.ErrUnsupported] if the plugin
+// is not known, or can't run on this platform. The plugin process is only started by [Plugin.Run] or
+// [Plugin.ConnectStream].
+func NewPlugin(name string, options string, remote transport.StreamEndpoint) (*Plugin, error) {
+	if name == "" {
+		return nil, errors.New("plugin name must not be empty")
+	}
+	if !slices.Contains(knownPlugins, name) {
+		return nil, fmt.Errorf("plugin %q is not supported: %w", name, errors.ErrUnsupported)
+	}
+	if remote == nil {
+		return nil, errors.New("remote endpoint must be provided")
+	}
+	path, err := findPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Plugin{name: name, path: path, options: options, remote: remote}, nil
+}
+
+// Run starts the plugin process for a session, and waits until it listens. The process is stopped when
+// ctx is done. If a process was started on demand before the session, it's replaced.
+func (p *Plugin) Run(ctx context.Context) error {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+	p.mu.Lock()
+	p.runCtx = ctx
+	if p.stop != nil {
+		p.stop()
+		p.stop = nil
+		p.local = ""
+	}
+	p.mu.Unlock()
+	_, err := p.start()
+	return err
+}
+
+// ConnectStream implements [transport.StreamEndpoint]. It starts the plugin process if it's not running, like
+// in a connectivity check before the session starts, or if the process exited. Without a session, the
+// process runs until it exits, or the app exits.
+func (p *Plugin) ConnectStream(ctx context.Context) (transport.StreamConn, error) {
+	p.startMu.Lock()
+	local, err := p.start()
+	p.startMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var dialer transport.TCPDialer
+	return dialer.DialStream(ctx, local)
+}
+
+// LocalAddress returns the local address the plugin listens on, or an empty string if it's not running.
+func (p *Plugin) LocalAddress() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.local
+}
+
+// start starts the plugin process if it's not running, and returns its local address. It must be called
+// with startMu held.
+func (p *Plugin) start() (string, error) {
+	p.mu.Lock()
+	local, ctx := p.local, p.runCtx
+	p.mu.Unlock()
+	if local != "" {
+		return local, nil
+	}
+	if ctx == nil || ctx.Err() != nil {
+		ctx = context.Background()
+	}
+	processCtx, stop := context.WithCancel(ctx)
+	local, err := p.startProcess(processCtx, func(exited string) {
+		stop()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.local == exited {
+			p.local = ""
+			p.stop = nil
+		}
+	})
+	if err != nil {
+		stop()
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The exit callback cancels processCtx before taking the lock, so an exit right after the start is seen here.
+	if processCtx.Err() != nil {
+		return "", fmt.Errorf("plugin %q exited", p.name)
+	}
+	p.local = local
+	p.stop = stop
+	return local, nil
+}
+
+// relay accepts the plugin connections to the server, and relays them through the remote endpoint until
+// the listener is closed.
+func (p *Plugin) relay(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			remoteConn, err := p.remote.ConnectStream(ctx)
+			if err != nil {
+				slog.Debug("failed to connect to the plugin server", "plugin", p.name, "err", err)
+				return
+			}
+			defer remoteConn.Close()
+			go func() {
+				io.Copy(remoteConn, conn)
+				remoteConn.CloseWrite()
+			}()
+			io.Copy(conn, remoteConn)
+			if tcpConn, ok := conn.(*net.TCPConn); ok {
+				tcpConn.CloseWrite()
+			}
+		}()
+	}
+}
+
+// pickLocalAddress returns a loopback address with a port that is currently free.
+func pickLocalAddress() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// waitForListener waits until address accepts connections.
+func waitForListener(ctx context.Context, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("plugin is not listening: %w", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip003
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// stopTimeout is how long to wait for the plugin to exit after SIGTERM before killing it.
+const stopTimeout = 2 * time.Second
+
+func findPlugin(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("plugin %q is not installed: %w", name, errors.ErrUnsupported)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find plugin %q: %w", name, err)
+	}
+	return path, nil
+}
+
+// startProcess starts the plugin process on a free local address, with a relay to the remote endpoint,
+// and waits until it listens. The process is stopped when ctx is done, and onExit is called with its
+// local address when it exits.
+func (p *Plugin) startProcess(ctx context.Context, onExit func(local string)) (string, error) {
+	relay, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for plugin relay: %w", err)
+	}
+	// The address is picked right before starting, to make it unlikely that the port is taken by then.
+	local, err := pickLocalAddress()
+	if err != nil {
+		relay.Close()
+		return "", fmt.Errorf("failed to pick local plugin address: %w", err)
+	}
+	remoteHost, remotePort, _ := net.SplitHostPort(relay.Addr().String())
+	localHost, localPort, _ := net.SplitHostPort(local)
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Env = append(os.Environ(),
+		"SS_REMOTE_HOST="+remoteHost,
+		"SS_REMOTE_PORT="+remotePort,
+		"SS_LOCAL_HOST="+localHost,
+		"SS_LOCAL_PORT="+localPort,
+		"SS_PLUGIN_OPTIONS="+p.options,
+	)
+	// Don't leave the plugin running if we crash.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = stopTimeout
+
+	started := make(chan error, 1)
+	exited := make(chan struct{})
+	go func() {
+		defer onExit(local)
+		defer close(exited)
+		defer relay.Close()
+		// Pdeathsig is sent when the thread that started the process exits, not the Go process. The thread
+		// stays locked to this goroutine until the plugin exits, and then exits with it.
+		runtime.LockOSThread()
+		if err := cmd.Start(); err != nil {
+			started <- err
+			return
+		}
+		started <- nil
+		go p.relay(ctx, relay)
+		err := cmd.Wait()
+		if ctx.Err() == nil {
+			slog.Warn("Shadowsocks plugin exited", "plugin", p.name, "err", err)
+		}
+	}()
+	if err := <-started; err != nil {
+		return "", fmt.Errorf("failed to start plugin %q: %w", p.name, err)
+	}
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-exited:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+	if err := waitForListener(waitCtx, local); err != nil {
+		select {
+		case <-exited:
+			return "", fmt.Errorf("plugin %q exited: %w", p.name, err)
+		default:
+			return "", fmt.Errorf("plugin %q failed to start: %w", p.name, err)
+		}
+	}
+	return local, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip003
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// testPluginEnv makes the test binary run as a plugin, see [runTestPlugin], or exit right away
+// if set to "exit".
+const testPluginEnv = "SIP003_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(testPluginEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "exit":
+		os.Exit(1)
+	default:
+		runTestPlugin()
+	}
+}
+
+// runTestPlugin is a plugin that sends the plugin options in a line at the start of each connection,
+// and then relays it to the remote server.
+func runTestPlugin() {
+	remote := net.JoinHostPort(os.Getenv("SS_REMOTE_HOST"), os.Getenv("SS_REMOTE_PORT"))
+	listener, err := net.Listen("tcp", net.JoinHostPort(os.Getenv("SS_LOCAL_HOST"), os.Getenv("SS_LOCAL_PORT")))
+	if err != nil {
+		os.Exit(1)
+	}
+	for {
+		clientConn, err := listener.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+		go func() {
+			defer clientConn.Close()
+			clientConn.Write([]byte(os.Getenv("SS_PLUGIN_OPTIONS") + "\n"))
+			remoteConn, err := net.Dial("tcp", remote)
+			if err != nil {
+				return
+			}
+			defer remoteConn.Close()
+			go io.Copy(remoteConn, clientConn)
+			io.Copy(clientConn, remoteConn)
+		}()
+	}
+}
+
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// installTestPlugin makes the test binary the only plugin in the PATH, as obfs-local.
+func installTestPlugin(t *testing.T) {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.Symlink(executable, filepath.Join(dir, "obfs-local")))
+	t.Setenv("PATH", dir)
+}
+
+// countingEndpoint is a remote endpoint that counts the connections.
+type countingEndpoint struct {
+	transport.TCPEndpoint
+	connects atomic.Int32
+}
+
+func (e *countingEndpoint) ConnectStream(ctx context.Context) (transport.StreamConn, error) {
+	e.connects.Add(1)
+	return e.TCPEndpoint.ConnectStream(ctx)
+}
+
+// requireEcho checks that conn goes through the test plugin to an echo server.
+func requireEcho(t *testing.T, conn net.Conn, wantOptions string) {
+	reader := bufio.NewReader(conn)
+	options, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, wantOptions+"\n", options)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	received := make([]byte, 5)
+	_, err = io.ReadFull(reader, received)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(received))
+}
+
+func TestPlugin_Run(t *testing.T) {
+	echo := startEchoServer(t)
+	installTestPlugin(t)
+	t.Setenv(testPluginEnv, "1")
+
+	remote := &countingEndpoint{TCPEndpoint: transport.TCPEndpoint{Address: echo.Addr().String()}}
+	plugin, err := NewPlugin("obfs-local", "obfs=http;obfs-host=example.com", remote)
+	require.NoError(t, err)
+	require.Equal(t, "", plugin.LocalAddress())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, plugin.Run(ctx))
+
+	conn, err := net.Dial("tcp", plugin.LocalAddress())
+	require.NoError(t, err)
+	defer conn.Close()
+	requireEcho(t, conn, "obfs=http;obfs-host=example.com")
+	// The plugin connects to the server through the remote endpoint.
+	require.NotZero(t, remote.connects.Load())
+
+	// The plugin stops when the context is done.
+	local := plugin.LocalAddress()
+	cancel()
+	require.Eventually(t, func() bool {
+		conn, err := net.DialTimeout("tcp", local, time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil && plugin.LocalAddress() == ""
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPlugin_ConnectOnDemand(t *testing.T) {
+	echo := startEchoServer(t)
+	installTestPlugin(t)
+	t.Setenv(testPluginEnv, "1")
+
+	plugin, err := NewPlugin("obfs-local", "obfs=tls", &transport.TCPEndpoint{Address: echo.Addr().String()})
+	require.NoError(t, err)
+
+	// Connect starts the plugin without a session, and concurrent connections share the process.
+	conns := make(chan transport.StreamConn, 2)
+	for range 2 {
+		go func() {
+			conn, err := plugin.ConnectStream(context.Background())
+			require.NoError(t, err)
+			conns <- conn
+		}()
+	}
+	first, second := <-conns, <-conns
+	defer first.Close()
+	defer second.Close()
+	requireEcho(t, first, "obfs=tls")
+	requireEcho(t, second, "obfs=tls")
+	local := plugin.LocalAddress()
+	require.NotEqual(t, "", local)
+	require.Equal(t, local, first.RemoteAddr().String())
+	require.Equal(t, local, second.RemoteAddr().String())
+
+	// A session replaces the process started on demand, and stops it when it ends.
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, plugin.Run(ctx))
+	require.NotEqual(t, local, plugin.LocalAddress())
+	cancel()
+	require.Eventually(t, func() bool {
+		return plugin.LocalAddress() == ""
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// It starts again on demand after the session.
+	conn, err := plugin.ConnectStream(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	requireEcho(t, conn, "obfs=tls")
+}
+
+func TestPlugin_RunExits(t *testing.T) {
+	installTestPlugin(t)
+	plugin, err := NewPlugin("obfs-local", "", &transport.TCPEndpoint{Address: "127.0.0.1:1"})
+	require.NoError(t, err)
+	t.Setenv(testPluginEnv, "exit")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = plugin.Run(ctx)
+	require.ErrorContains(t, err, "exited")
+	require.Equal(t, "", plugin.LocalAddress())
+	_, err = plugin.ConnectStream(ctx)
+	require.ErrorContains(t, err, "exited")
+}
+
+func TestNewPlugin_NotInstalled(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, err := NewPlugin("obfs-local", "", &transport.TCPEndpoint{Address: "example.com:443"})
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestNewPlugin_Unknown(t *testing.T) {
+	installTestPlugin(t)
+	for _, name := range []string{"sh", "/bin/sh", "./obfs-local", "../obfs-local", "bin/obfs-local", "kcptun"} {
+		_, err := NewPlugin(name, "", &transport.TCPEndpoint{Address: "example.com:443"})
+		require.ErrorIs(t, err, errors.ErrUnsupported, name)
+	}
+}
+
+func TestNewPlugin_Errors(t *testing.T) {
+	_, err := NewPlugin("", "", &transport.TCPEndpoint{Address: "example.com:443"})
+	require.Error(t, err)
+	installTestPlugin(t)
+	_, err = NewPlugin("obfs-local", "", nil)
+	require.ErrorContains(t, err, "remote endpoint")
+}
@@ -0,0 +1,33 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package sip003
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func findPlugin(name string) (string, error) {
+	return "", fmt.Errorf("shadowsocks plugins are not supported on %v: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+// startProcess is not supported on this platform.
+func (p *Plugin) startProcess(ctx context.Context, onExit func(local string)) (string, error) {
+	return "", errors.ErrUnsupported
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package split implements a [transport.StreamDialer] that splits the first bytes written
+// on a connection into multiple TCP segments, to evade Deep Packet Inspection.
+package split
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// StreamDialer is a [transport.StreamDialer] that splits the first writes on the connections it
+// creates into segments of the configured sizes, with a delay between each segment.
+type StreamDialer struct {
+	dialer transport.StreamDialer
+	sizes  []int
+	delay  time.Duration
+	writes int
+}
+
+// NewStreamDialer creates a new [StreamDialer]. For each of the first writes calls on a connection,
+// the data is written in segments of the given sizes, waiting delay between segments. Data past
+// the sum of the sizes is written in a final segment.
+func NewStreamDialer(dialer transport.StreamDialer, sizes []int, delay time.Duration, writes int) (*StreamDialer, error) {
+	if dialer == nil {
+		return nil, errors.New("dialer must not be nil")
+	}
+	if len(sizes) == 0 {
+		return nil, errors.New("sizes must not be empty")
+	}
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("segment size must be positive, found %d", size)
+		}
+	}
+	if delay < 0 {
+		return nil, fmt.Errorf("delay must not be negative, found %v", delay)
+	}
+	if writes <= 0 {
+		return nil, fmt.Errorf("writes must be positive, found %d", writes)
+	}
+	return &StreamDialer{
+		dialer: dialer,
+		sizes:  append([]int(nil), sizes...),
+		delay:  delay,
+		writes: writes,
+	}, nil
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// DialStream dials the address with the base dialer and wraps the connection to split the first writes.
+func (d *StreamDialer) DialStream(ctx context.Context, address string) (transport.StreamConn, error) {
+	conn, err := d.dialer.DialStream(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return &splitConn{StreamConn: conn, dialer: d, writesLeft: d.writes}, nil
+}
+
+type splitConn struct {
+	transport.StreamConn
+	dialer *StreamDialer
+
+	mu         sync.Mutex
+	writesLeft int
+}
+
+var _ transport.StreamConn = (*splitConn)(nil)
+
+func (c *splitConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writesLeft == 0 {
+		return c.StreamConn.Write(b)
+	}
+	c.writesLeft--
+
+	written := 0
+	for _, size := range c.dialer.sizes {
+		if written == len(b) {
+			return written, nil
+		}
+		if written > 0 && c.dialer.delay > 0 {
+			time.Sleep(c.dialer.delay)
+		}
+		n, err := c.StreamConn.Write(b[written:min(written+size, len(b))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	if written == len(b) {
+		return written, nil
+	}
+	if c.dialer.delay > 0 {
+		time.Sleep(c.dialer.delay)
+	}
+	n, err := c.StreamConn.Write(b[written:])
+	return written + n, err
+}
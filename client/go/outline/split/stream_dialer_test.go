@@ -0,0 +1,130 @@
+// Copyright 2025 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package split
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingConn is a [transport.StreamConn] that records each write call.
+type recordingConn struct {
+	net.Conn
+	writes [][]byte
+	times  []time.Time
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	c.times = append(c.times, time.Now())
+	return len(b), nil
+}
+func (c *recordingConn) CloseRead() error  { return nil }
+func (c *recordingConn) CloseWrite() error { return nil }
+
+func newRecordingDialer(conn *recordingConn) transport.StreamDialer {
+	return transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		return conn, nil
+	})
+}
+
+func writesAsStrings(writes [][]byte) []string {
+	var result []string
+	for _, w := range writes {
+		result = append(result, string(w))
+	}
+	return result
+}
+
+func TestDialStream_Split(t *testing.T) {
+	base := &recordingConn{}
+	dialer, err := NewStreamDialer(newRecordingDialer(base), []int{1, 3}, 0, 2)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), "example.com:80")
+	require.NoError(t, err)
+
+	n, err := conn.Write([]byte("GET / HTTP/1.1"))
+	require.NoError(t, err)
+	require.Equal(t, 14, n)
+	n, err = conn.Write([]byte("Host"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	// Only the first 2 writes are split.
+	_, err = conn.Write([]byte("example.com"))
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"G", "ET ", "/ HTTP/1.1", "H", "ost", "example.com"}, writesAsStrings(base.writes))
+}
+
+func TestDialStream_ShortWrite(t *testing.T) {
+	base := &recordingConn{}
+	dialer, err := NewStreamDialer(newRecordingDialer(base), []int{2, 2, 2}, 0, 1)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), "example.com:80")
+	require.NoError(t, err)
+
+	n, err := conn.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, []string{"ab", "c"}, writesAsStrings(base.writes))
+}
+
+func TestDialStream_Delay(t *testing.T) {
+	base := &recordingConn{}
+	dialer, err := NewStreamDialer(newRecordingDialer(base), []int{1}, 20*time.Millisecond, 1)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialStream(context.Background(), "example.com:80")
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("abc"))
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"a", "bc"}, writesAsStrings(base.writes))
+	require.GreaterOrEqual(t, base.times[1].Sub(base.times[0]), 20*time.Millisecond)
+}
+
+func TestDialStream_DialError(t *testing.T) {
+	dialErr := errors.New("dial failed")
+	dialer, err := NewStreamDialer(transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		return nil, dialErr
+	}), []int{1}, 0, 1)
+	require.NoError(t, err)
+
+	_, err = dialer.DialStream(context.Background(), "example.com:80")
+	require.ErrorIs(t, err, dialErr)
+}
+
+func TestNewStreamDialer_Invalid(t *testing.T) {
+	base := newRecordingDialer(&recordingConn{})
+
+	_, err := NewStreamDialer(nil, []int{1}, 0, 1)
+	require.Error(t, err)
+	_, err = NewStreamDialer(base, nil, 0, 1)
+	require.Error(t, err)
+	_, err = NewStreamDialer(base, []int{1, 0}, 0, 1)
+	require.Error(t, err)
+	_, err = NewStreamDialer(base, []int{1}, -time.Second, 1)
+	require.Error(t, err)
+	_, err = NewStreamDialer(base, []int{1}, 0, 0)
+	require.Error(t, err)
+}
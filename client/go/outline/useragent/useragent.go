@@ -19,6 +19,12 @@ import (
 	"runtime"
 )
 
+// GOOS returns the operating system the app is running on. It's the same as [runtime.GOOS], except
+// on Mac Catalyst, where it's "darwin" instead of "ios".
+func GOOS() string {
+	return fixedGOOS
+}
+
 func GetOutlineUserAgent() string {
 	platform := fixedGOOS
 	if platform == "darwin" {
@@ -60,7 +60,7 @@ func (api *vpnAPI) Establish(configStr string) (err error) {
 		}
 	}
 
-	clientConfig := ClientConfig{}
+	clientConfig := ClientConfig{ClientVersion: GetBackendConfig().ClientVersion}
 	tcp := newFWMarkProtectedTCPDialer(conf.VPN.ProtectionMark)
 	udp := newFWMarkProtectedUDPDialer(conf.VPN.ProtectionMark)
 	clientConfig.TransportParser = config.NewDefaultTransportProvider(tcp, udp)
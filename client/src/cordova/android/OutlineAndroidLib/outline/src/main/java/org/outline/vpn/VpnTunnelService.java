@@ -221,6 +221,9 @@ public class VpnTunnelService extends VpnService {
 
     final ClientConfig clientConfig = new ClientConfig();
     clientConfig.setDataDir(this.getFilesDir().getAbsolutePath());
+    clientConfig.setClientVersion(getClientVersion(this));
+    // The network is checked on every connect, since it may have changed since the last one.
+    clientConfig.setNetworkType(getNetworkType());
     final NewClientResult clientResult = clientConfig.new_(config.id, config.transportConfig);
     if (clientResult.getError() != null) {
       LOG.log(Level.WARNING, "Failed to create Outline Client", clientResult.getError());
@@ -599,6 +602,44 @@ public class VpnTunnelService extends VpnService {
     return (String) packageManager.getApplicationLabel(appInfo);
   }
 
+  /** Returns the version name of the app, or an empty string if it's not available. */
+  @NonNull
+  public static String getClientVersion(final Context context) {
+    try {
+      final String versionName =
+          context.getPackageManager().getPackageInfo(context.getPackageName(), 0).versionName;
+      return versionName != null ? versionName : "";
+    } catch (PackageManager.NameNotFoundException e) {
+      LOG.warning("Failed to retrieve the app version");
+      return "";
+    }
+  }
+
+  /**
+   * Returns the type of the active network for the configs that depend on it: "wifi", "cellular",
+   * "ethernet", or an empty string if it's unknown.
+   */
+  @NonNull
+  private String getNetworkType() {
+    final ConnectivityManager connectivityManager =
+        (ConnectivityManager) getSystemService(Context.CONNECTIVITY_SERVICE);
+    final NetworkCapabilities capabilities =
+        connectivityManager.getNetworkCapabilities(connectivityManager.getActiveNetwork());
+    if (capabilities == null) {
+      return "";
+    }
+    if (capabilities.hasTransport(NetworkCapabilities.TRANSPORT_WIFI)) {
+      return "wifi";
+    }
+    if (capabilities.hasTransport(NetworkCapabilities.TRANSPORT_CELLULAR)) {
+      return "cellular";
+    }
+    if (capabilities.hasTransport(NetworkCapabilities.TRANSPORT_ETHERNET)) {
+      return "ethernet";
+    }
+    return "";
+  }
+
   /** Retrieves a localized string by id from the application's resources. */
   private String getStringResource(final String name) {
     String resource = "";
@@ -13,6 +13,7 @@
 // limitations under the License.
 
 import CocoaLumberjackSwift
+import Network
 import NetworkExtension
 import OutlineError
 import Tun2socks
@@ -54,6 +55,11 @@ public class SwiftBridge: NSObject {
     } catch {
       DDLogWarn("Error finding Application Support directory: \(error)")
     }
+    // The extension has the same version as the app.
+    clientConfig.clientVersion =
+      Bundle.main.object(forInfoDictionaryKey: "CFBundleShortVersionString") as? String ?? ""
+    // The network is checked on every connect, since it may have changed since the last one.
+    clientConfig.networkType = getNetworkType()
     let result = clientConfig.new(id, providerClientConfigText: transportConfig)
     if result?.error != nil {
       DDLogInfo(
@@ -63,6 +69,33 @@ public class SwiftBridge: NSObject {
     return result!
   }
 
+  /**
+   Returns the type of the current network for the configs that depend on it: "wifi", "cellular",
+   "ethernet", or an empty string if it's unknown.
+   */
+  private static func getNetworkType() -> String {
+    let monitor = NWPathMonitor()
+    let queue = DispatchQueue(label: "org.outline.NetworkTypeMonitor")
+    let pathReady = DispatchSemaphore(value: 0)
+    var networkType = ""
+    monitor.pathUpdateHandler = { path in
+      if path.usesInterfaceType(.wifi) {
+        networkType = "wifi"
+      } else if path.usesInterfaceType(.cellular) {
+        networkType = "cellular"
+      } else if path.usesInterfaceType(.wiredEthernet) {
+        networkType = "ethernet"
+      }
+      pathReady.signal()
+    }
+    monitor.start(queue: queue)
+    // The current path is delivered right after the monitor starts.
+    _ = pathReady.wait(timeout: .now() + 1)
+    monitor.cancel()
+    // Read on the monitor queue, since the handler may still be running if it timed out.
+    return queue.sync { networkType }
+  }
+
   /**
    Creates a NSError (of `OutlineError.errorDomain`) from the `OutlineError.internalError`.
    */
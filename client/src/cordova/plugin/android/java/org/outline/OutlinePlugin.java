@@ -140,6 +140,7 @@ public class OutlinePlugin extends CordovaPlugin {
 
     final GoBackendConfig goConfig = Outline.getBackendConfig();
     goConfig.setDataDir(context.getFilesDir().getAbsolutePath());
+    goConfig.setClientVersion(VpnTunnelService.getClientVersion(context));
 
     IntentFilter broadcastFilter = new IntentFilter();
     broadcastFilter.addAction(VpnTunnelService.STATUS_BROADCAST_KEY);
@@ -96,6 +96,8 @@ class OutlinePlugin: CDVPlugin {
       } catch {
         DDLogWarn("Error finding Application Support directory: \(error)")
       }
+      goConfig.clientVersion =
+        Bundle.main.object(forInfoDictionaryKey: "CFBundleShortVersionString") as? String ?? ""
     }
   }
 
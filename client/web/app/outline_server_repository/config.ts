@@ -73,6 +73,8 @@ export enum ConnectionType {
  */
 export interface FirstHopAndTunnelConfigJson extends TunnelConfigJson {
   firstHop: string;
+  /** firstHops lists the first hops if the connections go through several of them. firstHop is empty then. */
+  firstHops?: string[];
   connectionType: ConnectionType;
 }
 
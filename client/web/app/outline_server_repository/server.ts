@@ -101,6 +101,7 @@ class OutlineServer implements Server {
         id: this.id,
         name: this.name,
         firstHop: tunnelConfig.firstHop,
+        firstHops: tunnelConfig.firstHops,
         client: tunnelConfig.client,
       };
       connectionType = tunnelConfig.connectionType;
@@ -27,6 +27,8 @@ export interface StartRequestJson {
   client: string;
   // First hop used by the legacy Electron code.
   firstHop: string;
+  // First hops, if the connections go through several of them. firstHop is empty then.
+  firstHops?: string[];
 }
 
 /** VpnApi is how we talk to the platform-specific VPN API. */
@@ -294,6 +294,7 @@ export enum GoErrorCode {
   PROVIDER_ERROR = 'ERR_PROVIDER',
   VPN_PERMISSION_NOT_GRANTED = 'ERR_VPN_PERMISSION_NOT_GRANTED',
   PROXY_SERVER_UNREACHABLE = 'ERR_PROXY_SERVER_UNREACHABLE',
+  CERTIFICATE_PIN_MISMATCH = 'ERR_CERTIFICATE_PIN_MISMATCH',
   /** Indicates that the OS routing service is not running (electron only). */
   ROUTING_SERVICE_NOT_RUNNING = 'ERR_ROUTING_SERVICE_NOT_RUNNING',
 }
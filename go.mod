@@ -6,6 +6,7 @@ require (
 	github.com/Wifx/gonetworkmanager/v2 v2.1.0
 	github.com/eycorsican/go-tun2socks v1.16.11
 	github.com/goccy/go-yaml v1.18.0
+	github.com/hashicorp/yamux v0.1.2
 	github.com/songgao/water v0.0.0-20200317203138-2b4b6d7c09d8
 	github.com/stretchr/testify v1.10.0
 	go.nhat.io/cookiejar v0.3.0
@@ -14,6 +15,7 @@ require (
 	golang.org/x/mobile v0.0.0-20250813145510-f12310a0cfd9
 	golang.org/x/net v0.44.0
 	golang.org/x/sys v0.36.0
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (